@@ -0,0 +1,193 @@
+// Prometheus alert rules generated from the health policy.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"sigs.k8s.io/yaml"
+)
+
+// AlertRule is a single Prometheus alerting rule
+type AlertRule struct {
+	Alert       string            `json:"alert"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// RuleGroup is a named group of alerting rules
+type RuleGroup struct {
+	Name  string      `json:"name"`
+	Rules []AlertRule `json:"rules"`
+}
+
+// buildAlertRules derives the alerts over the toolbox metrics from a health policy
+func buildAlertRules(policy HealthPolicy) []RuleGroup {
+	t := policy.Thresholds
+	alertFor := formatPromDuration(policy.AlertFor.Duration)
+
+	rule := func(name, severity, expr, forDuration, summary, description string) AlertRule {
+		return AlertRule{
+			Alert:  name,
+			Expr:   expr,
+			For:    forDuration,
+			Labels: map[string]string{"severity": severity},
+			Annotations: map[string]string{
+				"summary":     summary,
+				"description": description,
+			},
+		}
+	}
+
+	return []RuleGroup{
+		{
+			Name: "k8stoolbox.pods",
+			Rules: []AlertRule{
+				rule("K8sToolboxPodsUnhealthy", SeverityWarning,
+					`k8stoolbox_pods{status="unhealthy"} > 0`, alertFor,
+					"Unhealthy pods in namespace {{ $labels.namespace }}",
+					"{{ $value }} pods in namespace {{ $labels.namespace }} are not running and ready."),
+				rule("K8sToolboxExcessiveRestarts", SeverityWarning,
+					`k8stoolbox_pod_issues{code="`+IssueExcessiveRestarts+`"} > 0`, alertFor,
+					"Pods restarting in namespace {{ $labels.namespace }}",
+					fmt.Sprintf("{{ $value }} pods in namespace {{ $labels.namespace }} have containers restarted more than %d times.", t.RestartCount)),
+			},
+		},
+		{
+			Name: "k8stoolbox.workloads",
+			Rules: []AlertRule{
+				rule("K8sToolboxWorkloadReplicasUnavailable", SeverityCritical,
+					fmt.Sprintf(`100 * k8stoolbox_workload_replicas{state="ready"} / ignoring(state) k8stoolbox_workload_replicas{state="desired"} < %d`, t.ReplicaAvailabilityPercent),
+					alertFor,
+					"{{ $labels.kind }} {{ $labels.namespace }}/{{ $labels.name }} is missing replicas",
+					fmt.Sprintf("Only {{ $value | humanize }}%% of the desired replicas are ready (policy requires %d%%).", t.ReplicaAvailabilityPercent)),
+			},
+		},
+		{
+			Name: "k8stoolbox.nodes",
+			Rules: []AlertRule{
+				rule("K8sToolboxNodeNotReady", SeverityCritical,
+					`k8stoolbox_node_condition{condition="Ready"} == 0`, alertFor,
+					"Node {{ $labels.node }} is not ready",
+					"Node {{ $labels.node }} has reported NotReady for more than "+alertFor+"."),
+				rule("K8sToolboxNodePressure", SeverityWarning,
+					`k8stoolbox_node_condition{condition=~"MemoryPressure|DiskPressure|PIDPressure|NetworkUnavailable"} == 1`, alertFor,
+					"Node {{ $labels.node }} reports {{ $labels.condition }}",
+					"Condition {{ $labels.condition }} has been true on node {{ $labels.node }} for more than "+alertFor+"."),
+			},
+		},
+		{
+			Name: "k8stoolbox.connectivity",
+			Rules: []AlertRule{
+				rule("K8sToolboxConnectivityProbeFailing", SeverityCritical,
+					`sum by (namespace, target) (increase(k8stoolbox_connectivity_checks_total{status=~"failed|error"}[5m])) > 0`, alertFor,
+					"Connectivity probes to {{ $labels.target }} are failing",
+					"Probes from namespace {{ $labels.namespace }} to {{ $labels.target }} failed in the last 5 minutes."),
+				rule("K8sToolboxConnectivityProbeSlow", SeverityWarning,
+					fmt.Sprintf(`histogram_quantile(0.95, sum by (le, namespace, probe) (rate(k8stoolbox_connectivity_probe_duration_seconds_bucket{status="success"}[5m]))) > %g`, t.ProbeLatencySeconds),
+					alertFor,
+					"Connectivity probe {{ $labels.probe }} is slow",
+					fmt.Sprintf("p95 duration of probe {{ $labels.probe }} is {{ $value | humanizeDuration }}, above the %gs policy threshold.", t.ProbeLatencySeconds)),
+			},
+		},
+		{
+			Name: "k8stoolbox.toolbox",
+			Rules: []AlertRule{
+				rule("K8sToolboxChecksStale", SeverityWarning,
+					fmt.Sprintf(`time() - k8stoolbox_last_check_timestamp_seconds > %d`, int(policy.StaleAfter.Duration.Seconds())), "",
+					"K8sToolbox checks of {{ $labels.namespace }} are stale",
+					"No check has completed for more than "+formatPromDuration(policy.StaleAfter.Duration)+"."),
+				rule("K8sToolboxExporterDown", SeverityWarning,
+					`k8stoolbox_exporter_up == 0`, alertFor,
+					"K8sToolbox exporter serves stale metrics",
+					"The on-scrape evaluation has failed for more than "+alertFor+"; see the toolbox logs."),
+			},
+		},
+	}
+}
+
+// formatPromDuration renders a duration in Prometheus notation (e.g. 5m, 1h30m)
+func formatPromDuration(d time.Duration) string {
+	return model.Duration(d).String()
+}
+
+// renderAlertRules renders the rule groups as a PrometheusRule manifest or a plain rules file
+func renderAlertRules(groups []RuleGroup, format, name, namespace string, labels map[string]string) ([]byte, error) {
+	switch format {
+	case "rules":
+		return yaml.Marshal(map[string]interface{}{"groups": groups})
+	case "prometheusrule":
+		metadata := map[string]interface{}{"name": name}
+		if namespace != "" {
+			metadata["namespace"] = namespace
+		}
+		if len(labels) > 0 {
+			metadata["labels"] = labels
+		}
+		return yaml.Marshal(map[string]interface{}{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "PrometheusRule",
+			"metadata":   metadata,
+			"spec":       map[string]interface{}{"groups": groups},
+		})
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected prometheusrule or rules", format)
+	}
+}
+
+// runAlertsCommand handles the alerts subcommands
+func runAlertsCommand(args []string) {
+	if len(args) < 1 || args[0] != "generate" {
+		fmt.Println("Usage: k8stoolbox alerts generate [options]")
+		os.Exit(1)
+	}
+
+	generateCmd := flag.NewFlagSet("alerts generate", flag.ExitOnError)
+	policyPath := generateCmd.String("policy", getEnv("HEALTH_POLICY", ""), "Health policy file with the alert thresholds (defaults to the built-in policy)")
+	format := generateCmd.String("format", "prometheusrule", "Output format (prometheusrule, rules)")
+	name := generateCmd.String("name", "k8stoolbox", "Name of the PrometheusRule")
+	namespace := generateCmd.String("namespace", "", "Namespace of the PrometheusRule")
+	labels := generateCmd.String("labels", "", "Comma-separated key=value labels for the PrometheusRule (e.g. release=prometheus)")
+	output := generateCmd.String("output", "", "File to write the rules to (default: stdout)")
+	if err := generateCmd.Parse(args[1:]); err != nil {
+		return
+	}
+
+	policy := healthPolicy
+	if *policyPath != "" {
+		var err error
+		if policy, err = loadHealthPolicy(*policyPath); err != nil {
+			logger.Fatalf("%v", err)
+		}
+	}
+
+	ruleLabels := make(map[string]string)
+	for _, pair := range splitList(*labels) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			logger.Fatalf("Invalid label %q, expected key=value", pair)
+		}
+		ruleLabels[key] = value
+	}
+
+	data, err := renderAlertRules(buildAlertRules(policy), *format, *name, *namespace, ruleLabels)
+	if err != nil {
+		logger.Fatalf("Failed to generate alert rules: %v", err)
+	}
+
+	if *output == "" {
+		fmt.Print(string(data))
+		return
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		logger.Fatalf("Failed to write alert rules: %v", err)
+	}
+	logger.Printf("✅ Alert rules written to %s", *output)
+}
@@ -0,0 +1,88 @@
+// Reading and writing of the tar.gz archives used by bundles, backups and offline data.
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// tarGzWriter writes files into a gzip-compressed tar stream
+type tarGzWriter struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+}
+
+func newTarGzWriter(w io.Writer, modTime time.Time) *tarGzWriter {
+	gz := gzip.NewWriter(w)
+	return &tarGzWriter{gz: gz, tw: tar.NewWriter(gz), modTime: modTime}
+}
+
+// WriteFile adds a regular file to the archive
+func (a *tarGzWriter) WriteFile(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: a.modTime,
+	}
+	if err := a.tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if _, err := a.tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+// Close finishes the archive; the underlying writer is left open
+func (a *tarGzWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %v", err)
+	}
+	if err := a.gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %v", err)
+	}
+	return nil
+}
+
+// readTarGz calls fn for every regular file of a tar.gz archive
+func readTarGz(path string, fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v", path, err)
+	}
+	defer file.Close()
+	return readTarGzStream(path, file, fn)
+}
+
+// readTarGzStream calls fn for every regular file of a tar.gz stream
+func readTarGzStream(name string, r io.Reader, fn func(name string, r io.Reader) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: expected a tar.gz archive: %v", name, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", name, err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, tr); err != nil {
+			return err
+		}
+	}
+}
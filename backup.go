@@ -0,0 +1,896 @@
+// Backup and restore of namespaced API resources discovered from the cluster.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"sigs.k8s.io/yaml"
+)
+
+// backupManifestFile is the index at the root of a backup archive
+const backupManifestFile = "manifest.json"
+
+// backupResourcesDir holds one file per object below <resource>/[<namespace>/]<name>.yaml
+const backupResourcesDir = "resources"
+
+// defaultBackupExclusions are resources recreated by controllers or too short-lived to restore
+var defaultBackupExclusions = []string{
+	"events", "events.events.k8s.io",
+	"endpoints", "endpointslices.discovery.k8s.io",
+	"leases.coordination.k8s.io",
+}
+
+// systemNamespaces are skipped when backing up all namespaces
+var systemNamespaces = []string{"kube-system", "kube-public", "kube-node-lease"}
+
+// BackupResource is an API resource included in a backup
+type BackupResource struct {
+	Group      string `json:"group"`
+	Version    string `json:"version"`
+	Resource   string `json:"resource"`
+	Kind       string `json:"kind"`
+	Namespaced bool   `json:"namespaced"`
+	Count      int    `json:"count"`
+}
+
+// GroupResource returns the resource qualified by its group, e.g. deployments.apps
+func (r BackupResource) GroupResource() string {
+	return schema.GroupResource{Group: r.Group, Resource: r.Resource}.String()
+}
+
+// GroupVersionResource returns the resource for the dynamic client
+func (r BackupResource) GroupVersionResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: r.Group, Version: r.Version, Resource: r.Resource}
+}
+
+// BackupManifest describes the contents of a backup archive
+type BackupManifest struct {
+	Name          string           `json:"name"`
+	CreatedAt     time.Time        `json:"createdAt"`
+	Version       string           `json:"version"`
+	ServerVersion string           `json:"serverVersion,omitempty"`
+	Namespaces    []string         `json:"namespaces"`
+	Resources     []BackupResource `json:"resources"`
+	Objects       int              `json:"objects"`
+	// Checksums holds the SHA-256 of every other file of the archive
+	Checksums  map[string]string `json:"checksums"`
+	Encryption *BackupEncryption `json:"encryption,omitempty"`
+}
+
+// ResourceFilter selects resources by name, with or without their group (deployments, deployments.apps)
+type ResourceFilter struct {
+	Include []string
+	Exclude []string
+}
+
+// Allows reports whether the filter selects a resource. Exclusions win over inclusions.
+func (f ResourceFilter) Allows(r BackupResource) bool {
+	matches := func(names []string) bool {
+		for _, name := range names {
+			if name == r.Resource || name == r.GroupResource() || strings.EqualFold(name, r.Kind) {
+				return true
+			}
+		}
+		return false
+	}
+	if matches(f.Exclude) {
+		return false
+	}
+	return len(f.Include) == 0 || matches(f.Include)
+}
+
+// BackupOptions selects what a backup contains
+type BackupOptions struct {
+	Namespaces []string
+	Filter     ResourceFilter
+	Selector   string
+	// Key encrypts secrets and signs the manifest when set
+	Key *BackupKey
+}
+
+// discoverBackupResources returns the preferred version of every resource that can be listed
+// and created. Groups that fail discovery, such as an unavailable metrics API, are skipped.
+func discoverBackupResources() ([]BackupResource, error) {
+	lists, err := discovery.ServerPreferredResources(clientset.Discovery())
+	if err != nil {
+		if !discovery.IsGroupDiscoveryFailedError(err) {
+			return nil, fmt.Errorf("failed to discover API resources: %v", err)
+		}
+		logger.Printf("⚠️ Some API groups are unavailable and are not backed up: %v", err)
+	}
+
+	var resources []BackupResource
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") || !hasVerbs(r.Verbs, "list", "get", "create") {
+				continue
+			}
+			resources = append(resources, BackupResource{
+				Group:      gv.Group,
+				Version:    gv.Version,
+				Resource:   r.Name,
+				Kind:       r.Kind,
+				Namespaced: r.Namespaced,
+			})
+		}
+	}
+	return resources, nil
+}
+
+func hasVerbs(verbs metav1.Verbs, required ...string) bool {
+	for _, verb := range required {
+		found := false
+		for _, v := range verbs {
+			if v == verb {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// skipBackupObject reports objects that are recreated automatically: objects owned by a
+// controller, legacy service account tokens and the root CA configmap of every namespace
+func skipBackupObject(obj *unstructured.Unstructured) bool {
+	if metav1.GetControllerOfNoCopy(obj) != nil {
+		return true
+	}
+	switch obj.GetKind() {
+	case "Secret":
+		secretType, _, _ := unstructured.NestedString(obj.Object, "type")
+		return secretType == "kubernetes.io/service-account-token"
+	case "ConfigMap":
+		return obj.GetName() == "kube-root-ca.crt"
+	}
+	return false
+}
+
+// sanitizeObject strips the fields populated by the API server and controllers so the
+// object can be created again, possibly in another cluster
+func sanitizeObject(obj *unstructured.Unstructured) {
+	unstructured.RemoveNestedField(obj.Object, "status")
+	for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp",
+		"deletionTimestamp", "deletionGracePeriodSeconds", "selfLink", "managedFields", "ownerReferences"} {
+		unstructured.RemoveNestedField(obj.Object, "metadata", field)
+	}
+
+	switch obj.GetKind() {
+	case "Service":
+		// Headless services keep their "None" cluster IP
+		if clusterIP, _, _ := unstructured.NestedString(obj.Object, "spec", "clusterIP"); clusterIP != "None" {
+			unstructured.RemoveNestedField(obj.Object, "spec", "clusterIP")
+			unstructured.RemoveNestedField(obj.Object, "spec", "clusterIPs")
+		}
+	case "PersistentVolumeClaim":
+		unstructured.RemoveNestedField(obj.Object, "spec", "volumeName")
+		annotations := obj.GetAnnotations()
+		for _, key := range []string{"pv.kubernetes.io/bind-completed", "pv.kubernetes.io/bound-by-controller", "volume.kubernetes.io/selected-node"} {
+			delete(annotations, key)
+		}
+		obj.SetAnnotations(annotations)
+	case "Pod":
+		unstructured.RemoveNestedField(obj.Object, "spec", "nodeName")
+	case "Job":
+		// Generated selectors reference the uid of the original job
+		if manual, _, _ := unstructured.NestedBool(obj.Object, "spec", "manualSelector"); !manual {
+			unstructured.RemoveNestedField(obj.Object, "spec", "selector")
+			for _, label := range []string{"controller-uid", "batch.kubernetes.io/controller-uid"} {
+				unstructured.RemoveNestedField(obj.Object, "spec", "template", "metadata", "labels", label)
+			}
+		}
+	}
+	if len(obj.GetAnnotations()) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+	}
+}
+
+// backupObjectPath is the archive path of an object
+func backupObjectPath(r BackupResource, namespace, name string) string {
+	if namespace == "" {
+		return path.Join(backupResourcesDir, r.GroupResource(), name+".yaml")
+	}
+	return path.Join(backupResourcesDir, r.GroupResource(), namespace, name+".yaml")
+}
+
+// createBackup writes the selected objects of the namespaces, the namespaces themselves, the
+// CRDs of backed-up custom resources and the cluster roles bound in the namespaces to w
+func createBackup(ctx context.Context, w io.Writer, opts BackupOptions) (BackupManifest, error) {
+	now := time.Now()
+	manifest := BackupManifest{
+		Name:       "k8stoolbox-backup-" + now.Format("20060102-150405"),
+		CreatedAt:  now,
+		Version:    Version,
+		Namespaces: opts.Namespaces,
+		Resources:  []BackupResource{},
+		Checksums:  make(map[string]string),
+	}
+	if dynamicClient == nil {
+		return manifest, fmt.Errorf("backups need a Kubernetes cluster")
+	}
+	if info, err := clientset.Discovery().ServerVersion(); err == nil {
+		manifest.ServerVersion = info.GitVersion
+	}
+
+	resources, err := discoverBackupResources()
+	if err != nil {
+		return manifest, err
+	}
+
+	archive := newTarGzWriter(w, now)
+	write := func(r *BackupResource, obj *unstructured.Unstructured) error {
+		sanitizeObject(obj)
+		data, err := yaml.Marshal(obj.Object)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s %s: %v", r.Kind, obj.GetName(), err)
+		}
+		name := backupObjectPath(*r, obj.GetNamespace(), obj.GetName())
+		if opts.Key != nil && r.GroupResource() == "secrets" {
+			if data, err = opts.Key.Encrypt(name, data); err != nil {
+				return err
+			}
+			name += encryptedSuffix
+		}
+		if err := archive.WriteFile(name, data); err != nil {
+			return err
+		}
+		manifest.Checksums[name] = sha256Hex(data)
+		r.Count++
+		manifest.Objects++
+		return nil
+	}
+
+	customGroups := make(map[string]bool)
+	clusterRoles := make(map[string]bool)
+	clusterScoped := make(map[string]*BackupResource)
+	for i := range resources {
+		r := &resources[i]
+		if !r.Namespaced {
+			clusterScoped[r.GroupResource()] = r
+			continue
+		}
+		if !opts.Filter.Allows(*r) {
+			continue
+		}
+		for _, namespace := range opts.Namespaces {
+			list, err := dynamicClient.Resource(r.GroupVersionResource()).Namespace(namespace).List(ctx, metav1.ListOptions{LabelSelector: opts.Selector})
+			if err != nil {
+				if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+					logger.Printf("⚠️ Skipping %s in %s: %v", r.GroupResource(), namespace, err)
+					continue
+				}
+				return manifest, fmt.Errorf("failed to list %s in %s: %v", r.GroupResource(), namespace, err)
+			}
+			for j := range list.Items {
+				obj := &list.Items[j]
+				if skipBackupObject(obj) {
+					continue
+				}
+				if err := write(r, obj); err != nil {
+					return manifest, err
+				}
+				if !strings.HasSuffix(r.Group, ".k8s.io") && strings.Contains(r.Group, ".") {
+					customGroups[r.Group] = true
+				}
+				if obj.GetKind() == "RoleBinding" {
+					if kind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind"); kind == "ClusterRole" {
+						name, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+						clusterRoles[name] = true
+					}
+				}
+			}
+		}
+	}
+
+	// Cluster-scoped objects the namespaced ones depend on
+	getClusterObject := func(groupResource, name string) error {
+		r, ok := clusterScoped[groupResource]
+		if !ok {
+			return nil
+		}
+		obj, err := dynamicClient.Resource(r.GroupVersionResource()).Get(ctx, name, metav1.GetOptions{})
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to get %s %s: %v", r.Kind, name, err)
+		}
+		return write(r, obj)
+	}
+	for _, namespace := range opts.Namespaces {
+		if err := getClusterObject("namespaces", namespace); err != nil {
+			return manifest, err
+		}
+	}
+	for name := range clusterRoles {
+		if strings.HasPrefix(name, "system:") || name == "admin" || name == "edit" || name == "view" || name == "cluster-admin" {
+			continue
+		}
+		if err := getClusterObject("clusterroles.rbac.authorization.k8s.io", name); err != nil {
+			return manifest, err
+		}
+	}
+	if crds, ok := clusterScoped["customresourcedefinitions.apiextensions.k8s.io"]; ok && len(customGroups) > 0 {
+		list, err := dynamicClient.Resource(crds.GroupVersionResource()).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return manifest, fmt.Errorf("failed to list custom resource definitions: %v", err)
+		}
+		for i := range list.Items {
+			if group, _, _ := unstructured.NestedString(list.Items[i].Object, "spec", "group"); customGroups[group] {
+				if err := write(crds, &list.Items[i]); err != nil {
+					return manifest, err
+				}
+			}
+		}
+	}
+
+	for _, r := range resources {
+		if r.Count > 0 {
+			manifest.Resources = append(manifest.Resources, r)
+		}
+	}
+	if opts.Key != nil {
+		manifest.Encryption = &BackupEncryption{
+			Algorithm:      backupEncryptionAlgorithm,
+			KeyFingerprint: opts.Key.Fingerprint(),
+		}
+		if manifest.Encryption.MAC, err = opts.Key.MAC(manifest); err != nil {
+			return manifest, err
+		}
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err := archive.WriteFile(backupManifestFile, data); err != nil {
+		return manifest, err
+	}
+	return manifest, archive.Close()
+}
+
+// restorePriority orders the restore so that dependencies exist first: CRDs, namespaces,
+// RBAC, configuration, storage and services, workloads, then everything else
+func restorePriority(r BackupResource) int {
+	switch r.GroupResource() {
+	case "customresourcedefinitions.apiextensions.k8s.io":
+		return 0
+	case "namespaces":
+		return 1
+	case "serviceaccounts", "clusterroles.rbac.authorization.k8s.io", "roles.rbac.authorization.k8s.io",
+		"clusterrolebindings.rbac.authorization.k8s.io", "rolebindings.rbac.authorization.k8s.io":
+		return 2
+	case "configmaps", "secrets", "limitranges", "resourcequotas", "persistentvolumeclaims", "services",
+		"networkpolicies.networking.k8s.io":
+		return 3
+	case "deployments.apps", "statefulsets.apps", "daemonsets.apps", "replicasets.apps", "replicationcontrollers",
+		"jobs.batch", "cronjobs.batch", "pods":
+		return 4
+	default:
+		return 5
+	}
+}
+
+// RestoreOptions selects and maps the objects of a backup to restore
+type RestoreOptions struct {
+	// Namespaces limits the restore to these namespaces of the backup
+	Namespaces []string
+	// NamespaceMapping restores objects of a backed-up namespace into another one
+	NamespaceMapping map[string]string
+	Filter           ResourceFilter
+	DryRun           bool
+	// Overwrite replaces objects that already exist instead of leaving them untouched
+	Overwrite bool
+}
+
+// restoreItem is an object of the backup to create
+type restoreItem struct {
+	resource BackupResource
+	object   *unstructured.Unstructured
+}
+
+// RestoreReport counts the outcome of a restore
+type RestoreReport struct {
+	Created  int
+	Replaced int
+	Existing int
+	Failed   int
+}
+
+// readBackup reads the manifest and objects of a backup archive
+func readBackup(r io.Reader, name string) (BackupManifest, map[string][]byte, error) {
+	var manifest BackupManifest
+	files := make(map[string][]byte)
+	err := readTarGzStream(name, r, func(file string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		files[file] = data
+		return nil
+	})
+	if err != nil {
+		return manifest, nil, err
+	}
+
+	data, ok := files[backupManifestFile]
+	if !ok {
+		return manifest, nil, fmt.Errorf("%s is not a backup: %s is missing", name, backupManifestFile)
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, nil, fmt.Errorf("failed to parse backup manifest: %v", err)
+	}
+	delete(files, backupManifestFile)
+	return manifest, files, nil
+}
+
+// planRestore decodes, filters, remaps and orders the objects of a backup
+func planRestore(manifest BackupManifest, files map[string][]byte, opts RestoreOptions) ([]restoreItem, error) {
+	resources := make(map[string]BackupResource)
+	for _, r := range manifest.Resources {
+		resources[r.GroupResource()] = r
+	}
+	restoreNamespace := func(namespace string) bool {
+		if len(opts.Namespaces) == 0 {
+			return true
+		}
+		for _, ns := range opts.Namespaces {
+			if ns == namespace {
+				return true
+			}
+		}
+		return false
+	}
+	mapNamespace := func(namespace string) string {
+		if mapped, ok := opts.NamespaceMapping[namespace]; ok {
+			return mapped
+		}
+		return namespace
+	}
+
+	var items []restoreItem
+	for file, data := range files {
+		parts := strings.Split(file, "/")
+		if len(parts) < 3 || parts[0] != backupResourcesDir {
+			continue
+		}
+		r, ok := resources[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("%s belongs to a resource missing from the manifest", file)
+		}
+		if !opts.Filter.Allows(r) {
+			continue
+		}
+
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(data, &obj.Object); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+		}
+
+		switch {
+		case r.Namespaced:
+			if !restoreNamespace(obj.GetNamespace()) {
+				continue
+			}
+			obj.SetNamespace(mapNamespace(obj.GetNamespace()))
+		case r.GroupResource() == "namespaces":
+			if !restoreNamespace(obj.GetName()) {
+				continue
+			}
+			obj.SetName(mapNamespace(obj.GetName()))
+			unstructured.RemoveNestedField(obj.Object, "metadata", "labels", "kubernetes.io/metadata.name")
+		}
+		if r.Kind == "RoleBinding" || r.Kind == "ClusterRoleBinding" {
+			subjects, _, _ := unstructured.NestedSlice(obj.Object, "subjects")
+			for _, s := range subjects {
+				if subject, ok := s.(map[string]interface{}); ok {
+					if ns, ok := subject["namespace"].(string); ok {
+						subject["namespace"] = mapNamespace(ns)
+					}
+				}
+			}
+			if subjects != nil {
+				unstructured.SetNestedSlice(obj.Object, subjects, "subjects")
+			}
+		}
+		items = append(items, restoreItem{resource: r, object: obj})
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if pa, pb := restorePriority(a.resource), restorePriority(b.resource); pa != pb {
+			return pa < pb
+		}
+		if a.resource.GroupResource() != b.resource.GroupResource() {
+			return a.resource.GroupResource() < b.resource.GroupResource()
+		}
+		if a.object.GetNamespace() != b.object.GetNamespace() {
+			return a.object.GetNamespace() < b.object.GetNamespace()
+		}
+		return a.object.GetName() < b.object.GetName()
+	})
+	return items, nil
+}
+
+// restoreBackup creates the planned objects in order. Existing objects are left untouched
+// unless opts.Overwrite is set.
+func restoreBackup(ctx context.Context, items []restoreItem, opts RestoreOptions) (RestoreReport, error) {
+	var report RestoreReport
+	var crds []string
+	for i, item := range items {
+		obj := item.object
+		name := obj.GetName()
+		if obj.GetNamespace() != "" {
+			name = obj.GetNamespace() + "/" + name
+		}
+
+		// Custom resources can only be created once their definitions are established
+		if len(crds) > 0 && restorePriority(item.resource) > 0 && restorePriority(items[i-1].resource) == 0 {
+			if err := waitForCRDs(ctx, crds); err != nil {
+				return report, err
+			}
+		}
+
+		if opts.DryRun {
+			logger.Printf("Would create %s %s", item.resource.Kind, name)
+			report.Created++
+			continue
+		}
+
+		client := dynamicClient.Resource(item.resource.GroupVersionResource())
+		var resource dynamic.ResourceInterface = client
+		if item.resource.Namespaced {
+			resource = client.Namespace(obj.GetNamespace())
+		}
+		_, err := resource.Create(ctx, obj, metav1.CreateOptions{})
+		if apierrors.IsAlreadyExists(err) && opts.Overwrite {
+			if err := replaceObject(ctx, resource, obj); err != nil {
+				logger.Printf("⚠️ Failed to replace %s %s: %v", item.resource.Kind, name, err)
+				report.Failed++
+			} else {
+				logger.Printf("✅ Replaced %s %s", item.resource.Kind, name)
+				report.Replaced++
+			}
+			continue
+		}
+		switch {
+		case apierrors.IsAlreadyExists(err):
+			logger.Printf("%s %s already exists", item.resource.Kind, name)
+			report.Existing++
+		case err != nil:
+			logger.Printf("⚠️ Failed to create %s %s: %v", item.resource.Kind, name, err)
+			report.Failed++
+		default:
+			logger.Printf("✅ Created %s %s", item.resource.Kind, name)
+			report.Created++
+			if item.resource.Kind == "CustomResourceDefinition" {
+				crds = append(crds, obj.GetName())
+			}
+		}
+	}
+	return report, nil
+}
+
+// replaceObject overwrites an existing object with the backed-up one. Objects whose immutable
+// fields differ from the backup, e.g. bound claims, cannot be replaced.
+func replaceObject(ctx context.Context, resource dynamic.ResourceInterface, obj *unstructured.Unstructured) error {
+	existing, err := resource.Get(ctx, obj.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	obj.SetResourceVersion(existing.GetResourceVersion())
+	_, err = resource.Update(ctx, obj, metav1.UpdateOptions{})
+	return err
+}
+
+// waitForCRDs waits until the restored custom resource definitions are established
+func waitForCRDs(ctx context.Context, names []string) error {
+	gvr := schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	for _, name := range names {
+		for {
+			crd, err := dynamicClient.Resource(gvr).Get(ctx, name, metav1.GetOptions{})
+			if err == nil && crdEstablished(crd) {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("custom resource definition %s was not established in time", name)
+			case <-time.After(time.Second):
+			}
+		}
+	}
+	return nil
+}
+
+func crdEstablished(crd *unstructured.Unstructured) bool {
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		if condition, ok := c.(map[string]interface{}); ok && condition["type"] == "Established" && condition["status"] == "True" {
+			return true
+		}
+	}
+	return false
+}
+
+// backupNamespaces resolves the namespaces to back up
+func backupNamespaces(ctx context.Context, namespaces string, all bool, exclude []string) ([]string, error) {
+	if !all {
+		return splitList(namespaces), nil
+	}
+	list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces: %v", err)
+	}
+	excluded := make(map[string]bool)
+	for _, ns := range exclude {
+		excluded[ns] = true
+	}
+	var names []string
+	for _, ns := range list.Items {
+		if !excluded[ns.Name] {
+			names = append(names, ns.Name)
+		}
+	}
+	return names, nil
+}
+
+// runBackupCommand backs up namespaces to a tar.gz archive
+func runBackupCommand(ctx context.Context, args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "list":
+			runBackupList(ctx, args[1:])
+			return
+		case "prune":
+			runBackupPrune(ctx, args[1:])
+			return
+		}
+	}
+
+	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
+	namespaces := backupCmd.String("namespaces", "default", "Comma-separated namespaces to back up")
+	allNamespaces := backupCmd.Bool("all-namespaces", false, "Back up every namespace")
+	excludeNamespaces := backupCmd.String("exclude-namespaces", strings.Join(systemNamespaces, ","), "Namespaces skipped with -all-namespaces")
+	include := backupCmd.String("include-resources", "", "Only back up these resources (e.g. deployments,configmaps,certificates.cert-manager.io)")
+	exclude := backupCmd.String("exclude-resources", "", "Resources to skip in addition to "+strings.Join(defaultBackupExclusions, ","))
+	selector := backupCmd.String("selector", "", "Only back up objects matching this label selector")
+	output := backupCmd.String("output", "", "Backup name, or a file path when no -storage is given (default: k8stoolbox-backup-<timestamp>.tar.gz)")
+	location := storageFlag(backupCmd)
+	policy := retentionFlags(backupCmd)
+	keyFile, keySecret := backupKeyFlags(backupCmd)
+	if err := backupCmd.Parse(args); err != nil {
+		return
+	}
+
+	names, err := backupNamespaces(ctx, *namespaces, *allNamespaces, splitList(*excludeNamespaces))
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	opts := BackupOptions{
+		Namespaces: names,
+		Filter: ResourceFilter{
+			Include: splitList(*include),
+			Exclude: append(append([]string{}, defaultBackupExclusions...), splitList(*exclude)...),
+		},
+		Selector: *selector,
+	}
+	if opts.Key, err = loadBackupKey(ctx, *keyFile, *keySecret); err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if opts.Key == nil && opts.Filter.Allows(BackupResource{Resource: "secrets", Kind: "Secret"}) {
+		logger.Println("⚠️ Secrets are stored unencrypted; use -encryption-key-file or -encryption-key-secret to encrypt them")
+	}
+	if opts.Key == nil {
+		logger.Println("⚠️ The backup is not signed: its checksums detect corruption but not deliberate changes; use a key to authenticate it")
+	}
+
+	name := *output
+	if name == "" {
+		name = backupNamePrefix + time.Now().Format("20060102-150405") + backupArchiveSuffix
+	}
+	if *location == "" {
+		*location, name = filepath.Split(name)
+	}
+	if err := storeBackup(ctx, *location, name, opts, *policy); err != nil {
+		logger.Fatalf("%v", err)
+	}
+}
+
+// storeBackup writes a backup to a storage location and applies the retention policy.
+// Errors are returned so that the storage, such as a PVC helper pod, is always closed.
+func storeBackup(ctx context.Context, location, name string, opts BackupOptions, policy RetentionPolicy) error {
+	storage, err := openBackupStorage(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	manifest, err := writeBackup(ctx, storage, name, opts)
+	if err != nil {
+		return fmt.Errorf("backup failed: %v", err)
+	}
+	logger.Printf("✅ Backed up %d objects of %d resource types from %d namespaces to %s in %s",
+		manifest.Objects, len(manifest.Resources), len(manifest.Namespaces), name, storage)
+
+	if policy.Enabled() {
+		prefix, ok := retentionPrefix(name)
+		if !ok {
+			logger.Printf("⚠️ Retention not applied: %s has no name prefix that identifies the backups to rotate", name)
+			return nil
+		}
+		expired, err := pruneBackups(ctx, storage, policy, prefix, false)
+		if err != nil {
+			return fmt.Errorf("failed to apply retention: %v", err)
+		}
+		for _, b := range expired {
+			logger.Printf("Deleted expired backup %s", b.Name)
+		}
+	}
+	return nil
+}
+
+// retentionPrefix returns the name prefix of the backups rotated together with name. Backups
+// with custom names are rotated among backups of the same prefix, e.g. nightly-20240101 with
+// nightly-*. Names without such a prefix, e.g. 20240101, are not rotated, since every backup
+// in the location would match.
+func retentionPrefix(name string) (string, bool) {
+	if strings.HasPrefix(name, backupNamePrefix) {
+		return backupNamePrefix, true
+	}
+	prefix := strings.TrimRight(strings.TrimSuffix(name, backupArchiveSuffix), "0123456789-_")
+	if prefix == "" || strings.HasSuffix(prefix, "/") {
+		return "", false
+	}
+	return prefix, true
+}
+
+// writeBackup creates a backup in a temporary file and stores it under name
+func writeBackup(ctx context.Context, storage BackupStorage, name string, opts BackupOptions) (BackupManifest, error) {
+	file, err := os.CreateTemp("", "k8stoolbox-backup-*.tar.gz")
+	if err != nil {
+		return BackupManifest{}, fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	manifest, err := createBackup(ctx, file, opts)
+	if err != nil {
+		return manifest, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return manifest, fmt.Errorf("failed to read backup: %v", err)
+	}
+	return manifest, storage.Put(ctx, name, file)
+}
+
+// backupKeyFlags registers the flags selecting the backup encryption key
+func backupKeyFlags(cmd *flag.FlagSet) (*string, *string) {
+	keyFile := cmd.String("encryption-key-file", "", "File with the key that encrypts secrets and signs the backup")
+	keySecret := cmd.String("encryption-key-secret", "", "Secret (namespace/name) whose \"key\" entry encrypts secrets and signs the backup")
+	return keyFile, keySecret
+}
+
+// fetchBackup reads a backup from a storage location. The storage is closed before returning,
+// so a PVC helper pod does not outlive the download.
+func fetchBackup(ctx context.Context, location, name string) (BackupManifest, map[string][]byte, error) {
+	storage, err := openBackupStorage(ctx, location)
+	if err != nil {
+		return BackupManifest{}, nil, err
+	}
+	defer storage.Close()
+	if name, err = resolveBackupName(ctx, storage, name); err != nil {
+		return BackupManifest{}, nil, err
+	}
+	archive, err := storage.Get(ctx, name)
+	if err != nil {
+		return BackupManifest{}, nil, err
+	}
+	defer archive.Close()
+	return readBackup(archive, name)
+}
+
+// runRestoreCommand restores a backup archive
+func runRestoreCommand(ctx context.Context, args []string) {
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	from := restoreCmd.String("from", "", "Backup to restore: a name in -storage, \"latest\", or a file path when no -storage is given")
+	location := storageFlag(restoreCmd)
+	namespaces := restoreCmd.String("namespaces", "", "Only restore these namespaces of the backup (default: all)")
+	mapping := restoreCmd.String("namespace-mapping", "", "Restore namespaces under new names (e.g. shop=shop-restored,db=db-restored)")
+	include := restoreCmd.String("include-resources", "", "Only restore these resources")
+	exclude := restoreCmd.String("exclude-resources", "", "Resources to skip")
+	dryRun := restoreCmd.Bool("dry-run", false, "Show what would be created without changing the cluster")
+	overwrite := restoreCmd.Bool("overwrite", false, "Replace objects that already exist instead of skipping them")
+	keyFile, keySecret := backupKeyFlags(restoreCmd)
+	if err := restoreCmd.Parse(args); err != nil {
+		return
+	}
+	if *from == "" {
+		logger.Println("Please specify the backup to restore with -from")
+		os.Exit(1)
+	}
+	if dynamicClient == nil && !*dryRun {
+		logger.Fatalf("Restoring needs a Kubernetes cluster")
+	}
+
+	opts := RestoreOptions{
+		Namespaces:       splitList(*namespaces),
+		NamespaceMapping: make(map[string]string),
+		Filter:           ResourceFilter{Include: splitList(*include), Exclude: splitList(*exclude)},
+		DryRun:           *dryRun,
+		Overwrite:        *overwrite,
+	}
+	for _, pair := range splitList(*mapping) {
+		source, target, ok := strings.Cut(pair, "=")
+		if !ok || source == "" || target == "" {
+			logger.Fatalf("Invalid namespace mapping %q, expected old=new", pair)
+		}
+		opts.NamespaceMapping[source] = target
+	}
+
+	name := *from
+	if *location == "" {
+		*location, name = filepath.Split(name)
+	}
+	manifest, files, err := fetchBackup(ctx, *location, name)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	key, err := loadBackupKey(ctx, *keyFile, *keySecret)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if err := verifyBackup(manifest, files, key); err != nil {
+		logger.Fatalf("Refusing to restore: %v", err)
+	}
+	if manifest.Encryption == nil {
+		logger.Printf("⚠️ Checked %d files of backup %s for corruption only: it is not signed, so anyone able to write it could have changed both files and checksums", len(manifest.Checksums), manifest.Name)
+	} else {
+		logger.Printf("✅ Verified %d files of backup %s against its signed manifest", len(manifest.Checksums), manifest.Name)
+	}
+
+	items, err := planRestore(manifest, files, opts)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+
+	logger.Printf("Restoring %d objects from backup %s (created %s)", len(items), manifest.Name, manifest.CreatedAt.Format(time.RFC3339))
+	report, err := restoreBackup(ctx, items, opts)
+	if err != nil {
+		logger.Fatalf("Restore failed: %v", err)
+	}
+	if opts.DryRun {
+		logger.Printf("Dry run: %d objects would be created", report.Created)
+		return
+	}
+	logger.Printf("Restore finished: %d created, %d replaced, %d already existed, %d failed",
+		report.Created, report.Replaced, report.Existing, report.Failed)
+	if report.Failed > 0 {
+		os.Exit(1)
+	}
+}
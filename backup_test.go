@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+)
+
+func TestRetentionPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		ok     bool
+	}{
+		{"k8stoolbox-backup-20240101-120000.tar.gz", backupNamePrefix, true},
+		{"nightly-20240101.tar.gz", "nightly", true},
+		{"prod/nightly_20240101-1200.tar.gz", "prod/nightly", true},
+		{"20260301.tar.gz", "", false},
+		{"20260301", "", false},
+		{"prod/20260301.tar.gz", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			prefix, ok := retentionPrefix(tt.name)
+			if prefix != tt.prefix || ok != tt.ok {
+				t.Errorf("retentionPrefix(%q) = %q, %v, want %q, %v", tt.name, prefix, ok, tt.prefix, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSanitizeObject(t *testing.T) {
+	meta := map[string]interface{}{
+		"name":              "web",
+		"namespace":         "shop",
+		"uid":               "2f1c",
+		"resourceVersion":   "4711",
+		"generation":        int64(3),
+		"creationTimestamp": "2024-01-01T00:00:00Z",
+		"managedFields":     []interface{}{map[string]interface{}{"manager": "kubectl"}},
+		"ownerReferences":   []interface{}{map[string]interface{}{"kind": "Application", "name": "shop"}},
+		"labels":            map[string]interface{}{"app": "web"},
+	}
+	tests := []struct {
+		name    string
+		object  map[string]interface{}
+		removed [][]string
+		kept    [][]string
+	}{
+		{
+			"deployment",
+			map[string]interface{}{
+				"kind":   "Deployment",
+				"spec":   map[string]interface{}{"replicas": int64(2)},
+				"status": map[string]interface{}{"readyReplicas": int64(2)},
+			},
+			[][]string{{"status"}},
+			[][]string{{"spec", "replicas"}},
+		},
+		{
+			"service",
+			map[string]interface{}{
+				"kind": "Service",
+				"spec": map[string]interface{}{"clusterIP": "10.0.0.1", "clusterIPs": []interface{}{"10.0.0.1"}},
+			},
+			[][]string{{"spec", "clusterIP"}, {"spec", "clusterIPs"}},
+			nil,
+		},
+		{
+			"headless service",
+			map[string]interface{}{
+				"kind": "Service",
+				"spec": map[string]interface{}{"clusterIP": "None"},
+			},
+			nil,
+			[][]string{{"spec", "clusterIP"}},
+		},
+		{
+			"bound claim",
+			map[string]interface{}{
+				"kind": "PersistentVolumeClaim",
+				"spec": map[string]interface{}{"volumeName": "pv-1", "storageClassName": "standard"},
+			},
+			[][]string{{"spec", "volumeName"}},
+			[][]string{{"spec", "storageClassName"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj := &unstructured.Unstructured{Object: runtime.DeepCopyJSON(tt.object)}
+			obj.Object["metadata"] = runtime.DeepCopyJSONValue(meta)
+			sanitizeObject(obj)
+
+			for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "ownerReferences"} {
+				tt.removed = append(tt.removed, []string{"metadata", field})
+			}
+			for _, fields := range tt.removed {
+				if _, found, _ := unstructured.NestedFieldNoCopy(obj.Object, fields...); found {
+					t.Errorf("%s was not removed", strings.Join(fields, "."))
+				}
+			}
+			for _, fields := range append(tt.kept, []string{"metadata", "name"}, []string{"metadata", "labels", "app"}) {
+				if _, found, _ := unstructured.NestedFieldNoCopy(obj.Object, fields...); !found {
+					t.Errorf("%s was removed", strings.Join(fields, "."))
+				}
+			}
+		})
+	}
+}
+
+// backupFiles builds a manifest and archive files of objects as written by createBackup
+func backupFiles(t *testing.T, objects map[BackupResource][]*unstructured.Unstructured) (BackupManifest, map[string][]byte) {
+	t.Helper()
+	manifest := BackupManifest{}
+	files := make(map[string][]byte)
+	for r, objs := range objects {
+		for _, obj := range objs {
+			data, err := yaml.Marshal(obj.Object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[backupObjectPath(r, obj.GetNamespace(), obj.GetName())] = data
+			r.Count++
+		}
+		manifest.Resources = append(manifest.Resources, r)
+	}
+	return manifest, files
+}
+
+func backupObject(apiVersion, kind, namespace, name string) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace(namespace)
+	obj.SetName(name)
+	return obj
+}
+
+var (
+	backupCRDs = BackupResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions", Kind: "CustomResourceDefinition"}
+	backupNS   = BackupResource{Version: "v1", Resource: "namespaces", Kind: "Namespace"}
+	backupRB   = BackupResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "rolebindings", Kind: "RoleBinding", Namespaced: true}
+	backupCMs  = BackupResource{Version: "v1", Resource: "configmaps", Kind: "ConfigMap", Namespaced: true}
+	backupDeps = BackupResource{Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment", Namespaced: true}
+	backupCRs  = BackupResource{Group: "example.com", Version: "v1", Resource: "widgets", Kind: "Widget", Namespaced: true}
+)
+
+func TestPlanRestoreOrder(t *testing.T) {
+	binding := backupObject("rbac.authorization.k8s.io/v1", "RoleBinding", "shop", "deployer")
+	binding.Object["subjects"] = []interface{}{map[string]interface{}{"kind": "ServiceAccount", "name": "ci", "namespace": "shop"}}
+	manifest, files := backupFiles(t, map[BackupResource][]*unstructured.Unstructured{
+		backupCRs:  {backupObject("example.com/v1", "Widget", "shop", "gear")},
+		backupDeps: {backupObject("apps/v1", "Deployment", "shop", "web"), backupObject("apps/v1", "Deployment", "db", "postgres")},
+		backupCMs:  {backupObject("v1", "ConfigMap", "shop", "settings")},
+		backupRB:   {binding},
+		backupNS:   {backupObject("v1", "Namespace", "", "shop"), backupObject("v1", "Namespace", "", "db")},
+		backupCRDs: {backupObject("apiextensions.k8s.io/v1", "CustomResourceDefinition", "", "widgets.example.com")},
+	})
+
+	items, err := planRestore(manifest, files, RestoreOptions{
+		Namespaces:       []string{"shop"},
+		NamespaceMapping: map[string]string{"shop": "shop-restored"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, item := range items {
+		got = append(got, item.object.GetKind()+" "+item.object.GetNamespace()+"/"+item.object.GetName())
+	}
+	want := []string{
+		"CustomResourceDefinition /widgets.example.com",
+		"Namespace /shop-restored",
+		"RoleBinding shop-restored/deployer",
+		"ConfigMap shop-restored/settings",
+		"Deployment shop-restored/web",
+		"Widget shop-restored/gear",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected restore order:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	subjects, _, _ := unstructured.NestedSlice(items[2].object.Object, "subjects")
+	if len(subjects) != 1 || subjects[0].(map[string]interface{})["namespace"] != "shop-restored" {
+		t.Errorf("role binding subject was not remapped: %v", subjects)
+	}
+}
+
+func TestRestoreBackupConflicts(t *testing.T) {
+	configMap := func(name, value string) *unstructured.Unstructured {
+		obj := backupObject("v1", "ConfigMap", "shop", name)
+		obj.Object["data"] = map[string]interface{}{"mode": value}
+		return obj
+	}
+
+	tests := []struct {
+		name      string
+		overwrite bool
+		want      RestoreReport
+		mode      string
+	}{
+		{"skip", false, RestoreReport{Created: 1, Existing: 1}, "live"},
+		{"overwrite", true, RestoreReport{Created: 1, Replaced: 1}, "backup"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := dynamicClient
+			t.Cleanup(func() { dynamicClient = previous })
+			existing := configMap("settings", "live")
+			existing.SetResourceVersion("7")
+			dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), existing)
+
+			items := []restoreItem{
+				{resource: backupCMs, object: configMap("settings", "backup")},
+				{resource: backupCMs, object: configMap("flags", "backup")},
+			}
+			report, err := restoreBackup(context.Background(), items, RestoreOptions{Overwrite: tt.overwrite})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if report != tt.want {
+				t.Errorf("report = %+v, want %+v", report, tt.want)
+			}
+
+			client := dynamicClient.Resource(backupCMs.GroupVersionResource()).Namespace("shop")
+			for name, mode := range map[string]string{"settings": tt.mode, "flags": "backup"} {
+				obj, err := client.Get(context.Background(), name, metav1.GetOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, _, _ := unstructured.NestedString(obj.Object, "data", "mode"); got != mode {
+					t.Errorf("%s has mode %q, want %q", name, got, mode)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,213 @@
+// Integrity checks and secret encryption of backup archives.
+
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// encryptedSuffix marks archive files encrypted with the backup key
+const encryptedSuffix = ".enc"
+
+// backupEncryptionAlgorithm is the cipher used for encrypted files
+const backupEncryptionAlgorithm = "AES-256-GCM"
+
+// BackupEncryption describes how the secrets of a backup are encrypted. The MAC authenticates
+// the whole manifest, including the checksums of all files, so that an archive cannot be
+// altered without the key.
+type BackupEncryption struct {
+	Algorithm      string `json:"algorithm"`
+	KeyFingerprint string `json:"keyFingerprint"`
+	MAC            string `json:"mac"`
+}
+
+// BackupKey holds the keys derived from the key material of a backup
+type BackupKey struct {
+	encryption []byte
+	integrity  []byte
+}
+
+// newBackupKey derives separate encryption and integrity keys from the key material
+func newBackupKey(material []byte) (*BackupKey, error) {
+	material = []byte(strings.TrimSpace(string(material)))
+	if len(material) < 16 {
+		return nil, fmt.Errorf("backup key is too short: use at least 16 bytes, e.g. the output of 'openssl rand -base64 32'")
+	}
+	derive := func(purpose string) []byte {
+		mac := hmac.New(sha256.New, material)
+		mac.Write([]byte("k8stoolbox-backup-" + purpose))
+		return mac.Sum(nil)
+	}
+	return &BackupKey{encryption: derive("encryption"), integrity: derive("integrity")}, nil
+}
+
+// Fingerprint identifies the key without revealing it
+func (k *BackupKey) Fingerprint() string {
+	sum := sha256.Sum256(k.integrity)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Encrypt seals data with AES-256-GCM. The archive path is authenticated so that encrypted
+// files cannot be swapped.
+func (k *BackupKey) Encrypt(name string, data []byte) ([]byte, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return gcm.Seal(nonce, nonce, data, []byte(name)), nil
+}
+
+// Decrypt opens data sealed by Encrypt for the same archive path
+func (k *BackupKey) Decrypt(name string, data []byte) ([]byte, error) {
+	gcm, err := k.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("failed to decrypt %s: data is truncated", name)
+	}
+	plain, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: wrong key or tampered data", name)
+	}
+	return plain, nil
+}
+
+func (k *BackupKey) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(k.encryption)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// MAC authenticates every field of a backup manifest except the MAC itself. The manifest is
+// hashed in its JSON encoding, so fields added later are covered as well.
+func (k *BackupKey) MAC(manifest BackupManifest) (string, error) {
+	if manifest.Encryption != nil {
+		encryption := *manifest.Encryption
+		encryption.MAC = ""
+		manifest.Encryption = &encryption
+	}
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	mac := hmac.New(sha256.New, k.integrity)
+	mac.Write(data)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadBackupKey reads the key material from a file or from a Kubernetes Secret given as
+// namespace/name. The secret's "key" entry is used, or its only entry.
+func loadBackupKey(ctx context.Context, file, secret string) (*BackupKey, error) {
+	switch {
+	case file != "" && secret != "":
+		return nil, fmt.Errorf("specify either a key file or a key secret, not both")
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read backup key: %v", err)
+		}
+		return newBackupKey(data)
+	case secret != "":
+		namespace, name, ok := strings.Cut(secret, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid key secret %q, expected namespace/name", secret)
+		}
+		if clientset == nil {
+			return nil, fmt.Errorf("reading the key secret needs a Kubernetes cluster")
+		}
+		s, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get key secret %s: %v", secret, err)
+		}
+		data, ok := s.Data["key"]
+		if !ok && len(s.Data) == 1 {
+			for _, value := range s.Data {
+				data = value
+			}
+		}
+		if data == nil {
+			return nil, fmt.Errorf("key secret %s has no \"key\" entry", secret)
+		}
+		return newBackupKey(data)
+	}
+	return nil, nil
+}
+
+// verifyBackup checks every file of a backup against the manifest and decrypts the encrypted
+// ones in place. Archives with missing, unexpected or modified files are refused.
+func verifyBackup(manifest BackupManifest, files map[string][]byte, key *BackupKey) error {
+	if len(manifest.Checksums) == 0 {
+		return fmt.Errorf("backup has no checksums and cannot be verified")
+	}
+	for name, data := range files {
+		expected, ok := manifest.Checksums[name]
+		if !ok {
+			return fmt.Errorf("backup was tampered with: %s is not in the manifest", name)
+		}
+		if sha256Hex(data) != expected {
+			return fmt.Errorf("backup was tampered with: checksum of %s does not match", name)
+		}
+	}
+	for name := range manifest.Checksums {
+		if _, ok := files[name]; !ok {
+			return fmt.Errorf("backup is incomplete: %s is missing", name)
+		}
+	}
+
+	if manifest.Encryption == nil {
+		if key != nil {
+			// A key is only given for signed backups; an unsigned one may have been replaced
+			return fmt.Errorf("backup is not signed with a key and cannot be authenticated")
+		}
+		return nil
+	}
+	if key == nil {
+		return fmt.Errorf("backup is encrypted: provide the key with -encryption-key-file or -encryption-key-secret")
+	}
+	if key.Fingerprint() != manifest.Encryption.KeyFingerprint {
+		return fmt.Errorf("backup was encrypted with another key (fingerprint %s)", manifest.Encryption.KeyFingerprint)
+	}
+	mac, err := key.MAC(manifest)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(mac), []byte(manifest.Encryption.MAC)) {
+		return fmt.Errorf("backup was tampered with: manifest signature does not match")
+	}
+	for name, data := range files {
+		if !strings.HasSuffix(name, encryptedSuffix) {
+			continue
+		}
+		plain, err := key.Decrypt(strings.TrimSuffix(name, encryptedSuffix), data)
+		if err != nil {
+			return err
+		}
+		delete(files, name)
+		files[strings.TrimSuffix(name, encryptedSuffix)] = plain
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedBackup returns a manifest signed with key, as read back from an archive, and its files
+func signedBackup(t *testing.T, key *BackupKey) (BackupManifest, map[string][]byte) {
+	t.Helper()
+	secret, err := key.Encrypt("shop/secrets.yaml", []byte("kind: List\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"shop/deployments.apps.yaml": []byte("kind: List\n"),
+		"shop/secrets.yaml.enc":      secret,
+	}
+	manifest := BackupManifest{
+		Name:       "backup-20240101-120000.tar.gz",
+		CreatedAt:  time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local),
+		Version:    "1.0.0",
+		Namespaces: []string{"shop"},
+		Resources:  []BackupResource{{Group: "apps", Version: "v1", Resource: "deployments", Kind: "Deployment", Namespaced: true, Count: 2}},
+		Objects:    3,
+		Checksums:  map[string]string{},
+		Encryption: &BackupEncryption{Algorithm: backupEncryptionAlgorithm, KeyFingerprint: key.Fingerprint()},
+	}
+	for name, data := range files {
+		manifest.Checksums[name] = sha256Hex(data)
+	}
+	if manifest.Encryption.MAC, err = key.MAC(manifest); err != nil {
+		t.Fatal(err)
+	}
+
+	// Round-trip through JSON like readBackup does
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read BackupManifest
+	if err := json.Unmarshal(data, &read); err != nil {
+		t.Fatal(err)
+	}
+	return read, files
+}
+
+func TestVerifyBackupAuthenticatesWholeManifest(t *testing.T) {
+	key, err := newBackupKey([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		tamper  func(m *BackupManifest, files map[string][]byte)
+		wantErr string
+	}{
+		{"untouched", func(*BackupManifest, map[string][]byte) {}, ""},
+		{"namespaces", func(m *BackupManifest, _ map[string][]byte) { m.Namespaces = []string{"kube-system"} }, "signature"},
+		{"objects", func(m *BackupManifest, _ map[string][]byte) { m.Objects = 1 }, "signature"},
+		{"version", func(m *BackupManifest, _ map[string][]byte) { m.Version = "0.9.0" }, "signature"},
+		{"server version", func(m *BackupManifest, _ map[string][]byte) { m.ServerVersion = "v1.30.0" }, "signature"},
+		{"algorithm", func(m *BackupManifest, _ map[string][]byte) { m.Encryption.Algorithm = "none" }, "signature"},
+		{"resource count", func(m *BackupManifest, _ map[string][]byte) { m.Resources[0].Count = 5 }, "signature"},
+		{"file and checksum", func(m *BackupManifest, files map[string][]byte) {
+			files["shop/deployments.apps.yaml"] = []byte("kind: List\nitems: []\n")
+			m.Checksums["shop/deployments.apps.yaml"] = sha256Hex(files["shop/deployments.apps.yaml"])
+		}, "signature"},
+		{"file only", func(_ *BackupManifest, files map[string][]byte) {
+			files["shop/deployments.apps.yaml"] = []byte("kind: List\nitems: []\n")
+		}, "checksum"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			manifest, files := signedBackup(t, key)
+			tt.tamper(&manifest, files)
+			err := verifyBackup(manifest, files, key)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if string(files["shop/secrets.yaml"]) != "kind: List\n" {
+					t.Errorf("encrypted file was not decrypted: %v", files)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error about the %s, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestVerifyUnsignedBackup(t *testing.T) {
+	files := map[string][]byte{"shop/deployments.apps.yaml": []byte("kind: List\n")}
+	manifest := BackupManifest{Name: "backup.tar.gz", Checksums: map[string]string{
+		"shop/deployments.apps.yaml": sha256Hex(files["shop/deployments.apps.yaml"]),
+	}}
+	if err := verifyBackup(manifest, files, nil); err != nil {
+		t.Fatalf("unsigned backup with matching checksums was refused: %v", err)
+	}
+
+	key, err := newBackupKey([]byte("0123456789abcdef0123456789abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyBackup(manifest, files, key); err == nil {
+		t.Error("unsigned backup was accepted although a key was given")
+	}
+}
@@ -0,0 +1,759 @@
+// Storage backends and retention for backup archives: local paths, S3-compatible object
+// stores and persistent volume claims.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// backupArchiveSuffix identifies backup archives in a storage location
+const backupArchiveSuffix = ".tar.gz"
+
+// backupNamePrefix starts the default names of backups
+const backupNamePrefix = "k8stoolbox-backup-"
+
+// BackupObject is an archive stored in a backup location
+type BackupObject struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// BackupStorage stores backup archives under flat names
+type BackupStorage interface {
+	Put(ctx context.Context, name string, r io.ReadSeeker) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+	// List returns the archives of the location, newest first
+	List(ctx context.Context) ([]BackupObject, error)
+	Delete(ctx context.Context, name string) error
+	Close() error
+	String() string
+}
+
+// openBackupStorage opens the location described by spec: a local path, "s3://bucket/prefix"
+// or "pvc://namespace/claim/dir"
+func openBackupStorage(ctx context.Context, spec string) (BackupStorage, error) {
+	switch {
+	case strings.HasPrefix(spec, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(spec, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid S3 location %q, expected s3://bucket/prefix", spec)
+		}
+		return newS3Storage(bucket, prefix)
+	case strings.HasPrefix(spec, "pvc://"):
+		parts := strings.SplitN(strings.TrimPrefix(spec, "pvc://"), "/", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid PVC location %q, expected pvc://namespace/claim/dir", spec)
+		}
+		dir := ""
+		if len(parts) == 3 {
+			dir = parts[2]
+		}
+		return newPVCStorage(ctx, parts[0], parts[1], dir)
+	default:
+		return newLocalStorage(strings.TrimPrefix(spec, "file://"))
+	}
+}
+
+// sortBackupObjects orders archives newest first
+func sortBackupObjects(objects []BackupObject) {
+	sort.Slice(objects, func(i, j int) bool {
+		if !objects[i].ModTime.Equal(objects[j].ModTime) {
+			return objects[i].ModTime.After(objects[j].ModTime)
+		}
+		return objects[i].Name > objects[j].Name
+	})
+}
+
+// localStorage keeps archives in a directory, e.g. a mounted volume
+type localStorage struct {
+	dir string
+}
+
+func newLocalStorage(dir string) (*localStorage, error) {
+	if dir == "" {
+		dir = "."
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %v", err)
+	}
+	return &localStorage{dir: dir}, nil
+}
+
+func (s *localStorage) Put(ctx context.Context, name string, r io.ReadSeeker) error {
+	// Write to a temporary file next to the archive first so that a failed upload never
+	// leaves a partial archive; names may contain directories
+	target := filepath.Join(s.dir, name)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %v", name, err)
+	}
+	file, err := os.CreateTemp(filepath.Dir(target), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %v", name, err)
+	}
+	defer os.Remove(file.Name())
+	if _, err := io.Copy(file, r); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return os.Rename(file.Name(), target)
+}
+
+func (s *localStorage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	file, err := os.Open(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup: %v", err)
+	}
+	return file, nil
+}
+
+// List walks the whole directory tree, since Put accepts names with directories
+func (s *localStorage) List(ctx context.Context) ([]BackupObject, error) {
+	var objects []BackupObject
+	err := filepath.WalkDir(s.dir, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), backupArchiveSuffix) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+		name, err := filepath.Rel(s.dir, file)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, BackupObject{Name: filepath.ToSlash(name), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list backups: %v", err)
+	}
+	sortBackupObjects(objects)
+	return objects, nil
+}
+
+func (s *localStorage) Delete(ctx context.Context, name string) error {
+	if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *localStorage) Close() error { return nil }
+
+func (s *localStorage) String() string { return s.dir }
+
+// s3Storage keeps archives in an S3-compatible object store using path-style requests signed
+// with AWS Signature Version 4. The endpoint and region come from BACKUP_S3_ENDPOINT and
+// BACKUP_S3_REGION, the credentials from the standard AWS_* variables.
+type s3Storage struct {
+	endpoint     *url.URL
+	region       string
+	bucket       string
+	prefix       string
+	accessKey    string
+	secretKey    string
+	sessionToken string
+	client       *http.Client
+}
+
+func newS3Storage(bucket, prefix string) (*s3Storage, error) {
+	region := config2.BackupS3Region
+	endpoint := config2.BackupS3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", endpoint)
+	}
+	s := &s3Storage{
+		endpoint:     u,
+		region:       region,
+		bucket:       bucket,
+		prefix:       strings.Trim(prefix, "/"),
+		accessKey:    getEnv("AWS_ACCESS_KEY_ID", ""),
+		secretKey:    getEnv("AWS_SECRET_ACCESS_KEY", ""),
+		sessionToken: getEnv("AWS_SESSION_TOKEN", ""),
+		client:       &http.Client{Transport: newS3Transport()},
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("S3 storage needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	return s, nil
+}
+
+// newS3Transport bounds connecting and waiting for a response but not the transfer itself, so
+// large archives are not cut off; the command context cancels stalled transfers
+func newS3Transport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = 30 * time.Second
+	transport.ResponseHeaderTimeout = time.Minute
+	return transport
+}
+
+func (s *s3Storage) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s *s3Storage) Put(ctx context.Context, name string, r io.ReadSeeker) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, r)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	resp, err := s.do(ctx, http.MethodPut, s.key(name), nil, io.NopCloser(r), size, hex.EncodeToString(hash.Sum(nil)))
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %v", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	resp, err := s.do(ctx, http.MethodGet, s.key(name), nil, nil, 0, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %v", name, err)
+	}
+	return resp.Body, nil
+}
+
+// s3ListResult is the response of ListObjectsV2
+type s3ListResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func (s *s3Storage) List(ctx context.Context) ([]BackupObject, error) {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = s.prefix + "/"
+	}
+	var objects []BackupObject
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do(ctx, http.MethodGet, "", query, nil, 0, "")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list backups: %v", err)
+		}
+		var result s3ListResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse backup listing: %v", err)
+		}
+		for _, c := range result.Contents {
+			name := strings.TrimPrefix(c.Key, prefix)
+			if strings.HasSuffix(name, backupArchiveSuffix) {
+				objects = append(objects, BackupObject{Name: name, Size: c.Size, ModTime: c.LastModified})
+			}
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sortBackupObjects(objects)
+	return objects, nil
+}
+
+func (s *s3Storage) Delete(ctx context.Context, name string) error {
+	resp, err := s.do(ctx, http.MethodDelete, s.key(name), nil, nil, 0, "")
+	if err != nil {
+		return fmt.Errorf("failed to delete %s: %v", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *s3Storage) Close() error { return nil }
+
+func (s *s3Storage) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.bucket, s.prefix)
+}
+
+// do sends a signed request for an object key, or for the bucket when key is empty.
+// Responses other than 2xx are returned as errors.
+func (s *s3Storage) do(ctx context.Context, method, key string, query url.Values, body io.ReadCloser, size int64, payloadHash string) (*http.Response, error) {
+	if payloadHash == "" {
+		payloadHash = sha256Hex(nil)
+	}
+	u := *s.endpoint
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = awsURIEncode(u.Path, false)
+	u.RawQuery = awsCanonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = size
+	s.sign(req, payloadHash, time.Now().UTC())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// sign adds the AWS Signature Version 4 authorization headers to a request
+func (s *s3Storage) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	if s.sessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, h := range headers {
+		value := req.Header.Get(h)
+		if h == "host" {
+			value = req.URL.Host
+		}
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", h, strings.TrimSpace(value))
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.region)
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	hmacSHA256 := func(key []byte, data string) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(data))
+		return mac.Sum(nil)
+	}
+	signingKey := hmacSHA256([]byte("AWS4"+s.secretKey), date)
+	signingKey = hmacSHA256(signingKey, s.region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// awsURIEncode percent-encodes everything but unreserved characters, as SigV4 requires.
+// Slashes are kept in paths.
+func awsURIEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsCanonicalQuery encodes query parameters sorted by name, as SigV4 requires
+func awsCanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, awsURIEncode(k, true)+"="+awsURIEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// pvcStorage keeps archives on a persistent volume claim through a helper pod that mounts
+// the claim. The pod is created on open and deleted on close.
+type pvcStorage struct {
+	namespace string
+	claim     string
+	dir       string
+	pod       string
+}
+
+// pvcMountPath is where the helper pod mounts the claim
+const pvcMountPath = "/backups"
+
+func newPVCStorage(ctx context.Context, namespace, claim, dir string) (*pvcStorage, error) {
+	if clientset == nil {
+		return nil, fmt.Errorf("PVC storage needs a Kubernetes cluster")
+	}
+	s := &pvcStorage{
+		namespace: namespace,
+		claim:     claim,
+		dir:       path.Join(pvcMountPath, dir),
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			// A generated name keeps concurrent backups of the same claim apart
+			GenerateName: "k8stoolbox-backup-",
+			Namespace:    namespace,
+			Labels:       map[string]string{"app.kubernetes.io/name": "k8stoolbox", "app.kubernetes.io/component": "backup-storage"},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:         "storage",
+				Image:        config2.BackupHelperImage,
+				Command:      []string{"sleep", "86400"},
+				VolumeMounts: []corev1.VolumeMount{{Name: "backups", MountPath: pvcMountPath}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name:         "backups",
+				VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+			}},
+		},
+	}
+	created, err := clientset.CoreV1().Pods(namespace).Create(ctx, pod, metav1.CreateOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage pod for claim %s/%s: %v", namespace, claim, err)
+	}
+	s.pod = created.Name
+
+	// Wait for the claim to be mounted
+	waitCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	for {
+		p, err := clientset.CoreV1().Pods(namespace).Get(waitCtx, s.pod, metav1.GetOptions{})
+		if err == nil && p.Status.Phase == corev1.PodRunning {
+			break
+		}
+		if err == nil && (p.Status.Phase == corev1.PodFailed || p.Status.Phase == corev1.PodSucceeded) {
+			s.Close()
+			return nil, fmt.Errorf("storage pod for claim %s/%s stopped: %s", namespace, claim, p.Status.Phase)
+		}
+		select {
+		case <-waitCtx.Done():
+			s.Close()
+			return nil, fmt.Errorf("storage pod for claim %s/%s did not start in time; is the claim bound and mountable?", namespace, claim)
+		case <-time.After(2 * time.Second):
+		}
+	}
+	return s, nil
+}
+
+func (s *pvcStorage) exec(ctx context.Context, script string, args []string, stdin io.Reader, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	command := append([]string{"sh", "-c", script, "sh"}, args...)
+	if err := execInPod(ctx, s.namespace, s.pod, "storage", command, stdin, stdout, &stderr); err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (s *pvcStorage) Put(ctx context.Context, name string, r io.ReadSeeker) error {
+	err := s.exec(ctx, `mkdir -p "$1" && cat > "$1/.$2.tmp" && mv "$1/.$2.tmp" "$1/$2"`, []string{s.dir, name}, r, io.Discard)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *pvcStorage) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(s.exec(ctx, `cat "$1"`, []string{path.Join(s.dir, name)}, nil, writer))
+	}()
+	return reader, nil
+}
+
+func (s *pvcStorage) List(ctx context.Context) ([]BackupObject, error) {
+	var out bytes.Buffer
+	script := `cd "$1" 2>/dev/null || exit 0; find . -type f -name '*` + backupArchiveSuffix + `' -exec stat -c '%s %Y %n' {} +`
+	if err := s.exec(ctx, script, []string{s.dir}, nil, &out); err != nil {
+		return nil, fmt.Errorf("failed to list backups: %v", err)
+	}
+	var objects []BackupObject
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		size, _ := strconv.ParseInt(fields[0], 10, 64)
+		modTime, _ := strconv.ParseInt(fields[1], 10, 64)
+		objects = append(objects, BackupObject{Name: strings.TrimPrefix(fields[2], "./"), Size: size, ModTime: time.Unix(modTime, 0)})
+	}
+	sortBackupObjects(objects)
+	return objects, nil
+}
+
+func (s *pvcStorage) Delete(ctx context.Context, name string) error {
+	if err := s.exec(ctx, `rm -f "$1"`, []string{path.Join(s.dir, name)}, nil, io.Discard); err != nil {
+		return fmt.Errorf("failed to delete %s: %v", name, err)
+	}
+	return nil
+}
+
+func (s *pvcStorage) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err := clientset.CoreV1().Pods(s.namespace).Delete(ctx, s.pod, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete storage pod %s: %v", s.pod, err)
+	}
+	return nil
+}
+
+func (s *pvcStorage) String() string {
+	return fmt.Sprintf("pvc://%s/%s%s", s.namespace, s.claim, strings.TrimPrefix(s.dir, pvcMountPath))
+}
+
+// RetentionPolicy selects the backups to keep: the newest KeepLast, plus the newest backup of
+// each of the last KeepDaily days and KeepWeekly ISO weeks that have one
+type RetentionPolicy struct {
+	KeepLast   int `json:"keepLast,omitempty"`
+	KeepDaily  int `json:"keepDaily,omitempty"`
+	KeepWeekly int `json:"keepWeekly,omitempty"`
+}
+
+// Enabled reports whether the policy removes anything
+func (p RetentionPolicy) Enabled() bool {
+	return p.KeepLast > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0
+}
+
+// Expired returns the backups, sorted newest first, that the policy does not keep
+func (p RetentionPolicy) Expired(backups []BackupObject) []BackupObject {
+	if !p.Enabled() {
+		return nil
+	}
+	keep := make(map[string]bool)
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for i, b := range backups {
+		if i < p.KeepLast {
+			keep[b.Name] = true
+		}
+		day := b.ModTime.Format("2006-01-02")
+		if !days[day] && len(days) < p.KeepDaily {
+			days[day] = true
+			keep[b.Name] = true
+		}
+		year, week := b.ModTime.ISOWeek()
+		weekKey := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[weekKey] && len(weeks) < p.KeepWeekly {
+			weeks[weekKey] = true
+			keep[b.Name] = true
+		}
+	}
+
+	var expired []BackupObject
+	for _, b := range backups {
+		if !keep[b.Name] {
+			expired = append(expired, b)
+		}
+	}
+	return expired
+}
+
+// pruneBackups deletes the archives whose names start with prefix that the policy does not
+// keep. The prefix keeps backups and bundles sharing a location apart.
+func pruneBackups(ctx context.Context, storage BackupStorage, policy RetentionPolicy, prefix string, dryRun bool) ([]BackupObject, error) {
+	all, err := storage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var backups []BackupObject
+	for _, b := range all {
+		if strings.HasPrefix(b.Name, prefix) {
+			backups = append(backups, b)
+		}
+	}
+	expired := policy.Expired(backups)
+	if dryRun {
+		return expired, nil
+	}
+	for _, b := range expired {
+		if err := storage.Delete(ctx, b.Name); err != nil {
+			return nil, err
+		}
+	}
+	return expired, nil
+}
+
+// retentionFlags registers the flags of a retention policy
+func retentionFlags(cmd *flag.FlagSet) *RetentionPolicy {
+	policy := &RetentionPolicy{}
+	cmd.IntVar(&policy.KeepLast, "keep-last", 0, "Keep the newest N backups (0 keeps all unless another -keep flag is set)")
+	cmd.IntVar(&policy.KeepDaily, "keep-daily", 0, "Keep the newest backup of each of the last N days")
+	cmd.IntVar(&policy.KeepWeekly, "keep-weekly", 0, "Keep the newest backup of each of the last N weeks")
+	return policy
+}
+
+// storageFlag registers the flag selecting the backup location
+func storageFlag(cmd *flag.FlagSet) *string {
+	return cmd.String("storage", config2.BackupStorage, "Backup location: a directory, s3://bucket/prefix or pvc://namespace/claim/dir")
+}
+
+// resolveBackupName finds an archive in the storage; "latest" selects the newest one
+func resolveBackupName(ctx context.Context, storage BackupStorage, name string) (string, error) {
+	if name != "latest" {
+		return name, nil
+	}
+	backups, err := storage.List(ctx)
+	if err != nil {
+		return "", err
+	}
+	if len(backups) == 0 {
+		return "", fmt.Errorf("no backups found in %s", storage)
+	}
+	return backups[0].Name, nil
+}
+
+// formatBytes renders a size in binary units
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
+
+// runBackupList lists the archives of a backup location
+func runBackupList(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("backup list", flag.ExitOnError)
+	location := storageFlag(cmd)
+	if err := cmd.Parse(args); err != nil {
+		return
+	}
+
+	if err := listBackups(ctx, *location); err != nil {
+		logger.Fatalf("%v", err)
+	}
+}
+
+// listBackups prints the archives of a backup location
+func listBackups(ctx context.Context, location string) error {
+	storage, err := openBackupStorage(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	backups, err := storage.List(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%-50s %-10s %s\n", "NAME", "SIZE", "CREATED")
+	fmt.Println(strings.Repeat("-", 85))
+	for _, b := range backups {
+		fmt.Printf("%-50s %-10s %s\n", b.Name, formatBytes(b.Size), b.ModTime.Format(time.RFC3339))
+	}
+	fmt.Printf("\n%d backups in %s\n", len(backups), storage)
+	return nil
+}
+
+// runBackupPrune applies a retention policy to a backup location
+func runBackupPrune(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("backup prune", flag.ExitOnError)
+	location := storageFlag(cmd)
+	policy := retentionFlags(cmd)
+	prefix := cmd.String("prefix", backupNamePrefix, "Only prune archives whose names start with this prefix")
+	dryRun := cmd.Bool("dry-run", false, "Show which backups would be deleted")
+	if err := cmd.Parse(args); err != nil {
+		return
+	}
+	if !policy.Enabled() {
+		logger.Fatalf("Please specify a retention policy with -keep-last, -keep-daily or -keep-weekly")
+	}
+
+	if err := pruneBackupLocation(ctx, *location, *policy, *prefix, *dryRun); err != nil {
+		logger.Fatalf("Failed to prune backups: %v", err)
+	}
+}
+
+// pruneBackupLocation applies a retention policy to a backup location and reports the result
+func pruneBackupLocation(ctx context.Context, location string, policy RetentionPolicy, prefix string, dryRun bool) error {
+	storage, err := openBackupStorage(ctx, location)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	expired, err := pruneBackups(ctx, storage, policy, prefix, dryRun)
+	if err != nil {
+		return err
+	}
+	for _, b := range expired {
+		logger.Printf("%s %s (%s)", conditionalString(dryRun, "Would delete", "Deleted"), b.Name, b.ModTime.Format(time.RFC3339))
+	}
+	logger.Printf("✅ %d backups %s from %s", len(expired), conditionalString(dryRun, "would be deleted", "deleted"), storage)
+	return nil
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStoragePut(t *testing.T) {
+	ctx := context.Background()
+	for _, name := range []string{"backup.tar.gz", "nightly/backup.tar.gz"} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			storage, err := newLocalStorage(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.Put(ctx, name, strings.NewReader("archive")); err != nil {
+				t.Fatal(err)
+			}
+
+			archive, err := storage.Get(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer archive.Close()
+			data, err := io.ReadAll(archive)
+			if err != nil || string(data) != "archive" {
+				t.Errorf("unexpected content %q: %v", data, err)
+			}
+
+			// No temporary files are left next to the archive
+			entries, err := os.ReadDir(filepath.Dir(filepath.Join(dir, name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("expected only the archive, found %d files", len(entries))
+			}
+		})
+	}
+}
+
+func TestLocalStorageListNested(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	storage, err := newLocalStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"k8stoolbox-backup-1.tar.gz", "nightly/backup-2.tar.gz", "nightly/eu/backup-3.tar.gz"} {
+		if err := storage.Put(ctx, name, strings.NewReader("archive")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "nightly", "notes.txt"), []byte("not a backup"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := storage.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range backups {
+		names = append(names, b.Name)
+	}
+	sort.Strings(names)
+	if strings.Join(names, ",") != "k8stoolbox-backup-1.tar.gz,nightly/backup-2.tar.gz,nightly/eu/backup-3.tar.gz" {
+		t.Fatalf("unexpected backups %v", names)
+	}
+
+	// Listed names can be deleted, so retention reaches nested backups
+	expired, err := pruneBackups(ctx, storage, RetentionPolicy{KeepLast: 1}, "nightly/", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 {
+		t.Fatalf("expected 1 expired nested backup, got %+v", expired)
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(expired[0].Name))); !os.IsNotExist(err) {
+		t.Errorf("expired backup %s was not deleted: %v", expired[0].Name, err)
+	}
+}
+
+// fakeS3 is an in-memory S3 bucket that verifies the AWS Signature Version 4 of every request
+// independently of the client's signing code
+type fakeS3 struct {
+	bucket    string
+	accessKey string
+	secretKey string
+	region    string
+	pageSize  int
+	objects   map[string][]byte
+	// rejected holds the verification errors of refused requests
+	rejected []error
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := f.verify(r, body); err != nil {
+		f.rejected = append(f.rejected, fmt.Errorf("%s %s: %v", r.Method, r.URL, err))
+		http.Error(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>", http.StatusForbidden)
+		return
+	}
+
+	path, err := url.PathUnescape(r.URL.EscapedPath())
+	if err != nil || !strings.HasPrefix(path, "/"+f.bucket) {
+		http.Error(w, "<Error><Code>NoSuchBucket</Code></Error>", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(path, "/"+f.bucket), "/")
+
+	switch {
+	case key == "" && r.Method == http.MethodGet:
+		f.list(w, r.URL.Query())
+	case r.Method == http.MethodPut:
+		f.objects[key] = body
+	case r.Method == http.MethodGet:
+		data, ok := f.objects[key]
+		if !ok {
+			http.Error(w, "<Error><Code>NoSuchKey</Code></Error>", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unsupported", http.StatusMethodNotAllowed)
+	}
+}
+
+// list answers ListObjectsV2 with pages of pageSize keys
+func (f *fakeS3) list(w http.ResponseWriter, query url.Values) {
+	if query.Get("list-type") != "2" {
+		http.Error(w, "only ListObjectsV2 is supported", http.StatusBadRequest)
+		return
+	}
+	var keys []string
+	for key := range f.objects {
+		if strings.HasPrefix(key, query.Get("prefix")) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	start := 0
+	if token := query.Get("continuation-token"); token != "" {
+		start, _ = strconv.Atoi(token)
+	}
+	end := start + f.pageSize
+	if end > len(keys) {
+		end = len(keys)
+	}
+
+	fmt.Fprint(w, "<ListBucketResult>")
+	for i, key := range keys[start:end] {
+		modTime := time.Date(2024, 1, 1, 0, 0, start+i, 0, time.UTC)
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents>",
+			key, modTime.Format(time.RFC3339), len(f.objects[key]))
+	}
+	if end < len(keys) {
+		fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+// verify rebuilds the canonical request from what the server received and checks the signature
+func (f *fakeS3) verify(r *http.Request, body []byte) error {
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if sum := sha256.Sum256(body); payloadHash != hex.EncodeToString(sum[:]) {
+		return fmt.Errorf("payload hash %s does not match the body", payloadHash)
+	}
+
+	auth := strings.TrimPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 ")
+	fields := make(map[string]string)
+	for _, part := range strings.Split(auth, ", ") {
+		name, value, _ := strings.Cut(part, "=")
+		fields[name] = value
+	}
+	amzDate := r.Header.Get("X-Amz-Date")
+	if len(amzDate) != 16 {
+		return fmt.Errorf("invalid X-Amz-Date %q", amzDate)
+	}
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", amzDate[:8], f.region)
+	if fields["Credential"] != f.accessKey+"/"+scope {
+		return fmt.Errorf("unexpected credential %q", fields["Credential"])
+	}
+
+	signed := strings.Split(fields["SignedHeaders"], ";")
+	for _, required := range []string{"host", "x-amz-content-sha256", "x-amz-date"} {
+		if !containsString(signed, required) {
+			return fmt.Errorf("header %s is not signed", required)
+		}
+	}
+	var headers strings.Builder
+	for _, name := range signed {
+		value := r.Header.Get(name)
+		if name == "host" {
+			value = r.Host
+		}
+		fmt.Fprintf(&headers, "%s:%s\n", name, strings.TrimSpace(value))
+	}
+
+	// Canonical query: names and values sorted, RFC 3986 encoded with %20 for spaces
+	query := r.URL.Query()
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, strings.ReplaceAll(url.QueryEscape(name)+"="+url.QueryEscape(value), "+", "%20"))
+		}
+	}
+	sort.Strings(params)
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		strings.Join(params, "&"),
+		headers.String(),
+		fields["SignedHeaders"],
+		payloadHash,
+	}, "\n")
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := []byte("AWS4" + f.secretKey)
+	for _, part := range []string{amzDate[:8], f.region, "s3", "aws4_request", stringToSign} {
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(part))
+		key = mac.Sum(nil)
+	}
+	if expected := hex.EncodeToString(key); fields["Signature"] != expected {
+		return fmt.Errorf("signature does not match; canonical request:\n%s", canonicalRequest)
+	}
+	return nil
+}
+
+func TestS3Storage(t *testing.T) {
+	fake := &fakeS3{
+		bucket:    "backups",
+		accessKey: "AKIDEXAMPLE",
+		secretKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+		region:    "eu-west-1",
+		pageSize:  2,
+		objects:   map[string][]byte{"other/k8stoolbox-backup-0.tar.gz": []byte("other prefix")},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	endpoint, _ := url.Parse(server.URL)
+
+	storage := &s3Storage{
+		endpoint:     endpoint,
+		region:       fake.region,
+		bucket:       fake.bucket,
+		prefix:       "prod/cluster a",
+		accessKey:    fake.accessKey,
+		secretKey:    fake.secretKey,
+		sessionToken: "session+token/=",
+		client:       server.Client(),
+	}
+	ctx := context.Background()
+
+	names := []string{"k8stoolbox-backup-1.tar.gz", "k8stoolbox-backup-2.tar.gz", "nightly backup (3).tar.gz"}
+	for _, name := range names {
+		if err := storage.Put(ctx, name, strings.NewReader("archive "+name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := fake.objects["prod/cluster a/nightly backup (3).tar.gz"]; !ok {
+		t.Fatalf("object stored under an unexpected key: %v", fake.objects)
+	}
+
+	backups, err := storage.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("expected 3 backups across pages, got %+v", backups)
+	}
+	if backups[0].Name != "nightly backup (3).tar.gz" || backups[0].Size != int64(len("archive nightly backup (3).tar.gz")) {
+		t.Errorf("expected the newest backup first, got %+v", backups[0])
+	}
+
+	archive, err := storage.Get(ctx, names[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(archive)
+	archive.Close()
+	if err != nil || string(data) != "archive "+names[2] {
+		t.Errorf("unexpected content %q: %v", data, err)
+	}
+
+	if err := storage.Delete(ctx, names[0]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := storage.Get(ctx, names[0]); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("expected a 404 for a deleted backup, got %v", err)
+	}
+	if backups, err := storage.List(ctx); err != nil || len(backups) != 2 {
+		t.Errorf("expected 2 backups after the delete, got %d: %v", len(backups), err)
+	}
+	for _, err := range fake.rejected {
+		t.Errorf("request refused: %v", err)
+	}
+
+	// A wrong secret must not pass the fake's verification
+	forged := *storage
+	forged.secretKey = "wrong"
+	fake.rejected = nil
+	if _, err := forged.List(ctx); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("expected a 403 for a wrong secret, got %v", err)
+	}
+	if len(fake.rejected) != 1 {
+		t.Errorf("expected the forged request to be refused, got %v", fake.rejected)
+	}
+}
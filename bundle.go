@@ -0,0 +1,491 @@
+// Diagnostic support bundles with objects, logs, health results and resource reports.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+	"unicode/utf8"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+// redactedValue replaces sensitive values in bundles
+const redactedValue = "REDACTED"
+
+// lastAppliedAnnotation holds the full applied manifest, including values that are redacted elsewhere
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// sensitiveEnvPattern matches environment variable names whose literal values are redacted
+var sensitiveEnvPattern = regexp.MustCompile(`(?i)(password|passwd|secret|token|api[_-]?key|access[_-]?key|private[_-]?key|credential)`)
+
+// BundleOptions selects what goes into a support bundle
+type BundleOptions struct {
+	Namespaces []string
+	Logs       bool
+	LogTail    int64
+	Redact     bool
+	// MaxBytes is the budget for the uncompressed content; zero means unlimited
+	MaxBytes int64
+}
+
+// BundleEntry describes a file of the bundle in its manifest
+type BundleEntry struct {
+	Path      string `json:"path"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Bytes     int64  `json:"bytes"`
+	Truncated bool   `json:"truncated,omitempty"`
+	Skipped   string `json:"skipped,omitempty"`
+}
+
+// BundleManifest is the index written to manifest.json at the root of the bundle
+type BundleManifest struct {
+	CreatedAt     time.Time     `json:"createdAt"`
+	Version       string        `json:"version"`
+	ServerVersion string        `json:"serverVersion,omitempty"`
+	Namespaces    []string      `json:"namespaces"`
+	Redacted      bool          `json:"redacted"`
+	MaxBytes      int64         `json:"maxBytes,omitempty"`
+	TotalBytes    int64         `json:"totalBytes"`
+	Entries       []BundleEntry `json:"entries"`
+	Errors        []string      `json:"errors,omitempty"`
+}
+
+// bundleResource is an object type collected into the bundle
+type bundleResource struct {
+	name string
+	list func(ctx context.Context, namespace string) (runtime.Object, error)
+}
+
+// namespacedBundleResources are collected for every bundled namespace
+var namespacedBundleResources = []bundleResource{
+	{"pods", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.CoreV1().Pods(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"deployments", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.AppsV1().Deployments(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"replicasets", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.AppsV1().ReplicaSets(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"statefulsets", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.AppsV1().StatefulSets(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"daemonsets", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.AppsV1().DaemonSets(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"jobs", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.BatchV1().Jobs(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"cronjobs", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.BatchV1().CronJobs(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"services", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.CoreV1().Services(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"endpoints", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.CoreV1().Endpoints(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"events", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.CoreV1().Events(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"networkpolicies", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.NetworkingV1().NetworkPolicies(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"persistentvolumeclaims", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.CoreV1().PersistentVolumeClaims(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"resourcequotas", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.CoreV1().ResourceQuotas(ns).List(ctx, metav1.ListOptions{})
+	}},
+	{"secrets", func(ctx context.Context, ns string) (runtime.Object, error) {
+		return clientset.CoreV1().Secrets(ns).List(ctx, metav1.ListOptions{})
+	}},
+}
+
+// bundleWriter writes entries into a tar.gz archive within the size budget
+type bundleWriter struct {
+	file     *os.File
+	archive  *tarGzWriter
+	root     string
+	manifest BundleManifest
+}
+
+func newBundleWriter(filename, root string, manifest BundleManifest) (*bundleWriter, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %v", err)
+	}
+	return &bundleWriter{file: file, archive: newTarGzWriter(file, manifest.CreatedAt), root: root, manifest: manifest}, nil
+}
+
+// add writes a file unless the budget is exhausted. Truncatable content such as logs
+// keeps its most recent part when only some of the budget is left.
+func (b *bundleWriter) add(entry BundleEntry, data []byte, truncatable bool) error {
+	if remaining := b.manifest.MaxBytes - b.manifest.TotalBytes; b.manifest.MaxBytes > 0 && int64(len(data)) > remaining {
+		var ok bool
+		if truncatable {
+			data, ok = truncateToBudget(data, remaining)
+		}
+		if !ok {
+			entry.Skipped = "size budget exceeded"
+			b.manifest.Entries = append(b.manifest.Entries, entry)
+			return nil
+		}
+		entry.Truncated = true
+	}
+
+	entry.Bytes = int64(len(data))
+	if err := b.write(entry.Path, data); err != nil {
+		return err
+	}
+	b.manifest.TotalBytes += entry.Bytes
+	b.manifest.Entries = append(b.manifest.Entries, entry)
+	return nil
+}
+
+// truncationMarker starts content whose beginning was cut to fit the size budget
+const truncationMarker = "[earlier content truncated to fit the bundle size budget]\n"
+
+// truncateToBudget keeps the most recent part of data that fits in budget bytes together with
+// the truncation marker. The kept part starts at a line boundary, or at a character boundary
+// when the last line alone is too long.
+func truncateToBudget(data []byte, budget int64) ([]byte, bool) {
+	if int64(len(data)) <= budget {
+		return data, true
+	}
+	available := budget - int64(len(truncationMarker))
+	if available <= 0 {
+		return nil, false
+	}
+	start := len(data) - int(available)
+	tail := data[start:]
+	// Look for a line end from the byte before the cut, which keeps a line starting right at
+	// the cut; a newline as the last byte only ends the final line and is no boundary
+	if i := bytes.IndexByte(data[start-1:len(data)-1], '\n'); i >= 0 {
+		tail = data[start+i:]
+	} else {
+		for len(tail) > 0 && !utf8.RuneStart(tail[0]) {
+			tail = tail[1:]
+		}
+	}
+	if len(tail) == 0 {
+		return nil, false
+	}
+	return append([]byte(truncationMarker), tail...), true
+}
+
+func (b *bundleWriter) write(name string, data []byte) error {
+	return b.archive.WriteFile(path.Join(b.root, name), data)
+}
+
+// errorf records a collection error in the manifest; bundles are best effort
+func (b *bundleWriter) errorf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	logger.Printf("⚠️ %s", message)
+	b.manifest.Errors = append(b.manifest.Errors, message)
+}
+
+// close writes the manifest and finishes the archive
+func (b *bundleWriter) close() error {
+	data, err := json.MarshalIndent(b.manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err := b.write("manifest.json", data); err != nil {
+		return err
+	}
+	if err := b.archive.Close(); err != nil {
+		return err
+	}
+	return b.file.Close()
+}
+
+// objectListYAML renders a typed list as a kubectl-style v1 List, with kinds set, managed
+// fields dropped and sensitive values redacted
+func objectListYAML(list runtime.Object, redact bool) ([]byte, error) {
+	var items []runtime.Object
+	err := meta.EachListItem(list, func(obj runtime.Object) error {
+		if gvks, _, err := scheme.Scheme.ObjectKinds(obj); err == nil && len(gvks) > 0 {
+			obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+		}
+		if accessor, err := meta.Accessor(obj); err == nil {
+			accessor.SetManagedFields(nil)
+			if redact {
+				annotations := accessor.GetAnnotations()
+				delete(annotations, lastAppliedAnnotation)
+				accessor.SetAnnotations(annotations)
+			}
+		}
+		if redact {
+			redactObject(obj)
+		}
+		items = append(items, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return yaml.Marshal(map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "List",
+		"items":      items,
+	})
+}
+
+// redactObject replaces secret data and sensitive literal environment values
+func redactObject(obj runtime.Object) {
+	switch o := obj.(type) {
+	case *corev1.Secret:
+		for key := range o.Data {
+			o.Data[key] = []byte(redactedValue)
+		}
+		for key := range o.StringData {
+			o.StringData[key] = redactedValue
+		}
+	case *corev1.Pod:
+		redactPodSpec(&o.Spec)
+	case *appsv1.Deployment:
+		redactPodSpec(&o.Spec.Template.Spec)
+	case *appsv1.ReplicaSet:
+		redactPodSpec(&o.Spec.Template.Spec)
+	case *appsv1.StatefulSet:
+		redactPodSpec(&o.Spec.Template.Spec)
+	case *appsv1.DaemonSet:
+		redactPodSpec(&o.Spec.Template.Spec)
+	case *batchv1.Job:
+		redactPodSpec(&o.Spec.Template.Spec)
+	case *batchv1.CronJob:
+		redactPodSpec(&o.Spec.JobTemplate.Spec.Template.Spec)
+	}
+}
+
+// redactPodSpec redacts literal values of environment variables with sensitive names
+func redactPodSpec(spec *corev1.PodSpec) {
+	redact := func(env []corev1.EnvVar) {
+		for i := range env {
+			if env[i].Value != "" && sensitiveEnvPattern.MatchString(env[i].Name) {
+				env[i].Value = redactedValue
+			}
+		}
+	}
+	for i := range spec.InitContainers {
+		redact(spec.InitContainers[i].Env)
+	}
+	for i := range spec.Containers {
+		redact(spec.Containers[i].Env)
+	}
+	for i := range spec.EphemeralContainers {
+		redact(spec.EphemeralContainers[i].Env)
+	}
+}
+
+// createBundle collects the bundle contents, objects and reports first so that logs
+// are what gets truncated or skipped when the size budget runs out
+func createBundle(ctx context.Context, filename string, opts BundleOptions) (BundleManifest, error) {
+	now := time.Now()
+	manifest := BundleManifest{
+		CreatedAt:  now,
+		Version:    Version,
+		Namespaces: opts.Namespaces,
+		Redacted:   opts.Redact,
+		MaxBytes:   opts.MaxBytes,
+		Entries:    []BundleEntry{},
+	}
+	root := "k8stoolbox-bundle-" + now.Format("20060102-150405")
+	b, err := newBundleWriter(filename, root, manifest)
+	if err != nil {
+		return manifest, err
+	}
+	defer b.file.Close()
+	if info, err := clientset.Discovery().ServerVersion(); err == nil {
+		b.manifest.ServerVersion = info.GitVersion
+	}
+
+	addJSON := func(entry BundleEntry, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return b.add(entry, data, false)
+	}
+
+	// Cluster-scoped objects and node health
+	nodes, err := clientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		b.errorf("failed to list nodes: %v", err)
+	} else {
+		var statuses []NodeHealthStatus
+		for i := range nodes.Items {
+			statuses = append(statuses, evaluateNode(&nodes.Items[i]))
+		}
+		if err := addJSON(BundleEntry{Path: "cluster/node-health.json", Kind: "health"}, statuses); err != nil {
+			return b.manifest, err
+		}
+		data, err := objectListYAML(nodes, opts.Redact)
+		if err != nil {
+			return b.manifest, err
+		}
+		if err := b.add(BundleEntry{Path: "cluster/nodes.yaml", Kind: "objects"}, data, false); err != nil {
+			return b.manifest, err
+		}
+	}
+
+	podsByNamespace := make(map[string][]corev1.Pod)
+	for _, namespace := range opts.Namespaces {
+		dir := path.Join("namespaces", namespace)
+		var workloads []WorkloadHealthStatus
+		for _, res := range namespacedBundleResources {
+			list, err := res.list(ctx, namespace)
+			if err != nil {
+				b.errorf("failed to list %s in %s: %v", res.name, namespace, err)
+				continue
+			}
+			// Evaluate before redaction, which changes the objects in place
+			switch l := list.(type) {
+			case *corev1.PodList:
+				podsByNamespace[namespace] = l.DeepCopy().Items
+			case *appsv1.DeploymentList:
+				for i := range l.Items {
+					workloads = append(workloads, evaluateDeployment(&l.Items[i], defaultHealthThresholds))
+				}
+			case *appsv1.StatefulSetList:
+				for i := range l.Items {
+					workloads = append(workloads, evaluateStatefulSet(&l.Items[i], defaultHealthThresholds))
+				}
+			case *appsv1.DaemonSetList:
+				for i := range l.Items {
+					workloads = append(workloads, evaluateDaemonSet(&l.Items[i], defaultHealthThresholds))
+				}
+			}
+			data, err := objectListYAML(list, opts.Redact)
+			if err != nil {
+				return b.manifest, fmt.Errorf("failed to encode %s: %v", res.name, err)
+			}
+			entry := BundleEntry{Path: path.Join(dir, res.name+".yaml"), Kind: "objects", Namespace: namespace}
+			if err := b.add(entry, data, false); err != nil {
+				return b.manifest, err
+			}
+		}
+
+		pods := podsByNamespace[namespace]
+		health := summarizePodHealth(namespace, pods, defaultHealthThresholds)
+		health.Workloads = workloads
+		if err := addJSON(BundleEntry{Path: path.Join(dir, "health.json"), Kind: "health", Namespace: namespace}, health); err != nil {
+			return b.manifest, err
+		}
+		if err := addJSON(BundleEntry{Path: path.Join(dir, "resources.json"), Kind: "resources", Namespace: namespace}, summarizePodResources(pods)); err != nil {
+			return b.manifest, err
+		}
+	}
+
+	if opts.Logs {
+		for _, namespace := range opts.Namespaces {
+			var sources []LogSource
+			for _, pod := range podsByNamespace[namespace] {
+				sources = append(sources, podLogSources(pod, true)...)
+			}
+			logs := collectLogs(ctx, sources, LogAggregateOptions{TailLines: opts.LogTail})
+			for _, l := range logs {
+				if l.Err != nil {
+					b.errorf("failed to read logs of %s: %v", l.Source, l.Err)
+				}
+			}
+			files, names, err := podLogFiles(logs, true)
+			if err != nil {
+				return b.manifest, err
+			}
+			for _, name := range names {
+				entry := BundleEntry{Path: path.Join("namespaces", namespace, "logs", path.Base(name)), Kind: "logs", Namespace: namespace}
+				if err := b.add(entry, files[name], true); err != nil {
+					return b.manifest, err
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(b.manifest.Entries, func(i, j int) bool { return b.manifest.Entries[i].Path < b.manifest.Entries[j].Path })
+	return b.manifest, b.close()
+}
+
+// runBundleCommand creates a diagnostic support bundle
+func runBundleCommand(ctx context.Context, args []string) {
+	bundleCmd := flag.NewFlagSet("bundle", flag.ExitOnError)
+	namespaces := bundleCmd.String("namespaces", "default", "Comma-separated namespaces to include")
+	allNamespaces := bundleCmd.Bool("all-namespaces", false, "Include every namespace")
+	output := bundleCmd.String("output", "", "Bundle file (default: k8stoolbox-bundle-<timestamp>.tar.gz)")
+	logs := bundleCmd.Bool("logs", true, "Include container logs, including previous instances")
+	logTail := bundleCmd.Int64("log-tail", 1000, "Number of most recent log lines per container (-1 for all)")
+	noRedact := bundleCmd.Bool("no-redact", false, "Keep secret data and sensitive environment values (not recommended when sharing)")
+	maxSize := bundleCmd.String("max-size", "100Mi", "Budget for the uncompressed bundle content (e.g. 50Mi, 0 for unlimited)")
+	if err := bundleCmd.Parse(args); err != nil {
+		return
+	}
+
+	budget, err := resource.ParseQuantity(*maxSize)
+	if err != nil {
+		logger.Fatalf("Invalid -max-size %q: %v", *maxSize, err)
+	}
+
+	opts := BundleOptions{
+		Namespaces: splitList(*namespaces),
+		Logs:       *logs,
+		LogTail:    *logTail,
+		Redact:     !*noRedact,
+		MaxBytes:   budget.Value(),
+	}
+	if *allNamespaces {
+		list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+		if err != nil {
+			logger.Fatalf("Failed to list namespaces: %v", err)
+		}
+		opts.Namespaces = nil
+		for _, ns := range list.Items {
+			opts.Namespaces = append(opts.Namespaces, ns.Name)
+		}
+	}
+
+	filename := *output
+	if filename == "" {
+		filename = fmt.Sprintf("k8stoolbox-bundle-%s.tar.gz", time.Now().Format("20060102-150405"))
+	}
+
+	manifest, err := createBundle(ctx, filename, opts)
+	if err != nil {
+		logger.Fatalf("Failed to create bundle: %v", err)
+	}
+
+	skipped := 0
+	for _, entry := range manifest.Entries {
+		if entry.Skipped != "" {
+			skipped++
+		}
+	}
+	logger.Printf("✅ Bundle written to %s (%d files, %d bytes uncompressed)", filename, len(manifest.Entries)-skipped, manifest.TotalBytes)
+	if skipped > 0 {
+		logger.Printf("⚠️ %d files were skipped to stay within the %s size budget", skipped, *maxSize)
+	}
+	if len(manifest.Errors) > 0 {
+		logger.Printf("⚠️ %d collection errors, see manifest.json", len(manifest.Errors))
+	}
+	if !opts.Redact {
+		logger.Println("⚠️ The bundle contains unredacted secrets")
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func sensitivePodSpec() corev1.PodSpec {
+	env := func() []corev1.EnvVar {
+		return []corev1.EnvVar{{Name: "DB_PASSWORD", Value: "hunter2"}, {Name: "LOG_LEVEL", Value: "debug"}}
+	}
+	return corev1.PodSpec{
+		InitContainers: []corev1.Container{{Name: "init", Env: env()}},
+		Containers:     []corev1.Container{{Name: "app", Env: env()}},
+		EphemeralContainers: []corev1.EphemeralContainer{{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug", Env: env()},
+		}},
+	}
+}
+
+func TestObjectListYAMLRedactsPodSpecs(t *testing.T) {
+	meta := metav1.ObjectMeta{Name: "app", Namespace: "shop"}
+	template := corev1.PodTemplateSpec{Spec: sensitivePodSpec()}
+	tests := []struct {
+		name string
+		list runtime.Object
+	}{
+		{"pod", &corev1.PodList{Items: []corev1.Pod{{ObjectMeta: meta, Spec: sensitivePodSpec()}}}},
+		{"job", &batchv1.JobList{Items: []batchv1.Job{{ObjectMeta: meta, Spec: batchv1.JobSpec{Template: template}}}}},
+		{"cronjob", &batchv1.CronJobList{Items: []batchv1.CronJob{{ObjectMeta: meta, Spec: batchv1.CronJobSpec{
+			JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: template}},
+		}}}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := objectListYAML(tt.list, true)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(data)
+			if strings.Contains(out, "hunter2") {
+				t.Errorf("sensitive value was not redacted:\n%s", out)
+			}
+			if count := strings.Count(out, redactedValue); count != 3 {
+				t.Errorf("expected 3 redacted values (init, app and ephemeral containers), got %d:\n%s", count, out)
+			}
+			if !strings.Contains(out, "debug") {
+				t.Errorf("non-sensitive value was redacted:\n%s", out)
+			}
+		})
+	}
+}
+
+func TestTruncateToBudget(t *testing.T) {
+	marker := len(truncationMarker)
+	lines := strings.Repeat("older line\n", 10) + "first line\nsecond line\nthird\n"
+	tests := []struct {
+		name   string
+		data   string
+		budget int
+		want   string
+		ok     bool
+	}{
+		{
+			name:   "cuts at a line boundary",
+			data:   lines,
+			budget: marker + 15,
+			want:   truncationMarker + "third\n",
+			ok:     true,
+		},
+		{
+			name:   "keeps whole lines that fit exactly",
+			data:   lines,
+			budget: marker + 18,
+			want:   truncationMarker + "second line\nthird\n",
+			ok:     true,
+		},
+		{
+			name:   "single long line is cut at a character boundary",
+			data:   strings.Repeat("ä", 60),
+			budget: marker + 5,
+			want:   truncationMarker + "ää",
+			ok:     true,
+		},
+		{
+			name:   "final newline is not a boundary",
+			data:   strings.Repeat("very ", 30) + "long line\n",
+			budget: marker + 5,
+			want:   truncationMarker + "line\n",
+			ok:     true,
+		},
+		{
+			name:   "fits without truncation",
+			data:   "short\n",
+			budget: 6,
+			want:   "short\n",
+			ok:     true,
+		},
+		{
+			name:   "no room for the marker",
+			data:   lines,
+			budget: marker,
+			ok:     false,
+		},
+		{
+			name:   "no complete character fits",
+			data:   strings.Repeat("€", 40),
+			budget: marker + 2,
+			ok:     false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := truncateToBudget([]byte(tt.data), int64(tt.budget))
+			if ok != tt.ok || string(got) != tt.want {
+				t.Errorf("got %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+			if len(got) > tt.budget {
+				t.Errorf("%d bytes exceed the budget of %d", len(got), tt.budget)
+			}
+		})
+	}
+}
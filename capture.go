@@ -0,0 +1,328 @@
+// Packet capture through ephemeral containers attached to a target pod.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	// captureSetupTimeout bounds how long we wait for the ephemeral container to start
+	captureSetupTimeout = 2 * time.Minute
+	// maxCaptureDuration and maxCaptureBytes bound captures requested through the API
+	maxCaptureDuration = 10 * time.Minute
+	maxCaptureBytes    = 1 << 30
+	// defaultCaptureImage is the toolbox image, which ships tcpdump
+	defaultCaptureImage = "narmidm/k8stoolbox:latest"
+)
+
+// errCaptureSizeLimit is returned by captureWriter once the size cap has been reached
+var errCaptureSizeLimit = errors.New("capture size limit reached")
+
+// CaptureOptions controls a packet capture run
+type CaptureOptions struct {
+	Namespace string
+	Pod       string
+	Image     string
+	Interface string
+	Filter    string
+	Duration  time.Duration
+	Count     int
+	MaxBytes  int64
+}
+
+// CaptureResult summarises a finished packet capture
+type CaptureResult struct {
+	Container string        `json:"container"`
+	Bytes     int64         `json:"bytes"`
+	Truncated bool          `json:"truncated"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// captureWriter forwards pcap data up to a byte limit and cancels the stream once it is hit
+type captureWriter struct {
+	w         io.Writer
+	max       int64
+	written   int64
+	truncated bool
+	cancel    context.CancelFunc
+}
+
+func (c *captureWriter) Write(p []byte) (int, error) {
+	if c.max > 0 && c.written+int64(len(p)) > c.max {
+		p = p[:c.max-c.written]
+		c.truncated = true
+	}
+	n, err := c.w.Write(p)
+	c.written += int64(n)
+	if err != nil {
+		return n, err
+	}
+	if c.truncated {
+		c.cancel()
+		return n, errCaptureSizeLimit
+	}
+	return n, nil
+}
+
+// buildCaptureCommand builds the tcpdump invocation run inside the ephemeral container
+func buildCaptureCommand(opts CaptureOptions) []string {
+	command := []string{
+		"timeout", strconv.Itoa(max(1, int(opts.Duration.Seconds()))),
+		"tcpdump", "-i", opts.Interface, "-U", "-w", "-",
+	}
+	if opts.Count > 0 {
+		command = append(command, "-c", strconv.Itoa(opts.Count))
+	}
+	if opts.Filter != "" {
+		// The filter is a single expression argument; after "--" it cannot inject tcpdump options
+		command = append(command, "--", opts.Filter)
+	}
+	return command
+}
+
+// startCaptureContainer attaches an ephemeral container to the pod and waits for it to run.
+// Ephemeral containers always share the pod's network namespace, so tcpdump inside it sees
+// the pod's interfaces without requiring tcpdump in the application image. Kubernetes cannot
+// remove ephemeral containers: each capture leaves a terminated container in the pod spec and
+// status until the pod is deleted.
+func startCaptureContainer(ctx context.Context, opts CaptureOptions) (string, error) {
+	pod, err := clientset.CoreV1().Pods(opts.Namespace).Get(ctx, opts.Pod, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get pod %s: %v", opts.Pod, err)
+	}
+	if pod.Status.Phase != corev1.PodRunning {
+		return "", fmt.Errorf("pod %s is in phase %s, captures require a running pod", opts.Pod, pod.Status.Phase)
+	}
+
+	name := fmt.Sprintf("k8stoolbox-capture-%s", rand.String(5))
+	// The container only needs to outlive the capture; it exits on its own afterwards
+	lifetime := int((opts.Duration + captureSetupTimeout).Seconds())
+	pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+		EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+			Name:    name,
+			Image:   opts.Image,
+			Command: []string{"sleep", strconv.Itoa(lifetime)},
+			SecurityContext: &corev1.SecurityContext{
+				Capabilities: &corev1.Capabilities{
+					Add: []corev1.Capability{"NET_ADMIN", "NET_RAW"},
+				},
+			},
+		},
+	})
+
+	_, err = clientset.CoreV1().Pods(opts.Namespace).UpdateEphemeralContainers(ctx, opts.Pod, pod, metav1.UpdateOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to add ephemeral container to pod %s: %v", opts.Pod, err)
+	}
+
+	err = wait.PollUntilContextTimeout(ctx, time.Second, captureSetupTimeout, true, func(ctx context.Context) (bool, error) {
+		current, err := clientset.CoreV1().Pods(opts.Namespace).Get(ctx, opts.Pod, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		for _, status := range current.Status.EphemeralContainerStatuses {
+			if status.Name != name {
+				continue
+			}
+			if status.State.Running != nil {
+				return true, nil
+			}
+			if status.State.Terminated != nil {
+				return false, fmt.Errorf("capture container terminated: %s", status.State.Terminated.Reason)
+			}
+			if status.State.Waiting != nil && status.State.Waiting.Reason == "ErrImagePull" {
+				return false, fmt.Errorf("capture container image %s could not be pulled: %s", opts.Image, status.State.Waiting.Message)
+			}
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("capture container %s did not start: %v", name, err)
+	}
+
+	return name, nil
+}
+
+// runPacketCapture captures traffic in the target pod's network namespace and streams the pcap to w
+func runPacketCapture(ctx context.Context, opts CaptureOptions, w io.Writer) (CaptureResult, error) {
+	if clientset == nil || config == nil {
+		return CaptureResult{}, fmt.Errorf("kubernetes clientset or config is not initialized")
+	}
+	if opts.Duration <= 0 {
+		return CaptureResult{}, fmt.Errorf("capture duration must be positive")
+	}
+
+	container, err := startCaptureContainer(ctx, opts)
+	if err != nil {
+		return CaptureResult{}, err
+	}
+	return streamCapture(ctx, opts, container, w)
+}
+
+// streamCapture runs tcpdump in an already running capture container and copies the pcap to w
+func streamCapture(ctx context.Context, opts CaptureOptions, container string, w io.Writer) (CaptureResult, error) {
+	result := CaptureResult{Container: container}
+
+	// Leave some headroom over the tcpdump timeout so the trailing data is flushed
+	streamCtx, cancel := context.WithTimeout(ctx, opts.Duration+30*time.Second)
+	defer cancel()
+
+	out := &captureWriter{w: w, max: opts.MaxBytes, cancel: cancel}
+	var stderr strings.Builder
+	start := time.Now()
+	err := execInPod(streamCtx, opts.Namespace, opts.Pod, container, buildCaptureCommand(opts), nil, out, &stderr)
+	result.Bytes = out.written
+	result.Truncated = out.truncated
+	result.Duration = time.Since(start)
+
+	if err != nil && !out.truncated {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		// timeout(1) ends tcpdump with an exit status that differs between implementations,
+		// so a capture that ran for its full duration is a success whatever the status
+		if streamCtx.Err() != nil || result.Duration >= opts.Duration {
+			return result, nil
+		}
+		return result, fmt.Errorf("capture failed: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return result, nil
+}
+
+// performPacketCapture runs a capture and writes the pcap to a local file
+func performPacketCapture(ctx context.Context, opts CaptureOptions, outputPath string) {
+	file, err := os.Create(outputPath)
+	if err != nil {
+		logger.Fatalf("Failed to create output file: %v", err)
+	}
+	defer file.Close()
+
+	logger.Printf("Capturing traffic on pod %s/%s for up to %v (filter: %q)\n",
+		opts.Namespace, opts.Pod, opts.Duration, opts.Filter)
+
+	result, err := runPacketCapture(ctx, opts, file)
+	if err != nil {
+		logger.Fatalf("Packet capture failed: %v", err)
+	}
+
+	if result.Truncated {
+		logger.Printf("⚠️ Capture stopped at the %d byte size limit\n", opts.MaxBytes)
+	}
+	logger.Printf("Captured %d bytes in %v to %s (container %s)\n",
+		result.Bytes, result.Duration.Round(time.Second), outputPath, result.Container)
+}
+
+// captureHandler streams a pcap captured from the requested pod as a file download. Only POST
+// is accepted because the capture adds an ephemeral container to the pod, where it remains
+// after the capture. Duration and size are capped at maxCaptureDuration and maxCaptureBytes.
+func captureHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		errorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if StandaloneMode {
+		errorResponse(w, "packet capture is not available in standalone mode", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		errorResponse(w, fmt.Sprintf("invalid parameters: %v", err), http.StatusBadRequest)
+		return
+	}
+	query := r.Form
+	opts := CaptureOptions{
+		Namespace: query.Get("namespace"),
+		Pod:       query.Get("pod"),
+		Image:     getEnv("CAPTURE_IMAGE", defaultCaptureImage),
+		Interface: query.Get("interface"),
+		Filter:    query.Get("filter"),
+		Duration:  30 * time.Second,
+		MaxBytes:  100 << 20,
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = "default"
+	}
+	if opts.Interface == "" {
+		opts.Interface = "any"
+	}
+	if opts.Pod == "" {
+		errorResponse(w, "pod parameter is required", http.StatusBadRequest)
+		return
+	}
+	if value := query.Get("duration"); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			errorResponse(w, fmt.Sprintf("invalid duration: %v", err), http.StatusBadRequest)
+			return
+		}
+		opts.Duration = d
+	}
+	if value := query.Get("count"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			errorResponse(w, fmt.Sprintf("invalid count: %v", err), http.StatusBadRequest)
+			return
+		}
+		opts.Count = n
+	}
+	if value := query.Get("maxBytes"); value != "" {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			errorResponse(w, fmt.Sprintf("invalid maxBytes: %v", err), http.StatusBadRequest)
+			return
+		}
+		opts.MaxBytes = n
+	}
+
+	if opts.Duration <= 0 {
+		errorResponse(w, "duration must be positive", http.StatusBadRequest)
+		return
+	}
+	opts.Duration = min(opts.Duration, maxCaptureDuration)
+	if opts.MaxBytes <= 0 || opts.MaxBytes > maxCaptureBytes {
+		opts.MaxBytes = maxCaptureBytes
+	}
+
+	container, err := startCaptureContainer(r.Context(), opts)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Headers can only be sent once the capture is known to be starting
+	filename := fmt.Sprintf("%s-%s-%s.pcap", opts.Namespace, opts.Pod, time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/vnd.tcpdump.pcap")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	if _, err := streamCapture(r.Context(), opts, container, flushWriter{w}); err != nil {
+		logger.Printf("Capture of %s/%s ended with error: %v", opts.Namespace, opts.Pod, err)
+	}
+}
+
+// flushWriter flushes the HTTP response after every write so downloads progress live
+type flushWriter struct {
+	w http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if flusher, ok := f.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return n, err
+}
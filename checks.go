@@ -0,0 +1,279 @@
+// Monitor checks beyond pod health: workloads, nodes, quotas and certificates.
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WorkloadHealthStatus is the replica health of a Deployment, StatefulSet or DaemonSet
+type WorkloadHealthStatus struct {
+	Kind      string   `json:"kind"`
+	Name      string   `json:"name"`
+	Desired   int32    `json:"desired"`
+	Ready     int32    `json:"ready"`
+	Available int32    `json:"available"`
+	Issues    []string `json:"issues"`
+}
+
+// NodeHealthStatus is the condition summary of a node
+type NodeHealthStatus struct {
+	Name          string   `json:"name"`
+	Ready         bool     `json:"ready"`
+	Unschedulable bool     `json:"unschedulable"`
+	Issues        []string `json:"issues"`
+	// Conditions holds the state of Ready, the pressure conditions and Unschedulable
+	Conditions map[string]bool `json:"conditions,omitempty"`
+}
+
+// QuotaStatus is the usage of one resource of a ResourceQuota
+type QuotaStatus struct {
+	Quota    string  `json:"quota"`
+	Resource string  `json:"resource"`
+	Used     string  `json:"used"`
+	Hard     string  `json:"hard"`
+	Percent  float64 `json:"percent"`
+	Exceeded bool    `json:"exceeded"`
+}
+
+// CertificateStatus describes the certificate stored in a TLS secret
+type CertificateStatus struct {
+	Secret   string    `json:"secret"`
+	Subject  string    `json:"subject"`
+	DNSNames []string  `json:"dnsNames,omitempty"`
+	NotAfter time.Time `json:"notAfter"`
+	DaysLeft int       `json:"daysLeft"`
+	Issues   []string  `json:"issues"`
+}
+
+// certificateChecksForbidden remembers the namespaces whose secrets may not be listed, so that
+// the warning is logged once instead of on every check
+var certificateChecksForbidden sync.Map
+
+// replicaIssue reports when fewer than the required share of desired replicas are ready
+func replicaIssue(desired, ready int32, thresholds HealthThresholds) []string {
+	if desired == 0 {
+		return []string{}
+	}
+	if int(ready)*100 < int(desired)*thresholds.ReplicaAvailabilityPercent {
+		return []string{fmt.Sprintf("%d of %d replicas are ready", ready, desired)}
+	}
+	return []string{}
+}
+
+// evaluateDeployment checks that a deployment has enough ready replicas
+func evaluateDeployment(d *appsv1.Deployment, thresholds HealthThresholds) WorkloadHealthStatus {
+	desired := int32(1)
+	if d.Spec.Replicas != nil {
+		desired = *d.Spec.Replicas
+	}
+	status := WorkloadHealthStatus{
+		Kind:      "Deployment",
+		Name:      d.Name,
+		Desired:   desired,
+		Ready:     d.Status.ReadyReplicas,
+		Available: d.Status.AvailableReplicas,
+		Issues:    replicaIssue(desired, d.Status.ReadyReplicas, thresholds),
+	}
+	for _, condition := range d.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == "ProgressDeadlineExceeded" {
+			status.Issues = append(status.Issues, fmt.Sprintf("Rollout stalled: %s", condition.Message))
+		}
+	}
+	return status
+}
+
+// evaluateStatefulSet checks that a statefulset has enough ready replicas
+func evaluateStatefulSet(s *appsv1.StatefulSet, thresholds HealthThresholds) WorkloadHealthStatus {
+	desired := int32(1)
+	if s.Spec.Replicas != nil {
+		desired = *s.Spec.Replicas
+	}
+	return WorkloadHealthStatus{
+		Kind:      "StatefulSet",
+		Name:      s.Name,
+		Desired:   desired,
+		Ready:     s.Status.ReadyReplicas,
+		Available: s.Status.AvailableReplicas,
+		Issues:    replicaIssue(desired, s.Status.ReadyReplicas, thresholds),
+	}
+}
+
+// evaluateDaemonSet checks that a daemonset runs ready pods on its scheduled nodes
+func evaluateDaemonSet(d *appsv1.DaemonSet, thresholds HealthThresholds) WorkloadHealthStatus {
+	status := WorkloadHealthStatus{
+		Kind:      "DaemonSet",
+		Name:      d.Name,
+		Desired:   d.Status.DesiredNumberScheduled,
+		Ready:     d.Status.NumberReady,
+		Available: d.Status.NumberAvailable,
+		Issues:    replicaIssue(d.Status.DesiredNumberScheduled, d.Status.NumberReady, thresholds),
+	}
+	if d.Status.NumberMisscheduled > 0 {
+		status.Issues = append(status.Issues, fmt.Sprintf("%d pods are running on nodes they should not run on", d.Status.NumberMisscheduled))
+	}
+	return status
+}
+
+// evaluateNode reports a node that is not ready, under pressure or cordoned
+func evaluateNode(node *corev1.Node) NodeHealthStatus {
+	status := NodeHealthStatus{
+		Name:          node.Name,
+		Unschedulable: node.Spec.Unschedulable,
+		Issues:        []string{},
+		Conditions:    map[string]bool{"Unschedulable": node.Spec.Unschedulable},
+	}
+	for _, condition := range node.Status.Conditions {
+		status.Conditions[string(condition.Type)] = condition.Status == corev1.ConditionTrue
+		switch condition.Type {
+		case corev1.NodeReady:
+			status.Ready = condition.Status == corev1.ConditionTrue
+			if !status.Ready {
+				status.Issues = append(status.Issues, fmt.Sprintf("Node is not ready: %s", condition.Message))
+			}
+		case corev1.NodeMemoryPressure, corev1.NodeDiskPressure, corev1.NodePIDPressure, corev1.NodeNetworkUnavailable:
+			if condition.Status == corev1.ConditionTrue {
+				status.Issues = append(status.Issues, fmt.Sprintf("Condition %s is True: %s", condition.Type, condition.Message))
+			}
+		}
+	}
+	if status.Unschedulable {
+		status.Issues = append(status.Issues, "Node is cordoned")
+	}
+	return status
+}
+
+// evaluateQuotas compares ResourceQuota usage against the usage threshold
+func evaluateQuotas(quotas []corev1.ResourceQuota, thresholds HealthThresholds) []QuotaStatus {
+	var statuses []QuotaStatus
+	for _, quota := range quotas {
+		for resource, hard := range quota.Status.Hard {
+			used, ok := quota.Status.Used[resource]
+			if !ok || hard.IsZero() {
+				continue
+			}
+			percent := used.AsApproximateFloat64() / hard.AsApproximateFloat64() * 100
+			statuses = append(statuses, QuotaStatus{
+				Quota:    quota.Name,
+				Resource: string(resource),
+				Used:     used.String(),
+				Hard:     hard.String(),
+				Percent:  percent,
+				Exceeded: percent >= float64(thresholds.ResourceUsagePercent),
+			})
+		}
+	}
+	return statuses
+}
+
+// checkCertificates inspects the TLS secrets of a namespace for expired or expiring certificates
+func checkCertificates(ctx context.Context, namespace string, thresholds HealthThresholds) ([]CertificateStatus, error) {
+	secrets, err := clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{
+		FieldSelector: "type=" + string(corev1.SecretTypeTLS),
+	})
+	if apierrors.IsForbidden(err) {
+		// Reading secrets is opt-in (security.allowCertificateChecks in the Helm chart)
+		if _, warned := certificateChecksForbidden.LoadOrStore(namespace, true); !warned {
+			logger.Printf("⚠️ Skipping certificate checks in namespace %s: listing secrets is forbidden", namespace)
+		}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list TLS secrets: %v", err)
+	}
+
+	now := time.Now()
+	var statuses []CertificateStatus
+	for _, secret := range secrets.Items {
+		status := CertificateStatus{Secret: secret.Name, Issues: []string{}}
+		block, _ := pem.Decode(secret.Data[corev1.TLSCertKey])
+		if block == nil {
+			status.Issues = append(status.Issues, "tls.crt does not contain a PEM certificate")
+			statuses = append(statuses, status)
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			status.Issues = append(status.Issues, fmt.Sprintf("Failed to parse certificate: %v", err))
+			statuses = append(statuses, status)
+			continue
+		}
+
+		status.Subject = cert.Subject.String()
+		status.DNSNames = cert.DNSNames
+		status.NotAfter = cert.NotAfter
+		status.DaysLeft = int(cert.NotAfter.Sub(now).Hours() / 24)
+		switch {
+		case now.After(cert.NotAfter):
+			status.Issues = append(status.Issues, fmt.Sprintf("Certificate expired on %s", cert.NotAfter.Format(time.RFC3339)))
+		case status.DaysLeft < thresholds.CertificateExpiryDays:
+			status.Issues = append(status.Issues, fmt.Sprintf("Certificate expires in %d days", status.DaysLeft))
+		}
+		if now.Before(cert.NotBefore) {
+			status.Issues = append(status.Issues, fmt.Sprintf("Certificate is not valid before %s", cert.NotBefore.Format(time.RFC3339)))
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// connectivityCommand builds the command used to test connectivity with the given protocol
+func connectivityCommand(protocol, target string, port int) ([]string, error) {
+	switch strings.ToLower(protocol) {
+	case "tcp":
+		return []string{"nc", "-zv", "-w", "5", target, fmt.Sprintf("%d", port)}, nil
+	case "http":
+		return []string{"curl", "-sSf", "-m", "10", "-o", "/dev/null", fmt.Sprintf("http://%s:%d", target, port)}, nil
+	case "icmp":
+		return []string{"ping", "-c", "3", target}, nil
+	default:
+		return nil, fmt.Errorf("invalid protocol: %s. Must be one of: tcp, http, icmp", protocol)
+	}
+}
+
+// CheckIssues lists the problems found by the optional monitor checks of a result
+func (r HealthCheckResult) CheckIssues() []string {
+	var issues []string
+	for _, w := range r.Workloads {
+		for _, issue := range w.Issues {
+			issues = append(issues, fmt.Sprintf("%s %s: %s", w.Kind, w.Name, issue))
+		}
+	}
+	for _, n := range r.Nodes {
+		for _, issue := range n.Issues {
+			issues = append(issues, fmt.Sprintf("Node %s: %s", n.Name, issue))
+		}
+	}
+	for _, q := range r.Quotas {
+		if q.Exceeded {
+			issues = append(issues, fmt.Sprintf("ResourceQuota %s: %s at %.0f%% (%s of %s)", q.Quota, q.Resource, q.Percent, q.Used, q.Hard))
+		}
+	}
+	for _, c := range r.Certificates {
+		for _, issue := range c.Issues {
+			issues = append(issues, fmt.Sprintf("Secret %s: %s", c.Secret, issue))
+		}
+	}
+	for _, p := range r.Probes {
+		if !p.Success {
+			issues = append(issues, fmt.Sprintf("Probe %s failed: %s", p.Name, p.Error))
+		}
+	}
+	for _, s := range r.LogAnalysis {
+		for _, issue := range s.Issues() {
+			issues = append(issues, fmt.Sprintf("%s %s logs: %s", s.Kind, s.Name, issue))
+		}
+	}
+	return issues
+}
@@ -0,0 +1,335 @@
+// Cleanup of custom resource definitions stuck in deletion and of their instances.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+// crdResource is the resource of custom resource definitions
+var crdResource = schema.GroupVersionResource{Group: "apiextensions.k8s.io", Version: "v1", Resource: "customresourcedefinitions"}
+
+// CRDInventory is a custom resource definition and its remaining instances
+type CRDInventory struct {
+	CRD       *unstructured.Unstructured
+	Resource  BackupResource
+	Instances []RemainingObject
+}
+
+// Deleting reports whether the definition is being deleted
+func (inv *CRDInventory) Deleting() bool {
+	return inv.CRD.GetDeletionTimestamp() != nil
+}
+
+// crdResourceOf returns the resource served for the storage version of a definition, or the
+// first served version if the storage version is not served
+func crdResourceOf(crd *unstructured.Unstructured) (BackupResource, error) {
+	group, _, _ := unstructured.NestedString(crd.Object, "spec", "group")
+	plural, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "plural")
+	kind, _, _ := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	scope, _, _ := unstructured.NestedString(crd.Object, "spec", "scope")
+	versions, _, _ := unstructured.NestedSlice(crd.Object, "spec", "versions")
+
+	r := BackupResource{Group: group, Resource: plural, Kind: kind, Namespaced: scope == "Namespaced"}
+	for _, v := range versions {
+		version, _ := v.(map[string]interface{})
+		served, _ := version["served"].(bool)
+		if !served {
+			continue
+		}
+		name, _ := version["name"].(string)
+		if storage, _ := version["storage"].(bool); storage || r.Version == "" {
+			r.Version = name
+		}
+	}
+	if r.Version == "" {
+		return r, fmt.Errorf("custom resource definition %s serves no version", crd.GetName())
+	}
+	return r, nil
+}
+
+// inventoryCRD lists every instance of a custom resource definition in all namespaces
+func inventoryCRD(ctx context.Context, name string) (*CRDInventory, error) {
+	crd, err := dynamicClient.Resource(crdResource).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	r, err := crdResourceOf(crd)
+	if err != nil {
+		return nil, err
+	}
+	inv := &CRDInventory{CRD: crd, Resource: r}
+	list, err := dynamicClient.Resource(r.GroupVersionResource()).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		// The resource is no longer served once the definition has been removed
+		if apierrors.IsNotFound(err) && inv.Deleting() {
+			return inv, nil
+		}
+		return nil, fmt.Errorf("failed to list %s: %v", r.GroupResource(), err)
+	}
+	for i := range list.Items {
+		inv.Instances = append(inv.Instances, RemainingObject{Resource: r, Object: &list.Items[i]})
+	}
+	sort.Slice(inv.Instances, func(i, j int) bool { return inv.Instances[i].String() < inv.Instances[j].String() })
+	return inv, nil
+}
+
+// describeOwners names the controller of an object and its other owners
+func describeOwners(obj *unstructured.Unstructured) string {
+	var owners []string
+	for _, ref := range obj.GetOwnerReferences() {
+		owner := ref.Kind + " " + ref.Name
+		if ref.Controller != nil && *ref.Controller {
+			owner = "controller " + owner
+		}
+		owners = append(owners, owner)
+	}
+	return strings.Join(owners, ", ")
+}
+
+// printCRDInventory reports the state of a definition and its instances per namespace
+func printCRDInventory(inv *CRDInventory) {
+	crd := inv.CRD
+	if inv.Deleting() {
+		logger.Printf("Custom resource definition %s is being deleted since %s", crd.GetName(), crd.GetDeletionTimestamp().Format(time.RFC3339))
+	} else {
+		logger.Printf("Custom resource definition %s (%s, %s)", crd.GetName(), inv.Resource.Kind,
+			conditionalString(inv.Resource.Namespaced, "namespaced", "cluster-scoped"))
+	}
+	if finalizers := crd.GetFinalizers(); len(finalizers) > 0 {
+		logger.Printf("Definition finalizers: %s", strings.Join(finalizers, ", "))
+	}
+	conditions, _, _ := unstructured.NestedSlice(crd.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, _ := c.(map[string]interface{})
+		if (condition["type"] == "Terminating" || condition["type"] == "InstanceDeletionInProgress") && condition["status"] == "True" {
+			logger.Printf("⚠️ %s: %s", condition["type"], condition["message"])
+		}
+	}
+
+	if len(inv.Instances) == 0 {
+		logger.Printf("✅ No instances of %s remain", inv.Resource.GroupResource())
+		return
+	}
+	byNamespace := make(map[string][]RemainingObject)
+	var namespaces []string
+	finalizers := make(map[string]int)
+	for _, o := range inv.Instances {
+		ns := o.Object.GetNamespace()
+		if _, ok := byNamespace[ns]; !ok {
+			namespaces = append(namespaces, ns)
+		}
+		byNamespace[ns] = append(byNamespace[ns], o)
+		for _, f := range o.Object.GetFinalizers() {
+			finalizers[f]++
+		}
+	}
+	sort.Strings(namespaces)
+
+	logger.Printf("Instances (%d):", len(inv.Instances))
+	for _, ns := range namespaces {
+		logger.Printf("  %s (%d):", conditionalString(ns == "", "cluster-scoped", "namespace "+ns), len(byNamespace[ns]))
+		for _, o := range byNamespace[ns] {
+			var details []string
+			if o.Object.GetDeletionTimestamp() != nil {
+				details = append(details, "deleting")
+			}
+			if f := o.Object.GetFinalizers(); len(f) > 0 {
+				details = append(details, "finalizers: "+strings.Join(f, ", "))
+			}
+			if owners := describeOwners(o.Object); owners != "" {
+				details = append(details, "owned by "+owners)
+			}
+			logger.Printf("    %s%s", o.Object.GetName(), conditionalString(len(details) == 0, "", " ("+strings.Join(details, "; ")+")"))
+		}
+	}
+	if len(finalizers) > 0 {
+		names := make([]string, 0, len(finalizers))
+		for f := range finalizers {
+			names = append(names, f)
+		}
+		sort.Strings(names)
+		logger.Printf("Instance finalizers (remove them with -remove-finalizers once their controller is gone):")
+		for _, f := range names {
+			logger.Printf("  ⚠️ %s on %d instances", f, finalizers[f])
+		}
+	}
+}
+
+// selectInstances filters instances by namespace and by name, given as name or namespace/name
+func selectInstances(instances []RemainingObject, namespaces, names []string) []RemainingObject {
+	var selected []RemainingObject
+	for _, o := range instances {
+		if len(namespaces) > 0 && !containsString(namespaces, o.Object.GetNamespace()) {
+			continue
+		}
+		if len(names) > 0 && !containsString(names, o.Object.GetName()) &&
+			!containsString(names, o.Object.GetNamespace()+"/"+o.Object.GetName()) {
+			continue
+		}
+		selected = append(selected, o)
+	}
+	return selected
+}
+
+// deleteInstances deletes the selected instances that are not being deleted yet
+func deleteInstances(ctx context.Context, instances []RemainingObject, dryRun bool) (int, error) {
+	deleted := 0
+	for _, o := range instances {
+		if o.Object.GetDeletionTimestamp() != nil {
+			continue
+		}
+		if dryRun {
+			logger.Printf("Would delete %s", o)
+			deleted++
+			continue
+		}
+		err := dynamicClient.Resource(o.Resource.GroupVersionResource()).Namespace(o.Object.GetNamespace()).
+			Delete(ctx, o.Object.GetName(), metav1.DeleteOptions{})
+		if err != nil && !apierrors.IsNotFound(err) {
+			return deleted, fmt.Errorf("failed to delete %s: %v", o, err)
+		}
+		logger.Printf("✅ Deleted %s", o)
+		deleted++
+	}
+	return deleted, nil
+}
+
+// waitForCRDDeletion waits until the definition is gone
+func waitForCRDDeletion(ctx context.Context, name string, timeout time.Duration) bool {
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		_, err := dynamicClient.Resource(crdResource).Get(ctx, name, metav1.GetOptions{})
+		return apierrors.IsNotFound(err), nil
+	})
+	return err == nil
+}
+
+// runCRDCommand dispatches the crd subcommands
+func runCRDCommand(ctx context.Context, args []string) {
+	if len(args) < 1 || args[0] != "cleanup" {
+		fmt.Println("Usage: k8stoolbox crd cleanup [options] <crd-name>")
+		os.Exit(1)
+	}
+	runCRDCleanup(ctx, args[1:])
+}
+
+// runCRDCleanup reports the instances of a definition and, as requested, deletes selected
+// instances, removes their finalizers and deletes the definition. The definition's own finalizer
+// is left to the API server, which removes it once all instances are gone; removing it by hand
+// would leave the instances behind in etcd.
+func runCRDCleanup(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("crd cleanup", flag.ExitOnError)
+	namespaces := cmd.String("namespaces", "", "Comma-separated namespaces of the instances to act on (default: all)")
+	names := cmd.String("names", "", "Comma-separated instances to act on, as name or namespace/name (default: all)")
+	deleteSelected := cmd.Bool("delete-instances", false, "Delete the selected instances")
+	removeList := cmd.String("remove-finalizers", "", "Comma-separated finalizers to remove from the selected instances")
+	deleteCRD := cmd.Bool("delete-crd", false, "Delete the custom resource definition")
+	dryRun := cmd.Bool("dry-run", false, "Show what would be changed without changing the cluster")
+	yes := cmd.Bool("yes", false, "Do not ask for confirmation")
+	timeout := cmd.Duration("wait", 2*time.Minute, "How long to wait for the custom resource definition to disappear")
+	if err := cmd.Parse(args); err != nil {
+		return
+	}
+	if cmd.NArg() != 1 {
+		fmt.Println("Usage: k8stoolbox crd cleanup [options] <crd-name>")
+		os.Exit(1)
+	}
+	name := cmd.Arg(0)
+	if dynamicClient == nil {
+		logger.Fatalf("CRD cleanup needs a Kubernetes cluster")
+	}
+	confirm := func(prompt string) bool {
+		if *dryRun || *yes {
+			return true
+		}
+		return confirmAction(prompt, "yes")
+	}
+
+	inv, err := inventoryCRD(ctx, name)
+	if apierrors.IsNotFound(err) {
+		logger.Printf("✅ Custom resource definition %s does not exist", name)
+		return
+	}
+	if err != nil {
+		logger.Fatalf("Failed to inspect custom resource definition %s: %v", name, err)
+	}
+	printCRDInventory(inv)
+
+	selected := selectInstances(inv.Instances, splitList(*namespaces), splitList(*names))
+	if (*deleteSelected || *removeList != "") && len(selected) == 0 {
+		logger.Printf("⚠️ No instances match the selection")
+	}
+
+	if *deleteSelected && len(selected) > 0 {
+		if !confirm(fmt.Sprintf("Delete %d instances of %s?", len(selected), inv.Resource.GroupResource())) {
+			logger.Fatalf("Aborted")
+		}
+		if _, err := deleteInstances(ctx, selected, *dryRun); err != nil {
+			logger.Fatalf("%v", err)
+		}
+	}
+
+	if remove := splitList(*removeList); len(remove) > 0 && len(selected) > 0 {
+		prompt := fmt.Sprintf("Remove finalizers %s from %d instances of %s? Their cleanup will be skipped.",
+			strings.Join(remove, ", "), len(selected), inv.Resource.GroupResource())
+		if !confirm(prompt) {
+			logger.Fatalf("Aborted")
+		}
+		// Deleting instances updates them, so remove finalizers from their current state
+		if *deleteSelected && !*dryRun {
+			if current, err := inventoryCRD(ctx, name); err == nil {
+				selected = selectInstances(current.Instances, splitList(*namespaces), splitList(*names))
+			}
+		}
+		removed, err := removeFinalizers(ctx, selected, remove, *dryRun)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if removed == 0 && len(selected) > 0 {
+			logger.Printf("⚠️ No selected instance has the finalizers %s", strings.Join(remove, ", "))
+		}
+	}
+
+	if *deleteCRD && !inv.Deleting() {
+		if !confirm(fmt.Sprintf("Delete custom resource definition %s? All remaining instances will be deleted.", name)) {
+			logger.Fatalf("Aborted")
+		}
+		if *dryRun {
+			logger.Printf("Would delete custom resource definition %s", name)
+		} else if err := dynamicClient.Resource(crdResource).Delete(ctx, name, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+			logger.Fatalf("Failed to delete custom resource definition %s: %v", name, err)
+		} else {
+			logger.Printf("✅ Deleted custom resource definition %s", name)
+		}
+	}
+
+	if *dryRun {
+		logger.Printf("Dry run: no changes were made")
+		return
+	}
+	if !*deleteCRD && !inv.Deleting() {
+		return
+	}
+	logger.Printf("Waiting up to %s for custom resource definition %s to disappear...", *timeout, name)
+	if waitForCRDDeletion(ctx, name, *timeout) {
+		logger.Printf("✅ Custom resource definition %s was deleted", name)
+		return
+	}
+	logger.Printf("⚠️ Custom resource definition %s still exists", name)
+	if inv, err = inventoryCRD(ctx, name); err == nil {
+		printCRDInventory(inv)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,401 @@
+// DNS diagnostics for the in-cluster CoreDNS/kube-dns deployment.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// dnsPodSelector matches both CoreDNS and legacy kube-dns pods
+	dnsPodSelector = "k8s-app=kube-dns"
+	// dnsServiceName is the conventional name of the cluster DNS Service
+	dnsServiceName = "kube-dns"
+	// maxSearchDomains is the search list size enforced by kubelet and older glibc releases
+	maxSearchDomains = 6
+	// maxSearchListChars is the search list length enforced by kubelet and older glibc releases
+	maxSearchListChars = 256
+)
+
+// ResolvConf holds the settings parsed from a resolv.conf file
+type ResolvConf struct {
+	Nameservers []string `json:"nameservers"`
+	Search      []string `json:"search"`
+	Ndots       int      `json:"ndots"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// DNSPodStatus represents the health of a single DNS server pod
+type DNSPodStatus struct {
+	Name     string   `json:"name"`
+	IP       string   `json:"ip"`
+	Node     string   `json:"node"`
+	Status   string   `json:"status"`
+	Ready    bool     `json:"ready"`
+	Restarts int32    `json:"restarts"`
+	Issues   []string `json:"issues"`
+}
+
+// DNSLookupResult holds the answer for a name resolved through a specific server
+type DNSLookupResult struct {
+	Name      string   `json:"name"`
+	Server    string   `json:"server"`
+	Addresses []string `json:"addresses"`
+	Error     string   `json:"error,omitempty"`
+}
+
+// DNSDiagnosticsResult represents the result of a DNS diagnostics run
+type DNSDiagnosticsResult struct {
+	ServiceIP         string            `json:"serviceIP"`
+	ReadyEndpoints    []string          `json:"readyEndpoints"`
+	NotReadyEndpoints []string          `json:"notReadyEndpoints"`
+	DNSPods           []DNSPodStatus    `json:"dnsPods"`
+	SourcePod         string            `json:"sourcePod,omitempty"`
+	ResolvConf        *ResolvConf       `json:"resolvConf,omitempty"`
+	Lookups           []DNSLookupResult `json:"lookups"`
+	Issues            []string          `json:"issues"`
+	Timestamp         time.Time         `json:"timestamp"`
+}
+
+// DNSDiagnosticsOptions controls what runDNSDiagnostics checks
+type DNSDiagnosticsOptions struct {
+	DNSNamespace string
+	Namespace    string
+	Pod          string
+	Container    string
+	Names        []string
+}
+
+// runDNSDiagnostics inspects the cluster DNS deployment and, when a source pod is given,
+// resolves names from that pod through the Service and through every DNS pod individually.
+func runDNSDiagnostics(ctx context.Context, opts DNSDiagnosticsOptions) (DNSDiagnosticsResult, error) {
+	result := DNSDiagnosticsResult{
+		DNSPods:   []DNSPodStatus{},
+		Lookups:   []DNSLookupResult{},
+		Issues:    []string{},
+		Timestamp: time.Now(),
+	}
+
+	svc, err := clientset.CoreV1().Services(opts.DNSNamespace).Get(ctx, dnsServiceName, metav1.GetOptions{})
+	if err != nil {
+		result.Issues = append(result.Issues, fmt.Sprintf("DNS service %s/%s not found: %v", opts.DNSNamespace, dnsServiceName, err))
+	} else {
+		result.ServiceIP = svc.Spec.ClusterIP
+	}
+
+	pods, err := clientset.CoreV1().Pods(opts.DNSNamespace).List(ctx, metav1.ListOptions{LabelSelector: dnsPodSelector})
+	if err != nil {
+		return result, fmt.Errorf("failed to list DNS pods: %v", err)
+	}
+	if len(pods.Items) == 0 {
+		result.Issues = append(result.Issues, fmt.Sprintf("No DNS pods found in namespace '%s' with selector %s", opts.DNSNamespace, dnsPodSelector))
+	}
+	for _, pod := range pods.Items {
+		status := evaluateDNSPod(pod)
+		result.DNSPods = append(result.DNSPods, status)
+		for _, issue := range status.Issues {
+			result.Issues = append(result.Issues, fmt.Sprintf("DNS pod %s: %s", status.Name, issue))
+		}
+	}
+
+	endpoints, err := clientset.CoreV1().Endpoints(opts.DNSNamespace).Get(ctx, dnsServiceName, metav1.GetOptions{})
+	if err != nil {
+		result.Issues = append(result.Issues, fmt.Sprintf("Endpoints for %s/%s not found: %v", opts.DNSNamespace, dnsServiceName, err))
+	} else {
+		for _, subset := range endpoints.Subsets {
+			for _, addr := range subset.Addresses {
+				result.ReadyEndpoints = appendUnique(result.ReadyEndpoints, addr.IP)
+			}
+			for _, addr := range subset.NotReadyAddresses {
+				result.NotReadyEndpoints = appendUnique(result.NotReadyEndpoints, addr.IP)
+			}
+		}
+		if len(result.ReadyEndpoints) == 0 {
+			result.Issues = append(result.Issues, "DNS service has no ready endpoints")
+		}
+		for _, ip := range result.NotReadyEndpoints {
+			result.Issues = append(result.Issues, fmt.Sprintf("DNS endpoint %s is not ready", ip))
+		}
+		for _, pod := range result.DNSPods {
+			if pod.Ready && pod.IP != "" && !containsString(result.ReadyEndpoints, pod.IP) {
+				result.Issues = append(result.Issues, fmt.Sprintf("Ready DNS pod %s (%s) is missing from the service endpoints", pod.Name, pod.IP))
+			}
+		}
+	}
+
+	if opts.Pod == "" {
+		return result, nil
+	}
+	result.SourcePod = fmt.Sprintf("%s/%s", opts.Namespace, opts.Pod)
+
+	var stdout, stderr bytes.Buffer
+	err = execInPod(ctx, opts.Namespace, opts.Pod, opts.Container, []string{"cat", "/etc/resolv.conf"}, nil, &stdout, &stderr)
+	if err != nil {
+		return result, fmt.Errorf("failed to read /etc/resolv.conf from pod %s: %v: %s", opts.Pod, err, strings.TrimSpace(stderr.String()))
+	}
+	resolv := parseResolvConf(stdout.String())
+	result.ResolvConf = &resolv
+	result.Issues = append(result.Issues, analyzeResolvConf(resolv, result.ServiceIP)...)
+
+	for _, name := range opts.Names {
+		// Resolve through the pod's own resolver configuration first
+		result.Lookups = append(result.Lookups, lookupFromPod(ctx, opts, name, ""))
+
+		// Then query every DNS pod directly so their answers can be compared
+		for _, dnsPod := range result.DNSPods {
+			if dnsPod.IP == "" {
+				continue
+			}
+			result.Lookups = append(result.Lookups, lookupFromPod(ctx, opts, name, dnsPod.IP))
+		}
+	}
+	result.Issues = append(result.Issues, compareDNSAnswers(result.Lookups)...)
+
+	return result, nil
+}
+
+// evaluateDNSPod checks the readiness and restart history of a DNS pod
+func evaluateDNSPod(pod corev1.Pod) DNSPodStatus {
+	status := DNSPodStatus{
+		Name:   pod.Name,
+		IP:     pod.Status.PodIP,
+		Node:   pod.Spec.NodeName,
+		Status: string(pod.Status.Phase),
+		Ready:  true,
+		Issues: []string{},
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		status.Ready = false
+		status.Issues = append(status.Issues, fmt.Sprintf("pod is in phase %s", pod.Status.Phase))
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		status.Restarts += cs.RestartCount
+		if !cs.Ready {
+			status.Ready = false
+			status.Issues = append(status.Issues, fmt.Sprintf("container %s is not ready", cs.Name))
+		}
+		if cs.RestartCount > defaultHealthThresholds.RestartCount {
+			status.Issues = append(status.Issues, fmt.Sprintf("container %s has restarted %d times", cs.Name, cs.RestartCount))
+		}
+	}
+
+	return status
+}
+
+// lookupFromPod resolves a name from inside the source pod. An empty server uses the pod's resolv.conf.
+func lookupFromPod(ctx context.Context, opts DNSDiagnosticsOptions, name, server string) DNSLookupResult {
+	lookup := DNSLookupResult{
+		Name:      name,
+		Server:    conditionalString(server == "", "resolv.conf", server),
+		Addresses: []string{},
+	}
+
+	command := []string{"nslookup", name}
+	if server != "" {
+		command = append(command, server)
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := execInPod(ctx, opts.Namespace, opts.Pod, opts.Container, command, nil, &stdout, &stderr)
+	lookup.Addresses = parseNslookupAddresses(stdout.String())
+	if err != nil && len(lookup.Addresses) == 0 {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(stdout.String())
+		}
+		lookup.Error = fmt.Sprintf("%v: %s", err, msg)
+	}
+
+	return lookup
+}
+
+// parseResolvConf parses nameserver, search and options lines from resolv.conf content
+func parseResolvConf(content string) ResolvConf {
+	// glibc defaults to ndots:1 when no option is present
+	resolv := ResolvConf{Ndots: 1}
+
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "nameserver":
+			if len(fields) > 1 {
+				resolv.Nameservers = append(resolv.Nameservers, fields[1])
+			}
+		case "search", "domain":
+			// The last search or domain line wins
+			resolv.Search = append([]string{}, fields[1:]...)
+		case "options":
+			for _, opt := range fields[1:] {
+				if value, ok := strings.CutPrefix(opt, "ndots:"); ok {
+					if n, err := strconv.Atoi(value); err == nil {
+						resolv.Ndots = n
+					}
+					continue
+				}
+				resolv.Options = append(resolv.Options, opt)
+			}
+		}
+	}
+
+	return resolv
+}
+
+// analyzeResolvConf flags common resolver misconfigurations
+func analyzeResolvConf(resolv ResolvConf, serviceIP string) []string {
+	var issues []string
+
+	if len(resolv.Nameservers) == 0 {
+		issues = append(issues, "resolv.conf has no nameserver entries")
+	} else if serviceIP != "" && !containsString(resolv.Nameservers, serviceIP) {
+		issues = append(issues, fmt.Sprintf("resolv.conf nameservers %v do not include the cluster DNS service IP %s (check the pod's dnsPolicy)",
+			resolv.Nameservers, serviceIP))
+	}
+	if len(resolv.Nameservers) > 3 {
+		issues = append(issues, fmt.Sprintf("resolv.conf lists %d nameservers; only the first 3 are used", len(resolv.Nameservers)))
+	}
+
+	if len(resolv.Search) > maxSearchDomains {
+		issues = append(issues, fmt.Sprintf("search list has %d domains; kubelet and older resolvers only honour %d", len(resolv.Search), maxSearchDomains))
+	}
+	if searchLen := len(strings.Join(resolv.Search, " ")); searchLen > maxSearchListChars {
+		issues = append(issues, fmt.Sprintf("search list is %d characters long; the limit is %d", searchLen, maxSearchListChars))
+	}
+
+	// With ndots:N, every name with fewer than N dots is first tried against each search domain,
+	// so a lookup like api.example.com costs len(search) extra queries before the absolute one.
+	if resolv.Ndots >= 5 && len(resolv.Search) > 0 {
+		issues = append(issues, fmt.Sprintf("ndots:%d with %d search domains causes up to %d extra queries for each external name; "+
+			"consider lowering ndots in dnsConfig or using fully qualified names with a trailing dot",
+			resolv.Ndots, len(resolv.Search), len(resolv.Search)))
+	}
+	if resolv.Ndots > 15 {
+		issues = append(issues, fmt.Sprintf("ndots:%d exceeds the resolver maximum of 15", resolv.Ndots))
+	}
+
+	return issues
+}
+
+// parseNslookupAddresses extracts the answer addresses from nslookup output (bind-tools and busybox formats)
+func parseNslookupAddresses(output string) []string {
+	addresses := []string{}
+	inAnswer := false
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "Name:") {
+			inAnswer = true
+			continue
+		}
+		if !inAnswer || !strings.HasPrefix(line, "Address") {
+			continue
+		}
+
+		// Formats: "Address: 10.0.0.1", "Address 1: 10.0.0.1 name.svc"
+		_, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(value)
+		if len(fields) > 0 {
+			addresses = appendUnique(addresses, fields[0])
+		}
+	}
+
+	sort.Strings(addresses)
+	return addresses
+}
+
+// compareDNSAnswers reports names for which DNS servers returned different answers
+func compareDNSAnswers(lookups []DNSLookupResult) []string {
+	var issues []string
+
+	byName := make(map[string][]DNSLookupResult)
+	var names []string
+	for _, lookup := range lookups {
+		if _, ok := byName[lookup.Name]; !ok {
+			names = append(names, lookup.Name)
+		}
+		byName[lookup.Name] = append(byName[lookup.Name], lookup)
+	}
+
+	for _, name := range names {
+		answers := make(map[string][]string)
+		for _, lookup := range byName[name] {
+			if lookup.Error != "" {
+				issues = append(issues, fmt.Sprintf("Lookup of %s via %s failed: %s", name, lookup.Server, lookup.Error))
+				continue
+			}
+			key := strings.Join(lookup.Addresses, ",")
+			answers[key] = append(answers[key], lookup.Server)
+		}
+		if len(answers) > 1 {
+			var parts []string
+			for answer, servers := range answers {
+				parts = append(parts, fmt.Sprintf("[%s] from %s", answer, strings.Join(servers, ", ")))
+			}
+			sort.Strings(parts)
+			issues = append(issues, fmt.Sprintf("DNS servers disagree on %s: %s", name, strings.Join(parts, "; ")))
+		}
+	}
+
+	return issues
+}
+
+// performDNSDiagnostics runs the DNS diagnostics and logs a report
+func performDNSDiagnostics(ctx context.Context, opts DNSDiagnosticsOptions) {
+	logger.Printf("Running DNS diagnostics (DNS namespace '%s')\n", opts.DNSNamespace)
+
+	result, err := runDNSDiagnostics(ctx, opts)
+	if err != nil {
+		logger.Fatalf("DNS diagnostics failed: %v", err)
+	}
+
+	logger.Printf("DNS service IP: %s\n", conditionalString(result.ServiceIP == "", "<none>", result.ServiceIP))
+	logger.Printf("Endpoints: %d ready %v, %d not ready %v\n",
+		len(result.ReadyEndpoints), result.ReadyEndpoints, len(result.NotReadyEndpoints), result.NotReadyEndpoints)
+
+	for _, pod := range result.DNSPods {
+		if pod.Ready && len(pod.Issues) == 0 {
+			logger.Printf("✅ DNS pod %s (%s on %s) is healthy\n", pod.Name, pod.IP, pod.Node)
+		} else {
+			logger.Printf("⚠️ DNS pod %s (%s on %s) is not healthy (Status: %s)\n", pod.Name, pod.IP, pod.Node, pod.Status)
+		}
+	}
+
+	if result.ResolvConf != nil {
+		logger.Printf("resolv.conf of %s: nameservers=%v search=%v ndots=%d options=%v\n", result.SourcePod,
+			result.ResolvConf.Nameservers, result.ResolvConf.Search, result.ResolvConf.Ndots, result.ResolvConf.Options)
+	}
+
+	for _, lookup := range result.Lookups {
+		if lookup.Error != "" {
+			logger.Printf("⚠️ %s via %s: %s\n", lookup.Name, lookup.Server, lookup.Error)
+		} else {
+			logger.Printf("✅ %s via %s: %s\n", lookup.Name, lookup.Server, strings.Join(lookup.Addresses, ", "))
+		}
+	}
+
+	if len(result.Issues) == 0 {
+		logger.Println("DNS diagnostics summary: no issues found")
+		return
+	}
+	logger.Printf("DNS diagnostics summary: %d issues found\n", len(result.Issues))
+	for _, issue := range result.Issues {
+		logger.Printf("  - %s\n", issue)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseResolvConf(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    ResolvConf
+	}{
+		{
+			name: "cluster first pod",
+			content: "search shop.svc.cluster.local svc.cluster.local cluster.local\n" +
+				"nameserver 10.96.0.10\n" +
+				"options ndots:5\n",
+			want: ResolvConf{
+				Nameservers: []string{"10.96.0.10"},
+				Search:      []string{"shop.svc.cluster.local", "svc.cluster.local", "cluster.local"},
+				Ndots:       5,
+			},
+		},
+		{
+			name: "comments, blank lines and other options",
+			content: "# Generated by NetworkManager\n" +
+				"; managed by systemd-resolved\n" +
+				"\n" +
+				"nameserver 10.0.0.2\n" +
+				"   nameserver   10.0.0.3   \n" +
+				"#nameserver 10.0.0.4\n" +
+				"search corp.example.com\r\n" +
+				"options timeout:2 ndots:2 attempts:3 rotate\n",
+			want: ResolvConf{
+				Nameservers: []string{"10.0.0.2", "10.0.0.3"},
+				Search:      []string{"corp.example.com"},
+				Ndots:       2,
+				Options:     []string{"timeout:2", "attempts:3", "rotate"},
+			},
+		},
+		{
+			name: "last search or domain line wins",
+			content: "search a.example.com b.example.com\n" +
+				"nameserver 8.8.8.8\n" +
+				"domain c.example.com\n",
+			want: ResolvConf{
+				Nameservers: []string{"8.8.8.8"},
+				Search:      []string{"c.example.com"},
+				Ndots:       1,
+			},
+		},
+		{
+			name: "ndots defaults to 1 and ignores invalid values",
+			content: "nameserver 10.96.0.10\n" +
+				"options ndots:many edns0\n",
+			want: ResolvConf{
+				Nameservers: []string{"10.96.0.10"},
+				Ndots:       1,
+				Options:     []string{"edns0"},
+			},
+		},
+		{
+			name:    "empty",
+			content: "",
+			want:    ResolvConf{Ndots: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseResolvConf(tt.content); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseNslookupAddresses(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name: "busybox",
+			output: "Server:\t\t10.96.0.10\n" +
+				"Address:\t10.96.0.10:53\n" +
+				"\n" +
+				"Name:\tkubernetes.default.svc.cluster.local\n" +
+				"Address: 10.96.0.1\n" +
+				"\n",
+			want: []string{"10.96.0.1"},
+		},
+		{
+			name: "busybox with IPv4 and IPv6 answers",
+			output: "Server:\t\t10.96.0.10\n" +
+				"Address:\t10.96.0.10:53\n" +
+				"\n" +
+				"Non-authoritative answer:\n" +
+				"Name:\texample.com\n" +
+				"Address: 93.184.215.14\n" +
+				"\n" +
+				"Non-authoritative answer:\n" +
+				"Name:\texample.com\n" +
+				"Address: 2606:2800:21f:cb07:6820:80da:af6b:8b2c\n" +
+				"\n",
+			want: []string{"2606:2800:21f:cb07:6820:80da:af6b:8b2c", "93.184.215.14"},
+		},
+		{
+			name: "busybox 1.28 with numbered addresses",
+			output: "Server:    10.96.0.10\n" +
+				"Address 1: 10.96.0.10 kube-dns.kube-system.svc.cluster.local\n" +
+				"\n" +
+				"Name:      db.shop\n" +
+				"Address 1: 10.244.2.9 10-244-2-9.db.shop.svc.cluster.local\n" +
+				"Address 2: 10.244.1.7 10-244-1-7.db.shop.svc.cluster.local\n",
+			want: []string{"10.244.1.7", "10.244.2.9"},
+		},
+		{
+			name: "busybox NXDOMAIN",
+			output: "Server:\t\t10.96.0.10\n" +
+				"Address:\t10.96.0.10:53\n" +
+				"\n" +
+				"** server can't find nosuch.default.svc.cluster.local: NXDOMAIN\n" +
+				"\n" +
+				"** server can't find nosuch.default.svc.cluster.local: NXDOMAIN\n" +
+				"\n",
+			want: []string{},
+		},
+		{
+			name: "busybox 1.28 NXDOMAIN",
+			output: "Server:    10.96.0.10\n" +
+				"Address 1: 10.96.0.10 kube-dns.kube-system.svc.cluster.local\n" +
+				"\n" +
+				"nslookup: can't resolve 'nosuch'\n",
+			want: []string{},
+		},
+		{
+			name: "bind",
+			output: "Server:\t\t10.96.0.10\n" +
+				"Address:\t10.96.0.10#53\n" +
+				"\n" +
+				"Name:\tkubernetes.default.svc.cluster.local\n" +
+				"Address: 10.96.0.1\n" +
+				"\n",
+			want: []string{"10.96.0.1"},
+		},
+		{
+			name: "bind headless service with several addresses",
+			output: "Server:\t\t10.96.0.10\n" +
+				"Address:\t10.96.0.10#53\n" +
+				"\n" +
+				"Name:\tdb.shop.svc.cluster.local\n" +
+				"Address: 10.244.1.7\n" +
+				"Name:\tdb.shop.svc.cluster.local\n" +
+				"Address: 10.244.2.9\n" +
+				"Name:\tdb.shop.svc.cluster.local\n" +
+				"Address: 10.244.1.5\n" +
+				"\n",
+			want: []string{"10.244.1.5", "10.244.1.7", "10.244.2.9"},
+		},
+		{
+			name: "bind external name with canonical name",
+			output: "Server:\t\t10.96.0.10\n" +
+				"Address:\t10.96.0.10#53\n" +
+				"\n" +
+				"Non-authoritative answer:\n" +
+				"www.example.org\tcanonical name = example.org.\n" +
+				"Name:\texample.org\n" +
+				"Address: 93.184.215.14\n" +
+				"Name:\texample.org\n" +
+				"Address: 93.184.215.14\n" +
+				"\n",
+			want: []string{"93.184.215.14"},
+		},
+		{
+			name: "bind NXDOMAIN",
+			output: "Server:\t\t10.96.0.10\n" +
+				"Address:\t10.96.0.10#53\n" +
+				"\n" +
+				"** server can't find nosuch.shop.svc.cluster.local: NXDOMAIN\n" +
+				"\n",
+			want: []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseNslookupAddresses(tt.output); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,254 @@
+// Prometheus collector that evaluates cluster health lazily on scrape.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+var (
+	exporterUp = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "k8stoolbox_exporter_up",
+		Help: "Whether the last on-scrape evaluation succeeded (1) or served stale values (0)",
+	})
+
+	exporterEvaluations = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "k8stoolbox_exporter_evaluations_total",
+			Help: "Number of on-scrape evaluations by result",
+		},
+		[]string{"result"},
+	)
+)
+
+// exporterTarget names the evaluation in metrics shared with the monitor
+const exporterTarget = "exporter"
+
+// HealthCollector exposes the state metrics. When evaluation is enabled it refreshes them
+// from an informer cache during a scrape if the last evaluation is older than the cache TTL.
+type HealthCollector struct {
+	collectors []prometheus.Collector
+
+	mu          sync.Mutex
+	cache       *clusterCache
+	target      MonitorTarget
+	ttl         time.Duration
+	timeout     time.Duration
+	evaluatedAt time.Time
+	namespaces  map[string]bool
+}
+
+var healthCollector = &HealthCollector{
+	collectors: []prometheus.Collector{
+		resourceUsage, podsByHealth, podIssues, workloadReplicas, workloadHealthy,
+		nodeCondition, lastCheckTimestamp, checkDuration, exporterUp, exporterEvaluations,
+	},
+}
+
+// Describe implements prometheus.Collector
+func (c *HealthCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *HealthCollector) Collect(ch chan<- prometheus.Metric) {
+	c.refresh()
+	for _, collector := range c.collectors {
+		collector.Collect(ch)
+	}
+}
+
+// EnableEvaluation makes scrapes evaluate pods, workloads and nodes of namespace
+// (all namespaces when empty). Informers are only started by the first scrape.
+func (c *HealthCollector) EnableEvaluation(ctx context.Context, client kubernetes.Interface, namespace string, thresholds HealthThresholds, ttl, timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cache = newClusterCache(ctx, client, namespace)
+	c.target = MonitorTarget{
+		Name:       exporterTarget,
+		Checks:     []string{CheckPods, CheckResources, CheckWorkloads, CheckNodes},
+		Thresholds: thresholds,
+	}
+	c.ttl = ttl
+	c.timeout = timeout
+	c.namespaces = make(map[string]bool)
+}
+
+// refresh re-evaluates the cache when the last evaluation expired.
+// Concurrent scrapes wait for the running evaluation instead of starting another one.
+func (c *HealthCollector) refresh() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cache == nil || time.Since(c.evaluatedAt) < c.ttl {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	if err := c.cache.WaitForSync(ctx); err != nil {
+		logger.Printf("Serving stale metrics: %v", err)
+		exporterUp.Set(0)
+		exporterEvaluations.WithLabelValues("error").Inc()
+		return
+	}
+
+	results := c.cache.Evaluate(c.target)
+	seen := make(map[string]bool, len(results))
+	for _, result := range results {
+		recordCheckMetrics(c.target, result)
+		if result.Namespace != "" {
+			seen[result.Namespace] = true
+			recordResourceMetrics(result.Namespace, c.cache.Pods(result.Namespace))
+		}
+	}
+	for namespace := range c.namespaces {
+		if !seen[namespace] {
+			forgetNamespaceMetrics(namespace)
+		}
+	}
+	c.namespaces = seen
+
+	checkDuration.WithLabelValues(exporterTarget).Observe(time.Since(start).Seconds())
+	exporterUp.Set(1)
+	exporterEvaluations.WithLabelValues("success").Inc()
+	c.evaluatedAt = time.Now()
+}
+
+// clusterCache is a lazily started informer cache of pods, workloads, namespaces and nodes
+type clusterCache struct {
+	ctx       context.Context
+	namespace string
+	factories []informers.SharedInformerFactory
+	synced    []cache.InformerSynced
+	started   bool
+
+	pods         corelisters.PodLister
+	namespaces   corelisters.NamespaceLister
+	nodes        corelisters.NodeLister
+	deployments  appslisters.DeploymentLister
+	statefulSets appslisters.StatefulSetLister
+	daemonSets   appslisters.DaemonSetLister
+}
+
+func newClusterCache(ctx context.Context, client kubernetes.Interface, namespace string) *clusterCache {
+	namespaced := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace))
+	cluster := informers.NewSharedInformerFactory(client, 0)
+
+	c := &clusterCache{
+		ctx:          ctx,
+		namespace:    namespace,
+		factories:    []informers.SharedInformerFactory{namespaced, cluster},
+		pods:         namespaced.Core().V1().Pods().Lister(),
+		deployments:  namespaced.Apps().V1().Deployments().Lister(),
+		statefulSets: namespaced.Apps().V1().StatefulSets().Lister(),
+		daemonSets:   namespaced.Apps().V1().DaemonSets().Lister(),
+		nodes:        cluster.Core().V1().Nodes().Lister(),
+	}
+	c.synced = []cache.InformerSynced{
+		namespaced.Core().V1().Pods().Informer().HasSynced,
+		namespaced.Apps().V1().Deployments().Informer().HasSynced,
+		namespaced.Apps().V1().StatefulSets().Informer().HasSynced,
+		namespaced.Apps().V1().DaemonSets().Informer().HasSynced,
+		cluster.Core().V1().Nodes().Informer().HasSynced,
+	}
+	// Namespaces are only needed to find empty namespaces when watching the whole cluster
+	if namespace == "" {
+		c.namespaces = cluster.Core().V1().Namespaces().Lister()
+		c.synced = append(c.synced, cluster.Core().V1().Namespaces().Informer().HasSynced)
+	}
+	return c
+}
+
+// WaitForSync starts the informers on first use and waits until their caches have synced
+func (c *clusterCache) WaitForSync(ctx context.Context) error {
+	if !c.started {
+		for _, factory := range c.factories {
+			factory.Start(c.ctx.Done())
+		}
+		c.started = true
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), c.synced...) {
+		return fmt.Errorf("informer cache did not sync within the scrape timeout")
+	}
+	return nil
+}
+
+// Pods returns the cached pods of a namespace sorted by name
+func (c *clusterCache) Pods(namespace string) []corev1.Pod {
+	cached, err := c.pods.Pods(namespace).List(labels.Everything())
+	if err != nil {
+		logger.Printf("Error reading pod cache: %v\n", err)
+		return nil
+	}
+	pods := make([]corev1.Pod, 0, len(cached))
+	for _, pod := range cached {
+		pods = append(pods, *pod)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods
+}
+
+// Evaluate builds one result per namespace plus a cluster result holding the nodes
+func (c *clusterCache) Evaluate(target MonitorTarget) []HealthCheckResult {
+	var namespaces []string
+	if c.namespace != "" {
+		namespaces = []string{c.namespace}
+	} else {
+		cached, err := c.namespaces.List(labels.Everything())
+		if err != nil {
+			logger.Printf("Error reading namespace cache: %v\n", err)
+		}
+		for _, ns := range cached {
+			namespaces = append(namespaces, ns.Name)
+		}
+		sort.Strings(namespaces)
+	}
+
+	results := make([]HealthCheckResult, 0, len(namespaces)+1)
+	for _, namespace := range namespaces {
+		result := summarizePodHealth(namespace, c.Pods(namespace), target.Thresholds)
+		result.Target = target.Name
+
+		deployments, _ := c.deployments.Deployments(namespace).List(labels.Everything())
+		for _, d := range deployments {
+			result.Workloads = append(result.Workloads, evaluateDeployment(d, target.Thresholds))
+		}
+		statefulSets, _ := c.statefulSets.StatefulSets(namespace).List(labels.Everything())
+		for _, s := range statefulSets {
+			result.Workloads = append(result.Workloads, evaluateStatefulSet(s, target.Thresholds))
+		}
+		daemonSets, _ := c.daemonSets.DaemonSets(namespace).List(labels.Everything())
+		for _, d := range daemonSets {
+			result.Workloads = append(result.Workloads, evaluateDaemonSet(d, target.Thresholds))
+		}
+		results = append(results, result)
+	}
+
+	nodes, err := c.nodes.List(labels.Everything())
+	if err != nil {
+		logger.Printf("Error reading node cache: %v\n", err)
+	}
+	nodeResult := HealthCheckResult{Target: target.Name, Timestamp: time.Now(), PodDetails: []PodHealthStatus{}}
+	for _, node := range nodes {
+		nodeResult.Nodes = append(nodeResult.Nodes, evaluateNode(node))
+	}
+	sort.Slice(nodeResult.Nodes, func(i, j int) bool { return nodeResult.Nodes[i].Name < nodeResult.Nodes[j].Name })
+	return append(results, nodeResult)
+}
@@ -0,0 +1,504 @@
+// Persistent health history backed by an embedded bbolt file or a ConfigMap.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/util/retry"
+)
+
+// History record kinds
+const (
+	HistoryKindCheck      = "check"
+	HistoryKindTransition = "transition"
+)
+
+const (
+	historyBucket = "records"
+	// historyConfigMap holds history when the ConfigMap store is selected
+	historyConfigMap = "k8stoolbox-history"
+	historyDataKey   = "history.json"
+	// historyConfigMapMaxBytes keeps the ConfigMap well below the 1MiB object limit
+	historyConfigMapMaxBytes = 900 * 1024
+	// historyFlushInterval batches ConfigMap writes
+	historyFlushInterval = 30 * time.Second
+)
+
+// HistoryRecord is a stored check result or health transition
+type HistoryRecord struct {
+	Kind       string             `json:"kind"`
+	Namespace  string             `json:"namespace"`
+	Timestamp  time.Time          `json:"timestamp"`
+	Check      *HealthCheckResult `json:"check,omitempty"`
+	Transition *HealthTransition  `json:"transition,omitempty"`
+}
+
+// HistoryQuery filters history records. Empty fields match everything.
+type HistoryQuery struct {
+	Namespace string
+	Pod       string
+	Kind      string
+	Since     time.Time
+}
+
+// HistoryStore persists health history
+type HistoryStore interface {
+	Record(ctx context.Context, record HistoryRecord) error
+	Query(ctx context.Context, query HistoryQuery) ([]HistoryRecord, error)
+	Prune(ctx context.Context, before time.Time) error
+	Close() error
+}
+
+// historyStore is the process-wide store, set when HISTORY_STORE is configured
+var historyStore HistoryStore
+
+// openHistoryStore opens the store described by spec: "configmap" or "bolt:<path>"
+// (a bare path also selects bolt, e.g. a file on a mounted PVC)
+func openHistoryStore(spec string) (HistoryStore, error) {
+	if spec == "configmap" {
+		if clientset == nil {
+			return nil, fmt.Errorf("the configmap history store requires a Kubernetes client")
+		}
+		return newConfigMapHistoryStore(toolboxNamespace()), nil
+	}
+	return newBoltHistoryStore(strings.TrimPrefix(spec, "bolt:"))
+}
+
+// matches reports whether a record is selected by the query
+func (q HistoryQuery) matches(record HistoryRecord) bool {
+	if q.Namespace != "" && record.Namespace != q.Namespace {
+		return false
+	}
+	if q.Kind != "" && record.Kind != q.Kind {
+		return false
+	}
+	if !q.Since.IsZero() && record.Timestamp.Before(q.Since) {
+		return false
+	}
+	if q.Pod != "" {
+		if record.Transition != nil {
+			return record.Transition.Pod == q.Pod
+		}
+		if record.Check != nil {
+			for _, pod := range record.Check.PodDetails {
+				if pod.Name == q.Pod {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// narrow trims check records down to the queried pod
+func (q HistoryQuery) narrow(record HistoryRecord) HistoryRecord {
+	if q.Pod == "" || record.Check == nil {
+		return record
+	}
+	check := *record.Check
+	check.PodDetails = nil
+	check.HealthyPods, check.UnhealthyPods = 0, 0
+	for _, pod := range record.Check.PodDetails {
+		if pod.Name != q.Pod {
+			continue
+		}
+		check.PodDetails = append(check.PodDetails, pod)
+		if pod.Healthy() {
+			check.HealthyPods++
+		} else {
+			check.UnhealthyPods++
+		}
+	}
+	record.Check = &check
+	return record
+}
+
+// boltHistoryStore keeps history in an embedded bbolt file keyed by timestamp
+type boltHistoryStore struct {
+	db *bolt.DB
+}
+
+func newBoltHistoryStore(path string) (*boltHistoryStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 2 * time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("history file %s is locked by another k8stoolbox process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history file %s: %v", path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(historyBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize history file %s: %v", path, err)
+	}
+	return &boltHistoryStore{db: db}, nil
+}
+
+// historyKey orders records by time; the sequence suffix keeps same-instant records apart
+func historyKey(ts time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(ts.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func (s *boltHistoryStore) Record(ctx context.Context, record HistoryRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(historyBucket))
+		seq, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(historyKey(record.Timestamp, seq), data)
+	})
+}
+
+func (s *boltHistoryStore) Query(ctx context.Context, query HistoryQuery) ([]HistoryRecord, error) {
+	records := []HistoryRecord{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(historyBucket)).Cursor()
+		key, value := cursor.First()
+		if !query.Since.IsZero() {
+			key, value = cursor.Seek(historyKey(query.Since, 0))
+		}
+		for ; key != nil; key, value = cursor.Next() {
+			var record HistoryRecord
+			if err := json.Unmarshal(value, &record); err != nil {
+				return err
+			}
+			if query.matches(record) {
+				records = append(records, query.narrow(record))
+			}
+		}
+		return nil
+	})
+	return records, err
+}
+
+func (s *boltHistoryStore) Prune(ctx context.Context, before time.Time) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(historyBucket))
+		limit := historyKey(before, 0)
+
+		// Collect first: deleting through the cursor while iterating skips keys
+		var expired [][]byte
+		cursor := bucket.Cursor()
+		for key, _ := cursor.First(); key != nil && bytes.Compare(key, limit) < 0; key, _ = cursor.Next() {
+			expired = append(expired, append([]byte{}, key...))
+		}
+		for _, key := range expired {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *boltHistoryStore) Close() error {
+	return s.db.Close()
+}
+
+// configMapHistoryStore keeps recent history in a ConfigMap so it survives pod restarts
+// without a volume. Check results are stored as compact summaries and writes are batched.
+// When the summaries still exceed the size limit the oldest records are dropped, so the
+// effective retention can be shorter than HISTORY_RETENTION_HOURS; a warning is logged then.
+type configMapHistoryStore struct {
+	namespace string
+
+	mu        sync.Mutex
+	pending   []HistoryRecord
+	lastFlush time.Time
+}
+
+func newConfigMapHistoryStore(namespace string) *configMapHistoryStore {
+	return &configMapHistoryStore{namespace: namespace, lastFlush: time.Now()}
+}
+
+func (s *configMapHistoryStore) Record(ctx context.Context, record HistoryRecord) error {
+	s.mu.Lock()
+	s.pending = append(s.pending, compactHistoryRecord(record))
+	due := time.Since(s.lastFlush) >= historyFlushInterval
+	s.mu.Unlock()
+
+	if !due {
+		return nil
+	}
+	return s.flush(ctx, time.Time{})
+}
+
+func (s *configMapHistoryStore) Query(ctx context.Context, query HistoryQuery) ([]HistoryRecord, error) {
+	stored, _, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	all := append(stored, s.pending...)
+	s.mu.Unlock()
+
+	records := []HistoryRecord{}
+	for _, record := range all {
+		if query.matches(record) {
+			records = append(records, query.narrow(record))
+		}
+	}
+	return records, nil
+}
+
+func (s *configMapHistoryStore) Prune(ctx context.Context, before time.Time) error {
+	return s.flush(ctx, before)
+}
+
+func (s *configMapHistoryStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return s.flush(ctx, time.Time{})
+}
+
+// flush writes pending records and drops records older than before
+func (s *configMapHistoryStore) flush(ctx context.Context, before time.Time) error {
+	s.mu.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.lastFlush = time.Now()
+	s.mu.Unlock()
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		stored, cm, err := s.load(ctx)
+		if err != nil {
+			return err
+		}
+
+		records := []HistoryRecord{}
+		for _, record := range append(stored, pending...) {
+			if before.IsZero() || !record.Timestamp.Before(before) {
+				records = append(records, record)
+			}
+		}
+		sort.SliceStable(records, func(i, j int) bool { return records[i].Timestamp.Before(records[j].Timestamp) })
+
+		data, err := json.Marshal(records)
+		if err != nil {
+			return err
+		}
+		// Drop the oldest records until the payload fits
+		dropped := 0
+		for len(data) > historyConfigMapMaxBytes && len(records) > 0 {
+			n := (len(records) + 9) / 10
+			dropped += n
+			records = records[n:]
+			if data, err = json.Marshal(records); err != nil {
+				return err
+			}
+		}
+		if dropped > 0 && len(records) > 0 {
+			logger.Printf("⚠️ Health history in ConfigMap %s/%s exceeds %d KiB: dropped %d records, keeping history since %s only. Use a bolt store on a volume for longer retention.\n",
+				s.namespace, historyConfigMap, historyConfigMapMaxBytes/1024, dropped, records[0].Timestamp.Format(time.RFC3339))
+		}
+
+		if cm == nil {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      historyConfigMap,
+					Namespace: s.namespace,
+					Labels:    map[string]string{"app": "k8stoolbox"},
+				},
+				Data: map[string]string{historyDataKey: string(data)},
+			}
+			_, err = clientset.CoreV1().ConfigMaps(s.namespace).Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				return apierrors.NewConflict(corev1.Resource("configmaps"), historyConfigMap, err)
+			}
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = map[string]string{}
+		}
+		cm.Data[historyDataKey] = string(data)
+		_, err = clientset.CoreV1().ConfigMaps(s.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		// Keep the records so the next flush can retry
+		s.mu.Lock()
+		s.pending = append(pending, s.pending...)
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// compactHistoryRecord reduces a check result to what the ConfigMap store can afford to keep:
+// the pod counts and the unhealthy pods. Healthy pods and the results of the optional checks
+// are dropped, so pod queries only find checks in which the pod was unhealthy.
+func compactHistoryRecord(record HistoryRecord) HistoryRecord {
+	if record.Check == nil {
+		return record
+	}
+	check := HealthCheckResult{
+		Namespace:     record.Check.Namespace,
+		Target:        record.Check.Target,
+		HealthyPods:   record.Check.HealthyPods,
+		UnhealthyPods: record.Check.UnhealthyPods,
+		Timestamp:     record.Check.Timestamp,
+	}
+	for _, pod := range record.Check.PodDetails {
+		if pod.Healthy() {
+			continue
+		}
+		check.PodDetails = append(check.PodDetails, pod)
+	}
+	record.Check = &check
+	return record
+}
+
+func (s *configMapHistoryStore) load(ctx context.Context) ([]HistoryRecord, *corev1.ConfigMap, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, historyConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read history: %v", err)
+	}
+
+	var records []HistoryRecord
+	if data := cm.Data[historyDataKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &records); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse history in ConfigMap %s/%s: %v", s.namespace, historyConfigMap, err)
+		}
+	}
+	return records, cm, nil
+}
+
+// recordHistory stores a record in the configured history store, if any
+func recordHistory(ctx context.Context, record HistoryRecord) {
+	if historyStore == nil {
+		return
+	}
+	if err := historyStore.Record(ctx, record); err != nil {
+		logger.Printf("Failed to record health history: %v", err)
+	}
+}
+
+// parseSince accepts either a duration before now (e.g. 24h) or an RFC3339 timestamp
+func parseSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since %q: expected a duration or RFC3339 timestamp", value)
+	}
+	return t, nil
+}
+
+// showHistory prints stored history records
+func showHistory(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("history", flag.ExitOnError)
+	namespace := cmd.String("namespace", "", "Only show records for this namespace")
+	pod := cmd.String("pod", "", "Only show records for this pod")
+	kind := cmd.String("kind", "", "Only show records of this kind (check, transition)")
+	since := cmd.String("since", "24h", "Show records since a duration ago or an RFC3339 timestamp")
+	output := cmd.String("output", "text", "Output format (text, json)")
+	if err := cmd.Parse(args); err != nil {
+		return
+	}
+
+	if historyStore == nil {
+		logger.Fatalf("No history store configured; set HISTORY_STORE (e.g. bolt:/data/history.db or configmap)")
+	}
+	sinceTime, err := parseSince(*since)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+
+	records, err := historyStore.Query(ctx, HistoryQuery{Namespace: *namespace, Pod: *pod, Kind: *kind, Since: sinceTime})
+	if err != nil {
+		logger.Fatalf("Failed to query history: %v", err)
+	}
+
+	if *output == "json" {
+		jsonData, err := json.MarshalIndent(records, "", "  ")
+		if err == nil {
+			fmt.Println(string(jsonData))
+		}
+		return
+	}
+
+	for _, record := range records {
+		ts := record.Timestamp.Format(time.RFC3339)
+		switch {
+		case record.Transition != nil:
+			t := record.Transition
+			fmt.Printf("%s  %-10s %s/%s %s -> %s (Status: %s) %s\n", ts, record.Kind, t.Namespace, t.Pod,
+				t.Previous, t.Current, t.Status, strings.Join(t.Codes, ","))
+		case record.Check != nil:
+			fmt.Printf("%s  %-10s %s: %d healthy pods, %d unhealthy pods\n", ts, record.Kind, record.Namespace,
+				record.Check.HealthyPods, record.Check.UnhealthyPods)
+		}
+	}
+	logger.Printf("%d history records since %s\n", len(records), sinceTime.Format(time.RFC3339))
+}
+
+// historyHandler serves /api/v1/history?namespace=&pod=&since=&kind=
+func historyHandler(w http.ResponseWriter, r *http.Request) {
+	if historyStore == nil {
+		errorResponse(w, "no history store configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	since, err := parseSince(query.Get("since"))
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := historyStore.Query(r.Context(), HistoryQuery{
+		Namespace: query.Get("namespace"),
+		Pod:       query.Get("pod"),
+		Kind:      query.Get("kind"),
+		Since:     since,
+	})
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Data: records})
+}
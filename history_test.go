@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func checkRecord(ts time.Time, healthy, unhealthy int) HistoryRecord {
+	check := &HealthCheckResult{Namespace: "shop", HealthyPods: healthy, UnhealthyPods: unhealthy, Timestamp: ts,
+		Workloads: []WorkloadHealthStatus{{Kind: "Deployment", Name: "web"}}}
+	for i := 0; i < healthy; i++ {
+		check.PodDetails = append(check.PodDetails, PodHealthStatus{Name: fmt.Sprintf("ok-%d", i), Status: "Running"})
+	}
+	for i := 0; i < unhealthy; i++ {
+		check.PodDetails = append(check.PodDetails, PodHealthStatus{
+			Name:   fmt.Sprintf("bad-%d", i),
+			Status: "Running",
+			Issues: []string{"Container web is not ready " + strings.Repeat("x", 200)},
+			Codes:  []string{IssueContainerNotReady},
+		})
+	}
+	return HistoryRecord{Kind: HistoryKindCheck, Namespace: "shop", Timestamp: ts, Check: check}
+}
+
+func withFakeClientset(t *testing.T) {
+	previous := clientset
+	clientset = fake.NewClientset()
+	t.Cleanup(func() { clientset = previous })
+}
+
+func TestConfigMapHistoryStoreCompactsChecks(t *testing.T) {
+	withFakeClientset(t)
+	ctx := context.Background()
+	store := newConfigMapHistoryStore("toolbox")
+
+	if err := store.Record(ctx, checkRecord(time.Now(), 3, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := store.Query(ctx, HistoryQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	check := records[0].Check
+	if check.HealthyPods != 3 || check.UnhealthyPods != 1 {
+		t.Errorf("pod counts were not kept: %+v", check)
+	}
+	if len(check.PodDetails) != 1 || check.PodDetails[0].Name != "bad-0" || check.Workloads != nil {
+		t.Errorf("check was not compacted to its unhealthy pods: %+v", check)
+	}
+
+	narrowed, err := store.Query(ctx, HistoryQuery{Pod: "bad-0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(narrowed) != 1 || narrowed[0].Check.UnhealthyPods != 1 {
+		t.Errorf("unhealthy pod not found in compacted history: %+v", narrowed)
+	}
+}
+
+func TestConfigMapHistoryStoreDropsOldestToFit(t *testing.T) {
+	withFakeClientset(t)
+	ctx := context.Background()
+	store := newConfigMapHistoryStore("toolbox")
+
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 100; i++ {
+		store.pending = append(store.pending, compactHistoryRecord(checkRecord(start.Add(time.Duration(i)*time.Minute), 0, 50)))
+	}
+	if err := store.flush(ctx, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cm, err := clientset.CoreV1().ConfigMaps("toolbox").Get(ctx, historyConfigMap, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := len(cm.Data[historyDataKey]); size > historyConfigMapMaxBytes {
+		t.Errorf("history of %d bytes exceeds the limit", size)
+	}
+
+	records, err := store.Query(ctx, HistoryQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) == 0 || len(records) == 100 {
+		t.Fatalf("expected the oldest records to be dropped, kept %d", len(records))
+	}
+	if last := records[len(records)-1].Timestamp; !last.Equal(start.Add(99 * time.Minute)) {
+		t.Errorf("newest record was dropped, last is %s", last)
+	}
+}
@@ -0,0 +1,718 @@
+// Scheduled jobs of server mode: cron-like schedules, leader election and run status.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"sigs.k8s.io/yaml"
+)
+
+// Job types the scheduler can run
+const (
+	JobTypeBackup   = "backup"
+	JobTypeBundle   = "bundle"
+	JobTypeSnapshot = "snapshot"
+)
+
+// schedulerLeaseName is the lease replicas compete for; only the holder runs jobs
+const schedulerLeaseName = "k8stoolbox-scheduler"
+
+// defaultJobTimeout bounds a job run unless the job sets its own timeout
+const defaultJobTimeout = 30 * time.Minute
+
+// Schedule computes when a job runs next
+type Schedule interface {
+	Next(after time.Time) time.Time
+}
+
+// cronSchedule is a standard five-field cron expression: minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// When both day fields are restricted, a day matching either runs the job, as in cron
+	domStar, dowStar bool
+}
+
+// everySchedule runs at a fixed interval
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(after time.Time) time.Time {
+	return after.Add(s.interval)
+}
+
+// scheduleDescriptors are the predefined schedules of cron
+var scheduleDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// parseSchedule parses a cron expression, a descriptor such as @daily, or "@every <duration>"
+func parseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if interval, ok := strings.CutPrefix(spec, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("invalid interval %q: must be a duration of at least 1m", interval)
+		}
+		return everySchedule{interval: d}, nil
+	}
+	if expanded, ok := scheduleDescriptors[spec]; ok {
+		spec = expanded
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields (minute hour day-of-month month day-of-week)", spec)
+	}
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid minute: %v", err)
+	}
+	if s.hour, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid hour: %v", err)
+	}
+	if s.dom, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid day of month: %v", err)
+	}
+	if s.month, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid month: %v", err)
+	}
+	if s.dow, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid day of week: %v", err)
+	}
+	// Sunday is both 0 and 7
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	return s, nil
+}
+
+// parseCronField parses lists of values, ranges and steps (e.g. "*/15", "1-5", "0,30") into a bit set
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		valueRange, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepText)
+			}
+		}
+
+		low, high := min, max
+		if valueRange != "*" {
+			lowText, highText, isRange := strings.Cut(valueRange, "-")
+			var err error
+			if low, err = strconv.Atoi(lowText); err != nil {
+				return 0, fmt.Errorf("invalid value %q", lowText)
+			}
+			high = low
+			if isRange {
+				if high, err = strconv.Atoi(highText); err != nil {
+					return 0, fmt.Errorf("invalid value %q", highText)
+				}
+			} else if hasStep {
+				high = max
+			}
+		}
+		if low < min || high > max || low > high {
+			return 0, fmt.Errorf("%q is out of range %d-%d", part, min, max)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func (s cronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first matching minute after the given time, or the zero time if there is
+// none within five years (e.g. February 30th). Local times skipped by a DST change do not
+// run; a fixed hour repeated by a DST change runs once.
+func (s cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = advanceTo(t, time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location()))
+		case !s.dayMatches(t):
+			t = advanceTo(t, time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location()))
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			// Whole minutes rather than time.Date, which normalises back into a DST gap
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		case s.hour != allCronHours && isRepeatedLocalTime(t):
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// allCronHours is the hour field of "*"
+const allCronHours = 1<<24 - 1
+
+// advanceTo returns next, moved past t when time.Date normalised a local time that does not
+// exist (a DST gap) to an earlier instant
+func advanceTo(t, next time.Time) time.Time {
+	for !next.After(t) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+// isRepeatedLocalTime reports whether the wall clock time of t already occurred an hour
+// earlier, i.e. t is in the second pass of an hour repeated when DST ends
+func isRepeatedLocalTime(t time.Time) bool {
+	earlier := t.Add(-time.Hour)
+	return earlier.Hour() == t.Hour() && earlier.Minute() == t.Minute() && earlier.Day() == t.Day()
+}
+
+// BackupJobSpec configures a scheduled backup
+type BackupJobSpec struct {
+	Namespaces          []string        `json:"namespaces,omitempty"`
+	AllNamespaces       bool            `json:"allNamespaces,omitempty"`
+	ExcludeNamespaces   []string        `json:"excludeNamespaces,omitempty"`
+	IncludeResources    []string        `json:"includeResources,omitempty"`
+	ExcludeResources    []string        `json:"excludeResources,omitempty"`
+	Selector            string          `json:"selector,omitempty"`
+	Storage             string          `json:"storage,omitempty"`
+	EncryptionKeyFile   string          `json:"encryptionKeyFile,omitempty"`
+	EncryptionKeySecret string          `json:"encryptionKeySecret,omitempty"`
+	Retention           RetentionPolicy `json:"retention,omitempty"`
+}
+
+// BundleJobSpec configures a scheduled support bundle
+type BundleJobSpec struct {
+	Namespaces    []string        `json:"namespaces,omitempty"`
+	AllNamespaces bool            `json:"allNamespaces,omitempty"`
+	Storage       string          `json:"storage,omitempty"`
+	Logs          *bool           `json:"logs,omitempty"`
+	LogTail       int64           `json:"logTail,omitempty"`
+	MaxSize       string          `json:"maxSize,omitempty"`
+	Retention     RetentionPolicy `json:"retention,omitempty"`
+}
+
+// SnapshotJobSpec configures a scheduled snapshot. Every snapshot is compared with the
+// previous one in the storage to report drift.
+type SnapshotJobSpec struct {
+	Namespaces []string        `json:"namespaces,omitempty"`
+	Storage    string          `json:"storage,omitempty"`
+	Retention  RetentionPolicy `json:"retention,omitempty"`
+}
+
+// ScheduledJob is a job run by the scheduler
+type ScheduledJob struct {
+	Name     string           `json:"name"`
+	Schedule string           `json:"schedule"`
+	Type     string           `json:"type"`
+	Timeout  *metav1.Duration `json:"timeout,omitempty"`
+	Backup   *BackupJobSpec   `json:"backup,omitempty"`
+	Bundle   *BundleJobSpec   `json:"bundle,omitempty"`
+	Snapshot *SnapshotJobSpec `json:"snapshot,omitempty"`
+
+	schedule Schedule
+}
+
+// Validate checks the job and parses its schedule
+func (j *ScheduledJob) Validate() error {
+	if j.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	schedule, err := parseSchedule(j.Schedule)
+	if err != nil {
+		return err
+	}
+	j.schedule = schedule
+	if j.Timeout == nil {
+		j.Timeout = &metav1.Duration{Duration: defaultJobTimeout}
+	}
+
+	switch j.Type {
+	case JobTypeBackup:
+		if j.Backup == nil {
+			j.Backup = &BackupJobSpec{}
+		}
+		if len(j.Backup.Namespaces) == 0 && !j.Backup.AllNamespaces {
+			return fmt.Errorf("backup needs namespaces or allNamespaces")
+		}
+		if j.Backup.EncryptionKeyFile != "" && j.Backup.EncryptionKeySecret != "" {
+			return fmt.Errorf("encryptionKeyFile and encryptionKeySecret are mutually exclusive")
+		}
+	case JobTypeBundle:
+		if j.Bundle == nil {
+			j.Bundle = &BundleJobSpec{}
+		}
+		if len(j.Bundle.Namespaces) == 0 && !j.Bundle.AllNamespaces {
+			return fmt.Errorf("bundle needs namespaces or allNamespaces")
+		}
+		if j.Bundle.MaxSize != "" {
+			if _, err := resource.ParseQuantity(j.Bundle.MaxSize); err != nil {
+				return fmt.Errorf("invalid maxSize %q: %v", j.Bundle.MaxSize, err)
+			}
+		}
+	case JobTypeSnapshot:
+		if j.Snapshot == nil {
+			j.Snapshot = &SnapshotJobSpec{}
+		}
+	default:
+		return fmt.Errorf("unknown job type %q", j.Type)
+	}
+	return nil
+}
+
+// ScheduleConfig lists the jobs run in server mode
+type ScheduleConfig struct {
+	Jobs []ScheduledJob `json:"jobs"`
+}
+
+// Validate checks every job
+func (c *ScheduleConfig) Validate() error {
+	if len(c.Jobs) == 0 {
+		return fmt.Errorf("at least one job is required")
+	}
+	names := make(map[string]bool)
+	for i := range c.Jobs {
+		if err := c.Jobs[i].Validate(); err != nil {
+			return fmt.Errorf("job %d: %v", i, err)
+		}
+		if names[c.Jobs[i].Name] {
+			return fmt.Errorf("duplicate job name %q", c.Jobs[i].Name)
+		}
+		names[c.Jobs[i].Name] = true
+	}
+	return nil
+}
+
+// loadScheduleConfig reads a schedule config file (YAML or JSON)
+func loadScheduleConfig(path string) (ScheduleConfig, error) {
+	var cfg ScheduleConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read schedule config: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse schedule config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid schedule config: %v", err)
+	}
+	return cfg, nil
+}
+
+// JobRun is the outcome of one run of a job
+type JobRun struct {
+	StartedAt  time.Time `json:"startedAt"`
+	FinishedAt time.Time `json:"finishedAt"`
+	Duration   string    `json:"duration"`
+	Success    bool      `json:"success"`
+	Result     string    `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// JobStatus is the state of a scheduled job
+type JobStatus struct {
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	Schedule string    `json:"schedule"`
+	NextRun  time.Time `json:"nextRun,omitempty"`
+	Running  bool      `json:"running"`
+	Runs     int       `json:"runs"`
+	Failures int       `json:"failures"`
+	LastRun  *JobRun   `json:"lastRun,omitempty"`
+}
+
+// SchedulerStatus is returned by the jobs API
+type SchedulerStatus struct {
+	Identity string      `json:"identity"`
+	Leader   string      `json:"leader,omitempty"`
+	IsLeader bool        `json:"isLeader"`
+	Jobs     []JobStatus `json:"jobs"`
+}
+
+// Scheduler runs jobs on their schedules while this replica holds the scheduler lease
+type Scheduler struct {
+	jobs     []ScheduledJob
+	identity string
+
+	mu     sync.Mutex
+	leader string
+	status map[string]*JobStatus
+}
+
+// jobScheduler is the scheduler of server mode, read by the jobs API
+var jobScheduler atomic.Pointer[Scheduler]
+
+func newScheduler(cfg ScheduleConfig) *Scheduler {
+	identity := getEnv("POD_NAME", "")
+	if identity == "" {
+		identity, _ = os.Hostname()
+	}
+	s := &Scheduler{jobs: cfg.Jobs, identity: identity, status: make(map[string]*JobStatus)}
+	for _, job := range cfg.Jobs {
+		s.status[job.Name] = &JobStatus{Name: job.Name, Type: job.Type, Schedule: job.Schedule}
+	}
+	return s
+}
+
+// Status returns a snapshot of the scheduler and its jobs
+func (s *Scheduler) Status() SchedulerStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := SchedulerStatus{Identity: s.identity, Leader: s.leader, IsLeader: s.leader == s.identity}
+	for _, job := range s.jobs {
+		js := *s.status[job.Name]
+		if js.LastRun != nil {
+			run := *js.LastRun
+			js.LastRun = &run
+		}
+		status.Jobs = append(status.Jobs, js)
+	}
+	sort.Slice(status.Jobs, func(i, j int) bool { return status.Jobs[i].Name < status.Jobs[j].Name })
+	return status
+}
+
+func (s *Scheduler) update(name string, fn func(*JobStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(s.status[name])
+}
+
+// Run competes for the scheduler lease and runs the jobs while holding it, until ctx is done
+func (s *Scheduler) Run(ctx context.Context) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta:  metav1.ObjectMeta{Name: schedulerLeaseName, Namespace: toolboxNamespace()},
+		Client:     clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: s.identity},
+	}
+	logger.Printf("Scheduler %s waiting for lease %s/%s", s.identity, lock.LeaseMeta.Namespace, schedulerLeaseName)
+
+	// A replica that loses the lease competes again until shutdown
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			Name:            schedulerLeaseName,
+			LeaseDuration:   30 * time.Second,
+			RenewDeadline:   20 * time.Second,
+			RetryPeriod:     5 * time.Second,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					logger.Printf("✅ Scheduler %s acquired the lease, running %d jobs", s.identity, len(s.jobs))
+					s.runJobs(ctx)
+				},
+				OnStoppedLeading: func() {
+					logger.Printf("Scheduler %s released the lease", s.identity)
+				},
+				OnNewLeader: func(identity string) {
+					s.mu.Lock()
+					s.leader = identity
+					s.mu.Unlock()
+				},
+			},
+		})
+	}
+}
+
+// runJobs runs every job on its schedule until ctx is done. Runs of a job never overlap.
+func (s *Scheduler) runJobs(ctx context.Context) {
+	var wg sync.WaitGroup
+	for i := range s.jobs {
+		wg.Add(1)
+		go func(job ScheduledJob) {
+			defer wg.Done()
+			for {
+				next := job.schedule.Next(time.Now())
+				s.update(job.Name, func(js *JobStatus) { js.NextRun = next })
+				if next.IsZero() {
+					logger.Printf("⚠️ Job %s never runs: schedule %q has no next time", job.Name, job.Schedule)
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(time.Until(next)):
+				}
+				s.runJob(ctx, job)
+			}
+		}(s.jobs[i])
+	}
+	wg.Wait()
+}
+
+// runJob runs a job once and records its outcome
+func (s *Scheduler) runJob(ctx context.Context, job ScheduledJob) {
+	run := JobRun{StartedAt: time.Now()}
+	s.update(job.Name, func(js *JobStatus) { js.Running = true })
+	logger.Printf("Running %s job %s", job.Type, job.Name)
+
+	ctx, cancel := context.WithTimeout(ctx, job.Timeout.Duration)
+	defer cancel()
+	ctx, span := startSpan(ctx, "job "+job.Name)
+	result, err := executeJob(ctx, job)
+	endSpan(span, err)
+
+	run.FinishedAt = time.Now()
+	run.Duration = run.FinishedAt.Sub(run.StartedAt).Round(time.Millisecond).String()
+	run.Success = err == nil
+	run.Result = result
+	if err != nil {
+		run.Error = err.Error()
+		logger.Printf("⚠️ Job %s failed after %s: %v", job.Name, run.Duration, err)
+	} else {
+		logger.Printf("✅ Job %s finished in %s: %s", job.Name, run.Duration, result)
+	}
+	s.update(job.Name, func(js *JobStatus) {
+		js.Running = false
+		js.Runs++
+		if !run.Success {
+			js.Failures++
+		}
+		js.LastRun = &run
+	})
+}
+
+// executeJob runs the work of a job and summarizes the result
+func executeJob(ctx context.Context, job ScheduledJob) (string, error) {
+	switch job.Type {
+	case JobTypeBackup:
+		return runBackupJob(ctx, job.Backup)
+	case JobTypeBundle:
+		return runBundleJob(ctx, job.Bundle)
+	case JobTypeSnapshot:
+		return runSnapshotJob(ctx, job.Snapshot)
+	}
+	return "", fmt.Errorf("unknown job type %q", job.Type)
+}
+
+func runBackupJob(ctx context.Context, spec *BackupJobSpec) (string, error) {
+	namespaces, err := backupNamespaces(ctx, strings.Join(spec.Namespaces, ","), spec.AllNamespaces,
+		append(append([]string{}, systemNamespaces...), spec.ExcludeNamespaces...))
+	if err != nil {
+		return "", err
+	}
+	opts := BackupOptions{
+		Namespaces: namespaces,
+		Filter: ResourceFilter{
+			Include: spec.IncludeResources,
+			Exclude: append(append([]string{}, defaultBackupExclusions...), spec.ExcludeResources...),
+		},
+		Selector: spec.Selector,
+	}
+	if opts.Key, err = loadBackupKey(ctx, spec.EncryptionKeyFile, spec.EncryptionKeySecret); err != nil {
+		return "", err
+	}
+
+	storage, err := openBackupStorage(ctx, conditionalString(spec.Storage == "", config2.BackupStorage, spec.Storage))
+	if err != nil {
+		return "", err
+	}
+	defer storage.Close()
+	name := backupNamePrefix + time.Now().Format("20060102-150405") + backupArchiveSuffix
+	manifest, err := writeBackup(ctx, storage, name, opts)
+	if err != nil {
+		return "", err
+	}
+	expired, err := pruneBackups(ctx, storage, spec.Retention, backupNamePrefix, false)
+	if err != nil {
+		return "", fmt.Errorf("backup %s written, but retention failed: %v", name, err)
+	}
+	return fmt.Sprintf("backed up %d objects from %d namespaces to %s in %s, %d expired backups deleted",
+		manifest.Objects, len(manifest.Namespaces), name, storage, len(expired)), nil
+}
+
+func runBundleJob(ctx context.Context, spec *BundleJobSpec) (string, error) {
+	namespaces, err := backupNamespaces(ctx, strings.Join(spec.Namespaces, ","), spec.AllNamespaces, nil)
+	if err != nil {
+		return "", err
+	}
+	opts := BundleOptions{
+		Namespaces: namespaces,
+		Logs:       spec.Logs == nil || *spec.Logs,
+		LogTail:    spec.LogTail,
+		Redact:     true,
+		MaxBytes:   100 << 20,
+	}
+	if opts.LogTail == 0 {
+		opts.LogTail = 1000
+	}
+	if spec.MaxSize != "" {
+		budget := resource.MustParse(spec.MaxSize)
+		opts.MaxBytes = budget.Value()
+	}
+
+	storage, err := openBackupStorage(ctx, conditionalString(spec.Storage == "", config2.BackupStorage, spec.Storage))
+	if err != nil {
+		return "", err
+	}
+	defer storage.Close()
+
+	file, err := os.CreateTemp("", "k8stoolbox-bundle-*.tar.gz")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	file.Close()
+	defer os.Remove(file.Name())
+	manifest, err := createBundle(ctx, file.Name(), opts)
+	if err != nil {
+		return "", err
+	}
+	bundle, err := os.Open(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read bundle: %v", err)
+	}
+	defer bundle.Close()
+	name := fmt.Sprintf("k8stoolbox-bundle-%s.tar.gz", manifest.CreatedAt.Format("20060102-150405"))
+	if err := storage.Put(ctx, name, bundle); err != nil {
+		return "", err
+	}
+	expired, err := pruneBackups(ctx, storage, spec.Retention, "k8stoolbox-bundle-", false)
+	if err != nil {
+		return "", fmt.Errorf("bundle %s written, but retention failed: %v", name, err)
+	}
+	return fmt.Sprintf("bundle of %d namespaces written to %s in %s with %d collection errors, %d expired bundles deleted",
+		len(namespaces), name, storage, len(manifest.Errors), len(expired)), nil
+}
+
+func runSnapshotJob(ctx context.Context, spec *SnapshotJobSpec) (string, error) {
+	storage, err := openBackupStorage(ctx, conditionalString(spec.Storage == "", config2.BackupStorage, spec.Storage))
+	if err != nil {
+		return "", err
+	}
+	defer storage.Close()
+
+	file, err := os.CreateTemp("", snapshotNamePrefix+"*"+backupArchiveSuffix)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	previous, err := latestSnapshot(ctx, storage)
+	if err != nil {
+		return "", err
+	}
+	opts := SnapshotOptions{Namespaces: spec.Namespaces}
+	if previous != nil {
+		// Sharing the salt keeps secret changes since the previous snapshot detectable
+		opts.SecretSalt = previous.Manifest.SecretSalt
+	}
+	manifest, err := takeSnapshot(ctx, file, opts)
+	if err != nil {
+		return "", err
+	}
+	name := manifest.Name + backupArchiveSuffix
+
+	drift := "no previous snapshot to compare"
+	if previous != nil {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return "", fmt.Errorf("failed to read snapshot: %v", err)
+		}
+		current, err := readSnapshot(name, file)
+		if err != nil {
+			return "", err
+		}
+		diff := diffSnapshots(previous, current)
+		for _, f := range diff.Findings {
+			logger.Printf("⚠️ Drift since %s: [%s] %s: %s", previous.Manifest.Name, f.Category, f.Object, f.Message)
+		}
+		drift = fmt.Sprintf("since %s: %d added, %d removed, %d modified, %d findings",
+			previous.Manifest.Name, diff.Added, diff.Removed, diff.Modified, len(diff.Findings))
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	if err := storage.Put(ctx, name, file); err != nil {
+		return "", err
+	}
+	expired, err := pruneBackups(ctx, storage, spec.Retention, snapshotNamePrefix, false)
+	if err != nil {
+		return "", fmt.Errorf("snapshot %s written, but retention failed: %v", name, err)
+	}
+	return fmt.Sprintf("snapshot of %d objects written to %s in %s (%s), %d expired snapshots deleted",
+		manifest.Objects, name, storage, drift, len(expired)), nil
+}
+
+// latestSnapshot loads the newest snapshot in storage, or nil when there is none
+func latestSnapshot(ctx context.Context, storage BackupStorage) (*Snapshot, error) {
+	objects, err := storage.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range objects {
+		if !strings.HasPrefix(o.Name, snapshotNamePrefix) {
+			continue
+		}
+		r, err := storage.Get(ctx, o.Name)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readSnapshot(o.Name, r)
+	}
+	return nil, nil
+}
+
+// startScheduler loads the schedule config and runs the scheduler until ctx is done
+func startScheduler(ctx context.Context, path string) {
+	cfg, err := loadScheduleConfig(path)
+	if err != nil {
+		logger.Printf("⚠️ Scheduled jobs disabled: %v", err)
+		return
+	}
+	if StandaloneMode {
+		logger.Println("⚠️ Scheduled jobs disabled: they need a Kubernetes cluster")
+		return
+	}
+	scheduler := newScheduler(cfg)
+	jobScheduler.Store(scheduler)
+	scheduler.Run(ctx)
+}
+
+// jobsHandler returns the scheduled jobs and their last runs
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	scheduler := jobScheduler.Load()
+	if scheduler == nil {
+		errorResponse(w, "no scheduled jobs: run in server mode with SCHEDULE_CONFIG", http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(APIResponse{Success: true, Data: scheduler.Status()})
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"*/15 * * * *", false},
+		{"0 9-17/2 * * 1-5", false},
+		{"0,30 0 1,15 * *", false},
+		{"0 0 * * 7", false},
+		{"@daily", false},
+		{"@every 90m", false},
+		{"@every 30s", true},
+		{"@every soon", true},
+		{"* * * *", true},
+		{"60 * * * *", true},
+		{"0 24 * * *", true},
+		{"0 0 0 * *", true},
+		{"0 0 * 13 *", true},
+		{"0 0 * * 8", true},
+		{"5-1 * * * *", true},
+		{"*/0 * * * *", true},
+		{"a * * * *", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			_, err := parseSchedule(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseSchedule(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(value string) time.Time {
+		ts, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ts
+	}
+
+	tests := []struct {
+		name  string
+		spec  string
+		after time.Time
+		want  time.Time
+	}{
+		{"every 15 minutes", "*/15 * * * *", utc("2026-01-01T10:07:30Z"), utc("2026-01-01T10:15:00Z")},
+		{"range with step", "0 9-17/4 * * *", utc("2026-01-01T13:00:00Z"), utc("2026-01-01T17:00:00Z")},
+		{"next month", "0 0 1 * *", utc("2026-01-15T00:00:00Z"), utc("2026-02-01T00:00:00Z")},
+		{"weekday", "0 8 * * 1-5", utc("2026-01-02T09:00:00Z"), utc("2026-01-05T08:00:00Z")},
+		{"sunday as 7", "0 0 * * 7", utc("2026-01-01T00:00:00Z"), utc("2026-01-04T00:00:00Z")},
+		{"either day field", "0 0 13 * 5", utc("2026-01-01T00:00:00Z"), utc("2026-01-02T00:00:00Z")},
+		{"february 29", "0 0 29 2 *", utc("2026-01-01T00:00:00Z"), utc("2028-02-29T00:00:00Z")},
+		{"february 30 never", "0 0 30 2 *", utc("2026-01-01T00:00:00Z"), time.Time{}},
+		{
+			"spring forward skips the missing time",
+			"30 2 * * *",
+			time.Date(2026, 3, 8, 0, 0, 0, 0, newYork),
+			time.Date(2026, 3, 9, 2, 30, 0, 0, newYork),
+		},
+		{
+			"spring forward hourly",
+			"0 * * * *",
+			time.Date(2026, 3, 8, 1, 30, 0, 0, newYork),
+			time.Date(2026, 3, 8, 3, 0, 0, 0, newYork),
+		},
+		{
+			"fall back runs a fixed hour once",
+			"30 1 * * *",
+			// 01:30 EDT, before the clocks go back to 01:00 EST
+			utc("2026-11-01T05:30:00Z").In(newYork),
+			time.Date(2026, 11, 2, 1, 30, 0, 0, newYork),
+		},
+		{
+			"fall back runs hourly in both passes",
+			"30 * * * *",
+			utc("2026-11-01T05:30:00Z").In(newYork),
+			utc("2026-11-01T06:30:00Z"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := parseSchedule(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan time.Time, 1)
+			go func() { done <- schedule.Next(tt.after) }()
+			select {
+			case got := <-done:
+				if !got.Equal(tt.want) {
+					t.Errorf("Next(%s) = %s, want %s", tt.after, got, tt.want)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("Next(%s) did not return", tt.after)
+			}
+		})
+	}
+}
@@ -0,0 +1,449 @@
+// Concurrent log collection from many pods for the logs aggregate command.
+
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// LogAggregateOptions selects the pods, containers and lines to collect
+type LogAggregateOptions struct {
+	Namespaces    []string
+	AllNamespaces bool
+	Selector      string
+	Previous      bool
+	Since         time.Duration
+	TailLines     int64
+	Grep          *regexp.Regexp
+	Invert        bool
+	Concurrency   int
+}
+
+// LogSource identifies one container instance
+type LogSource struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Previous  bool   `json:"previous,omitempty"`
+	// Workload is the owning workload as kind/name
+	Workload string `json:"workload,omitempty"`
+	// Reason is why a previous instance terminated, e.g. OOMKilled
+	Reason string `json:"reason,omitempty"`
+}
+
+func (s LogSource) String() string {
+	name := fmt.Sprintf("%s/%s/%s", s.Namespace, s.Pod, s.Container)
+	if s.Previous {
+		name += " (previous)"
+	}
+	return name
+}
+
+// label names the container instance inside its pod
+func (s LogSource) label() string {
+	if s.Previous {
+		return s.Container + " (previous)"
+	}
+	return s.Container
+}
+
+// podFileName is the path of the pod's log file inside an output directory or bundle
+func (s LogSource) podFileName() string {
+	return filepath.Join(s.Namespace, s.Pod+".log")
+}
+
+// LogLine is a single timestamped log line
+type LogLine struct {
+	Source LogSource
+	Time   time.Time
+	Text   string
+}
+
+// ContainerLogs holds the lines collected from one container instance
+type ContainerLogs struct {
+	Source LogSource
+	Lines  []LogLine
+	Err    error
+}
+
+// listLogSources finds every container instance with logs in the selected pods
+func listLogSources(ctx context.Context, opts LogAggregateOptions) ([]LogSource, error) {
+	namespaces := opts.Namespaces
+	if opts.AllNamespaces {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var sources []LogSource
+	for _, namespace := range namespaces {
+		pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: opts.Selector})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list pods: %v", err)
+		}
+		for _, pod := range pods.Items {
+			sources = append(sources, podLogSources(pod, opts.Previous)...)
+		}
+	}
+	sort.Slice(sources, func(i, j int) bool { return sources[i].String() < sources[j].String() })
+	return sources, nil
+}
+
+// podLogSources returns the container instances of a pod that have logs.
+// Previous instances are only included for containers that have terminated before.
+func podLogSources(pod corev1.Pod, previous bool) []LogSource {
+	kind, name := podWorkload(pod)
+	workload := kind + "/" + name
+
+	var sources []LogSource
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		source := LogSource{Namespace: pod.Namespace, Pod: pod.Name, Container: status.Name, Workload: workload}
+		// A waiting container, e.g. in CrashLoopBackOff, only has logs of its previous instance
+		if status.State.Waiting == nil {
+			sources = append(sources, source)
+		}
+		if previous && status.LastTerminationState.Terminated != nil {
+			source.Previous = true
+			source.Reason = status.LastTerminationState.Terminated.Reason
+			sources = append(sources, source)
+		}
+	}
+	return sources
+}
+
+// podWorkload returns the kind and name of the workload owning a pod, resolving
+// ReplicaSets to their Deployment; unowned pods are their own workload
+func podWorkload(pod corev1.Pod) (string, string) {
+	owner := metav1.GetControllerOf(&pod)
+	if owner == nil {
+		return "Pod", pod.Name
+	}
+	if owner.Kind == "ReplicaSet" {
+		if hash := pod.Labels["pod-template-hash"]; hash != "" && strings.HasSuffix(owner.Name, "-"+hash) {
+			return "Deployment", strings.TrimSuffix(owner.Name, "-"+hash)
+		}
+	}
+	return owner.Kind, owner.Name
+}
+
+// collectLogs reads the logs of every source concurrently
+func collectLogs(ctx context.Context, sources []LogSource, opts LogAggregateOptions) []ContainerLogs {
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = 8
+	}
+
+	results := make([]ContainerLogs, len(sources))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source LogSource) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = readContainerLogs(ctx, source, opts)
+		}(i, source)
+	}
+	wg.Wait()
+	return results
+}
+
+// readContainerLogs reads and filters the timestamped lines of one container instance
+func readContainerLogs(ctx context.Context, source LogSource, opts LogAggregateOptions) ContainerLogs {
+	result := ContainerLogs{Source: source}
+
+	logOpts := LogOptions{
+		Namespace:  source.Namespace,
+		Pod:        source.Pod,
+		Container:  source.Container,
+		Previous:   source.Previous,
+		Timestamps: true,
+	}
+	if opts.TailLines >= 0 {
+		logOpts.TailLines = &opts.TailLines
+	}
+	if opts.Since > 0 {
+		seconds := int64(opts.Since.Seconds())
+		logOpts.SinceSeconds = &seconds
+	}
+
+	stream, err := openPodLogs(ctx, logOpts)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer stream.Close()
+
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := parseLogLine(source, scanner.Text())
+		if opts.Grep != nil && opts.Grep.MatchString(line.Text) == opts.Invert {
+			continue
+		}
+		result.Lines = append(result.Lines, line)
+	}
+	result.Err = scanner.Err()
+	return result
+}
+
+// parseLogLine splits the RFC3339 timestamp added by the kubelet from the log text
+func parseLogLine(source LogSource, raw string) LogLine {
+	line := LogLine{Source: source, Text: raw}
+	if stamp, text, ok := strings.Cut(raw, " "); ok {
+		if t, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+			line.Time = t
+			line.Text = text
+		}
+	}
+	return line
+}
+
+// logLineHeap merges per-container streams, each already ordered by time
+type logLineHeap struct {
+	logs   []ContainerLogs
+	cursor []int
+	order  []int
+}
+
+func (h *logLineHeap) Len() int { return len(h.order) }
+func (h *logLineHeap) Less(i, j int) bool {
+	a, b := h.logs[h.order[i]].Lines[h.cursor[h.order[i]]].Time, h.logs[h.order[j]].Lines[h.cursor[h.order[j]]].Time
+	if a.Equal(b) {
+		// Keep lines with the same timestamp in container order
+		return h.order[i] < h.order[j]
+	}
+	return a.Before(b)
+}
+func (h *logLineHeap) Swap(i, j int) { h.order[i], h.order[j] = h.order[j], h.order[i] }
+func (h *logLineHeap) Push(x any)    { h.order = append(h.order, x.(int)) }
+func (h *logLineHeap) Pop() any {
+	last := h.order[len(h.order)-1]
+	h.order = h.order[:len(h.order)-1]
+	return last
+}
+
+// mergeLogLines interleaves the lines of all containers by timestamp
+func mergeLogLines(logs []ContainerLogs, emit func(LogLine) error) error {
+	h := &logLineHeap{logs: logs, cursor: make([]int, len(logs))}
+	for i, l := range logs {
+		if len(l.Lines) > 0 {
+			h.order = append(h.order, i)
+		}
+	}
+	heap.Init(h)
+
+	for h.Len() > 0 {
+		i := h.order[0]
+		if err := emit(logs[i].Lines[h.cursor[i]]); err != nil {
+			return err
+		}
+		h.cursor[i]++
+		if h.cursor[i] == len(logs[i].Lines) {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return nil
+}
+
+// formatLogLine renders a line prefixed with its optional timestamp and source label
+func formatLogLine(line LogLine, timestamps bool, label string) string {
+	var b strings.Builder
+	if timestamps && !line.Time.IsZero() {
+		b.WriteString(line.Time.UTC().Format(time.RFC3339Nano))
+		b.WriteByte(' ')
+	}
+	if label != "" {
+		fmt.Fprintf(&b, "[%s] ", label)
+	}
+	b.WriteString(line.Text)
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// writeMergedLogs writes all lines interleaved by timestamp
+func writeMergedLogs(w io.Writer, logs []ContainerLogs, timestamps bool) error {
+	out := bufio.NewWriter(w)
+	err := mergeLogLines(logs, func(line LogLine) error {
+		_, err := out.WriteString(formatLogLine(line, timestamps, line.Source.String()))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// podLogFiles renders one file per pod with the lines of its containers interleaved by timestamp
+func podLogFiles(logs []ContainerLogs, timestamps bool) (map[string][]byte, []string, error) {
+	byPod := make(map[string][]ContainerLogs)
+	var names []string
+	for _, l := range logs {
+		name := l.Source.podFileName()
+		if _, ok := byPod[name]; !ok {
+			names = append(names, name)
+		}
+		byPod[name] = append(byPod[name], l)
+	}
+	sort.Strings(names)
+
+	files := make(map[string][]byte, len(names))
+	for _, name := range names {
+		var b strings.Builder
+		err := mergeLogLines(byPod[name], func(line LogLine) error {
+			_, err := b.WriteString(formatLogLine(line, timestamps, line.Source.label()))
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		files[name] = []byte(b.String())
+	}
+	return files, names, nil
+}
+
+// writeLogFiles writes one file per pod below dir
+func writeLogFiles(dir string, logs []ContainerLogs, timestamps bool) error {
+	files, names, err := podLogFiles(logs, timestamps)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, files[name], 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLogBundle writes one file per pod into a tar.gz archive
+func writeLogBundle(path string, logs []ContainerLogs, timestamps bool) error {
+	files, names, err := podLogFiles(logs, timestamps)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	archive := newTarGzWriter(file, time.Now())
+	for _, name := range names {
+		if err := archive.WriteFile(filepath.ToSlash(name), files[name]); err != nil {
+			return err
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// logSelectionFlags registers the pod and line selection flags shared by logs aggregate and
+// logs analyze; the returned function builds the options after parsing
+func logSelectionFlags(cmd *flag.FlagSet) func() LogAggregateOptions {
+	namespaces := cmd.String("namespaces", "default", "Comma-separated namespaces to collect logs from")
+	allNamespaces := cmd.Bool("all-namespaces", false, "Collect logs from every namespace")
+	selector := cmd.String("selector", "", "Label selector for pods (e.g. app=frontend)")
+	previous := cmd.Bool("previous", true, "Include logs of previous container instances")
+	since := cmd.Duration("since", 0, "Only collect logs newer than this duration")
+	tail := cmd.Int64("tail", -1, "Number of most recent lines per container (-1 for all)")
+	concurrency := cmd.Int("concurrency", 8, "Number of log streams read in parallel")
+	return func() LogAggregateOptions {
+		return LogAggregateOptions{
+			Namespaces:    splitList(*namespaces),
+			AllNamespaces: *allNamespaces,
+			Selector:      *selector,
+			Previous:      *previous,
+			Since:         *since,
+			TailLines:     *tail,
+			Concurrency:   *concurrency,
+		}
+	}
+}
+
+// runLogAggregate collects logs from many pods and writes them merged, per container or as a bundle
+func runLogAggregate(ctx context.Context, args []string) {
+	aggregateCmd := flag.NewFlagSet("logs aggregate", flag.ExitOnError)
+	selection := logSelectionFlags(aggregateCmd)
+	grep := aggregateCmd.String("grep", "", "Only keep lines matching this regular expression")
+	ignoreCase := aggregateCmd.Bool("ignore-case", false, "Match -grep case-insensitively")
+	invert := aggregateCmd.Bool("invert", false, "Only keep lines not matching -grep")
+	timestamps := aggregateCmd.Bool("timestamps", true, "Prefix lines with their timestamp")
+	outputDir := aggregateCmd.String("output-dir", "", "Write one file per pod below this directory instead of a merged stream")
+	bundle := aggregateCmd.String("bundle", "", "Write one file per pod into this tar.gz archive")
+	if err := aggregateCmd.Parse(args); err != nil {
+		return
+	}
+
+	opts := selection()
+	opts.Invert = *invert
+	if *grep != "" {
+		pattern := *grep
+		if *ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			logger.Fatalf("Invalid -grep expression: %v", err)
+		}
+		opts.Grep = re
+	}
+
+	sources, err := listLogSources(ctx, opts)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	if len(sources) == 0 {
+		logger.Println("No containers with logs found")
+		return
+	}
+	logs := collectLogs(ctx, sources, opts)
+	lines := 0
+	for _, l := range logs {
+		if l.Err != nil {
+			logger.Printf("⚠️ %s: %v", l.Source, l.Err)
+		}
+		lines += len(l.Lines)
+	}
+
+	switch {
+	case *bundle != "":
+		if err := writeLogBundle(*bundle, logs, *timestamps); err != nil {
+			logger.Fatalf("Failed to write log bundle: %v", err)
+		}
+		logger.Printf("✅ %d lines from %d containers written to %s", lines, len(logs), *bundle)
+	case *outputDir != "":
+		if err := writeLogFiles(*outputDir, logs, *timestamps); err != nil {
+			logger.Fatalf("Failed to write log files: %v", err)
+		}
+		logger.Printf("✅ %d lines from %d containers written to %s", lines, len(logs), *outputDir)
+	default:
+		if err := writeMergedLogs(os.Stdout, logs, *timestamps); err != nil {
+			logger.Fatalf("Failed to write logs: %v", err)
+		}
+	}
+}
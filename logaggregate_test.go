@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// containerLogs parses kubelet-style lines of one container
+func containerLogs(pod string, raw ...string) ContainerLogs {
+	source := LogSource{Namespace: "shop", Pod: pod, Container: "app"}
+	logs := ContainerLogs{Source: source}
+	for _, line := range raw {
+		logs.Lines = append(logs.Lines, parseLogLine(source, line))
+	}
+	return logs
+}
+
+func TestMergeLogLines(t *testing.T) {
+	tests := []struct {
+		name string
+		logs []ContainerLogs
+		want []string
+	}{
+		{
+			name: "interleaved by timestamp across pods",
+			logs: []ContainerLogs{
+				containerLogs("web-1", "2024-05-01T10:00:00Z a1", "2024-05-01T10:00:02Z a2", "2024-05-01T10:00:04Z a3"),
+				containerLogs("web-2", "2024-05-01T10:00:01Z b1", "2024-05-01T10:00:03.5Z b2"),
+				containerLogs("web-3"),
+			},
+			want: []string{"a1", "b1", "a2", "b2", "a3"},
+		},
+		{
+			name: "equal timestamps keep container order",
+			logs: []ContainerLogs{
+				containerLogs("web-1", "2024-05-01T10:00:01Z a1"),
+				containerLogs("web-2", "2024-05-01T10:00:00Z b1", "2024-05-01T10:00:01Z b2"),
+				containerLogs("web-3", "2024-05-01T10:00:01Z c1"),
+			},
+			want: []string{"b1", "a1", "b2", "c1"},
+		},
+		{
+			name: "time zones are compared as instants",
+			logs: []ContainerLogs{
+				containerLogs("web-1", "2024-05-01T12:00:00+02:00 a1"),
+				containerLogs("web-2", "2024-05-01T09:59:59Z b1"),
+			},
+			want: []string{"b1", "a1"},
+		},
+		{
+			name: "lines without timestamps follow the previous line of their container",
+			logs: []ContainerLogs{
+				containerLogs("web-1", "2024-05-01T10:00:00Z panic: boom", "goroutine 1 [running]:", "2024-05-01T10:00:05Z restarted"),
+				containerLogs("web-2", "2024-05-01T10:00:01Z b1", "2024-05-01T10:00:02Z b2"),
+			},
+			want: []string{"panic: boom", "goroutine 1 [running]:", "b1", "b2", "restarted"},
+		},
+		{
+			name: "containers without timestamps come first",
+			logs: []ContainerLogs{
+				containerLogs("web-1", "2024-05-01T10:00:00Z a1"),
+				containerLogs("web-2", "plain 1", "plain 2"),
+			},
+			want: []string{"plain 1", "plain 2", "a1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			err := mergeLogLines(tt.logs, func(line LogLine) error {
+				got = append(got, line.Text)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeLogLinesStopsOnError(t *testing.T) {
+	logs := []ContainerLogs{
+		containerLogs("web-1", "2024-05-01T10:00:00Z a1", "2024-05-01T10:00:02Z a2"),
+		containerLogs("web-2", "2024-05-01T10:00:01Z b1"),
+	}
+	failure := errors.New("disk full")
+	emitted := 0
+	err := mergeLogLines(logs, func(LogLine) error {
+		emitted++
+		if emitted == 2 {
+			return failure
+		}
+		return nil
+	})
+	if !errors.Is(err, failure) || emitted != 2 {
+		t.Errorf("got %v after %d lines, want the emit error after 2", err, emitted)
+	}
+}
+
+func TestWriteMergedLogs(t *testing.T) {
+	logs := []ContainerLogs{
+		containerLogs("web-1", "2024-05-01T10:00:00.5+02:00 started"),
+		containerLogs("web-2", "no timestamp"),
+	}
+	var b strings.Builder
+	if err := writeMergedLogs(&b, logs, true); err != nil {
+		t.Fatal(err)
+	}
+	want := "[shop/web-2/app] no timestamp\n2024-05-01T08:00:00.5Z [shop/web-1/app] started\n"
+	if b.String() != want {
+		t.Errorf("got %q, want %q", b.String(), want)
+	}
+}
+
+func TestReadContainerLogsOptions(t *testing.T) {
+	previous := clientset
+	t.Cleanup(func() { clientset = previous })
+
+	source := LogSource{Namespace: "shop", Pod: "web-1", Container: "app"}
+	tests := []struct {
+		name      string
+		opts      LogAggregateOptions
+		wantTail  int64 // -1 when no tail is requested
+		wantLines int
+	}{
+		{name: "all lines", opts: LogAggregateOptions{TailLines: -1}, wantTail: -1, wantLines: 1},
+		{name: "tail", opts: LogAggregateOptions{TailLines: 50}, wantTail: 50, wantLines: 1},
+		{name: "tail of zero", opts: LogAggregateOptions{TailLines: 0}, wantTail: 0, wantLines: 1},
+		{name: "grep", opts: LogAggregateOptions{TailLines: -1, Grep: regexp.MustCompile("error")}, wantTail: -1, wantLines: 0},
+		{name: "inverted grep", opts: LogAggregateOptions{TailLines: -1, Grep: regexp.MustCompile("error"), Invert: true}, wantTail: -1, wantLines: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fakeClient := fake.NewClientset()
+			clientset = fakeClient
+
+			// The fake client answers every log request with the untimestamped line "fake logs"
+			result := readContainerLogs(context.Background(), source, tt.opts)
+			if result.Err != nil {
+				t.Fatal(result.Err)
+			}
+			if len(result.Lines) != tt.wantLines {
+				t.Errorf("got %d lines, want %d", len(result.Lines), tt.wantLines)
+			}
+
+			actions := fakeClient.Actions()
+			if len(actions) != 1 {
+				t.Fatalf("expected one log request, got %d", len(actions))
+			}
+			logOpts := actions[0].(k8stesting.GenericAction).GetValue().(*corev1.PodLogOptions)
+			if !logOpts.Timestamps {
+				t.Error("expected timestamps to be requested")
+			}
+			tail := int64(-1)
+			if logOpts.TailLines != nil {
+				tail = *logOpts.TailLines
+			}
+			if tail != tt.wantTail {
+				t.Errorf("TailLines = %d, want %d", tail, tt.wantTail)
+			}
+		})
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	source := LogSource{Pod: "web-1"}
+	line := parseLogLine(source, "2024-05-01T10:00:00.123456789Z GET /healthz 200")
+	if line.Text != "GET /healthz 200" || !line.Time.Equal(time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)) {
+		t.Errorf("unexpected line %+v", line)
+	}
+	for _, raw := range []string{"GET /healthz 200", "2024-05-01 not a timestamp", ""} {
+		if line := parseLogLine(source, raw); line.Text != raw || !line.Time.IsZero() {
+			t.Errorf("parseLogLine(%q) = %+v, want the raw text without a time", raw, line)
+		}
+	}
+}
@@ -0,0 +1,487 @@
+// Log pattern detection: Drain-style template clustering, error ranking and crash detection.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Detection kinds reported by the log analysis
+const (
+	DetectionPanic      = "panic"
+	DetectionStackTrace = "stacktrace"
+	DetectionOOM        = "oom"
+)
+
+// logWildcard replaces the variable tokens of a template
+const logWildcard = "<*>"
+
+var (
+	errorLevelPattern   = regexp.MustCompile(`(?i)\b(error|err|fatal|panic|exception|critical|crit|fail|failed|failure)\b`)
+	warningLevelPattern = regexp.MustCompile(`(?i)\b(warn|warning)\b`)
+
+	panicPattern = regexp.MustCompile(`^(panic: |fatal error: |thread '.*' panicked at )`)
+	stackPattern = regexp.MustCompile(`^(goroutine \d+ \[|Traceback \(most recent call last\):|Exception in thread "|Caused by: |\s+at [\w$.<>/]+\(.*\)$)`)
+	oomPattern   = regexp.MustCompile(`(?i)(out of memory|OutOfMemoryError|OOMKilled|oom-kill|Killed process \d+|cannot allocate memory)`)
+)
+
+// stackContextLines is how many lines after a panic or stack trace start are kept as context
+const stackContextLines = 20
+
+// LogAnalysisOptions tunes the template clustering and error ranking
+type LogAnalysisOptions struct {
+	// Similarity is the fraction of equal tokens for a line to join a template
+	Similarity float64
+	// Window is the recent period compared against the earlier baseline
+	Window time.Duration
+	// Top is the number of ranked error templates reported per workload
+	Top int
+}
+
+// defaultLogAnalysisOptions are used when attaching analyses to health results
+var defaultLogAnalysisOptions = LogAnalysisOptions{Similarity: 0.5, Window: 5 * time.Minute, Top: 5}
+
+// LogTemplate is a cluster of log lines sharing the same shape
+type LogTemplate struct {
+	Template    string    `json:"template"`
+	Level       string    `json:"level"`
+	Count       int       `json:"count"`
+	RecentCount int       `json:"recentCount"`
+	Pods        int       `json:"pods"`
+	New         bool      `json:"new,omitempty"`
+	BurstFactor float64   `json:"burstFactor,omitempty"`
+	FirstSeen   time.Time `json:"firstSeen,omitempty"`
+	LastSeen    time.Time `json:"lastSeen,omitempty"`
+	Example     string    `json:"example"`
+
+	tokens []string
+	pods   map[string]bool
+}
+
+// LogDetection is a panic, stack trace or out-of-memory event found in the logs
+type LogDetection struct {
+	Kind      string    `json:"kind"`
+	Pod       string    `json:"pod"`
+	Container string    `json:"container"`
+	Previous  bool      `json:"previous,omitempty"`
+	Time      time.Time `json:"time,omitempty"`
+	Message   string    `json:"message"`
+	Context   []string  `json:"context,omitempty"`
+	Count     int       `json:"count"`
+}
+
+// WorkloadLogSummary summarises the logs of all replicas of a workload
+type WorkloadLogSummary struct {
+	Namespace  string         `json:"namespace"`
+	Kind       string         `json:"kind"`
+	Name       string         `json:"name"`
+	Pods       []string       `json:"pods"`
+	Lines      int            `json:"lines"`
+	Templates  int            `json:"templates"`
+	TopErrors  []LogTemplate  `json:"topErrors,omitempty"`
+	Detections []LogDetection `json:"detections,omitempty"`
+}
+
+// Issues describes the detections and new or bursting error templates of the summary
+func (s WorkloadLogSummary) Issues() []string {
+	var issues []string
+	for _, d := range s.Detections {
+		issues = append(issues, fmt.Sprintf("%s in %s/%s (%dx): %s", d.Kind, d.Pod, d.Container, d.Count, d.Message))
+	}
+	for _, t := range s.TopErrors {
+		switch {
+		case t.New:
+			issues = append(issues, fmt.Sprintf("new error (%dx): %s", t.Count, t.Template))
+		case t.BurstFactor > 0:
+			issues = append(issues, fmt.Sprintf("error burst x%.1f (%dx): %s", t.BurstFactor, t.Count, t.Template))
+		}
+	}
+	return issues
+}
+
+// tokenizeLogLine splits a line into tokens, masking tokens that contain digits
+// (ids, timestamps, addresses, durations) so they do not split templates
+func tokenizeLogLine(text string) []string {
+	tokens := strings.Fields(text)
+	for i, token := range tokens {
+		if !strings.ContainsAny(token, "0123456789") {
+			continue
+		}
+		if key, _, ok := strings.Cut(token, "="); ok && key != "" && !strings.ContainsAny(key, "0123456789") {
+			tokens[i] = key + "=" + logWildcard
+			continue
+		}
+		tokens[i] = logWildcard
+	}
+	return tokens
+}
+
+// logLevel classifies a line as error, warning or info by its keywords
+func logLevel(text string) string {
+	switch {
+	case errorLevelPattern.MatchString(text):
+		return "error"
+	case warningLevelPattern.MatchString(text):
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// drainParser clusters lines into templates. Like Drain, candidates are looked up by token
+// count and first token, and a line joins the most similar template, which then generalises
+// the differing positions to wildcards.
+type drainParser struct {
+	similarity float64
+	groups     map[string][]*LogTemplate
+	templates  []*LogTemplate
+}
+
+func newDrainParser(similarity float64) *drainParser {
+	return &drainParser{similarity: similarity, groups: make(map[string][]*LogTemplate)}
+}
+
+// add assigns a line to its template, creating one when no template is similar enough
+func (d *drainParser) add(line LogLine) *LogTemplate {
+	tokens := tokenizeLogLine(line.Text)
+	if len(tokens) == 0 {
+		return nil
+	}
+	key := fmt.Sprintf("%d %s", len(tokens), tokens[0])
+
+	var best *LogTemplate
+	bestScore := -1.0
+	for _, t := range d.groups[key] {
+		if score := templateSimilarity(t.tokens, tokens); score >= d.similarity && score > bestScore {
+			best, bestScore = t, score
+		}
+	}
+	if best == nil {
+		best = &LogTemplate{
+			tokens:  tokens,
+			pods:    make(map[string]bool),
+			Level:   logLevel(line.Text),
+			Example: line.Text,
+		}
+		d.groups[key] = append(d.groups[key], best)
+		d.templates = append(d.templates, best)
+	} else {
+		for i := range best.tokens {
+			if best.tokens[i] != tokens[i] {
+				best.tokens[i] = logWildcard
+			}
+		}
+	}
+
+	best.Count++
+	best.pods[line.Source.Pod] = true
+	if !line.Time.IsZero() {
+		if best.FirstSeen.IsZero() || line.Time.Before(best.FirstSeen) {
+			best.FirstSeen = line.Time
+		}
+		if line.Time.After(best.LastSeen) {
+			best.LastSeen = line.Time
+		}
+	}
+	return best
+}
+
+// templateSimilarity is the fraction of positions where template and tokens agree
+func templateSimilarity(template, tokens []string) float64 {
+	equal := 0
+	for i := range template {
+		if template[i] == tokens[i] || template[i] == logWildcard {
+			equal++
+		}
+	}
+	return float64(equal) / float64(len(template))
+}
+
+// detectionScanner finds panics, stack traces and OOM messages in the lines of one container
+type detectionScanner struct {
+	detections map[string]*LogDetection
+	order      []string
+	current    *LogDetection
+	remaining  int
+}
+
+func (s *detectionScanner) scan(line LogLine) {
+	// Lines following a panic or trace start are its context, not new detections;
+	// only the first occurrence keeps them
+	if s.remaining > 0 {
+		if strings.TrimSpace(line.Text) == "" {
+			return
+		}
+		s.remaining--
+		if s.current.Count == 1 {
+			s.current.Context = append(s.current.Context, line.Text)
+		}
+		return
+	}
+
+	kind := ""
+	switch {
+	case panicPattern.MatchString(line.Text):
+		kind = DetectionPanic
+	case stackPattern.MatchString(line.Text):
+		kind = DetectionStackTrace
+	case oomPattern.MatchString(line.Text):
+		kind = DetectionOOM
+	default:
+		return
+	}
+
+	detection := s.record(kind, line.Source, line.Time, line.Text)
+	if kind != DetectionOOM {
+		s.current, s.remaining = detection, stackContextLines
+	}
+}
+
+// record counts a detection, keeping the first occurrence of each kind and message shape
+func (s *detectionScanner) record(kind string, source LogSource, t time.Time, message string) *LogDetection {
+	key := kind + " " + strings.Join(tokenizeLogLine(message), " ")
+	if d, ok := s.detections[key]; ok {
+		d.Count++
+		return d
+	}
+	d := &LogDetection{
+		Kind:      kind,
+		Pod:       source.Pod,
+		Container: source.Container,
+		Previous:  source.Previous,
+		Time:      t,
+		Message:   message,
+		Count:     1,
+	}
+	s.detections[key] = d
+	s.order = append(s.order, key)
+	return d
+}
+
+// analyzeLogs clusters the collected lines per workload and ranks their error templates
+func analyzeLogs(logs []ContainerLogs, opts LogAnalysisOptions) []WorkloadLogSummary {
+	byWorkload := make(map[string][]ContainerLogs)
+	var keys []string
+	for _, l := range logs {
+		key := l.Source.Namespace + "/" + l.Source.Workload
+		if _, ok := byWorkload[key]; !ok {
+			keys = append(keys, key)
+		}
+		byWorkload[key] = append(byWorkload[key], l)
+	}
+	sort.Strings(keys)
+
+	summaries := make([]WorkloadLogSummary, 0, len(keys))
+	for _, key := range keys {
+		summaries = append(summaries, analyzeWorkloadLogs(byWorkload[key], opts))
+	}
+	return summaries
+}
+
+// analyzeWorkloadLogs builds the summary of the replicas of a single workload
+func analyzeWorkloadLogs(logs []ContainerLogs, opts LogAnalysisOptions) WorkloadLogSummary {
+	source := logs[0].Source
+	kind, name, _ := strings.Cut(source.Workload, "/")
+	summary := WorkloadLogSummary{Namespace: source.Namespace, Kind: kind, Name: name}
+
+	parser := newDrainParser(opts.Similarity)
+	scanner := &detectionScanner{detections: make(map[string]*LogDetection)}
+	var start, end time.Time
+	// Template of every timestamped line, to count recent occurrences once the time range is known
+	type assignment struct {
+		template *LogTemplate
+		time     time.Time
+	}
+	var assigned []assignment
+	for _, l := range logs {
+		summary.Pods = appendUnique(summary.Pods, l.Source.Pod)
+		if l.Source.Previous && l.Source.Reason == "OOMKilled" {
+			scanner.record(DetectionOOM, l.Source, time.Time{}, fmt.Sprintf("container %s was OOMKilled", l.Source.Container))
+		}
+		scanner.remaining = 0
+		for _, line := range l.Lines {
+			summary.Lines++
+			template := parser.add(line)
+			scanner.scan(line)
+			if line.Time.IsZero() || template == nil {
+				continue
+			}
+			assigned = append(assigned, assignment{template, line.Time})
+			if start.IsZero() || line.Time.Before(start) {
+				start = line.Time
+			}
+			if line.Time.After(end) {
+				end = line.Time
+			}
+		}
+	}
+	sort.Strings(summary.Pods)
+	summary.Templates = len(parser.templates)
+
+	windowStart := end.Add(-opts.Window)
+	counts := make(map[*LogTemplate]int)
+	for _, a := range assigned {
+		if !a.time.Before(windowStart) {
+			counts[a.template]++
+		}
+	}
+
+	baseline := windowStart.Sub(start)
+	var errors []LogTemplate
+	for _, t := range parser.templates {
+		t.Template = strings.Join(t.tokens, " ")
+		t.Pods = len(t.pods)
+		t.RecentCount = counts[t]
+		if t.Level != "error" {
+			continue
+		}
+		if baseline > 0 && !t.FirstSeen.IsZero() {
+			t.New = !t.FirstSeen.Before(windowStart)
+			if !t.New && t.RecentCount > 0 {
+				recentRate := float64(t.RecentCount) / opts.Window.Seconds()
+				baselineRate := float64(t.Count-t.RecentCount) / baseline.Seconds()
+				if factor := recentRate / baselineRate; factor >= 3 {
+					t.BurstFactor = factor
+				}
+			}
+		}
+		errors = append(errors, *t)
+	}
+	sort.SliceStable(errors, func(i, j int) bool {
+		a, b := errors[i], errors[j]
+		if a.New != b.New {
+			return a.New
+		}
+		if a.BurstFactor != b.BurstFactor {
+			return a.BurstFactor > b.BurstFactor
+		}
+		return a.Count > b.Count
+	})
+	if len(errors) > opts.Top {
+		errors = errors[:opts.Top]
+	}
+	summary.TopErrors = errors
+
+	for _, key := range scanner.order {
+		summary.Detections = append(summary.Detections, *scanner.detections[key])
+	}
+	return summary
+}
+
+// analyzeCrashLoopLogs analyses the logs of every workload with a pod in CrashLoopBackOff,
+// across all replicas of the workload in the given pods
+func analyzeCrashLoopLogs(ctx context.Context, pods []corev1.Pod) []WorkloadLogSummary {
+	crashing := make(map[string]bool)
+	for _, pod := range pods {
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Waiting != nil && cs.State.Waiting.Reason == "CrashLoopBackOff" {
+				kind, name := podWorkload(pod)
+				crashing[pod.Namespace+"/"+kind+"/"+name] = true
+			}
+		}
+	}
+	if len(crashing) == 0 {
+		return nil
+	}
+
+	var sources []LogSource
+	for _, pod := range pods {
+		kind, name := podWorkload(pod)
+		if crashing[pod.Namespace+"/"+kind+"/"+name] {
+			sources = append(sources, podLogSources(pod, true)...)
+		}
+	}
+
+	logs := collectLogs(ctx, sources, LogAggregateOptions{TailLines: 500})
+	for _, l := range logs {
+		if l.Err != nil {
+			logger.Printf("⚠️ Failed to read logs of %s: %v", l.Source, l.Err)
+		}
+	}
+	return analyzeLogs(logs, defaultLogAnalysisOptions)
+}
+
+// printLogSummaries prints the workload summaries as text
+func printLogSummaries(summaries []WorkloadLogSummary) {
+	for _, s := range summaries {
+		fmt.Printf("%s %s/%s: %d lines from %d pods, %d templates\n", s.Kind, s.Namespace, s.Name, s.Lines, len(s.Pods), s.Templates)
+		for _, d := range s.Detections {
+			fmt.Printf("  [%s] %s/%s (%dx): %s\n", d.Kind, d.Pod, d.Container, d.Count, d.Message)
+			for _, line := range d.Context {
+				fmt.Printf("      %s\n", line)
+			}
+		}
+		for _, t := range s.TopErrors {
+			marker := ""
+			switch {
+			case t.New:
+				marker = " NEW"
+			case t.BurstFactor > 0:
+				marker = fmt.Sprintf(" BURST x%.1f", t.BurstFactor)
+			}
+			fmt.Printf("  %6d%s  %s (%d pods)\n", t.Count, marker, t.Template, t.Pods)
+		}
+	}
+}
+
+// runLogAnalyze collects logs like logs aggregate and prints a per-workload analysis
+func runLogAnalyze(ctx context.Context, args []string) {
+	analyzeCmd := flag.NewFlagSet("logs analyze", flag.ExitOnError)
+	selection := logSelectionFlags(analyzeCmd)
+	similarity := analyzeCmd.Float64("similarity", defaultLogAnalysisOptions.Similarity, "Fraction of equal tokens for lines to share a template")
+	window := analyzeCmd.Duration("window", defaultLogAnalysisOptions.Window, "Recent period compared with the earlier logs to find new and bursting errors")
+	top := analyzeCmd.Int("top", defaultLogAnalysisOptions.Top, "Number of error templates reported per workload")
+	output := analyzeCmd.String("output", "text", "Output format (text, json)")
+	if err := analyzeCmd.Parse(args); err != nil {
+		return
+	}
+	if *similarity <= 0 || *similarity > 1 {
+		logger.Fatalf("Invalid -similarity %g, expected a value in (0, 1]", *similarity)
+	}
+	if *window <= 0 {
+		logger.Fatalf("Invalid -window %v, expected a positive duration", *window)
+	}
+	if *top < 1 {
+		logger.Fatalf("Invalid -top %d, expected at least 1", *top)
+	}
+	if *output != "text" && *output != "json" {
+		logger.Fatalf("Unknown output format %q, expected text or json", *output)
+	}
+
+	opts := selection()
+	sources, err := listLogSources(ctx, opts)
+	if err != nil {
+		logger.Fatalf("%v", err)
+	}
+	logs := collectLogs(ctx, sources, opts)
+	for _, l := range logs {
+		if l.Err != nil {
+			logger.Printf("⚠️ %s: %v", l.Source, l.Err)
+		}
+	}
+
+	summaries := analyzeLogs(logs, LogAnalysisOptions{Similarity: *similarity, Window: *window, Top: *top})
+	if *output == "json" {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(summaries); err != nil {
+			logger.Fatalf("Failed to encode analysis: %v", err)
+		}
+		return
+	}
+	if len(summaries) == 0 {
+		logger.Println("No containers with logs found")
+		return
+	}
+	printLogSummaries(summaries)
+}
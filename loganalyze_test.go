@@ -0,0 +1,130 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenizeLogLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"GET /api/users/42 took 35ms", "GET <*> took <*>"},
+		{"retrying request_id=a1b2 attempt=3 backoff=2s", "retrying request_id=<*> attempt=<*> backoff=<*>"},
+		{"dial tcp 10.0.0.7:5432: connection refused", "dial tcp <*> connection refused"},
+		{"user2=alice logged in", "<*> logged in"},
+		{"  leading   and trailing  ", "leading and trailing"},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(tokenizeLogLine(tt.line), " "); got != tt.want {
+			t.Errorf("tokenizeLogLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestDrainParserGrouping(t *testing.T) {
+	parser := newDrainParser(defaultLogAnalysisOptions.Similarity)
+	lines := []struct {
+		pod, text string
+	}{
+		{"web-1", "user alice logged in from 10.0.0.1"},
+		{"web-2", "user bob logged in from 10.0.0.2"},
+		{"web-1", "ERROR connection to db-0 refused"},
+		{"web-2", "ERROR connection to db-1 refused"},
+		// Same first token and length but too different to join
+		{"web-1", "ERROR disk full on /var"},
+		// Same shape but a different token count
+		{"web-1", "user alice logged in"},
+		{"web-1", ""},
+	}
+	for _, l := range lines {
+		parser.add(LogLine{Source: LogSource{Pod: l.pod}, Text: l.text})
+	}
+
+	got := make(map[string]*LogTemplate)
+	for _, tmpl := range parser.templates {
+		got[strings.Join(tmpl.tokens, " ")] = tmpl
+	}
+	want := map[string]struct {
+		count, pods int
+		level       string
+	}{
+		"user <*> logged in from <*>":     {2, 2, "info"},
+		"ERROR connection to <*> refused": {2, 2, "error"},
+		"ERROR disk full on /var":         {1, 1, "error"},
+		"user alice logged in":            {1, 1, "info"},
+	}
+	if len(got) != len(want) {
+		t.Errorf("expected %d templates, got %d: %v", len(want), len(got), parser.templates)
+	}
+	for template, w := range want {
+		tmpl, ok := got[template]
+		if !ok {
+			t.Errorf("missing template %q", template)
+			continue
+		}
+		if tmpl.Count != w.count || len(tmpl.pods) != w.pods || tmpl.Level != w.level {
+			t.Errorf("template %q: count %d, pods %d, level %s; want %d, %d, %s",
+				template, tmpl.Count, len(tmpl.pods), tmpl.Level, w.count, w.pods, w.level)
+		}
+	}
+}
+
+func TestAnalyzeWorkloadLogsBursts(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	source := LogSource{Namespace: "shop", Pod: "web-1", Container: "app", Workload: "Deployment/web"}
+	var lines []LogLine
+	add := func(offset time.Duration, text string) {
+		lines = append(lines, LogLine{Source: source, Time: start.Add(offset), Text: text})
+	}
+	for i := 0; i <= 13; i++ {
+		// A steady error every 5 minutes over the whole range
+		add(time.Duration(i)*5*time.Minute, "ERROR cache refresh failed for shard "+string(rune('a'+i)))
+	}
+	for i := 0; i < 13; i++ {
+		add(time.Duration(i)*5*time.Minute+time.Minute, "served request in 12ms")
+	}
+	for i := 0; i < 6; i++ {
+		// Rare in the baseline, then bursting in the last 5 minutes
+		add(time.Duration(i)*10*time.Minute, "ERROR db query timed out after 5003ms")
+	}
+	for i := 0; i < 9; i++ {
+		add(60*time.Minute+time.Duration(i)*30*time.Second, "ERROR db query timed out after 5003ms")
+	}
+	add(63*time.Minute, "ERROR payment provider rejected request")
+
+	tests := []struct {
+		top  int
+		want []string
+	}{
+		{5, []string{"ERROR payment provider rejected request", "ERROR db query timed out after <*>", "ERROR cache refresh failed for shard <*>"}},
+		{2, []string{"ERROR payment provider rejected request", "ERROR db query timed out after <*>"}},
+	}
+	for _, tt := range tests {
+		opts := defaultLogAnalysisOptions
+		opts.Top = tt.top
+		summary := analyzeWorkloadLogs([]ContainerLogs{{Source: source, Lines: lines}}, opts)
+
+		var got []string
+		for _, e := range summary.TopErrors {
+			got = append(got, e.Template)
+		}
+		if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+			t.Fatalf("top %d errors:\n%s\nwant:\n%s", tt.top, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+
+		errors := summary.TopErrors
+		if !errors[0].New || errors[0].BurstFactor != 0 {
+			t.Errorf("expected the payment error to be new: %+v", errors[0])
+		}
+		// 9 lines in 5 minutes against 6 in the preceding hour
+		if errors[1].New || errors[1].RecentCount != 9 || errors[1].BurstFactor < 17 || errors[1].BurstFactor > 19 {
+			t.Errorf("expected the db error to burst about x18: %+v", errors[1])
+		}
+		if tt.top > 2 && (errors[2].New || errors[2].BurstFactor != 0) {
+			t.Errorf("steady error was reported as new or bursting: %+v", errors[2])
+		}
+	}
+}
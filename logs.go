@@ -0,0 +1,240 @@
+// Pod log retrieval for the logs command and the logs API.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// LogOptions selects the log lines of a pod container
+type LogOptions struct {
+	Namespace    string
+	Pod          string
+	Container    string
+	Previous     bool
+	TailLines    *int64
+	SinceSeconds *int64
+	Timestamps   bool
+	Follow       bool
+}
+
+func (o LogOptions) podLogOptions() *corev1.PodLogOptions {
+	return &corev1.PodLogOptions{
+		Container:    o.Container,
+		Previous:     o.Previous,
+		TailLines:    o.TailLines,
+		SinceSeconds: o.SinceSeconds,
+		Timestamps:   o.Timestamps,
+		Follow:       o.Follow,
+	}
+}
+
+// openPodLogs opens the log stream of a pod container
+func openPodLogs(ctx context.Context, opts LogOptions) (io.ReadCloser, error) {
+	if clientset == nil {
+		return nil, fmt.Errorf("kubernetes clientset is not initialized")
+	}
+	stream, err := clientset.CoreV1().Pods(opts.Namespace).GetLogs(opts.Pod, opts.podLogOptions()).Stream(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get logs of pod %s/%s: %v", opts.Namespace, opts.Pod, err)
+	}
+	return stream, nil
+}
+
+// streamPodLogs copies the logs of a pod container to w until the stream ends or ctx is cancelled
+func streamPodLogs(ctx context.Context, opts LogOptions, w io.Writer) error {
+	stream, err := openPodLogs(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if _, err := io.Copy(w, stream); err != nil && ctx.Err() == nil {
+		return fmt.Errorf("failed to read logs of pod %s/%s: %v", opts.Namespace, opts.Pod, err)
+	}
+	return nil
+}
+
+// runLogsCommand prints the logs of a pod container, or aggregates or analyses the logs of many pods
+func runLogsCommand(ctx context.Context, args []string) {
+	if len(args) > 0 {
+		switch args[0] {
+		case "aggregate":
+			runLogAggregate(ctx, args[1:])
+			return
+		case "analyze":
+			runLogAnalyze(ctx, args[1:])
+			return
+		}
+	}
+
+	logsCmd := flag.NewFlagSet("logs", flag.ExitOnError)
+	namespace := logsCmd.String("namespace", "default", "Namespace of the pod")
+	pod := logsCmd.String("pod", "", "Name of the pod")
+	container := logsCmd.String("container", "", "Container to read logs from (defaults to the only container)")
+	previous := logsCmd.Bool("previous", false, "Show logs of the previous, terminated container instance")
+	tail := logsCmd.Int64("tail", -1, "Number of most recent lines to show (-1 for all)")
+	since := logsCmd.Duration("since", 0, "Only show logs newer than this duration (e.g. 5m)")
+	timestamps := logsCmd.Bool("timestamps", false, "Prefix each line with its timestamp")
+	follow := logsCmd.Bool("follow", false, "Keep streaming new log lines")
+	if err := logsCmd.Parse(args); err != nil {
+		return
+	}
+
+	if *pod == "" {
+		logger.Println("Please specify the pod to show logs of")
+		os.Exit(1)
+	}
+
+	opts := LogOptions{
+		Namespace:  *namespace,
+		Pod:        *pod,
+		Container:  *container,
+		Previous:   *previous,
+		Timestamps: *timestamps,
+		Follow:     *follow,
+	}
+	if *tail >= 0 {
+		opts.TailLines = tail
+	}
+	if *since > 0 {
+		seconds := int64(since.Seconds())
+		opts.SinceSeconds = &seconds
+	}
+
+	if !opts.Follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config2.DefaultTimeout)
+		defer cancel()
+	}
+	if err := streamPodLogs(ctx, opts, os.Stdout); err != nil {
+		logger.Fatalf("%v", err)
+	}
+}
+
+// podLogsHandler serves /api/v1/pods/{namespace}/{name}/logs.
+// Followed logs are streamed as chunked text, or as server-sent events when the
+// client accepts text/event-stream or passes format=sse.
+func podLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		errorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if StandaloneMode {
+		errorResponse(w, "pod logs are not available in standalone mode", http.StatusServiceUnavailable)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := LogOptions{
+		Namespace: r.PathValue("namespace"),
+		Pod:       r.PathValue("name"),
+		Container: query.Get("container"),
+	}
+	for name, target := range map[string]*bool{"previous": &opts.Previous, "timestamps": &opts.Timestamps, "follow": &opts.Follow} {
+		if value := query.Get(name); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				errorResponse(w, fmt.Sprintf("invalid %s: %v", name, err), http.StatusBadRequest)
+				return
+			}
+			*target = b
+		}
+	}
+	for name, target := range map[string]**int64{"tailLines": &opts.TailLines, "sinceSeconds": &opts.SinceSeconds} {
+		if value := query.Get(name); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || n < 0 {
+				errorResponse(w, fmt.Sprintf("invalid %s: must be a non-negative integer", name), http.StatusBadRequest)
+				return
+			}
+			*target = &n
+		}
+	}
+
+	ctx := r.Context()
+	if !opts.Follow {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, config2.DefaultTimeout)
+		defer cancel()
+	}
+
+	stream, err := openPodLogs(ctx, opts)
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer stream.Close()
+
+	if opts.Follow && (query.Get("format") == "sse" || r.Header.Get("Accept") == "text/event-stream") {
+		streamLogEvents(ctx, w, stream)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	var out io.Writer = w
+	if opts.Follow {
+		out = flushWriter{w}
+	}
+	if _, err := io.Copy(out, stream); err != nil && ctx.Err() == nil {
+		logger.Printf("Log stream of %s/%s ended with error: %v", opts.Namespace, opts.Pod, err)
+	}
+}
+
+// streamLogEvents sends each log line as a server-sent event, with a comment as keep-alive
+// so proxies do not close idle streams
+func streamLogEvents(ctx context.Context, w http.ResponseWriter, stream io.Reader) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	out := flushWriter{w}
+
+	lines := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		scanner := bufio.NewScanner(stream)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		done <- scanner.Err()
+	}()
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case line := <-lines:
+			if _, err := fmt.Fprintf(out, "data: %s\n\n", line); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(out, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case err := <-done:
+			if err != nil {
+				fmt.Fprintf(out, "event: error\ndata: %s\n\n", err)
+			}
+			io.WriteString(out, "event: end\ndata: \n\n")
+			return
+		}
+	}
+}
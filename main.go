@@ -8,17 +8,22 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
@@ -34,9 +39,10 @@ var (
 
 // Global variables
 var (
-	clientset *kubernetes.Clientset
-	config    *rest.Config
-	logger    *log.Logger
+	clientset     kubernetes.Interface
+	dynamicClient dynamic.Interface
+	config        *rest.Config
+	logger        *log.Logger
 
 	// Prometheus metrics
 	checksTotal = prometheus.NewCounterVec(
@@ -55,6 +61,15 @@ var (
 		[]string{"namespace", "status", "target"},
 	)
 
+	connectivityProbeDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "k8stoolbox_connectivity_probe_duration_seconds",
+			Help:    "Duration of scheduled connectivity probes, including the exec session into the source pod",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"namespace", "probe", "status"},
+	)
+
 	resourceUsage = prometheus.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "k8stoolbox_resource_usage",
@@ -81,6 +96,11 @@ type Configuration struct {
 	EnablePrometheus bool
 	PrometheusPort   int
 
+	// Exporter configuration used by server mode to evaluate health on scrape
+	ExporterNamespace     string
+	ExporterCacheTTL      time.Duration
+	ExporterScrapeTimeout time.Duration
+
 	// Logging configuration
 	LogLevel  string
 	LogFormat string
@@ -88,13 +108,55 @@ type Configuration struct {
 	// Kubernetes client configuration
 	KubeConfig     string
 	DefaultTimeout time.Duration
+
+	// Health policy file shared by checks, monitor, exporter and alerts
+	HealthPolicy string
+
+	// Directory or tar.gz of saved objects served instead of a cluster
+	OfflineData string
+
+	// History configuration
+	HistoryStore     string
+	HistoryRetention time.Duration
+
+	// Backup storage configuration
+	BackupStorage     string
+	BackupS3Endpoint  string
+	BackupS3Region    string
+	BackupHelperImage string
+
+	// Jobs scheduled in server mode
+	ScheduleConfig string
 }
 
+// Issue codes identify the kind of problem behind a pod health issue
+const (
+	IssueContainerNotReady = "ContainerNotReady"
+	IssueExcessiveRestarts = "ExcessiveRestarts"
+	IssueConditionNotTrue  = "ConditionNotTrue"
+	IssuePodNotRunning     = "PodNotRunning"
+	IssueCrashLoopBackOff  = "CrashLoopBackOff"
+	IssueOOMKilled         = "OOMKilled"
+)
+
 // PodHealthStatus represents the health status of a pod
 type PodHealthStatus struct {
 	Name   string
 	Status string
 	Issues []string
+	// Codes holds the distinct issue codes behind Issues
+	Codes []string
+}
+
+// addIssue records an issue message together with its code
+func (p *PodHealthStatus) addIssue(code, message string) {
+	p.Issues = append(p.Issues, message)
+	p.Codes = appendUnique(p.Codes, code)
+}
+
+// Healthy reports whether the pod is running without any detected issues
+func (p PodHealthStatus) Healthy() bool {
+	return len(p.Issues) == 0 && p.Status == "Running"
 }
 
 // APIResponse defines the standard API response format
@@ -107,23 +169,42 @@ type APIResponse struct {
 
 // Global configuration
 var config2 = Configuration{
-	EnableWebUI:      getBoolEnv("ENABLE_WEB_UI", false),
-	WebUIPort:        getIntEnv("WEB_UI_PORT", 8080),
-	WebUIPath:        getEnv("WEB_UI_PATH", "/"),
-	EnableAuth:       getBoolEnv("ENABLE_AUTH", true),
-	AuthUsername:     getEnv("AUTH_USERNAME", "admin"),
-	AuthPassword:     getEnv("AUTH_PASSWORD", ""),
-	AuthSecretName:   getEnv("AUTH_SECRET_NAME", "k8stoolbox-auth"),
-	EnablePrometheus: getBoolEnv("ENABLE_PROMETHEUS", false),
-	PrometheusPort:   getIntEnv("PROMETHEUS_PORT", 9090),
-	LogLevel:         getEnv("LOG_LEVEL", "info"),
-	LogFormat:        getEnv("LOG_FORMAT", "text"),
-	KubeConfig:       getEnv("KUBECONFIG", ""),
-	DefaultTimeout:   time.Duration(getIntEnv("DEFAULT_TIMEOUT", 30)) * time.Second,
+	EnableWebUI:           getBoolEnv("ENABLE_WEB_UI", false),
+	WebUIPort:             getIntEnv("WEB_UI_PORT", 8080),
+	WebUIPath:             getEnv("WEB_UI_PATH", "/"),
+	EnableAuth:            getBoolEnv("ENABLE_AUTH", true),
+	AuthUsername:          getEnv("AUTH_USERNAME", "admin"),
+	AuthPassword:          getEnv("AUTH_PASSWORD", ""),
+	AuthSecretName:        getEnv("AUTH_SECRET_NAME", "k8stoolbox-auth"),
+	EnablePrometheus:      getBoolEnv("ENABLE_PROMETHEUS", false),
+	PrometheusPort:        getIntEnv("PROMETHEUS_PORT", 9090),
+	ExporterNamespace:     getEnv("EXPORTER_NAMESPACE", ""),
+	ExporterCacheTTL:      time.Duration(getIntEnv("EXPORTER_CACHE_TTL", 15)) * time.Second,
+	ExporterScrapeTimeout: time.Duration(getIntEnv("EXPORTER_SCRAPE_TIMEOUT", 10)) * time.Second,
+	LogLevel:              getEnv("LOG_LEVEL", "info"),
+	LogFormat:             getEnv("LOG_FORMAT", "text"),
+	KubeConfig:            getEnv("KUBECONFIG", ""),
+	DefaultTimeout:        time.Duration(getIntEnv("DEFAULT_TIMEOUT", 30)) * time.Second,
+	HealthPolicy:          getEnv("HEALTH_POLICY", ""),
+	OfflineData:           getEnv("OFFLINE_DATA", ""),
+	HistoryStore:          getEnv("HISTORY_STORE", ""),
+	HistoryRetention:      time.Duration(getIntEnv("HISTORY_RETENTION_HOURS", 168)) * time.Hour,
+	BackupStorage:         getEnv("BACKUP_STORAGE", ""),
+	BackupS3Endpoint:      getEnv("BACKUP_S3_ENDPOINT", ""),
+	BackupS3Region:        getEnv("BACKUP_S3_REGION", "us-east-1"),
+	BackupHelperImage:     getEnv("BACKUP_HELPER_IMAGE", "busybox:1.36"),
+	ScheduleConfig:        getEnv("SCHEDULE_CONFIG", ""),
 }
 
-// StandaloneMode allows running without Kubernetes
-var StandaloneMode = getBoolEnv("STANDALONE_MODE", false)
+// offlineCommands do not need a Kubernetes client; subcommands are keyed as "command subcommand"
+var offlineCommands = map[string]bool{
+	"alerts":        true,
+	"version":       true,
+	"snapshot diff": true,
+}
+
+// StandaloneMode allows running without Kubernetes, serving saved objects from OFFLINE_DATA
+var StandaloneMode = getBoolEnv("STANDALONE_MODE", false) || config2.OfflineData != ""
 
 func init() {
 	// Initialize logger
@@ -132,12 +213,19 @@ func init() {
 	// Register Prometheus metrics
 	prometheus.MustRegister(checksTotal)
 	prometheus.MustRegister(connectivityChecksTotal)
-	prometheus.MustRegister(resourceUsage)
+	prometheus.MustRegister(connectivityProbeDuration)
+	prometheus.MustRegister(healthCollector)
 }
 
 func main() {
-	// Display version information
-	logger.Printf("K8sToolbox %s (Build: %s, Commit: %s)\n", Version, BuildTime, Commit)
+	// Display version information; offline commands keep stdout clean for their output
+	offline := len(os.Args) > 1 && offlineCommands[os.Args[1]]
+	if len(os.Args) > 2 && offlineCommands[os.Args[1]+" "+os.Args[2]] {
+		offline = true
+	}
+	if !offline {
+		logger.Printf("K8sToolbox %s (Build: %s, Commit: %s)\n", Version, BuildTime, Commit)
+	}
 
 	// Create context that can be canceled on SIGTERM/SIGINT
 	ctx, cancel := context.WithCancel(context.Background())
@@ -152,13 +240,52 @@ func main() {
 		cancel()
 	}()
 
+	// Load the health policy before any check reads the thresholds
+	if config2.HealthPolicy != "" {
+		policy, err := loadHealthPolicy(config2.HealthPolicy)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		applyHealthPolicy(policy)
+	}
+
+	// Export metrics and traces over OTLP when configured
+	shutdownTelemetry := func(context.Context) error { return nil }
+	if !offline {
+		var err error
+		if shutdownTelemetry, err = initTelemetry(ctx); err != nil {
+			logger.Printf("OpenTelemetry export disabled: %v", err)
+		}
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTelemetry(shutdownCtx); err != nil {
+			logger.Printf("Failed to flush telemetry: %v", err)
+		}
+	}()
+
 	// Initialize Kubernetes client
-	if !StandaloneMode {
+	if StandaloneMode && !offline {
+		if err := initOfflineClient(config2.OfflineData); err != nil {
+			logger.Fatalf("Failed to load offline data: %v", err)
+		}
+		logger.Println("Running in standalone mode - serving saved objects instead of a cluster")
+	} else if !offline {
 		if err := initKubernetesClient(); err != nil {
 			logger.Fatalf("Failed to initialize Kubernetes client: %v", err)
 		}
-	} else {
-		logger.Println("Running in standalone mode - Kubernetes client not initialized")
+	}
+
+	// Open the health history store if configured
+	if config2.HistoryStore != "" {
+		store, err := openHistoryStore(config2.HistoryStore)
+		if err != nil {
+			logger.Printf("Health history disabled: %v", err)
+		} else {
+			historyStore = store
+			defer historyStore.Close()
+		}
 	}
 
 	// Start web server if enabled
@@ -175,6 +302,7 @@ func main() {
 	healthCheckCmd := flag.NewFlagSet("healthcheck", flag.ExitOnError)
 	namespace := healthCheckCmd.String("namespace", "default", "Namespace to check pod health")
 	timeout := healthCheckCmd.Duration("timeout", 30*time.Second, "Timeout for operations")
+	analyzeLogs := healthCheckCmd.Bool("analyze-logs", false, "Analyse the logs of workloads with pods in CrashLoopBackOff")
 
 	connectivityCheckCmd := flag.NewFlagSet("connectivity", flag.ExitOnError)
 	namespaceConn := connectivityCheckCmd.String("namespace", "default", "Namespace of the pod")
@@ -191,7 +319,27 @@ func main() {
 	monitorCmd := flag.NewFlagSet("monitor", flag.ExitOnError)
 	monitorNamespace := monitorCmd.String("namespace", "default", "Namespace to monitor")
 	interval := monitorCmd.Duration("interval", 30*time.Second, "Monitoring interval")
-	output := monitorCmd.String("output", "stdout", "Output destination (stdout, prometheus, json)")
+	output := monitorCmd.String("output", "stdout", "Comma-separated output sinks (stdout, json, prometheus, file:<path>, tcp:<addr>, unix:<path>, syslog[:<url>])")
+	notifyConfig := monitorCmd.String("notify-config", getEnv("NOTIFY_CONFIG", ""), "Path to a notification sinks config file (YAML or JSON)")
+	monitorConfig := monitorCmd.String("config", getEnv("MONITOR_CONFIG", ""), "Path to a monitor targets config file (YAML or JSON); overrides -namespace and -interval")
+
+	dnsCmd := flag.NewFlagSet("dns", flag.ExitOnError)
+	dnsNamespace := dnsCmd.String("dns-namespace", "kube-system", "Namespace of the CoreDNS/kube-dns deployment")
+	namespaceDNS := dnsCmd.String("namespace", "default", "Namespace of the pod to resolve names from")
+	podDNS := dnsCmd.String("pod", "", "Name of the pod to resolve names from (optional)")
+	containerDNS := dnsCmd.String("container", "", "Container to exec into (defaults to the first container)")
+	namesDNS := dnsCmd.String("names", "kubernetes.default", "Comma-separated list of names to resolve")
+
+	captureCmd := flag.NewFlagSet("capture", flag.ExitOnError)
+	namespaceCapture := captureCmd.String("namespace", "default", "Namespace of the pod to capture traffic from")
+	podCapture := captureCmd.String("pod", "", "Name of the pod to capture traffic from")
+	filterCapture := captureCmd.String("filter", "", "BPF filter expression (e.g. 'tcp port 443')")
+	ifaceCapture := captureCmd.String("interface", "any", "Network interface to capture on")
+	durationCapture := captureCmd.Duration("duration", 30*time.Second, "Maximum capture duration")
+	countCapture := captureCmd.Int("count", 0, "Stop after this many packets (0 for no limit)")
+	maxSizeCapture := captureCmd.Int64("max-bytes", 100<<20, "Stop once the pcap reaches this many bytes (0 for no limit)")
+	imageCapture := captureCmd.String("image", getEnv("CAPTURE_IMAGE", defaultCaptureImage), "Image for the ephemeral capture container (must provide tcpdump)")
+	outputCapture := captureCmd.String("output", "capture.pcap", "Local file to write the pcap to")
 
 	if len(os.Args) < 2 {
 		printUsage()
@@ -208,7 +356,7 @@ func main() {
 		if err != nil {
 			return
 		}
-		performHealthCheck(timeoutCtx, *namespace)
+		performHealthCheck(timeoutCtx, *namespace, *analyzeLogs)
 	case "connectivity":
 		err := connectivityCheckCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -230,12 +378,100 @@ func main() {
 		if err != nil {
 			return
 		}
-		startMonitoring(ctx, *monitorNamespace, *interval, *output)
+		var targets MonitorConfig
+		if *monitorConfig != "" {
+			targets, err = loadMonitorConfig(*monitorConfig)
+		} else {
+			targets, err = singleNamespaceMonitorConfig(*monitorNamespace, *interval)
+		}
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		var notifier *Notifier
+		if *notifyConfig != "" {
+			cfg, err := loadNotifierConfig(*notifyConfig)
+			if err != nil {
+				logger.Fatalf("%v", err)
+			}
+			notifier, err = newNotifier(cfg)
+			if err != nil {
+				logger.Fatalf("Invalid notifier config: %v", err)
+			}
+			silences := newSilenceStore(toolboxNamespace())
+			go silences.Run(ctx)
+			notifier.AddFilter(silenceFilter(silences, cfg.MaintenanceWindows))
+			go notifier.Start(ctx)
+		}
+		outputs, err := parseMonitorOutputs(*output)
+		if err != nil {
+			logger.Fatalf("Invalid monitor output: %v", err)
+		}
+		defer outputs.Close()
+		startMonitoring(ctx, targets, outputs, notifier)
+	case "dns":
+		err := dnsCmd.Parse(os.Args[2:])
+		if err != nil {
+			return
+		}
+		performDNSDiagnostics(timeoutCtx, DNSDiagnosticsOptions{
+			DNSNamespace: *dnsNamespace,
+			Namespace:    *namespaceDNS,
+			Pod:          *podDNS,
+			Container:    *containerDNS,
+			Names:        splitList(*namesDNS),
+		})
+	case "capture":
+		err := captureCmd.Parse(os.Args[2:])
+		if err != nil {
+			return
+		}
+		if *podCapture == "" {
+			logger.Println("Please specify the pod to capture traffic from")
+			os.Exit(1)
+		}
+		performPacketCapture(ctx, CaptureOptions{
+			Namespace: *namespaceCapture,
+			Pod:       *podCapture,
+			Image:     *imageCapture,
+			Interface: *ifaceCapture,
+			Filter:    *filterCapture,
+			Duration:  *durationCapture,
+			Count:     *countCapture,
+			MaxBytes:  *maxSizeCapture,
+		}, *outputCapture)
+	case "logs":
+		runLogsCommand(ctx, os.Args[2:])
+	case "silence":
+		runSilenceCommand(timeoutCtx, os.Args[2:])
+	case "history":
+		showHistory(timeoutCtx, os.Args[2:])
+	case "alerts":
+		runAlertsCommand(os.Args[2:])
+	case "bundle":
+		runBundleCommand(ctx, os.Args[2:])
+	case "backup":
+		runBackupCommand(ctx, os.Args[2:])
+	case "restore":
+		runRestoreCommand(ctx, os.Args[2:])
+	case "snapshot":
+		runSnapshotCommand(ctx, os.Args[2:])
+	case "namespace":
+		runNamespaceCommand(ctx, os.Args[2:])
+	case "crd":
+		runCRDCommand(ctx, os.Args[2:])
 	case "version":
 		fmt.Printf("K8sToolbox %s\nBuild Time: %s\nCommit: %s\n", Version, BuildTime, Commit)
 	case "server":
-		// Just start the servers and wait
 		logger.Println("Starting in server mode...")
+		// Evaluate health on scrape so the metrics endpoint works as a standalone exporter
+		if config2.EnablePrometheus && !StandaloneMode {
+			healthCollector.EnableEvaluation(ctx, clientset, config2.ExporterNamespace, defaultHealthThresholds,
+				config2.ExporterCacheTTL, config2.ExporterScrapeTimeout)
+		}
+		// Run scheduled backups, bundles and snapshots on the replica holding the scheduler lease
+		if config2.ScheduleConfig != "" {
+			go startScheduler(ctx, config2.ScheduleConfig)
+		}
 		<-ctx.Done()
 	default:
 		logger.Printf("Unknown command: %s\n", os.Args[1])
@@ -281,6 +517,20 @@ func getIntEnv(key string, defaultValue int) int {
 	return defaultValue
 }
 
+// toolboxNamespace returns the namespace used to store toolbox state, preferring
+// TOOLBOX_NAMESPACE, then the namespace of the service account when running in a pod
+func toolboxNamespace() string {
+	if ns := getEnv("TOOLBOX_NAMESPACE", ""); ns != "" {
+		return ns
+	}
+	if data, err := os.ReadFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace"); err == nil {
+		if ns := strings.TrimSpace(string(data)); ns != "" {
+			return ns
+		}
+	}
+	return "default"
+}
+
 // basicAuth implements HTTP basic authentication middleware
 func basicAuth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -342,8 +592,13 @@ func startWebServer(ctx context.Context) {
 	mux.HandleFunc("/api/v1/health", healthHandler)
 	mux.HandleFunc("/api/v1/namespaces", namespacesHandler)
 	mux.HandleFunc("/api/v1/pods", podsHandler)
+	mux.HandleFunc("/api/v1/pods/{namespace}/{name}/logs", podLogsHandler)
 	mux.HandleFunc("/api/v1/services", servicesHandler)
 	mux.HandleFunc("/api/v1/nodes", nodesHandler)
+	mux.HandleFunc("/api/v1/capture", captureHandler)
+	mux.HandleFunc("/api/v1/silences", silencesHandler)
+	mux.HandleFunc("/api/v1/history", historyHandler)
+	mux.HandleFunc("/api/v1/jobs", jobsHandler)
 
 	// Static content (in a real implementation this would serve actual HTML/JS/CSS)
 	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -358,6 +613,7 @@ func startWebServer(ctx context.Context) {
 	if config2.EnableAuth {
 		handler = basicAuth(mux)
 	}
+	handler = traceHTTPHandler(handler)
 
 	server := &http.Server{
 		Addr:    addr,
@@ -402,19 +658,14 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 func namespacesHandler(w http.ResponseWriter, r *http.Request) {
 	var namespaceNames []string
 
-	if StandaloneMode {
-		// In standalone mode, return some dummy namespaces
-		namespaceNames = []string{"default", "kube-system", "demo"}
-	} else {
-		namespaces, err := clientset.CoreV1().Namespaces().List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			errorResponse(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	namespaces, err := clientset.CoreV1().Namespaces().List(r.Context(), metav1.ListOptions{})
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		for _, ns := range namespaces.Items {
-			namespaceNames = append(namespaceNames, ns.Name)
-		}
+	for _, ns := range namespaces.Items {
+		namespaceNames = append(namespaceNames, ns.Name)
 	}
 
 	response := APIResponse{
@@ -442,64 +693,28 @@ func podsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var podInfos []PodInfo
 
-	if StandaloneMode {
-		// In standalone mode, return some dummy pods
-		podInfos = []PodInfo{
-			{
-				Name:      "example-pod-1",
-				Namespace: namespace,
-				Status:    "Running",
-				Ready:     true,
-				Labels: map[string]string{
-					"app":  "example",
-					"tier": "frontend",
-				},
-			},
-			{
-				Name:      "example-pod-2",
-				Namespace: namespace,
-				Status:    "Running",
-				Ready:     true,
-				Labels: map[string]string{
-					"app":  "example",
-					"tier": "backend",
-				},
-			},
-			{
-				Name:      "example-pod-3",
-				Namespace: namespace,
-				Status:    "Pending",
-				Ready:     false,
-				Labels: map[string]string{
-					"app":  "example",
-					"tier": "database",
-				},
-			},
-		}
-	} else {
-		pods, err := clientset.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			errorResponse(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	pods, err := clientset.CoreV1().Pods(namespace).List(r.Context(), metav1.ListOptions{})
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		for _, pod := range pods.Items {
-			ready := true
-			for _, cs := range pod.Status.ContainerStatuses {
-				if !cs.Ready {
-					ready = false
-					break
-				}
+	for _, pod := range pods.Items {
+		ready := true
+		for _, cs := range pod.Status.ContainerStatuses {
+			if !cs.Ready {
+				ready = false
+				break
 			}
-
-			podInfos = append(podInfos, PodInfo{
-				Name:      pod.Name,
-				Namespace: pod.Namespace,
-				Status:    string(pod.Status.Phase),
-				Ready:     ready,
-				Labels:    pod.Labels,
-			})
 		}
+
+		podInfos = append(podInfos, PodInfo{
+			Name:      pod.Name,
+			Namespace: pod.Namespace,
+			Status:    string(pod.Status.Phase),
+			Ready:     ready,
+			Labels:    pod.Labels,
+		})
 	}
 
 	response := APIResponse{
@@ -529,66 +744,37 @@ func servicesHandler(w http.ResponseWriter, r *http.Request) {
 
 	var serviceInfos []ServiceInfo
 
-	if StandaloneMode {
-		// In standalone mode, return some dummy services
-		serviceInfos = []ServiceInfo{
-			{
-				Name:      "example-service-1",
-				Namespace: namespace,
-				Type:      "ClusterIP",
-				ClusterIP: "10.96.0.10",
-				Ports:     []string{"80/TCP", "443/TCP"},
-				Labels: map[string]string{
-					"app":  "example",
-					"tier": "frontend",
-				},
-			},
-			{
-				Name:       "example-service-2",
-				Namespace:  namespace,
-				Type:       "LoadBalancer",
-				ClusterIP:  "10.96.0.11",
-				ExternalIP: []string{"192.168.1.100"},
-				Ports:      []string{"8080/TCP"},
-				Labels: map[string]string{
-					"app":  "example",
-					"tier": "backend",
-				},
-			},
-		}
-	} else {
-		services, err := clientset.CoreV1().Services(namespace).List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			errorResponse(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	services, err := clientset.CoreV1().Services(namespace).List(r.Context(), metav1.ListOptions{})
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		for _, svc := range services.Items {
-			var ports []string
-			for _, port := range svc.Spec.Ports {
-				ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
-			}
+	for _, svc := range services.Items {
+		var ports []string
+		for _, port := range svc.Spec.Ports {
+			ports = append(ports, fmt.Sprintf("%d/%s", port.Port, port.Protocol))
+		}
 
-			// Safely handle external IPs
-			var externalIPs []string
-			if len(svc.Status.LoadBalancer.Ingress) > 0 {
-				for _, ingress := range svc.Status.LoadBalancer.Ingress {
-					if ingress.IP != "" {
-						externalIPs = append(externalIPs, ingress.IP)
-					}
+		// Safely handle external IPs
+		var externalIPs []string
+		if len(svc.Status.LoadBalancer.Ingress) > 0 {
+			for _, ingress := range svc.Status.LoadBalancer.Ingress {
+				if ingress.IP != "" {
+					externalIPs = append(externalIPs, ingress.IP)
 				}
 			}
-
-			serviceInfos = append(serviceInfos, ServiceInfo{
-				Name:       svc.Name,
-				Namespace:  svc.Namespace,
-				Type:       string(svc.Spec.Type),
-				ClusterIP:  svc.Spec.ClusterIP,
-				ExternalIP: externalIPs,
-				Ports:      ports,
-				Labels:     svc.Labels,
-			})
 		}
+
+		serviceInfos = append(serviceInfos, ServiceInfo{
+			Name:       svc.Name,
+			Namespace:  svc.Namespace,
+			Type:       string(svc.Spec.Type),
+			ClusterIP:  svc.Spec.ClusterIP,
+			ExternalIP: externalIPs,
+			Ports:      ports,
+			Labels:     svc.Labels,
+		})
 	}
 
 	response := APIResponse{
@@ -614,74 +800,42 @@ func nodesHandler(w http.ResponseWriter, r *http.Request) {
 
 	var nodeInfos []NodeInfo
 
-	if StandaloneMode {
-		// In standalone mode, return some dummy nodes
-		nodeInfos = []NodeInfo{
-			{
-				Name:             "example-node-1",
-				Status:           "Ready",
-				Addresses:        []string{"InternalIP: 192.168.1.10", "Hostname: example-node-1"},
-				KubeletVersion:   "v1.28.3",
-				KernelVersion:    "5.15.0-86-generic",
-				OSImage:          "Ubuntu 22.04.3 LTS",
-				ContainerRuntime: "containerd://1.7.4",
-				Labels: map[string]string{
-					"kubernetes.io/hostname":                "example-node-1",
-					"node-role.kubernetes.io/control-plane": "",
-				},
-			},
-			{
-				Name:             "example-node-2",
-				Status:           "Ready",
-				Addresses:        []string{"InternalIP: 192.168.1.11", "Hostname: example-node-2"},
-				KubeletVersion:   "v1.28.3",
-				KernelVersion:    "5.15.0-86-generic",
-				OSImage:          "Ubuntu 22.04.3 LTS",
-				ContainerRuntime: "containerd://1.7.4",
-				Labels: map[string]string{
-					"kubernetes.io/hostname":         "example-node-2",
-					"node-role.kubernetes.io/worker": "",
-				},
-			},
-		}
-	} else {
-		nodes, err := clientset.CoreV1().Nodes().List(context.Background(), metav1.ListOptions{})
-		if err != nil {
-			errorResponse(w, err.Error(), http.StatusInternalServerError)
-			return
-		}
+	nodes, err := clientset.CoreV1().Nodes().List(r.Context(), metav1.ListOptions{})
+	if err != nil {
+		errorResponse(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-		for _, node := range nodes.Items {
-			// Determine node status
-			status := "Unknown"
-			for _, cond := range node.Status.Conditions {
-				if cond.Type == "Ready" {
-					if cond.Status == "True" {
-						status = "Ready"
-					} else {
-						status = "NotReady"
-					}
-					break
+	for _, node := range nodes.Items {
+		// Determine node status
+		status := "Unknown"
+		for _, cond := range node.Status.Conditions {
+			if cond.Type == "Ready" {
+				if cond.Status == "True" {
+					status = "Ready"
+				} else {
+					status = "NotReady"
 				}
+				break
 			}
+		}
 
-			// Collect addresses
-			var addresses []string
-			for _, addr := range node.Status.Addresses {
-				addresses = append(addresses, fmt.Sprintf("%s: %s", addr.Type, addr.Address))
-			}
-
-			nodeInfos = append(nodeInfos, NodeInfo{
-				Name:             node.Name,
-				Status:           status,
-				Addresses:        addresses,
-				KubeletVersion:   node.Status.NodeInfo.KubeletVersion,
-				KernelVersion:    node.Status.NodeInfo.KernelVersion,
-				OSImage:          node.Status.NodeInfo.OSImage,
-				ContainerRuntime: node.Status.NodeInfo.ContainerRuntimeVersion,
-				Labels:           node.Labels,
-			})
+		// Collect addresses
+		var addresses []string
+		for _, addr := range node.Status.Addresses {
+			addresses = append(addresses, fmt.Sprintf("%s: %s", addr.Type, addr.Address))
 		}
+
+		nodeInfos = append(nodeInfos, NodeInfo{
+			Name:             node.Name,
+			Status:           status,
+			Addresses:        addresses,
+			KubeletVersion:   node.Status.NodeInfo.KubeletVersion,
+			KernelVersion:    node.Status.NodeInfo.KernelVersion,
+			OSImage:          node.Status.NodeInfo.OSImage,
+			ContainerRuntime: node.Status.NodeInfo.ContainerRuntimeVersion,
+			Labels:           node.Labels,
+		})
 	}
 
 	response := APIResponse{
@@ -713,6 +867,18 @@ func printUsage() {
 	fmt.Println("  connectivity   Tests network connectivity from a pod to a target")
 	fmt.Println("  resources      Checks resource usage in a namespace")
 	fmt.Println("  monitor        Continuously monitors resources with the specified interval")
+	fmt.Println("  dns            Diagnoses cluster DNS health and resolution from a pod")
+	fmt.Println("  capture        Captures pod traffic to a pcap file via an ephemeral container")
+	fmt.Println("  logs           Shows or follows pod logs; 'logs aggregate' collects and 'logs analyze' clusters logs of many pods")
+	fmt.Println("  silence        Creates, lists and expires notification silences")
+	fmt.Println("  history        Shows recorded health checks and transitions")
+	fmt.Println("  alerts         Generates Prometheus alert rules from the health policy")
+	fmt.Println("  bundle         Collects objects, logs and health reports into a support bundle")
+	fmt.Println("  backup         Backs up the resources of namespaces; 'backup list' and 'backup prune' manage stored backups")
+	fmt.Println("  restore        Restores a backup in dependency order, optionally into other namespaces")
+	fmt.Println("  crd            Removes the instances of a custom resource definition and waits for it to go ('crd cleanup <name>')")
+	fmt.Println("  namespace      Diagnoses a namespace stuck in Terminating and removes what blocks it ('namespace unstick <ns>')")
+	fmt.Println("  snapshot       Takes cluster snapshots ('snapshot take') and reports drift between two ('snapshot diff A B')")
 	fmt.Println("  server         Starts the web UI and/or Prometheus metrics server")
 	fmt.Println("  version        Shows version information")
 	fmt.Println("\nUse 'k8stoolbox <command> --help' for more information about a command.")
@@ -724,6 +890,25 @@ func printUsage() {
 	fmt.Println("  AUTH_PASSWORD       Password for basic authentication")
 	fmt.Println("  ENABLE_PROMETHEUS   Enable Prometheus metrics endpoint (true/false)")
 	fmt.Println("  PROMETHEUS_PORT     Prometheus metrics port (default: 9090)")
+	fmt.Println("  EXPORTER_NAMESPACE  Namespace evaluated on scrape in server mode (default: all)")
+	fmt.Println("  EXPORTER_CACHE_TTL  Seconds an on-scrape evaluation is reused (default: 15)")
+	fmt.Println("  EXPORTER_SCRAPE_TIMEOUT  Seconds a scrape waits for the informer cache (default: 10)")
+	fmt.Println("  CAPTURE_IMAGE       Image used for packet capture containers")
+	fmt.Println("  NOTIFY_CONFIG       Notification sinks config file used by monitor")
+	fmt.Println("  MONITOR_CONFIG      Monitor targets config file (namespaces, checks, thresholds)")
+	fmt.Println("  HEALTH_POLICY       Health policy file with the default thresholds for checks and alerts")
+	fmt.Println("  STANDALONE_MODE     Run without a cluster (true/false)")
+	fmt.Println("  OFFLINE_DATA        Directory or tar.gz of saved YAML/JSON objects (e.g. a bundle) to check instead of a cluster")
+	fmt.Println("  OTEL_EXPORTER_OTLP_ENDPOINT  Enables OTLP export of metrics and traces (standard OTEL_* variables apply)")
+	fmt.Println("  OTEL_EXPORTER_OTLP_PROTOCOL  OTLP protocol: grpc or http/protobuf (default: http/protobuf)")
+	fmt.Println("  TOOLBOX_NAMESPACE   Namespace for toolbox state such as silences")
+	fmt.Println("  HISTORY_STORE       Health history store: bolt:<path> or configmap (compact summaries, ~900KiB; oldest records are dropped first)")
+	fmt.Println("  HISTORY_RETENTION_HOURS  Hours of health history to keep (default: 168)")
+	fmt.Println("  BACKUP_STORAGE      Backup location: a directory, s3://bucket/prefix or pvc://namespace/claim/dir")
+	fmt.Println("  BACKUP_S3_ENDPOINT  S3-compatible endpoint, e.g. a MinIO URL (default: AWS for BACKUP_S3_REGION)")
+	fmt.Println("  BACKUP_S3_REGION    S3 region used for request signing (default: us-east-1)")
+	fmt.Println("  BACKUP_HELPER_IMAGE Image of the pod mounting PVC backup storage (default: busybox:1.36)")
+	fmt.Println("  SCHEDULE_CONFIG     Jobs (backups, bundles, snapshots) run on cron schedules in server mode")
 }
 
 // initKubernetesClient initializes the Kubernetes client
@@ -752,51 +937,85 @@ func initKubernetesClient() error {
 	// Set reasonable timeouts
 	config.Timeout = 30 * time.Second
 
+	// Trace API requests when OpenTelemetry export is enabled
+	traceKubernetesClient()
+
 	// Creates the clientset
 	clientset, err = kubernetes.NewForConfig(config)
 	if err != nil {
 		return fmt.Errorf("failed to create Kubernetes clientset: %v", err)
 	}
 
+	// Dynamic client for resources discovered at runtime, such as in backups
+	dynamicClient, err = dynamic.NewForConfig(config)
+	if err != nil {
+		return fmt.Errorf("failed to create dynamic client: %v", err)
+	}
+
 	return nil
 }
 
-// startMonitoring begins continuous monitoring of cluster resources
-func startMonitoring(ctx context.Context, namespace string, interval time.Duration, outputFormat string) {
-	logger.Printf("Starting monitoring of namespace '%s' with interval %v", namespace, interval)
+// startMonitoring begins continuous monitoring of cluster resources.
+// Every target of the config runs concurrently on its own interval; pod, workload and
+// node state come from shared informers, so each interval mostly reads the local cache.
+// Health transitions are forwarded to the notifier when one is configured.
+func startMonitoring(ctx context.Context, cfg MonitorConfig, outputs *MonitorOutputs, notifier *Notifier) {
+	onTransition := func(t HealthTransition) {
+		outputs.Emit(MonitorEvent{Transition: &t})
+		recordHistory(ctx, HistoryRecord{Kind: HistoryKindTransition, Namespace: t.Namespace, Timestamp: t.Timestamp, Transition: &t})
+		if notifier != nil {
+			notifier.Notify(t)
+		}
+	}
+	onCheck := func(result HealthCheckResult) {
+		recordHistory(ctx, HistoryRecord{Kind: HistoryKindCheck, Namespace: result.Namespace, Timestamp: result.Timestamp, Check: &result})
+		outputs.Emit(MonitorEvent{Check: &result})
+		if notifier != nil {
+			notifier.ResendDue(time.Now())
+		}
+	}
 
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+	var nodes *NodeMonitor
+	for _, target := range cfg.Targets {
+		if target.Enabled(CheckNodes) {
+			nodes = newNodeMonitor(clientset)
+			if err := nodes.Start(ctx); err != nil {
+				logger.Printf("Node checks disabled: %v", err)
+				nodes = nil
+			}
+			break
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, target := range cfg.Targets {
+		wg.Add(1)
+		go func(target MonitorTarget) {
+			defer wg.Done()
+			runMonitorTarget(ctx, target, nodes, onTransition, onCheck)
+		}(target)
+	}
 
+	pruneHistory(ctx)
+	wg.Wait()
+	logger.Println("Monitoring stopped")
+}
+
+// pruneHistory drops health history older than the retention period every hour until ctx is cancelled
+func pruneHistory(ctx context.Context) {
+	if historyStore == nil {
+		return
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
 	for {
+		if err := historyStore.Prune(ctx, time.Now().Add(-config2.HistoryRetention)); err != nil && ctx.Err() == nil {
+			logger.Printf("Failed to prune health history: %v", err)
+		}
 		select {
 		case <-ctx.Done():
-			logger.Println("Monitoring stopped")
 			return
 		case <-ticker.C:
-			// Perform health check
-			healthResult := performHealthCheckWithResults(ctx, namespace)
-
-			// Output results based on format
-			switch outputFormat {
-			case "json":
-				jsonData, err := json.Marshal(healthResult)
-				if err == nil {
-					fmt.Println(string(jsonData))
-				}
-			case "prometheus":
-				// Update Prometheus metrics (already done in performHealthCheckWithResults)
-				logger.Println("Metrics updated in Prometheus")
-			default:
-				// Default stdout output
-				fmt.Printf("Health check at %s: %d healthy pods, %d unhealthy pods\n",
-					time.Now().Format(time.RFC3339),
-					healthResult.HealthyPods,
-					healthResult.UnhealthyPods)
-			}
-
-			// Collect resource usage
-			checkResourceUsage(ctx, namespace, 80)
 		}
 	}
 }
@@ -804,91 +1023,154 @@ func startMonitoring(ctx context.Context, namespace string, interval time.Durati
 // HealthCheckResult represents the result of a health check operation
 type HealthCheckResult struct {
 	Namespace     string            `json:"namespace"`
+	Target        string            `json:"target,omitempty"`
 	HealthyPods   int               `json:"healthyPods"`
 	UnhealthyPods int               `json:"unhealthyPods"`
 	PodDetails    []PodHealthStatus `json:"podDetails"`
 	Timestamp     time.Time         `json:"timestamp"`
+
+	// Results of the optional monitor checks
+	Workloads    []WorkloadHealthStatus `json:"workloads,omitempty"`
+	Nodes        []NodeHealthStatus     `json:"nodes,omitempty"`
+	Quotas       []QuotaStatus          `json:"quotas,omitempty"`
+	Certificates []CertificateStatus    `json:"certificates,omitempty"`
+	Probes       []ProbeResult          `json:"probes,omitempty"`
+
+	// Log analysis of workloads with pods in CrashLoopBackOff
+	LogAnalysis []WorkloadLogSummary `json:"logAnalysis,omitempty"`
 }
 
-// performHealthCheckWithResults performs a health check and returns structured results
-func performHealthCheckWithResults(ctx context.Context, namespace string) HealthCheckResult {
-	result := HealthCheckResult{
-		Namespace:  namespace,
-		Timestamp:  time.Now(),
-		PodDetails: []PodHealthStatus{},
-	}
+// performHealthCheckWithResults performs a health check and returns structured results.
+// With analyzeLogs, the logs of workloads with pods in CrashLoopBackOff are analysed as well.
+func performHealthCheckWithResults(ctx context.Context, namespace string, analyzeLogs bool) HealthCheckResult {
+	ctx, span := startSpan(ctx, "healthcheck", attribute.String("k8s.namespace.name", namespace))
+	var err error
+	defer func() { endSpan(span, err) }()
 
 	// Get all pods in the specified namespace
 	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
 	if err != nil {
 		logger.Printf("Error listing pods: %v\n", err)
-		return result
+		return HealthCheckResult{Namespace: namespace, Timestamp: time.Now(), PodDetails: []PodHealthStatus{}}
 	}
 
 	if len(pods.Items) == 0 {
 		logger.Printf("No pods found in namespace '%s'\n", namespace)
-		return result
 	}
 
-	// Process each pod
-	for _, pod := range pods.Items {
-		podStatus := PodHealthStatus{
-			Name:   pod.Name,
-			Status: string(pod.Status.Phase),
-			Issues: []string{},
-		}
-
-		// Check readiness and liveness probes
-		for _, containerStatus := range pod.Status.ContainerStatuses {
-			if !containerStatus.Ready {
-				podStatus.Issues = append(podStatus.Issues,
-					fmt.Sprintf("Container %s is not ready", containerStatus.Name))
-			}
+	result := summarizePodHealth(namespace, pods.Items, defaultHealthThresholds)
+	recordPodChecks(result)
+	if analyzeLogs {
+		result.LogAnalysis = analyzeCrashLoopLogs(ctx, pods.Items)
+	}
+	return result
+}
 
-			if containerStatus.RestartCount > 5 {
-				podStatus.Issues = append(podStatus.Issues,
-					fmt.Sprintf("Container %s has restarted %d times",
-						containerStatus.Name, containerStatus.RestartCount))
-			}
-		}
+// summarizePodHealth evaluates a set of pods and aggregates them into a HealthCheckResult
+func summarizePodHealth(namespace string, pods []corev1.Pod, thresholds HealthThresholds) HealthCheckResult {
+	result := HealthCheckResult{
+		Namespace:  namespace,
+		Timestamp:  time.Now(),
+		PodDetails: []PodHealthStatus{},
+	}
 
-		// Check pod conditions
-		for _, condition := range pod.Status.Conditions {
-			if condition.Status != "True" && condition.Type != "PodScheduled" {
-				podStatus.Issues = append(podStatus.Issues,
-					fmt.Sprintf("Condition %s is %s: %s",
-						condition.Type, condition.Status, condition.Message))
-			}
-		}
+	// Process each pod
+	for _, pod := range pods {
+		podStatus := evaluatePodHealth(pod, thresholds)
 
 		// Update counts and details
 		result.PodDetails = append(result.PodDetails, podStatus)
 
-		if len(podStatus.Issues) > 0 || podStatus.Status != "Running" {
+		if !podStatus.Healthy() {
 			result.UnhealthyPods++
-
-			// Update Prometheus metrics
-			checksTotal.WithLabelValues(namespace, "unhealthy").Inc()
 		} else {
 			result.HealthyPods++
-
-			// Update Prometheus metrics
-			checksTotal.WithLabelValues(namespace, "healthy").Inc()
 		}
 	}
 
 	return result
 }
 
+// recordPodChecks counts the pods evaluated by an explicit health check or a monitor run.
+// Exporter scrapes and support bundles evaluate pods as well but are not counted as checks.
+func recordPodChecks(result HealthCheckResult) {
+	if result.HealthyPods > 0 {
+		checksTotal.WithLabelValues(result.Namespace, "healthy").Add(float64(result.HealthyPods))
+	}
+	if result.UnhealthyPods > 0 {
+		checksTotal.WithLabelValues(result.Namespace, "unhealthy").Add(float64(result.UnhealthyPods))
+	}
+}
+
+// evaluatePodHealth checks container readiness, restarts and conditions of a single pod
+func evaluatePodHealth(pod corev1.Pod, thresholds HealthThresholds) PodHealthStatus {
+	podStatus := PodHealthStatus{
+		Name:   pod.Name,
+		Status: string(pod.Status.Phase),
+		Issues: []string{},
+		Codes:  []string{},
+	}
+
+	// Check readiness and liveness probes
+	for _, containerStatus := range pod.Status.ContainerStatuses {
+		if !containerStatus.Ready {
+			podStatus.addIssue(IssueContainerNotReady,
+				fmt.Sprintf("Container %s is not ready", containerStatus.Name))
+		}
+
+		if containerStatus.RestartCount > thresholds.RestartCount {
+			podStatus.addIssue(IssueExcessiveRestarts,
+				fmt.Sprintf("Container %s has restarted %d times",
+					containerStatus.Name, containerStatus.RestartCount))
+		}
+
+		if waiting := containerStatus.State.Waiting; waiting != nil && waiting.Reason == IssueCrashLoopBackOff {
+			podStatus.addIssue(IssueCrashLoopBackOff,
+				fmt.Sprintf("Container %s is in CrashLoopBackOff", containerStatus.Name))
+		}
+
+		// A past OOM kill only matters while the container has not recovered from it
+		if !containerStatus.Ready && containerOOMKilled(containerStatus) {
+			podStatus.addIssue(IssueOOMKilled,
+				fmt.Sprintf("Container %s was OOMKilled", containerStatus.Name))
+		}
+	}
+
+	// Check pod conditions
+	for _, condition := range pod.Status.Conditions {
+		if condition.Status != "True" && condition.Type != "PodScheduled" {
+			podStatus.addIssue(IssueConditionNotTrue,
+				fmt.Sprintf("Condition %s is %s: %s",
+					condition.Type, condition.Status, condition.Message))
+		}
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		podStatus.Codes = appendUnique(podStatus.Codes, IssuePodNotRunning)
+	}
+
+	return podStatus
+}
+
+// containerOOMKilled reports whether the current or last instance of a container was OOM killed
+func containerOOMKilled(cs corev1.ContainerStatus) bool {
+	for _, terminated := range []*corev1.ContainerStateTerminated{cs.State.Terminated, cs.LastTerminationState.Terminated} {
+		if terminated != nil && terminated.Reason == IssueOOMKilled {
+			return true
+		}
+	}
+	return false
+}
+
 // performHealthCheck performs a health check on all pods in the specified namespace
-func performHealthCheck(ctx context.Context, namespace string) {
-	result := performHealthCheckWithResults(ctx, namespace)
+func performHealthCheck(ctx context.Context, namespace string, analyzeLogs bool) {
+	result := performHealthCheckWithResults(ctx, namespace, analyzeLogs)
 
 	// Log the results
 	logger.Printf("Performing health checks on namespace '%s'\n", namespace)
 
 	for _, podStatus := range result.PodDetails {
-		if len(podStatus.Issues) > 0 || podStatus.Status != "Running" {
+		if !podStatus.Healthy() {
 			logger.Printf("⚠️ Pod %s is not healthy (Status: %s)\n", podStatus.Name, podStatus.Status)
 			for _, issue := range podStatus.Issues {
 				logger.Printf("  - %s\n", issue)
@@ -898,6 +1180,13 @@ func performHealthCheck(ctx context.Context, namespace string) {
 		}
 	}
 
+	for _, summary := range result.LogAnalysis {
+		logger.Printf("⚠️ Log analysis of %s %s (%d pods, %d lines):\n", summary.Kind, summary.Name, len(summary.Pods), summary.Lines)
+		for _, issue := range summary.Issues() {
+			logger.Printf("  - %s\n", issue)
+		}
+	}
+
 	logger.Printf("Health check summary: %d healthy pods, %d unhealthy pods\n",
 		result.HealthyPods, result.UnhealthyPods)
 }
@@ -910,58 +1199,71 @@ func testPodConnectivity(ctx context.Context, namespace, podName, target, protoc
 
 	// Validate protocol
 	protocol = strings.ToLower(protocol)
-	if protocol != "tcp" && protocol != "http" && protocol != "icmp" {
-		logger.Fatalf("Invalid protocol: %s. Must be one of: tcp, http, icmp", protocol)
+	command, err := connectivityCommand(protocol, target, port)
+	if err != nil {
+		logger.Fatalf("%v", err)
 	}
 
-	var command []string
-	switch protocol {
-	case "tcp":
-		command = []string{"nc", "-zv", "-w", "5", target, fmt.Sprintf("%d", port)}
-	case "http":
-		command = []string{"curl", "-sSf", "-m", "10", "-o", "/dev/null", fmt.Sprintf("http://%s:%d", target, port)}
-	case "icmp":
-		command = []string{"ping", "-c", "3", target}
+	logger.Printf("Testing %s connectivity from pod %s to %s\n", protocol, podName, target)
+
+	ctx, span := startSpan(ctx, "connectivity",
+		attribute.String("k8s.namespace.name", namespace),
+		attribute.String("k8s.pod.name", podName),
+		attribute.String("target", target),
+		attribute.String("protocol", protocol))
+	err = execInPod(ctx, namespace, podName, "", command, nil, os.Stdout, os.Stderr)
+	endSpan(span, err)
+
+	if err != nil {
+		connectivityChecksTotal.WithLabelValues(namespace, "failed", target).Inc()
+		logger.Fatalf("Connectivity test failed: %v", err)
+	} else {
+		logger.Printf("Connectivity test succeeded\n")
+		connectivityChecksTotal.WithLabelValues(namespace, "success", target).Inc()
 	}
+}
 
-	logger.Printf("Testing %s connectivity from pod %s to %s\n", protocol, podName, target)
+// execInPod runs a command in a pod container and streams its output to the given writers.
+// An empty container name selects the pod's first container.
+func execInPod(ctx context.Context, namespace, podName, container string, command []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	if clientset == nil || config == nil {
+		return fmt.Errorf("kubernetes clientset or config is not initialized")
+	}
+
+	ctx, span := startSpan(ctx, "k8s exec",
+		attribute.String("k8s.namespace.name", namespace),
+		attribute.String("k8s.pod.name", podName),
+		attribute.String("k8s.container.name", container),
+		attribute.String("command", strings.Join(command, " ")))
+	defer func() { endSpan(span, err) }()
 
-	// Set up the exec request
 	req := clientset.CoreV1().RESTClient().
 		Post().
 		Resource("pods").
 		Name(podName).
 		Namespace(namespace).
 		SubResource("exec").
-		Param("container", ""). // Leave empty to use the first container
+		Param("container", container).
 		Param("stdout", "true").
 		Param("stderr", "true").
 		Param("tty", "false")
-
-	// Add command params
+	if stdin != nil {
+		req.Param("stdin", "true")
+	}
 	for _, cmd := range command {
 		req.Param("command", cmd)
 	}
 
 	exec, err := remotecommand.NewSPDYExecutor(config, "POST", req.URL())
 	if err != nil {
-		logger.Fatalf("Could not initialize command: %v", err)
-		connectivityChecksTotal.WithLabelValues(namespace, "error", target).Inc()
+		return fmt.Errorf("could not initialize command: %v", err)
 	}
 
-	// Call StreamWithContext
-	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{
-		Stdout: os.Stdout,
-		Stderr: os.Stderr,
+	return exec.StreamWithContext(ctx, remotecommand.StreamOptions{
+		Stdin:  stdin,
+		Stdout: stdout,
+		Stderr: stderr,
 	})
-
-	if err != nil {
-		logger.Fatalf("Connectivity test failed: %v", err)
-		connectivityChecksTotal.WithLabelValues(namespace, "failed", target).Inc()
-	} else {
-		logger.Printf("Connectivity test succeeded\n")
-		connectivityChecksTotal.WithLabelValues(namespace, "success", target).Inc()
-	}
 }
 
 // checkResourceUsage checks the resource usage in a namespace
@@ -974,7 +1276,14 @@ func checkResourceUsage(ctx context.Context, namespace string, threshold int) {
 		logger.Fatalf("Error listing pods: %v", err)
 	}
 
-	if len(pods.Items) == 0 {
+	reportResourceUsage(namespace, pods.Items)
+}
+
+// reportResourceUsage prints the requested resources of pods and updates the resource gauges
+func reportResourceUsage(namespace string, pods []corev1.Pod) {
+	recordResourceMetrics(namespace, pods)
+
+	if len(pods) == 0 {
 		logger.Printf("No pods found in namespace '%s'\n", namespace)
 		return
 	}
@@ -987,50 +1296,46 @@ func checkResourceUsage(ctx context.Context, namespace string, threshold int) {
 	fmt.Printf("%-40s %-10s %-10s %-10s %-10s\n", "POD", "CPU REQ", "CPU LIM", "MEM REQ", "MEM LIM")
 	fmt.Println(strings.Repeat("-", 80))
 
-	for _, pod := range pods.Items {
-		// Calculate total requests and limits for the pod
-		var cpuReq, cpuLim, memReq, memLim string
+	for _, usage := range summarizePodResources(pods) {
+		fmt.Printf("%-40s %-10s %-10s %-10s %-10s\n",
+			usage.Pod, usage.CPURequest, usage.CPULimit, usage.MemoryRequest, usage.MemoryLimit)
+	}
+}
 
-		cpuReq = "0"
-		cpuLim = "0"
-		memReq = "0"
-		memLim = "0"
+// PodResourceUsage holds the requested resources of a pod
+type PodResourceUsage struct {
+	Pod           string `json:"pod"`
+	CPURequest    string `json:"cpuRequest"`
+	CPULimit      string `json:"cpuLimit"`
+	MemoryRequest string `json:"memoryRequest"`
+	MemoryLimit   string `json:"memoryLimit"`
+}
+
+// summarizePodResources returns the requests and limits of each pod
+func summarizePodResources(pods []corev1.Pod) []PodResourceUsage {
+	usages := make([]PodResourceUsage, 0, len(pods))
+	for _, pod := range pods {
+		// Calculate total requests and limits for the pod
+		usage := PodResourceUsage{Pod: pod.Name, CPURequest: "0", CPULimit: "0", MemoryRequest: "0", MemoryLimit: "0"}
 
 		for _, container := range pod.Spec.Containers {
-			if container.Resources.Requests != nil {
-				if cpu, ok := container.Resources.Requests["cpu"]; ok {
-					cpuReq = cpu.String()
-					// Update Prometheus metrics
-					cpuValue := cpu.AsApproximateFloat64()
-					resourceUsage.WithLabelValues(namespace, pod.Name, "cpu_request").Set(cpuValue)
-				}
-				if mem, ok := container.Resources.Requests["memory"]; ok {
-					memReq = mem.String()
-					// Update Prometheus metrics
-					memValue := mem.AsApproximateFloat64()
-					resourceUsage.WithLabelValues(namespace, pod.Name, "memory_request").Set(memValue)
-				}
+			if cpu, ok := container.Resources.Requests["cpu"]; ok {
+				usage.CPURequest = cpu.String()
 			}
-
-			if container.Resources.Limits != nil {
-				if cpu, ok := container.Resources.Limits["cpu"]; ok {
-					cpuLim = cpu.String()
-					// Update Prometheus metrics
-					cpuValue := cpu.AsApproximateFloat64()
-					resourceUsage.WithLabelValues(namespace, pod.Name, "cpu_limit").Set(cpuValue)
-				}
-				if mem, ok := container.Resources.Limits["memory"]; ok {
-					memLim = mem.String()
-					// Update Prometheus metrics
-					memValue := mem.AsApproximateFloat64()
-					resourceUsage.WithLabelValues(namespace, pod.Name, "memory_limit").Set(memValue)
-				}
+			if mem, ok := container.Resources.Requests["memory"]; ok {
+				usage.MemoryRequest = mem.String()
+			}
+			if cpu, ok := container.Resources.Limits["cpu"]; ok {
+				usage.CPULimit = cpu.String()
+			}
+			if mem, ok := container.Resources.Limits["memory"]; ok {
+				usage.MemoryLimit = mem.String()
 			}
 		}
 
-		fmt.Printf("%-40s %-10s %-10s %-10s %-10s\n",
-			pod.Name, cpuReq, cpuLim, memReq, memLim)
+		usages = append(usages, usage)
 	}
+	return usages
 }
 
 // conditionalString returns the first string if condition is true, otherwise the second
@@ -1040,3 +1345,32 @@ func conditionalString(condition bool, trueVal, falseVal string) string {
 	}
 	return falseVal
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// appendUnique appends value to list if it is not already present
+func appendUnique(list []string, value string) []string {
+	if containsString(list, value) {
+		return list
+	}
+	return append(list, value)
+}
+
+// containsString reports whether list contains value
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,239 @@
+// State metrics describing the current health of monitored objects.
+
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+var (
+	podsByHealth = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8stoolbox_pods",
+			Help: "Number of pods by health status at the last check",
+		},
+		[]string{"namespace", "status"},
+	)
+
+	podIssues = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8stoolbox_pod_issues",
+			Help: "Number of pods with a given issue code at the last check",
+		},
+		[]string{"namespace", "code"},
+	)
+
+	workloadReplicas = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8stoolbox_workload_replicas",
+			Help: "Desired, ready and available replicas of deployments, statefulsets and daemonsets",
+		},
+		[]string{"namespace", "kind", "name", "state"},
+	)
+
+	workloadHealthy = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8stoolbox_workload_healthy",
+			Help: "Whether a workload has enough ready replicas (1) or not (0)",
+		},
+		[]string{"namespace", "kind", "name"},
+	)
+
+	nodeCondition = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8stoolbox_node_condition",
+			Help: "Whether a node condition is currently true (1) or not (0)",
+		},
+		[]string{"node", "condition"},
+	)
+
+	lastCheckTimestamp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "k8stoolbox_last_check_timestamp_seconds",
+			Help: "Unix time of the last completed check; node checks use an empty namespace",
+		},
+		[]string{"namespace"},
+	)
+
+	checkDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "k8stoolbox_check_duration_seconds",
+			Help:    "Duration of a monitor check run across all namespaces of a target",
+			Buckets: prometheus.DefBuckets,
+		},
+		[]string{"target"},
+	)
+)
+
+// knownIssueCodes are always exported so alerts can rely on the series existing
+var knownIssueCodes = []string{IssueContainerNotReady, IssueExcessiveRestarts, IssueConditionNotTrue, IssuePodNotRunning,
+	IssueCrashLoopBackOff, IssueOOMKilled}
+
+// nodeConditionNames are the node conditions exported by k8stoolbox_node_condition
+var nodeConditionNames = []string{"Ready", "MemoryPressure", "DiskPressure", "PIDPressure", "NetworkUnavailable", "Unschedulable"}
+
+// labelDeleter is implemented by the prometheus metric vectors
+type labelDeleter interface {
+	DeleteLabelValues(lvs ...string) bool
+	DeletePartialMatch(labels prometheus.Labels) int
+}
+
+// seriesTracker remembers the series written for each owner (usually a namespace)
+// so series of objects that disappeared between two checks can be deleted
+type seriesTracker struct {
+	vec labelDeleter
+
+	mu   sync.Mutex
+	seen map[string]map[string][]string
+}
+
+func newSeriesTracker(vec labelDeleter) *seriesTracker {
+	return &seriesTracker{vec: vec, seen: make(map[string]map[string][]string)}
+}
+
+// sync records the label sets written for owner and deletes the ones missing since the last call
+func (t *seriesTracker) sync(owner string, current [][]string) {
+	next := make(map[string][]string, len(current))
+	for _, lvs := range current {
+		next[strings.Join(lvs, "\x00")] = lvs
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key, lvs := range t.seen[owner] {
+		if _, ok := next[key]; !ok {
+			t.vec.DeleteLabelValues(lvs...)
+		}
+	}
+	t.seen[owner] = next
+}
+
+// forget deletes every series written for owner
+func (t *seriesTracker) forget(owner string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, lvs := range t.seen[owner] {
+		t.vec.DeleteLabelValues(lvs...)
+	}
+	delete(t.seen, owner)
+}
+
+var (
+	resourceUsageSeries    = newSeriesTracker(resourceUsage)
+	workloadReplicaSeries  = newSeriesTracker(workloadReplicas)
+	workloadHealthySeries  = newSeriesTracker(workloadHealthy)
+	nodeConditionSeries    = newSeriesTracker(nodeCondition)
+	monitoredNamespaceVecs = []labelDeleter{podsByHealth, podIssues, lastCheckTimestamp}
+)
+
+// recordCheckMetrics updates the state gauges from a check result of the given target
+func recordCheckMetrics(target MonitorTarget, result HealthCheckResult) {
+	if result.Namespace == "" {
+		if target.Enabled(CheckNodes) {
+			recordNodeMetrics(result.Nodes)
+		}
+		return
+	}
+
+	namespace := result.Namespace
+	if target.Enabled(CheckPods) {
+		podsByHealth.WithLabelValues(namespace, HealthStateHealthy).Set(float64(result.HealthyPods))
+		podsByHealth.WithLabelValues(namespace, HealthStateUnhealthy).Set(float64(result.UnhealthyPods))
+
+		counts := make(map[string]int)
+		for _, pod := range result.PodDetails {
+			for _, code := range pod.Codes {
+				counts[code]++
+			}
+		}
+		for _, code := range knownIssueCodes {
+			podIssues.WithLabelValues(namespace, code).Set(float64(counts[code]))
+		}
+	}
+
+	if target.Enabled(CheckWorkloads) {
+		var replicaSeries, healthySeries [][]string
+		for _, w := range result.Workloads {
+			for state, value := range map[string]int32{"desired": w.Desired, "ready": w.Ready, "available": w.Available} {
+				lvs := []string{namespace, w.Kind, w.Name, state}
+				workloadReplicas.WithLabelValues(lvs...).Set(float64(value))
+				replicaSeries = append(replicaSeries, lvs)
+			}
+			lvs := []string{namespace, w.Kind, w.Name}
+			workloadHealthy.WithLabelValues(lvs...).Set(boolToFloat(len(w.Issues) == 0))
+			healthySeries = append(healthySeries, lvs)
+		}
+		workloadReplicaSeries.sync(namespace, replicaSeries)
+		workloadHealthySeries.sync(namespace, healthySeries)
+	}
+
+	lastCheckTimestamp.WithLabelValues(namespace).Set(float64(result.Timestamp.Unix()))
+}
+
+// recordNodeMetrics exports the conditions of every node and drops series of removed nodes
+func recordNodeMetrics(nodes []NodeHealthStatus) {
+	var series [][]string
+	for _, node := range nodes {
+		for _, condition := range nodeConditionNames {
+			nodeCondition.WithLabelValues(node.Name, condition).Set(boolToFloat(node.Conditions[condition]))
+			series = append(series, []string{node.Name, condition})
+		}
+	}
+	nodeConditionSeries.sync("", series)
+	lastCheckTimestamp.WithLabelValues("").Set(float64(time.Now().Unix()))
+}
+
+// recordResourceMetrics sets the requested and limited resources of pods and drops series of removed pods
+func recordResourceMetrics(namespace string, pods []corev1.Pod) {
+	var series [][]string
+	set := func(pod string, resourceType string, quantity resource.Quantity) {
+		resourceUsage.WithLabelValues(namespace, pod, resourceType).Set(quantity.AsApproximateFloat64())
+		series = append(series, []string{namespace, pod, resourceType})
+	}
+
+	for _, pod := range pods {
+		for _, container := range pod.Spec.Containers {
+			if cpu, ok := container.Resources.Requests["cpu"]; ok {
+				set(pod.Name, "cpu_request", cpu)
+			}
+			if mem, ok := container.Resources.Requests["memory"]; ok {
+				set(pod.Name, "memory_request", mem)
+			}
+			if cpu, ok := container.Resources.Limits["cpu"]; ok {
+				set(pod.Name, "cpu_limit", cpu)
+			}
+			if mem, ok := container.Resources.Limits["memory"]; ok {
+				set(pod.Name, "memory_limit", mem)
+			}
+		}
+	}
+	resourceUsageSeries.sync(namespace, series)
+}
+
+// forgetNamespaceMetrics deletes every series of a namespace that is no longer monitored
+func forgetNamespaceMetrics(namespace string) {
+	for _, vec := range monitoredNamespaceVecs {
+		vec.DeletePartialMatch(prometheus.Labels{"namespace": namespace})
+	}
+	resourceUsageSeries.forget(namespace)
+	workloadReplicaSeries.forget(namespace)
+	workloadHealthySeries.forget(namespace)
+}
+
+// forgetPodMetrics deletes the resource series of a deleted pod
+func forgetPodMetrics(namespace, pod string) {
+	resourceUsage.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "pod": pod})
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
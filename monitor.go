@@ -0,0 +1,566 @@
+// Informer-backed pod health monitoring.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Health states reported in transitions
+const (
+	HealthStateHealthy   = "healthy"
+	HealthStateUnhealthy = "unhealthy"
+	HealthStatePending   = "pending"
+	HealthStateDeleted   = "deleted"
+)
+
+// startupFailureReasons are container waiting reasons that mean a new pod will not become ready on its own
+var startupFailureReasons = map[string]bool{
+	"CrashLoopBackOff":           true,
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+// HealthTransition is emitted when a pod changes between healthy and unhealthy.
+// Newly created pods are pending until they become healthy or fail to start.
+type HealthTransition struct {
+	Namespace string            `json:"namespace"`
+	Pod       string            `json:"pod"`
+	Previous  string            `json:"previous"`
+	Current   string            `json:"current"`
+	Status    string            `json:"status"`
+	Issues    []string          `json:"issues"`
+	Codes     []string          `json:"codes"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// PodMonitor evaluates pod health incrementally from a shared informer cache.
+// The API server is only contacted for the initial list and the watch stream,
+// so the load does not depend on how often results are read.
+type PodMonitor struct {
+	namespace  string
+	thresholds HealthThresholds
+	factories  []informers.SharedInformerFactory
+	lister     corelisters.PodLister
+	synced     []cache.InformerSynced
+
+	// Optional listers, set when the matching checks are enabled
+	deployments  appslisters.DeploymentLister
+	statefulSets appslisters.StatefulSetLister
+	daemonSets   appslisters.DaemonSetLister
+	quotas       corelisters.ResourceQuotaLister
+
+	mu       sync.Mutex
+	states   map[string]string
+	handlers []func(HealthTransition)
+}
+
+// PodMonitorOptions selects what a PodMonitor watches besides pods
+type PodMonitorOptions struct {
+	PodSelector string
+	Thresholds  HealthThresholds
+	Workloads   bool
+	Quotas      bool
+}
+
+// newPodMonitor creates a monitor for the pods of a namespace
+func newPodMonitor(client kubernetes.Interface, namespace string, opts PodMonitorOptions) *PodMonitor {
+	// The pod selector only applies to pods; workloads and quotas share the factory unfiltered
+	podFactory := informers.NewSharedInformerFactoryWithOptions(client, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(o *metav1.ListOptions) { o.LabelSelector = opts.PodSelector }))
+	factory := informers.NewSharedInformerFactoryWithOptions(client, 0, informers.WithNamespace(namespace))
+	podInformer := podFactory.Core().V1().Pods()
+
+	m := &PodMonitor{
+		namespace:  namespace,
+		thresholds: opts.Thresholds,
+		factories:  []informers.SharedInformerFactory{podFactory, factory},
+		lister:     podInformer.Lister(),
+		synced:     []cache.InformerSynced{podInformer.Informer().HasSynced},
+		states:     make(map[string]string),
+	}
+
+	if opts.Workloads {
+		apps := factory.Apps().V1()
+		m.deployments = apps.Deployments().Lister()
+		m.statefulSets = apps.StatefulSets().Lister()
+		m.daemonSets = apps.DaemonSets().Lister()
+		m.synced = append(m.synced,
+			apps.Deployments().Informer().HasSynced,
+			apps.StatefulSets().Informer().HasSynced,
+			apps.DaemonSets().Informer().HasSynced)
+	}
+	if opts.Quotas {
+		quotas := factory.Core().V1().ResourceQuotas()
+		m.quotas = quotas.Lister()
+		m.synced = append(m.synced, quotas.Informer().HasSynced)
+	}
+
+	podInformer.Informer().AddEventHandler(cache.ResourceEventHandlerDetailedFuncs{
+		AddFunc: func(obj interface{}, isInInitialList bool) {
+			if pod, ok := obj.(*corev1.Pod); ok {
+				m.observe(pod, !isInInitialList)
+			}
+		},
+		UpdateFunc: func(_, newObj interface{}) {
+			if pod, ok := newObj.(*corev1.Pod); ok {
+				m.observe(pod, true)
+			}
+		},
+		DeleteFunc: func(obj interface{}) {
+			if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+				obj = tombstone.Obj
+			}
+			if pod, ok := obj.(*corev1.Pod); ok {
+				m.forget(pod)
+			}
+		},
+	})
+
+	return m
+}
+
+// OnTransition registers a handler that is called for every health transition
+func (m *PodMonitor) OnTransition(handler func(HealthTransition)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, handler)
+}
+
+// Start runs the informers and blocks until the cache has synced.
+// The informers stop when ctx is cancelled.
+func (m *PodMonitor) Start(ctx context.Context) error {
+	for _, factory := range m.factories {
+		factory.Start(ctx.Done())
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), m.synced...) {
+		return fmt.Errorf("timed out waiting for pod cache of namespace '%s' to sync", m.namespace)
+	}
+	return nil
+}
+
+// Shutdown waits for the informers to stop after the Start context was cancelled
+func (m *PodMonitor) Shutdown() {
+	for _, factory := range m.factories {
+		factory.Shutdown()
+	}
+}
+
+// Pods returns the cached pods of the namespace sorted by name
+func (m *PodMonitor) Pods() []corev1.Pod {
+	cached, err := m.lister.Pods(m.namespace).List(labels.Everything())
+	if err != nil {
+		logger.Printf("Error reading pod cache: %v\n", err)
+		return nil
+	}
+
+	pods := make([]corev1.Pod, 0, len(cached))
+	for _, pod := range cached {
+		pods = append(pods, *pod)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+	return pods
+}
+
+// Snapshot builds a HealthCheckResult from the cache without calling the API server
+func (m *PodMonitor) Snapshot() HealthCheckResult {
+	return summarizePodHealth(m.namespace, m.Pods(), m.thresholds)
+}
+
+// Workloads evaluates the cached deployments, statefulsets and daemonsets of the namespace
+func (m *PodMonitor) Workloads() []WorkloadHealthStatus {
+	statuses := []WorkloadHealthStatus{}
+	if m.deployments == nil {
+		return statuses
+	}
+
+	deployments, err := m.deployments.Deployments(m.namespace).List(labels.Everything())
+	if err != nil {
+		logger.Printf("Error reading deployment cache: %v\n", err)
+	}
+	for _, d := range deployments {
+		statuses = append(statuses, evaluateDeployment(d, m.thresholds))
+	}
+	statefulSets, err := m.statefulSets.StatefulSets(m.namespace).List(labels.Everything())
+	if err != nil {
+		logger.Printf("Error reading statefulset cache: %v\n", err)
+	}
+	for _, s := range statefulSets {
+		statuses = append(statuses, evaluateStatefulSet(s, m.thresholds))
+	}
+	daemonSets, err := m.daemonSets.DaemonSets(m.namespace).List(labels.Everything())
+	if err != nil {
+		logger.Printf("Error reading daemonset cache: %v\n", err)
+	}
+	for _, d := range daemonSets {
+		statuses = append(statuses, evaluateDaemonSet(d, m.thresholds))
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Kind != statuses[j].Kind {
+			return statuses[i].Kind < statuses[j].Kind
+		}
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Quotas evaluates the cached ResourceQuotas of the namespace
+func (m *PodMonitor) Quotas() []QuotaStatus {
+	if m.quotas == nil {
+		return nil
+	}
+	cached, err := m.quotas.ResourceQuotas(m.namespace).List(labels.Everything())
+	if err != nil {
+		logger.Printf("Error reading resource quota cache: %v\n", err)
+		return nil
+	}
+	quotas := make([]corev1.ResourceQuota, 0, len(cached))
+	for _, quota := range cached {
+		quotas = append(quotas, *quota)
+	}
+	statuses := evaluateQuotas(quotas, m.thresholds)
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Quota != statuses[j].Quota {
+			return statuses[i].Quota < statuses[j].Quota
+		}
+		return statuses[i].Resource < statuses[j].Resource
+	})
+	return statuses
+}
+
+// observe records the health of a pod and emits a transition when it changed.
+// Pods from the initial list only seed the state so startup does not flood handlers.
+func (m *PodMonitor) observe(pod *corev1.Pod, emit bool) {
+	status := evaluatePodHealth(*pod, m.thresholds)
+
+	m.mu.Lock()
+	previous, known := m.states[pod.Name]
+	current := HealthStateUnhealthy
+	switch {
+	case status.Healthy():
+		current = HealthStateHealthy
+	case (!known || previous == HealthStatePending) && isPodStarting(pod):
+		current = HealthStatePending
+	}
+	m.states[pod.Name] = current
+	handlers := m.handlers
+	m.mu.Unlock()
+
+	if !emit || previous == current {
+		return
+	}
+	// Pods that come up cleanly are not worth reporting
+	if current == HealthStatePending || (current == HealthStateHealthy && (!known || previous == HealthStatePending)) {
+		return
+	}
+	if !known {
+		previous = HealthStatePending
+	}
+
+	m.emit(handlers, HealthTransition{
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Previous:  previous,
+		Current:   current,
+		Status:    status.Status,
+		Issues:    status.Issues,
+		Codes:     status.Codes,
+		Labels:    pod.Labels,
+		Timestamp: time.Now(),
+	})
+}
+
+// isPodStarting reports whether a not-yet-healthy pod is still starting up normally
+func isPodStarting(pod *corev1.Pod) bool {
+	if pod.Status.Phase != corev1.PodPending && pod.Status.Phase != corev1.PodRunning {
+		return false
+	}
+	for _, cs := range pod.Status.ContainerStatuses {
+		if cs.RestartCount > 0 {
+			return false
+		}
+		if cs.State.Waiting != nil && startupFailureReasons[cs.State.Waiting.Reason] {
+			return false
+		}
+	}
+	return true
+}
+
+// forget drops a deleted pod, reporting the deletion if the pod was unhealthy
+func (m *PodMonitor) forget(pod *corev1.Pod) {
+	m.mu.Lock()
+	previous, known := m.states[pod.Name]
+	delete(m.states, pod.Name)
+	handlers := m.handlers
+	m.mu.Unlock()
+
+	forgetPodMetrics(pod.Namespace, pod.Name)
+
+	if !known || previous != HealthStateUnhealthy {
+		return
+	}
+
+	m.emit(handlers, HealthTransition{
+		Namespace: pod.Namespace,
+		Pod:       pod.Name,
+		Previous:  previous,
+		Current:   HealthStateDeleted,
+		Status:    string(pod.Status.Phase),
+		Issues:    []string{},
+		Codes:     []string{},
+		Labels:    pod.Labels,
+		Timestamp: time.Now(),
+	})
+}
+
+func (m *PodMonitor) emit(handlers []func(HealthTransition), transition HealthTransition) {
+	for _, handler := range handlers {
+		handler(transition)
+	}
+}
+
+// NodeMonitor keeps a cluster-wide node cache shared by every target that checks nodes
+type NodeMonitor struct {
+	factory informers.SharedInformerFactory
+	lister  corelisters.NodeLister
+	synced  cache.InformerSynced
+}
+
+// newNodeMonitor creates a monitor for the nodes of the cluster
+func newNodeMonitor(client kubernetes.Interface) *NodeMonitor {
+	factory := informers.NewSharedInformerFactory(client, 0)
+	nodeInformer := factory.Core().V1().Nodes()
+	return &NodeMonitor{
+		factory: factory,
+		lister:  nodeInformer.Lister(),
+		synced:  nodeInformer.Informer().HasSynced,
+	}
+}
+
+// Start runs the node informer and blocks until the cache has synced
+func (m *NodeMonitor) Start(ctx context.Context) error {
+	m.factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), m.synced) {
+		return fmt.Errorf("timed out waiting for node cache to sync")
+	}
+	return nil
+}
+
+// Nodes evaluates the cached nodes sorted by name
+func (m *NodeMonitor) Nodes() []NodeHealthStatus {
+	cached, err := m.lister.List(labels.Everything())
+	if err != nil {
+		logger.Printf("Error reading node cache: %v\n", err)
+		return nil
+	}
+	statuses := make([]NodeHealthStatus, 0, len(cached))
+	for _, node := range cached {
+		statuses = append(statuses, evaluateNode(node))
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// targetNamespace is a running PodMonitor of one namespace selected by a target
+type targetNamespace struct {
+	monitor *PodMonitor
+	cancel  context.CancelFunc
+}
+
+// runMonitorTarget runs the checks of a target every interval plus a random jitter until ctx is cancelled.
+// Namespaces chosen by a selector are re-resolved every minute.
+func runMonitorTarget(ctx context.Context, target MonitorTarget, nodes *NodeMonitor, onTransition func(HealthTransition), onCheck func(HealthCheckResult)) {
+	logger.Printf("Starting monitoring target '%s' with interval %v", target.Name, target.Interval.Duration)
+
+	namespaces := make(map[string]*targetNamespace)
+	defer func() {
+		for _, ns := range namespaces {
+			ns.cancel()
+			ns.monitor.Shutdown()
+		}
+	}()
+
+	var lastResolve time.Time
+	resolve := func() {
+		names, err := resolveTargetNamespaces(ctx, target)
+		if err != nil {
+			logger.Printf("Target '%s': %v", target.Name, err)
+			return
+		}
+		lastResolve = time.Now()
+
+		wanted := make(map[string]bool)
+		for _, name := range names {
+			wanted[name] = true
+			if _, ok := namespaces[name]; ok {
+				continue
+			}
+			monitor := newPodMonitor(clientset, name, PodMonitorOptions{
+				PodSelector: target.PodSelector,
+				Thresholds:  target.Thresholds,
+				Workloads:   target.Enabled(CheckWorkloads),
+				Quotas:      target.Enabled(CheckResources),
+			})
+			if target.Enabled(CheckPods) {
+				monitor.OnTransition(onTransition)
+			}
+			nsCtx, cancel := context.WithCancel(ctx)
+			if err := monitor.Start(nsCtx); err != nil {
+				logger.Printf("Target '%s': %v", target.Name, err)
+				cancel()
+				monitor.Shutdown()
+				continue
+			}
+			logger.Printf("Target '%s': monitoring namespace '%s'", target.Name, name)
+			namespaces[name] = &targetNamespace{monitor: monitor, cancel: cancel}
+		}
+		for name, ns := range namespaces {
+			if !wanted[name] {
+				logger.Printf("Target '%s': namespace '%s' no longer selected", target.Name, name)
+				ns.cancel()
+				ns.monitor.Shutdown()
+				delete(namespaces, name)
+				forgetNamespaceMetrics(name)
+			}
+		}
+	}
+	resolve()
+
+	// Probes run on their own schedules; each check reports their latest results
+	var probes *ProbeScheduler
+	if target.Enabled(CheckConnectivity) {
+		probes = newProbeScheduler()
+		go probes.Run(ctx, target.Probes, target.Interval.Duration, target.MaxJitter())
+	}
+
+	timer := time.NewTimer(target.Interval.Duration + jitter(target.MaxJitter()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			if target.NamespaceSelector != "" && time.Since(lastResolve) >= time.Minute {
+				resolve()
+			}
+			start := time.Now()
+			results := runTargetChecks(ctx, target, namespaces, nodes, probes)
+			checkDuration.WithLabelValues(target.Name).Observe(time.Since(start).Seconds())
+			for _, result := range results {
+				recordCheckMetrics(target, result)
+				onCheck(result)
+			}
+			timer.Reset(target.Interval.Duration + jitter(target.MaxJitter()))
+		}
+	}
+}
+
+// runTargetChecks evaluates the enabled checks of a target and returns one result per namespace.
+// Node results are reported with an empty namespace; probe results are grouped by source namespace.
+func runTargetChecks(ctx context.Context, target MonitorTarget, namespaces map[string]*targetNamespace, nodes *NodeMonitor, probes *ProbeScheduler) []HealthCheckResult {
+	ctx, span := startSpan(ctx, "monitor.check",
+		attribute.String("target", target.Name),
+		attribute.Int("namespaces", len(namespaces)))
+	defer span.End()
+
+	results := make(map[string]*HealthCheckResult)
+	result := func(namespace string) *HealthCheckResult {
+		if r, ok := results[namespace]; ok {
+			return r
+		}
+		r := &HealthCheckResult{Namespace: namespace, Target: target.Name, Timestamp: time.Now(), PodDetails: []PodHealthStatus{}}
+		results[namespace] = r
+		return r
+	}
+
+	for name, ns := range namespaces {
+		r := result(name)
+		if target.Enabled(CheckPods) {
+			*r = ns.monitor.Snapshot()
+			r.Target = target.Name
+			recordPodChecks(*r)
+		}
+		if target.Enabled(CheckWorkloads) {
+			r.Workloads = ns.monitor.Workloads()
+		}
+		if target.Enabled(CheckResources) {
+			// Only the gauges: the table of reportResourceUsage would corrupt -output json on stdout
+			recordResourceMetrics(name, ns.monitor.Pods())
+			r.Quotas = ns.monitor.Quotas()
+		}
+		if target.Enabled(CheckCertificates) {
+			checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			certificates, err := checkCertificates(checkCtx, name, target.Thresholds)
+			cancel()
+			if err != nil {
+				logger.Printf("Target '%s': certificate check of namespace '%s' failed: %v", target.Name, name, err)
+			}
+			r.Certificates = certificates
+		}
+	}
+
+	if probes != nil {
+		for namespace, probeResults := range probes.Results() {
+			result(namespace).Probes = probeResults
+		}
+	}
+
+	if target.Enabled(CheckNodes) && nodes != nil {
+		result("").Nodes = nodes.Nodes()
+	}
+
+	sorted := make([]HealthCheckResult, 0, len(results))
+	for _, r := range results {
+		sorted = append(sorted, *r)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Namespace < sorted[j].Namespace })
+	return sorted
+}
+
+// resolveTargetNamespaces returns the namespaces listed or selected by a target
+func resolveTargetNamespaces(ctx context.Context, target MonitorTarget) ([]string, error) {
+	if target.NamespaceSelector == "" {
+		return target.Namespaces, nil
+	}
+	list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{LabelSelector: target.NamespaceSelector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list namespaces matching '%s': %v", target.NamespaceSelector, err)
+	}
+	names := make([]string, 0, len(list.Items))
+	for _, ns := range list.Items {
+		if ns.Status.Phase != corev1.NamespaceTerminating {
+			names = append(names, ns.Name)
+		}
+	}
+	return names, nil
+}
+
+// jitter returns a random delay in [0, max)
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
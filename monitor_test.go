@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// transitionRecorder collects the transitions emitted by a PodMonitor
+type transitionRecorder struct {
+	mu          sync.Mutex
+	transitions []HealthTransition
+}
+
+func (r *transitionRecorder) record(t HealthTransition) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.transitions = append(r.transitions, t)
+}
+
+func (r *transitionRecorder) all() []HealthTransition {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]HealthTransition{}, r.transitions...)
+}
+
+func healthyPod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop", Labels: map[string]string{"app": "web"}},
+		Status: corev1.PodStatus{
+			Phase:             corev1.PodRunning,
+			ContainerStatuses: []corev1.ContainerStatus{{Name: "web", Ready: true}},
+		},
+	}
+}
+
+func startingPod(name string) *corev1.Pod {
+	pod := healthyPod(name)
+	pod.Status.Phase = corev1.PodPending
+	pod.Status.ContainerStatuses[0].Ready = false
+	pod.Status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{Reason: "ContainerCreating"}
+	return pod
+}
+
+func crashingPod(name string) *corev1.Pod {
+	pod := healthyPod(name)
+	pod.Status.ContainerStatuses[0].Ready = false
+	pod.Status.ContainerStatuses[0].RestartCount = 1
+	pod.Status.ContainerStatuses[0].State.Waiting = &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"}
+	return pod
+}
+
+func TestPodMonitorTransitions(t *testing.T) {
+	type step struct {
+		pod    *corev1.Pod
+		emit   bool
+		delete bool
+	}
+	tests := []struct {
+		name     string
+		steps    []step
+		expected [][2]string
+	}{
+		{
+			name:  "initial list only seeds state",
+			steps: []step{{pod: crashingPod("web-1")}},
+		},
+		{
+			name:  "pod starting up cleanly is not reported",
+			steps: []step{{pod: startingPod("web-1"), emit: true}, {pod: healthyPod("web-1"), emit: true}},
+		},
+		{
+			name:     "new pod crashing on startup",
+			steps:    []step{{pod: startingPod("web-1"), emit: true}, {pod: crashingPod("web-1"), emit: true}},
+			expected: [][2]string{{HealthStatePending, HealthStateUnhealthy}},
+		},
+		{
+			name:     "healthy pod breaks and recovers",
+			steps:    []step{{pod: healthyPod("web-1")}, {pod: crashingPod("web-1"), emit: true}, {pod: healthyPod("web-1"), emit: true}},
+			expected: [][2]string{{HealthStateHealthy, HealthStateUnhealthy}, {HealthStateUnhealthy, HealthStateHealthy}},
+		},
+		{
+			name:  "unchanged state is not repeated",
+			steps: []step{{pod: healthyPod("web-1")}, {pod: healthyPod("web-1"), emit: true}},
+		},
+		{
+			name:     "deleting an unhealthy pod is reported",
+			steps:    []step{{pod: crashingPod("web-1")}, {pod: crashingPod("web-1"), delete: true}},
+			expected: [][2]string{{HealthStateUnhealthy, HealthStateDeleted}},
+		},
+		{
+			name:  "deleting a healthy pod is not reported",
+			steps: []step{{pod: healthyPod("web-1")}, {pod: healthyPod("web-1"), delete: true}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newPodMonitor(fake.NewClientset(), "shop", PodMonitorOptions{Thresholds: defaultHealthThresholds})
+			recorder := &transitionRecorder{}
+			m.OnTransition(recorder.record)
+
+			for _, s := range tt.steps {
+				if s.delete {
+					m.forget(s.pod)
+				} else {
+					m.observe(s.pod, s.emit)
+				}
+			}
+
+			got := recorder.all()
+			if len(got) != len(tt.expected) {
+				t.Fatalf("expected %d transitions, got %d: %+v", len(tt.expected), len(got), got)
+			}
+			for i, want := range tt.expected {
+				if got[i].Previous != want[0] || got[i].Current != want[1] {
+					t.Errorf("transition %d: expected %s -> %s, got %s -> %s",
+						i, want[0], want[1], got[i].Previous, got[i].Current)
+				}
+				if got[i].Namespace != "shop" || got[i].Pod != "web-1" || got[i].Labels["app"] != "web" {
+					t.Errorf("transition %d does not identify the pod: %+v", i, got[i])
+				}
+			}
+			if _, known := m.states["web-1"]; known == tt.steps[len(tt.steps)-1].delete {
+				t.Errorf("unexpected state after last step: %v", m.states)
+			}
+		})
+	}
+}
+
+func TestPodMonitorForgetDeletesMetrics(t *testing.T) {
+	resourceUsage.WithLabelValues("shop", "web-1", "cpu").Set(1)
+	resourceUsage.WithLabelValues("shop", "web-1", "memory").Set(1)
+	resourceUsage.WithLabelValues("shop", "web-2", "cpu").Set(1)
+	t.Cleanup(func() { forgetNamespaceMetrics("shop") })
+
+	m := newPodMonitor(fake.NewClientset(), "shop", PodMonitorOptions{Thresholds: defaultHealthThresholds})
+	m.observe(healthyPod("web-1"), false)
+	m.forget(healthyPod("web-1"))
+
+	if count := testutil.CollectAndCount(resourceUsage); count != 1 {
+		t.Errorf("expected only the series of web-2 to remain, got %d series", count)
+	}
+	if value := testutil.ToFloat64(resourceUsage.WithLabelValues("shop", "web-2", "cpu")); value != 1 {
+		t.Errorf("series of another pod was changed: %v", value)
+	}
+}
+
+func TestPodMonitorInformerEvents(t *testing.T) {
+	client := fake.NewClientset(healthyPod("web-1"))
+	m := newPodMonitor(client, "shop", PodMonitorOptions{Thresholds: defaultHealthThresholds})
+	recorder := &transitionRecorder{}
+	m.OnTransition(recorder.record)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer func() {
+		cancel()
+		m.Shutdown()
+	}()
+	if err := m.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pods := client.CoreV1().Pods("shop")
+	if _, err := pods.UpdateStatus(ctx, crashingPod("web-1"), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	waitForTransitions(t, recorder, 1)
+	if err := pods.Delete(ctx, "web-1", metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	got := waitForTransitions(t, recorder, 2)
+
+	expected := [][2]string{{HealthStateHealthy, HealthStateUnhealthy}, {HealthStateUnhealthy, HealthStateDeleted}}
+	for i, want := range expected {
+		if got[i].Previous != want[0] || got[i].Current != want[1] {
+			t.Errorf("transition %d: expected %s -> %s, got %s -> %s",
+				i, want[0], want[1], got[i].Previous, got[i].Current)
+		}
+	}
+	if len(m.Pods()) != 0 {
+		t.Errorf("deleted pod is still cached: %v", m.Pods())
+	}
+}
+
+// waitForTransitions waits until the informer delivered at least n transitions
+func waitForTransitions(t *testing.T, recorder *transitionRecorder, n int) []HealthTransition {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		got := recorder.all()
+		if len(got) >= n {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d transitions, got %d: %+v", n, len(got), got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSummarizePodHealthDoesNotCountChecks(t *testing.T) {
+	before := testutil.ToFloat64(checksTotal.WithLabelValues("scraped", "healthy"))
+	// Exporter scrapes summarize the cached pods on every request
+	for i := 0; i < 3; i++ {
+		summarizePodHealth("scraped", []corev1.Pod{*healthyPod("web-1")}, defaultHealthThresholds)
+	}
+	if after := testutil.ToFloat64(checksTotal.WithLabelValues("scraped", "healthy")); after != before {
+		t.Errorf("summarizing pods changed the checks counter from %v to %v", before, after)
+	}
+}
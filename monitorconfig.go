@@ -0,0 +1,221 @@
+// Monitor configuration: targets, enabled checks and thresholds.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/yaml"
+)
+
+// Checks a monitor target can enable
+const (
+	CheckPods         = "pods"
+	CheckResources    = "resources"
+	CheckWorkloads    = "workloads"
+	CheckNodes        = "nodes"
+	CheckConnectivity = "connectivity"
+	CheckCertificates = "certificates"
+)
+
+var knownChecks = map[string]bool{
+	CheckPods:         true,
+	CheckResources:    true,
+	CheckWorkloads:    true,
+	CheckNodes:        true,
+	CheckConnectivity: true,
+	CheckCertificates: true,
+}
+
+// HealthThresholds are the limits above which a check reports an issue. Configuration files
+// are decoded on top of the defaults, so a threshold can be set to 0.
+type HealthThresholds struct {
+	// RestartCount is the number of container restarts tolerated before a pod is unhealthy
+	RestartCount int32 `json:"restartCount"`
+	// ResourceUsagePercent is the ResourceQuota usage at which a namespace is reported
+	ResourceUsagePercent int `json:"resourceUsagePercent"`
+	// CertificateExpiryDays is how close to expiry a TLS secret may get before it is reported
+	CertificateExpiryDays int `json:"certificateExpiryDays"`
+	// ReplicaAvailabilityPercent is the share of desired workload replicas that must be ready
+	ReplicaAvailabilityPercent int `json:"replicaAvailabilityPercent"`
+	// ProbeLatencySeconds is the p95 connectivity probe duration above which probes are slow
+	ProbeLatencySeconds float64 `json:"probeLatencySeconds"`
+}
+
+var defaultHealthThresholds = HealthThresholds{
+	RestartCount:               5,
+	ResourceUsagePercent:       80,
+	CertificateExpiryDays:      14,
+	ReplicaAvailabilityPercent: 100,
+	ProbeLatencySeconds:        2,
+}
+
+// Validate checks that the thresholds are in range
+func (t HealthThresholds) Validate() error {
+	if t.RestartCount < 0 || t.ResourceUsagePercent < 0 || t.CertificateExpiryDays < 0 || t.ProbeLatencySeconds < 0 {
+		return fmt.Errorf("thresholds must not be negative")
+	}
+	if t.ReplicaAvailabilityPercent < 0 || t.ReplicaAvailabilityPercent > 100 {
+		return fmt.Errorf("replicaAvailabilityPercent must be between 0 and 100")
+	}
+	return nil
+}
+
+// MonitorTarget is a set of namespaces monitored with the same interval, checks and thresholds
+type MonitorTarget struct {
+	Name string `json:"name,omitempty"`
+	// Namespaces lists namespaces explicitly; NamespaceSelector selects them by label
+	Namespaces        []string `json:"namespaces,omitempty"`
+	NamespaceSelector string   `json:"namespaceSelector,omitempty"`
+	// PodSelector restricts pod checks to matching pods
+	PodSelector string          `json:"podSelector,omitempty"`
+	Interval    metav1.Duration `json:"interval,omitempty"`
+	// Jitter is the maximum random delay added to each interval, defaulting to 10% of it
+	Jitter     *metav1.Duration    `json:"jitter,omitempty"`
+	Checks     []string            `json:"checks,omitempty"`
+	Thresholds HealthThresholds    `json:"thresholds,omitempty"`
+	Probes     []ConnectivityProbe `json:"probes,omitempty"`
+}
+
+// UnmarshalJSON decodes the target on top of defaultHealthThresholds, so only the thresholds
+// present in the config replace the defaults. Unknown fields are rejected like in the rest of
+// the config.
+func (t *MonitorTarget) UnmarshalJSON(data []byte) error {
+	type plainTarget MonitorTarget
+	target := plainTarget{Thresholds: defaultHealthThresholds}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&target); err != nil {
+		return err
+	}
+	*t = MonitorTarget(target)
+	return nil
+}
+
+// Enabled reports whether a check is enabled for the target
+func (t MonitorTarget) Enabled(check string) bool {
+	return containsString(t.Checks, check)
+}
+
+// MaxJitter returns the maximum random delay for the target
+func (t MonitorTarget) MaxJitter() time.Duration {
+	if t.Jitter != nil {
+		return t.Jitter.Duration
+	}
+	return t.Interval.Duration / 10
+}
+
+// Validate checks the target and fills in defaults
+func (t *MonitorTarget) Validate() error {
+	if len(t.Namespaces) == 0 && t.NamespaceSelector == "" {
+		return fmt.Errorf("namespaces or namespaceSelector is required")
+	}
+	if len(t.Namespaces) > 0 && t.NamespaceSelector != "" {
+		return fmt.Errorf("namespaces and namespaceSelector are mutually exclusive")
+	}
+	for _, selector := range []string{t.NamespaceSelector, t.PodSelector} {
+		if _, err := labels.Parse(selector); err != nil {
+			return fmt.Errorf("invalid selector %q: %v", selector, err)
+		}
+	}
+	if t.Name == "" {
+		t.Name = t.NamespaceSelector
+		if len(t.Namespaces) > 0 {
+			t.Name = t.Namespaces[0]
+		}
+	}
+	if t.Interval.Duration == 0 {
+		t.Interval.Duration = 30 * time.Second
+	}
+	if t.Interval.Duration < time.Second {
+		return fmt.Errorf("interval must be at least 1s")
+	}
+	if t.Jitter != nil && t.Jitter.Duration < 0 {
+		return fmt.Errorf("jitter must not be negative")
+	}
+	if len(t.Checks) == 0 {
+		t.Checks = []string{CheckPods, CheckResources}
+		if len(t.Probes) > 0 {
+			t.Checks = append(t.Checks, CheckConnectivity)
+		}
+	}
+	for _, check := range t.Checks {
+		if !knownChecks[check] {
+			return fmt.Errorf("unknown check %q", check)
+		}
+	}
+	if t.Enabled(CheckConnectivity) && len(t.Probes) == 0 {
+		return fmt.Errorf("connectivity check requires at least one probe")
+	}
+	if len(t.Probes) > 0 && !t.Enabled(CheckConnectivity) {
+		return fmt.Errorf("probes are only run by the connectivity check, which is not enabled")
+	}
+	for i := range t.Probes {
+		if t.Probes[i].Namespace == "" && len(t.Namespaces) == 1 {
+			t.Probes[i].Namespace = t.Namespaces[0]
+		}
+		if t.Probes[i].Namespace == "" {
+			return fmt.Errorf("probe %d: namespace is required", i)
+		}
+		if err := t.Probes[i].Validate(); err != nil {
+			return fmt.Errorf("probe %d: %v", i, err)
+		}
+	}
+	return t.Thresholds.Validate()
+}
+
+// MonitorConfig lists the targets run concurrently by the monitor command
+type MonitorConfig struct {
+	Targets []MonitorTarget `json:"targets"`
+}
+
+// Validate checks every target and fills in defaults
+func (c *MonitorConfig) Validate() error {
+	if len(c.Targets) == 0 {
+		return fmt.Errorf("at least one target is required")
+	}
+	names := make(map[string]bool)
+	for i := range c.Targets {
+		if err := c.Targets[i].Validate(); err != nil {
+			return fmt.Errorf("target %d: %v", i, err)
+		}
+		if names[c.Targets[i].Name] {
+			return fmt.Errorf("duplicate target name %q", c.Targets[i].Name)
+		}
+		names[c.Targets[i].Name] = true
+	}
+	return nil
+}
+
+// loadMonitorConfig reads a monitor config file (YAML or JSON)
+func loadMonitorConfig(path string) (MonitorConfig, error) {
+	var cfg MonitorConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read monitor config: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse monitor config: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return cfg, fmt.Errorf("invalid monitor config: %v", err)
+	}
+	return cfg, nil
+}
+
+// singleNamespaceMonitorConfig builds the config used when no file is given
+func singleNamespaceMonitorConfig(namespace string, interval time.Duration) (MonitorConfig, error) {
+	cfg := MonitorConfig{Targets: []MonitorTarget{{
+		Namespaces: []string{namespace},
+		Interval:   metav1.Duration{Duration: interval},
+		Jitter:     &metav1.Duration{},
+		Thresholds: defaultHealthThresholds,
+	}}}
+	return cfg, cfg.Validate()
+}
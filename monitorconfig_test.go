@@ -0,0 +1,94 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadMonitorConfigThresholds(t *testing.T) {
+	cfg, err := loadMonitorConfig(writeConfig(t, `
+targets:
+  - name: strict
+    namespaces: [shop]
+    thresholds:
+      restartCount: 0
+      replicaAvailabilityPercent: 50
+  - name: defaults
+    namespaces: [db]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strict := defaultHealthThresholds
+	strict.RestartCount = 0
+	strict.ReplicaAvailabilityPercent = 50
+	if got := cfg.Targets[0].Thresholds; got != strict {
+		t.Errorf("explicit thresholds = %+v, want %+v", got, strict)
+	}
+	if got := cfg.Targets[1].Thresholds; got != defaultHealthThresholds {
+		t.Errorf("default thresholds = %+v, want %+v", got, defaultHealthThresholds)
+	}
+}
+
+func TestLoadMonitorConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"unknown target field", "targets:\n  - namespaces: [shop]\n    treshholds: {}\n", "unknown field"},
+		{"unknown threshold", "targets:\n  - namespaces: [shop]\n    thresholds: {restarts: 3}\n", "unknown field"},
+		{"negative threshold", "targets:\n  - namespaces: [shop]\n    thresholds: {restartCount: -1}\n", "negative"},
+		{"availability above 100", "targets:\n  - namespaces: [shop]\n    thresholds: {replicaAvailabilityPercent: 150}\n", "between 0 and 100"},
+		{"probes without connectivity", "targets:\n  - namespaces: [shop]\n    checks: [pods]\n    probes: [{pod: web-1, to: 'db:5432'}]\n", "not enabled"},
+		{"connectivity without probes", "targets:\n  - namespaces: [shop]\n    checks: [connectivity]\n", "at least one probe"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadMonitorConfig(writeConfig(t, tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("expected an error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestMonitorTargetProbesEnableConnectivity(t *testing.T) {
+	cfg, err := loadMonitorConfig(writeConfig(t, `
+targets:
+  - namespaces: [shop]
+    probes:
+      - pod: web-1
+        to: db:5432
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target := cfg.Targets[0]; !target.Enabled(CheckConnectivity) || !target.Enabled(CheckPods) {
+		t.Errorf("expected the default checks plus connectivity, got %v", target.Checks)
+	}
+}
+
+func TestLoadHealthPolicyKeepsZeroThresholds(t *testing.T) {
+	policy, err := loadHealthPolicy(writeConfig(t, "thresholds:\n  certificateExpiryDays: 0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := defaultHealthThresholds
+	want.CertificateExpiryDays = 0
+	if policy.Thresholds != want {
+		t.Errorf("thresholds = %+v, want %+v", policy.Thresholds, want)
+	}
+}
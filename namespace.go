@@ -0,0 +1,464 @@
+// Diagnosis and safe finalization of namespaces stuck in Terminating.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/discovery"
+)
+
+// apiServicesResource is the aggregated APIs registration, which blocks namespace deletion
+// while one of its services is unavailable
+var apiServicesResource = schema.GroupVersionResource{Group: "apiregistration.k8s.io", Version: "v1", Resource: "apiservices"}
+
+// RemainingObject is an object that still exists in a terminating namespace or of a deleted
+// custom resource definition
+type RemainingObject struct {
+	Resource BackupResource
+	Object   *unstructured.Unstructured
+}
+
+func (o RemainingObject) String() string {
+	if o.Object.GetNamespace() == "" {
+		return o.Resource.GroupResource() + " " + o.Object.GetName()
+	}
+	return o.Resource.GroupResource() + " " + o.Object.GetNamespace() + "/" + o.Object.GetName()
+}
+
+// UnavailableAPIService is an aggregated API the namespace controller cannot discover. Its
+// resources cannot be listed, so namespaces are not finalized until it is back or removed.
+type UnavailableAPIService struct {
+	Name    string
+	Service string
+	Reason  string
+	Message string
+}
+
+// NamespaceDiagnosis explains why a namespace has not been deleted yet
+type NamespaceDiagnosis struct {
+	Namespace  *corev1.Namespace
+	Conditions []corev1.NamespaceCondition
+	Objects    []RemainingObject
+	// FailedGroups are API groups whose resources could not be discovered
+	FailedGroups           map[string]string
+	UnavailableAPIServices []UnavailableAPIService
+}
+
+// Terminating reports whether the namespace is being deleted
+func (d *NamespaceDiagnosis) Terminating() bool {
+	return d.Namespace.DeletionTimestamp != nil
+}
+
+// Finalizers counts the remaining objects per finalizer
+func (d *NamespaceDiagnosis) Finalizers() map[string]int {
+	counts := make(map[string]int)
+	for _, o := range d.Objects {
+		for _, f := range o.Object.GetFinalizers() {
+			counts[f]++
+		}
+	}
+	return counts
+}
+
+// diagnoseNamespace lists every object left in the namespace through discovery, the finalizers
+// holding them and the aggregated APIs blocking the namespace controller
+func diagnoseNamespace(ctx context.Context, name string) (*NamespaceDiagnosis, error) {
+	if dynamicClient == nil {
+		return nil, fmt.Errorf("namespace diagnosis needs a Kubernetes cluster")
+	}
+	ns, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	d := &NamespaceDiagnosis{Namespace: ns, FailedGroups: make(map[string]string)}
+	for _, c := range ns.Status.Conditions {
+		if c.Status == corev1.ConditionTrue {
+			d.Conditions = append(d.Conditions, c)
+		}
+	}
+
+	apiServices, err := dynamicClient.Resource(apiServicesResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		logger.Printf("⚠️ Cannot check APIServices: %v", err)
+	} else {
+		for _, s := range apiServices.Items {
+			if unavailable, ok := unavailableAPIService(&s); ok {
+				d.UnavailableAPIServices = append(d.UnavailableAPIServices, unavailable)
+			}
+		}
+	}
+
+	lists, err := discovery.ServerPreferredNamespacedResources(clientset.Discovery())
+	if err != nil {
+		var failed *discovery.ErrGroupDiscoveryFailed
+		if !errors.As(err, &failed) {
+			return nil, fmt.Errorf("failed to discover API resources: %v", err)
+		}
+		for gv, groupErr := range failed.Groups {
+			d.FailedGroups[gv.String()] = groupErr.Error()
+		}
+	}
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		// Events are served by two groups; the core one lists them all
+		if err != nil || gv.Group == "events.k8s.io" {
+			continue
+		}
+		for _, r := range list.APIResources {
+			if strings.Contains(r.Name, "/") || !hasVerbs(r.Verbs, "list", "delete") {
+				continue
+			}
+			resource := BackupResource{Group: gv.Group, Version: gv.Version, Resource: r.Name, Kind: r.Kind, Namespaced: true}
+			objects, err := dynamicClient.Resource(resource.GroupVersionResource()).Namespace(name).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+					d.FailedGroups[resource.GroupResource()] = err.Error()
+					continue
+				}
+				return nil, fmt.Errorf("failed to list %s: %v", resource.GroupResource(), err)
+			}
+			for i := range objects.Items {
+				d.Objects = append(d.Objects, RemainingObject{Resource: resource, Object: &objects.Items[i]})
+			}
+		}
+	}
+	sort.Slice(d.Objects, func(i, j int) bool { return d.Objects[i].String() < d.Objects[j].String() })
+	return d, nil
+}
+
+// unavailableAPIService reports an APIService whose Available condition is not true
+func unavailableAPIService(obj *unstructured.Unstructured) (UnavailableAPIService, bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, _ := c.(map[string]interface{})
+		if condition["type"] != "Available" {
+			continue
+		}
+		if condition["status"] == "True" {
+			return UnavailableAPIService{}, false
+		}
+		s := UnavailableAPIService{Name: obj.GetName()}
+		s.Reason, _ = condition["reason"].(string)
+		s.Message, _ = condition["message"].(string)
+		if namespace, _, _ := unstructured.NestedString(obj.Object, "spec", "service", "namespace"); namespace != "" {
+			name, _, _ := unstructured.NestedString(obj.Object, "spec", "service", "name")
+			s.Service = namespace + "/" + name
+		}
+		return s, true
+	}
+	return UnavailableAPIService{}, false
+}
+
+// printNamespaceDiagnosis reports what keeps the namespace from being deleted
+func printNamespaceDiagnosis(d *NamespaceDiagnosis) {
+	ns := d.Namespace
+	if !d.Terminating() {
+		logger.Printf("Namespace %s is %s and not being deleted", ns.Name, ns.Status.Phase)
+	} else {
+		logger.Printf("Namespace %s is Terminating since %s (%s ago)", ns.Name, ns.DeletionTimestamp.Format(time.RFC3339),
+			time.Since(ns.DeletionTimestamp.Time).Round(time.Second))
+	}
+	if len(ns.Spec.Finalizers) > 0 {
+		var finalizers []string
+		for _, f := range ns.Spec.Finalizers {
+			finalizers = append(finalizers, string(f))
+		}
+		logger.Printf("Namespace finalizers: %s", strings.Join(finalizers, ", "))
+	}
+	for _, c := range d.Conditions {
+		logger.Printf("⚠️ %s: %s", c.Type, c.Message)
+	}
+
+	if len(d.UnavailableAPIServices) > 0 {
+		logger.Printf("Unavailable APIServices (the namespace controller cannot list their resources):")
+		for _, s := range d.UnavailableAPIServices {
+			logger.Printf("  ⚠️ %s%s: %s %s", s.Name, conditionalString(s.Service == "", "", " (service "+s.Service+")"), s.Reason, s.Message)
+		}
+	}
+	if len(d.FailedGroups) > 0 {
+		var groups []string
+		for group, err := range d.FailedGroups {
+			groups = append(groups, group+": "+err)
+		}
+		sort.Strings(groups)
+		logger.Printf("Resources that could not be checked:")
+		for _, g := range groups {
+			logger.Printf("  ⚠️ %s", g)
+		}
+	}
+
+	if len(d.Objects) == 0 {
+		logger.Printf("✅ No objects remain in namespace %s", ns.Name)
+		return
+	}
+	logger.Printf("Remaining objects (%d):", len(d.Objects))
+	for _, o := range d.Objects {
+		var details []string
+		if o.Object.GetDeletionTimestamp() != nil {
+			details = append(details, "deleting")
+		}
+		if finalizers := o.Object.GetFinalizers(); len(finalizers) > 0 {
+			details = append(details, "finalizers: "+strings.Join(finalizers, ", "))
+		}
+		logger.Printf("  %s%s", o, conditionalString(len(details) == 0, "", " ("+strings.Join(details, "; ")+")"))
+	}
+
+	finalizers := d.Finalizers()
+	if len(finalizers) > 0 {
+		names := make([]string, 0, len(finalizers))
+		for f := range finalizers {
+			names = append(names, f)
+		}
+		sort.Strings(names)
+		logger.Printf("Blocking finalizers (remove them with -remove-finalizers once their controller is gone):")
+		for _, f := range names {
+			logger.Printf("  ⚠️ %s on %d objects", f, finalizers[f])
+		}
+	}
+}
+
+// removeFinalizers removes the given finalizers from the objects holding them. The patch carries
+// the resourceVersion so that objects changed in the meantime are not overwritten.
+func removeFinalizers(ctx context.Context, objects []RemainingObject, remove []string, dryRun bool) (int, error) {
+	removed := 0
+	for _, o := range objects {
+		var kept []string
+		var dropped []string
+		for _, f := range o.Object.GetFinalizers() {
+			if containsString(remove, f) {
+				dropped = append(dropped, f)
+			} else {
+				kept = append(kept, f)
+			}
+		}
+		if len(dropped) == 0 {
+			continue
+		}
+		if dryRun {
+			logger.Printf("Would remove finalizers %s from %s", strings.Join(dropped, ", "), o)
+			removed++
+			continue
+		}
+		patch, err := finalizersPatch(o.Object, kept)
+		if err != nil {
+			return removed, err
+		}
+		_, err = dynamicClient.Resource(o.Resource.GroupVersionResource()).Namespace(o.Object.GetNamespace()).
+			Patch(ctx, o.Object.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+		if apierrors.IsNotFound(err) {
+			logger.Printf("%s is already gone", o)
+			continue
+		}
+		if err != nil {
+			return removed, fmt.Errorf("failed to remove finalizers from %s: %v", o, err)
+		}
+		logger.Printf("✅ Removed finalizers %s from %s", strings.Join(dropped, ", "), o)
+		removed++
+	}
+	return removed, nil
+}
+
+// finalizersPatch builds a merge patch replacing the finalizers of obj
+func finalizersPatch(obj *unstructured.Unstructured, finalizers []string) ([]byte, error) {
+	if finalizers == nil {
+		finalizers = []string{}
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"finalizers":      finalizers,
+			"resourceVersion": obj.GetResourceVersion(),
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode patch: %v", err)
+	}
+	return patch, nil
+}
+
+// forceFinalizeNamespace clears the finalizers of the namespace itself through the finalize
+// subresource, which removes it without waiting for its contents to be deleted
+func forceFinalizeNamespace(ctx context.Context, ns *corev1.Namespace, dryRun bool) error {
+	if dryRun {
+		logger.Printf("Would clear the finalizers of namespace %s", ns.Name)
+		return nil
+	}
+	ns = ns.DeepCopy()
+	ns.Spec.Finalizers = nil
+	if _, err := clientset.CoreV1().Namespaces().Finalize(ctx, ns, metav1.UpdateOptions{}); err != nil {
+		return fmt.Errorf("failed to finalize namespace %s: %v", ns.Name, err)
+	}
+	return nil
+}
+
+// waitForNamespaceDeletion waits until the namespace is gone
+func waitForNamespaceDeletion(ctx context.Context, name string, timeout time.Duration) bool {
+	err := wait.PollUntilContextTimeout(ctx, 2*time.Second, timeout, true, func(ctx context.Context) (bool, error) {
+		_, err := clientset.CoreV1().Namespaces().Get(ctx, name, metav1.GetOptions{})
+		return apierrors.IsNotFound(err), nil
+	})
+	return err == nil
+}
+
+// confirmAction asks on the terminal to type the expected answer before a destructive change
+func confirmAction(prompt, expected string) bool {
+	fmt.Printf("%s Type %q to continue: ", prompt, expected)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	return strings.TrimSpace(answer) == expected
+}
+
+// runNamespaceCommand dispatches the namespace subcommands
+func runNamespaceCommand(ctx context.Context, args []string) {
+	if len(args) < 1 || args[0] != "unstick" {
+		fmt.Println("Usage: k8stoolbox namespace unstick [options] <namespace>")
+		os.Exit(1)
+	}
+	runNamespaceUnstick(ctx, args[1:])
+}
+
+// runNamespaceUnstick diagnoses a namespace stuck in Terminating and applies the requested
+// fixes in order of increasing risk: deleting unavailable APIServices, removing finalizers from
+// remaining objects and, last, forcing the namespace's own finalization
+func runNamespaceUnstick(ctx context.Context, args []string) {
+	cmd := flag.NewFlagSet("namespace unstick", flag.ExitOnError)
+	deleteAPIServices := cmd.String("delete-apiservices", "", "Comma-separated unavailable APIServices to delete")
+	removeList := cmd.String("remove-finalizers", "", "Comma-separated finalizers to remove from the remaining objects")
+	includeResources := cmd.String("resources", "", "Comma-separated resources to remove finalizers from, e.g. widgets.example.com (default: all)")
+	force := cmd.Bool("force-finalize", false, "Clear the namespace's own finalizers even if objects remain")
+	dryRun := cmd.Bool("dry-run", false, "Show what would be changed without changing the cluster")
+	yes := cmd.Bool("yes", false, "Do not ask for confirmation")
+	timeout := cmd.Duration("wait", time.Minute, "How long to wait for the namespace to disappear after the changes")
+	if err := cmd.Parse(args); err != nil {
+		return
+	}
+	if cmd.NArg() != 1 {
+		fmt.Println("Usage: k8stoolbox namespace unstick [options] <namespace>")
+		os.Exit(1)
+	}
+	name := cmd.Arg(0)
+	confirm := func(prompt, expected string) bool {
+		if *dryRun || *yes {
+			return true
+		}
+		return confirmAction(prompt, expected)
+	}
+
+	d, err := diagnoseNamespace(ctx, name)
+	if apierrors.IsNotFound(err) {
+		logger.Printf("✅ Namespace %s does not exist", name)
+		return
+	}
+	if err != nil {
+		logger.Fatalf("Failed to diagnose namespace %s: %v", name, err)
+	}
+	printNamespaceDiagnosis(d)
+	if *deleteAPIServices == "" && *removeList == "" && !*force {
+		return
+	}
+	if !d.Terminating() {
+		logger.Fatalf("Namespace %s is not being deleted; delete it before unsticking it", name)
+	}
+	changed := false
+
+	if names := splitList(*deleteAPIServices); len(names) > 0 {
+		unavailable := make(map[string]bool)
+		for _, s := range d.UnavailableAPIServices {
+			unavailable[s.Name] = true
+		}
+		for _, s := range names {
+			if !unavailable[s] {
+				logger.Fatalf("APIService %s is not unavailable; only unavailable APIServices can be deleted", s)
+			}
+		}
+		if !confirm(fmt.Sprintf("Delete the APIServices %s for the whole cluster?", strings.Join(names, ", ")), "yes") {
+			logger.Fatalf("Aborted")
+		}
+		for _, s := range names {
+			if *dryRun {
+				logger.Printf("Would delete APIService %s", s)
+				continue
+			}
+			if err := dynamicClient.Resource(apiServicesResource).Delete(ctx, s, metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+				logger.Fatalf("Failed to delete APIService %s: %v", s, err)
+			}
+			logger.Printf("✅ Deleted APIService %s", s)
+			changed = true
+		}
+	}
+
+	if remove := splitList(*removeList); len(remove) > 0 {
+		filter := ResourceFilter{Include: splitList(*includeResources)}
+		var objects []RemainingObject
+		for _, o := range d.Objects {
+			if filter.Allows(o.Resource) {
+				objects = append(objects, o)
+			}
+		}
+		prompt := fmt.Sprintf("Remove finalizers %s from objects in namespace %s? Their cleanup will be skipped.", strings.Join(remove, ", "), name)
+		if !confirm(prompt, "yes") {
+			logger.Fatalf("Aborted")
+		}
+		removed, err := removeFinalizers(ctx, objects, remove, *dryRun)
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		if removed == 0 {
+			logger.Printf("⚠️ No remaining object has the finalizers %s", strings.Join(remove, ", "))
+		}
+		changed = changed || (removed > 0 && !*dryRun)
+	}
+
+	if *force {
+		if changed && waitForNamespaceDeletion(ctx, name, *timeout) {
+			logger.Printf("✅ Namespace %s was deleted without forcing", name)
+			return
+		}
+		if !*dryRun {
+			if d, err = diagnoseNamespace(ctx, name); apierrors.IsNotFound(err) {
+				logger.Printf("✅ Namespace %s was deleted without forcing", name)
+				return
+			} else if err != nil {
+				logger.Fatalf("Failed to diagnose namespace %s: %v", name, err)
+			}
+		}
+		if len(d.Objects) > 0 {
+			logger.Printf("⚠️ %d objects remain and will be orphaned in etcd: they reappear if namespace %s is created again", len(d.Objects), name)
+		}
+		if !confirm(fmt.Sprintf("Force finalization of namespace %s?", name), name) {
+			logger.Fatalf("Aborted")
+		}
+		if err := forceFinalizeNamespace(ctx, d.Namespace, *dryRun); err != nil {
+			logger.Fatalf("%v", err)
+		}
+		changed = !*dryRun
+	}
+
+	if *dryRun {
+		logger.Printf("Dry run: no changes were made")
+		return
+	}
+	if changed && waitForNamespaceDeletion(ctx, name, *timeout) {
+		logger.Printf("✅ Namespace %s was deleted", name)
+		return
+	}
+	logger.Printf("⚠️ Namespace %s still exists; run 'k8stoolbox namespace unstick %s' again to see what is left", name, name)
+}
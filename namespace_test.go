@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestDiagnoseNamespaceWithoutCluster(t *testing.T) {
+	previous, previousDynamic := clientset, dynamicClient
+	t.Cleanup(func() { clientset, dynamicClient = previous, previousDynamic })
+	// Offline mode serves saved objects through a typed fake client only
+	clientset = fake.NewClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "shop"}})
+	dynamicClient = nil
+
+	if _, err := diagnoseNamespace(context.Background(), "shop"); err == nil {
+		t.Error("expected an error without a dynamic client")
+	}
+}
+
+func TestRemoveFinalizersReportsGoneObjects(t *testing.T) {
+	previous := dynamicClient
+	t.Cleanup(func() { dynamicClient = previous })
+
+	object := func(name string) *unstructured.Unstructured {
+		obj := &unstructured.Unstructured{}
+		obj.SetAPIVersion("v1")
+		obj.SetKind("ConfigMap")
+		obj.SetNamespace("shop")
+		obj.SetName(name)
+		obj.SetFinalizers([]string{"example.com/cleanup", "example.com/keep"})
+		return obj
+	}
+	present, gone := object("present"), object("gone")
+	dynamicClient = dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), present)
+
+	resource := BackupResource{Version: "v1", Resource: "configmaps", Kind: "ConfigMap", Namespaced: true}
+	objects := []RemainingObject{{Resource: resource, Object: present}, {Resource: resource, Object: gone}}
+	removed, err := removeFinalizers(context.Background(), objects, []string{"example.com/cleanup"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("expected only the present object to be counted, got %d", removed)
+	}
+
+	updated, err := dynamicClient.Resource(resource.GroupVersionResource()).Namespace("shop").Get(context.Background(), "present", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if finalizers := updated.GetFinalizers(); len(finalizers) != 1 || finalizers[0] != "example.com/keep" {
+		t.Errorf("unexpected finalizers %v", finalizers)
+	}
+}
@@ -0,0 +1,542 @@
+// State-change notifications from the monitor to webhooks, chat tools, PagerDuty and email.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// Notification severities, ordered from least to most severe
+const (
+	SeverityInfo     = "info"
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Notification states
+const (
+	NotificationFiring   = "firing"
+	NotificationResolved = "resolved"
+)
+
+const (
+	defaultNotifyTitle = `[{{.State}}] Pod {{.Namespace}}/{{.Pod}} is {{.Current}}`
+	defaultNotifyBody  = `Pod {{.Namespace}}/{{.Pod}} changed from {{.Previous}} to {{.Current}} at {{.Timestamp.Format "2006-01-02T15:04:05Z07:00"}} (Status: {{.Status}}).
+{{- range .Issues}}
+- {{.}}
+{{- end}}`
+	defaultPagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+	notifyQueueSize     = 256
+)
+
+var severityRank = map[string]int{
+	SeverityInfo:     0,
+	SeverityWarning:  1,
+	SeverityCritical: 2,
+}
+
+// NotifierConfig is the notification configuration file format (YAML or JSON)
+type NotifierConfig struct {
+	// Title and Body are Go templates rendered with a Notification
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body,omitempty"`
+	// ResendAfter re-sends still-firing notifications; zero disables reminders
+	ResendAfter metav1.Duration `json:"resendAfter,omitempty"`
+	Sinks       []SinkConfig    `json:"sinks"`
+	// MaintenanceWindows suppress matching notifications on a recurring schedule
+	MaintenanceWindows []MaintenanceWindow `json:"maintenanceWindows,omitempty"`
+}
+
+// SinkConfig configures a single notification destination
+type SinkConfig struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	URL         string            `json:"url,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	MinSeverity string            `json:"minSeverity,omitempty"`
+	ResendAfter *metav1.Duration  `json:"resendAfter,omitempty"`
+	Title       string            `json:"title,omitempty"`
+	Body        string            `json:"body,omitempty"`
+
+	// PagerDuty Events v2
+	RoutingKey string `json:"routingKey,omitempty"`
+
+	// SMTP
+	SMTPHost string   `json:"smtpHost,omitempty"`
+	SMTPPort int      `json:"smtpPort,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+}
+
+// Notification is the data passed to sinks and message templates
+type Notification struct {
+	HealthTransition
+	Key      string `json:"key"`
+	State    string `json:"state"`
+	Severity string `json:"severity"`
+	Reminder bool   `json:"reminder,omitempty"`
+}
+
+// NotificationSink delivers rendered notifications to one destination
+type NotificationSink interface {
+	Send(ctx context.Context, n Notification, title, body string) error
+}
+
+// sinkState tracks what a sink last received for a notification key
+type sinkState struct {
+	state        string
+	lastSent     time.Time
+	notification Notification
+}
+
+// notifierSink wraps a sink with its filters, templates and delivery state
+type notifierSink struct {
+	name        string
+	sink        NotificationSink
+	minSeverity string
+	resendAfter time.Duration
+	title       *template.Template
+	body        *template.Template
+	sent        map[string]*sinkState
+}
+
+// notifyJob is one queued delivery
+type notifyJob struct {
+	sink         *notifierSink
+	notification Notification
+}
+
+// Notifier turns health transitions into deduplicated notifications for its sinks
+type Notifier struct {
+	mu    sync.Mutex
+	sinks []*notifierSink
+	queue chan notifyJob
+	// filters can suppress firing notifications before they reach any sink
+	filters []func(Notification) bool
+}
+
+// loadNotifierConfig reads a notifier configuration file
+func loadNotifierConfig(path string) (NotifierConfig, error) {
+	var cfg NotifierConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read notifier config: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse notifier config %s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+// newNotifier builds a notifier and its sinks from configuration
+func newNotifier(cfg NotifierConfig) (*Notifier, error) {
+	n := &Notifier{queue: make(chan notifyJob, notifyQueueSize)}
+
+	for _, window := range cfg.MaintenanceWindows {
+		if err := window.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	for i, sc := range cfg.Sinks {
+		if sc.Name == "" {
+			sc.Name = fmt.Sprintf("%s-%d", sc.Type, i)
+		}
+
+		sink, err := newNotificationSink(sc)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %v", sc.Name, err)
+		}
+
+		minSeverity := sc.MinSeverity
+		if minSeverity == "" {
+			minSeverity = SeverityInfo
+		}
+		if _, ok := severityRank[minSeverity]; !ok {
+			return nil, fmt.Errorf("sink %s: unknown severity %q", sc.Name, minSeverity)
+		}
+
+		resendAfter := cfg.ResendAfter.Duration
+		if sc.ResendAfter != nil {
+			resendAfter = sc.ResendAfter.Duration
+		}
+
+		title, err := parseNotifyTemplate(sc.Name+"-title", sc.Title, cfg.Title, defaultNotifyTitle)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %v", sc.Name, err)
+		}
+		body, err := parseNotifyTemplate(sc.Name+"-body", sc.Body, cfg.Body, defaultNotifyBody)
+		if err != nil {
+			return nil, fmt.Errorf("sink %s: %v", sc.Name, err)
+		}
+
+		n.sinks = append(n.sinks, &notifierSink{
+			name:        sc.Name,
+			sink:        sink,
+			minSeverity: minSeverity,
+			resendAfter: resendAfter,
+			title:       title,
+			body:        body,
+			sent:        make(map[string]*sinkState),
+		})
+	}
+
+	return n, nil
+}
+
+// parseNotifyTemplate parses the first non-empty template text
+func parseNotifyTemplate(name string, candidates ...string) (*template.Template, error) {
+	for _, text := range candidates {
+		if text != "" {
+			return template.New(name).Parse(text)
+		}
+	}
+	return nil, fmt.Errorf("no template for %s", name)
+}
+
+// newNotificationSink creates the sink implementation for a sink type
+func newNotificationSink(sc SinkConfig) (NotificationSink, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	switch strings.ToLower(sc.Type) {
+	case "webhook":
+		if sc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		return &webhookSink{client: client, url: sc.URL, headers: sc.Headers}, nil
+	case "slack":
+		if sc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		return &slackSink{client: client, url: sc.URL}, nil
+	case "teams":
+		if sc.URL == "" {
+			return nil, fmt.Errorf("url is required")
+		}
+		return &teamsSink{client: client, url: sc.URL}, nil
+	case "pagerduty":
+		if sc.RoutingKey == "" {
+			return nil, fmt.Errorf("routingKey is required")
+		}
+		url := sc.URL
+		if url == "" {
+			url = defaultPagerDutyURL
+		}
+		return &pagerDutySink{client: client, url: url, routingKey: sc.RoutingKey}, nil
+	case "smtp", "email":
+		if sc.SMTPHost == "" || sc.From == "" || len(sc.To) == 0 {
+			return nil, fmt.Errorf("smtpHost, from and to are required")
+		}
+		port := sc.SMTPPort
+		if port == 0 {
+			port = 587
+		}
+		return &smtpSink{host: sc.SMTPHost, port: port, username: sc.Username, password: sc.Password, from: sc.From, to: sc.To}, nil
+	default:
+		return nil, fmt.Errorf("unknown sink type %q", sc.Type)
+	}
+}
+
+// AddFilter registers a filter; firing notifications for which it returns false are dropped
+func (n *Notifier) AddFilter(filter func(Notification) bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.filters = append(n.filters, filter)
+}
+
+// Start delivers queued notifications until the context is canceled
+func (n *Notifier) Start(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-n.queue:
+			n.deliver(ctx, job)
+		}
+	}
+}
+
+// Notify converts a health transition into a notification and queues it for matching sinks
+func (n *Notifier) Notify(t HealthTransition) {
+	notification := Notification{
+		HealthTransition: t,
+		Key:              fmt.Sprintf("%s/%s", t.Namespace, t.Pod),
+		State:            NotificationFiring,
+		Severity:         transitionSeverity(t),
+	}
+	// A deleted pod cannot recover, so its deletion closes whatever fired for it
+	if t.Current == HealthStateHealthy || t.Current == HealthStateDeleted {
+		notification.State = NotificationResolved
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// Resolutions always pass so sinks that saw the firing notification can close it
+	if notification.State == NotificationFiring && !n.allowed(notification) {
+		return
+	}
+
+	now := time.Now()
+	for _, s := range n.sinks {
+		state := s.sent[notification.Key]
+
+		if notification.State == NotificationResolved {
+			// Only resolve what this sink was told about; resolved keys are forgotten so the
+			// state does not grow with every pod that ever fired
+			delete(s.sent, notification.Key)
+			if state == nil || state.state != NotificationFiring {
+				continue
+			}
+			n.enqueue(notifyJob{sink: s, notification: notification})
+			continue
+		}
+
+		if severityRank[notification.Severity] < severityRank[s.minSeverity] {
+			continue
+		}
+		// Deduplicate repeated firing notifications until the resend interval passes
+		if state != nil && state.state == NotificationFiring &&
+			(s.resendAfter == 0 || now.Sub(state.lastSent) < s.resendAfter) {
+			state.notification = notification
+			continue
+		}
+
+		s.sent[notification.Key] = &sinkState{state: notification.State, lastSent: now, notification: notification}
+		n.enqueue(notifyJob{sink: s, notification: notification})
+	}
+}
+
+// ResendDue re-sends firing notifications whose resend interval has elapsed. Reminders pass
+// the same filters as new notifications, so silences also hold back alerts already firing.
+func (n *Notifier) ResendDue(now time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for _, s := range n.sinks {
+		if s.resendAfter == 0 {
+			continue
+		}
+		for _, state := range s.sent {
+			if state.state != NotificationFiring || now.Sub(state.lastSent) < s.resendAfter {
+				continue
+			}
+			// A suppressed reminder counts as sent, so it is not re-evaluated on every check
+			state.lastSent = now
+			if !n.allowed(state.notification) {
+				continue
+			}
+			reminder := state.notification
+			reminder.Reminder = true
+			n.enqueue(notifyJob{sink: s, notification: reminder})
+		}
+	}
+}
+
+// allowed reports whether every filter lets a firing notification through; n.mu must be held
+func (n *Notifier) allowed(notification Notification) bool {
+	for _, filter := range n.filters {
+		if !filter(notification) {
+			return false
+		}
+	}
+	return true
+}
+
+func (n *Notifier) enqueue(job notifyJob) {
+	select {
+	case n.queue <- job:
+	default:
+		logger.Printf("Notification queue full, dropping notification for %s to sink %s", job.notification.Key, job.sink.name)
+	}
+}
+
+func (n *Notifier) deliver(ctx context.Context, job notifyJob) {
+	var title, body bytes.Buffer
+	if err := job.sink.title.Execute(&title, job.notification); err != nil {
+		logger.Printf("Failed to render notification title for sink %s: %v", job.sink.name, err)
+		return
+	}
+	if err := job.sink.body.Execute(&body, job.notification); err != nil {
+		logger.Printf("Failed to render notification body for sink %s: %v", job.sink.name, err)
+		return
+	}
+
+	if err := job.sink.sink.Send(ctx, job.notification, title.String(), body.String()); err != nil {
+		logger.Printf("Failed to send notification for %s to sink %s: %v", job.notification.Key, job.sink.name, err)
+	}
+}
+
+// issueSeverity rates issue codes: pods that are down are critical, degraded pods are a warning
+var issueSeverity = map[string]string{
+	IssueContainerNotReady: SeverityWarning,
+	IssueExcessiveRestarts: SeverityWarning,
+	IssueConditionNotTrue:  SeverityWarning,
+	IssueCrashLoopBackOff:  SeverityCritical,
+	IssueOOMKilled:         SeverityCritical,
+	IssuePodNotRunning:     SeverityCritical,
+}
+
+// transitionSeverity maps a health transition to the highest severity of its issue codes.
+// Unhealthy pods are at least a warning, also when none of their codes is known.
+func transitionSeverity(t HealthTransition) string {
+	if t.Current != HealthStateUnhealthy {
+		return SeverityInfo
+	}
+	severity := SeverityWarning
+	for _, code := range t.Codes {
+		if s, ok := issueSeverity[code]; ok && severityRank[s] > severityRank[severity] {
+			severity = s
+		}
+	}
+	return severity
+}
+
+// postJSON sends a JSON payload and treats any non-2xx status as an error
+func postJSON(ctx context.Context, client *http.Client, url string, headers map[string]string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected response status %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookSink posts the full notification as generic JSON
+type webhookSink struct {
+	client  *http.Client
+	url     string
+	headers map[string]string
+}
+
+func (s *webhookSink) Send(ctx context.Context, n Notification, title, body string) error {
+	return postJSON(ctx, s.client, s.url, s.headers, struct {
+		Notification
+		Title   string `json:"title"`
+		Message string `json:"message"`
+	}{n, title, body})
+}
+
+// slackSink posts a Slack-compatible incoming webhook payload
+type slackSink struct {
+	client *http.Client
+	url    string
+}
+
+func (s *slackSink) Send(ctx context.Context, n Notification, title, body string) error {
+	return postJSON(ctx, s.client, s.url, nil, map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", title, body),
+	})
+}
+
+// teamsSink posts a Microsoft Teams MessageCard
+type teamsSink struct {
+	client *http.Client
+	url    string
+}
+
+func (s *teamsSink) Send(ctx context.Context, n Notification, title, body string) error {
+	color := map[string]string{SeverityCritical: "D13438", SeverityWarning: "FFB900", SeverityInfo: "2EB886"}[n.Severity]
+	if n.State == NotificationResolved {
+		color = "2EB886"
+	}
+	return postJSON(ctx, s.client, s.url, nil, map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "http://schema.org/extensions",
+		"summary":    title,
+		"title":      title,
+		"themeColor": color,
+		"text":       strings.ReplaceAll(body, "\n", "<br>"),
+	})
+}
+
+// pagerDutySink sends PagerDuty Events API v2 trigger and resolve events
+type pagerDutySink struct {
+	client     *http.Client
+	url        string
+	routingKey string
+}
+
+func (s *pagerDutySink) Send(ctx context.Context, n Notification, title, body string) error {
+	event := map[string]interface{}{
+		"routing_key":  s.routingKey,
+		"event_action": conditionalString(n.State == NotificationResolved, "resolve", "trigger"),
+		"dedup_key":    "k8stoolbox/" + n.Key,
+	}
+	if n.State != NotificationResolved {
+		event["payload"] = map[string]interface{}{
+			"summary":   title,
+			"source":    n.Key,
+			"severity":  n.Severity,
+			"timestamp": n.Timestamp.Format(time.RFC3339),
+			"component": n.Pod,
+			"group":     n.Namespace,
+			"custom_details": map[string]interface{}{
+				"status":  n.Status,
+				"issues":  n.Issues,
+				"message": body,
+			},
+		}
+	}
+	return postJSON(ctx, s.client, s.url, nil, event)
+}
+
+// smtpSink sends plain-text email
+type smtpSink struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (s *smtpSink) Send(ctx context.Context, n Notification, title, body string) error {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", title)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if s.username != "" {
+		auth = smtp.PlainAuth("", s.username, s.password, s.host)
+	}
+	return smtp.SendMail(fmt.Sprintf("%s:%d", s.host, s.port), auth, s.from, s.to, msg.Bytes())
+}
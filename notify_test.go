@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordingServer is an httptest stand-in for a notification endpoint
+type recordingServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	payloads []map[string]interface{}
+}
+
+func newRecordingServer(t *testing.T) *recordingServer {
+	r := &recordingServer{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		data, _ := io.ReadAll(req.Body)
+		var payload map[string]interface{}
+		if err := json.Unmarshal(data, &payload); err != nil {
+			t.Errorf("payload is not JSON: %v", err)
+		}
+		r.mu.Lock()
+		r.payloads = append(r.payloads, payload)
+		r.mu.Unlock()
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *recordingServer) Payloads() []map[string]interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]map[string]interface{}{}, r.payloads...)
+}
+
+// deliverQueued sends every queued notification and returns how many were sent
+func deliverQueued(n *Notifier) int {
+	count := 0
+	for {
+		select {
+		case job := <-n.queue:
+			n.deliver(context.Background(), job)
+			count++
+		default:
+			return count
+		}
+	}
+}
+
+func transition(current string) HealthTransition {
+	return HealthTransition{
+		Namespace: "shop",
+		Pod:       "web-1",
+		Previous:  HealthStateHealthy,
+		Current:   current,
+		Status:    "CrashLoopBackOff",
+		Issues:    []string{"Container web restarted 5 times", "Container web is in CrashLoopBackOff"},
+		Codes:     []string{IssueExcessiveRestarts, IssueCrashLoopBackOff},
+		Timestamp: time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestNotificationSinkPayloads(t *testing.T) {
+	tests := []struct {
+		sinkType string
+		check    func(t *testing.T, payload map[string]interface{})
+	}{
+		{"webhook", func(t *testing.T, p map[string]interface{}) {
+			if p["key"] != "shop/web-1" || p["state"] != NotificationFiring || p["severity"] != SeverityCritical {
+				t.Errorf("unexpected webhook payload: %v", p)
+			}
+			if p["title"] != "[firing] Pod shop/web-1 is unhealthy" {
+				t.Errorf("unexpected title %q", p["title"])
+			}
+		}},
+		{"slack", func(t *testing.T, p map[string]interface{}) {
+			if text, _ := p["text"].(string); text == "" || text[0] != '*' {
+				t.Errorf("unexpected slack payload: %v", p)
+			}
+		}},
+		{"teams", func(t *testing.T, p map[string]interface{}) {
+			if p["@type"] != "MessageCard" || p["themeColor"] != "D13438" {
+				t.Errorf("unexpected teams payload: %v", p)
+			}
+		}},
+		{"pagerduty", func(t *testing.T, p map[string]interface{}) {
+			if p["routing_key"] != "key" || p["event_action"] != "trigger" || p["dedup_key"] != "k8stoolbox/shop/web-1" {
+				t.Errorf("unexpected pagerduty event: %v", p)
+			}
+			payload, _ := p["payload"].(map[string]interface{})
+			if payload["severity"] != SeverityCritical || payload["group"] != "shop" {
+				t.Errorf("unexpected pagerduty payload: %v", payload)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sinkType, func(t *testing.T) {
+			server := newRecordingServer(t)
+			n, err := newNotifier(NotifierConfig{Sinks: []SinkConfig{{Type: tt.sinkType, URL: server.URL, RoutingKey: "key"}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			n.Notify(transition(HealthStateUnhealthy))
+			if sent := deliverQueued(n); sent != 1 {
+				t.Fatalf("expected 1 notification, sent %d", sent)
+			}
+			payloads := server.Payloads()
+			if len(payloads) != 1 {
+				t.Fatalf("expected 1 request, got %d", len(payloads))
+			}
+			tt.check(t, payloads[0])
+		})
+	}
+}
+
+func TestNotifierDedupAndResend(t *testing.T) {
+	server := newRecordingServer(t)
+	n, err := newNotifier(NotifierConfig{
+		ResendAfter: metav1.Duration{Duration: time.Hour},
+		Sinks:       []SinkConfig{{Type: "webhook", URL: server.URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Notify(transition(HealthStateUnhealthy))
+	n.Notify(transition(HealthStateUnhealthy))
+	if sent := deliverQueued(n); sent != 1 {
+		t.Fatalf("repeated firing notification was not deduplicated: sent %d", sent)
+	}
+
+	n.ResendDue(time.Now())
+	if sent := deliverQueued(n); sent != 0 {
+		t.Fatalf("reminder sent before the resend interval: sent %d", sent)
+	}
+	n.ResendDue(time.Now().Add(2 * time.Hour))
+	if sent := deliverQueued(n); sent != 1 {
+		t.Fatalf("expected a reminder after the resend interval, sent %d", sent)
+	}
+	if reminder := server.Payloads()[1]; reminder["reminder"] != true {
+		t.Errorf("reminder is not marked: %v", reminder)
+	}
+
+	n.Notify(transition(HealthStateHealthy))
+	if sent := deliverQueued(n); sent != 1 {
+		t.Fatalf("expected a resolution, sent %d", sent)
+	}
+	if resolved := server.Payloads()[2]; resolved["state"] != NotificationResolved {
+		t.Errorf("expected resolved state: %v", resolved)
+	}
+}
+
+func TestNotifierDeletedPodResolves(t *testing.T) {
+	server := newRecordingServer(t)
+	n, err := newNotifier(NotifierConfig{
+		ResendAfter: metav1.Duration{Duration: time.Minute},
+		Sinks:       []SinkConfig{{Type: "pagerduty", URL: server.URL, RoutingKey: "key"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n.Notify(transition(HealthStateUnhealthy))
+	n.Notify(transition(HealthStateDeleted))
+	if sent := deliverQueued(n); sent != 2 {
+		t.Fatalf("expected trigger and resolve, sent %d", sent)
+	}
+	if event := server.Payloads()[1]; event["event_action"] != "resolve" {
+		t.Errorf("deletion did not resolve the incident: %v", event)
+	}
+	if len(n.sinks[0].sent) != 0 {
+		t.Errorf("state of the deleted pod was kept: %v", n.sinks[0].sent)
+	}
+
+	n.ResendDue(time.Now().Add(time.Hour))
+	if sent := deliverQueued(n); sent != 0 {
+		t.Errorf("reminder sent for a deleted pod: sent %d", sent)
+	}
+}
+
+func TestNotifierFiltersSuppressFiring(t *testing.T) {
+	server := newRecordingServer(t)
+	n, err := newNotifier(NotifierConfig{Sinks: []SinkConfig{{Type: "webhook", URL: server.URL}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.AddFilter(func(Notification) bool { return false })
+
+	n.Notify(transition(HealthStateUnhealthy))
+	if sent := deliverQueued(n); sent != 0 {
+		t.Errorf("filtered notification was sent: %d", sent)
+	}
+}
+
+func TestNotifierRemindersHonorFilters(t *testing.T) {
+	server := newRecordingServer(t)
+	n, err := newNotifier(NotifierConfig{
+		ResendAfter: metav1.Duration{Duration: time.Minute},
+		Sinks:       []SinkConfig{{Type: "webhook", URL: server.URL}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	silenced := false
+	n.AddFilter(func(Notification) bool { return !silenced })
+
+	n.Notify(transition(HealthStateUnhealthy))
+	if sent := deliverQueued(n); sent != 1 {
+		t.Fatalf("expected 1 notification, sent %d", sent)
+	}
+
+	silenced = true
+	n.ResendDue(time.Now().Add(time.Hour))
+	if sent := deliverQueued(n); sent != 0 {
+		t.Fatalf("reminder sent during a silence: %d", sent)
+	}
+
+	silenced = false
+	n.ResendDue(time.Now().Add(3 * time.Hour))
+	if sent := deliverQueued(n); sent != 1 {
+		t.Errorf("expected a reminder after the silence, sent %d", sent)
+	}
+}
+
+func TestNotifierMinSeverity(t *testing.T) {
+	servers := make(map[string]*recordingServer)
+	var sinks []SinkConfig
+	for _, severity := range []string{SeverityInfo, SeverityWarning, SeverityCritical} {
+		servers[severity] = newRecordingServer(t)
+		sinks = append(sinks, SinkConfig{Name: severity, Type: "webhook", URL: servers[severity].URL, MinSeverity: severity})
+	}
+	n, err := newNotifier(NotifierConfig{Sinks: sinks})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	notify := func(pod, current string, codes ...string) {
+		tr := transition(current)
+		tr.Pod, tr.Codes = pod, codes
+		n.Notify(tr)
+	}
+	notify("starting", HealthStatePending)
+	notify("restarting", HealthStateUnhealthy, IssueExcessiveRestarts, IssueContainerNotReady)
+	notify("unknown", HealthStateUnhealthy, "SomethingNew")
+	notify("crashing", HealthStateUnhealthy, IssueExcessiveRestarts, IssueCrashLoopBackOff)
+	notify("oom", HealthStateUnhealthy, IssueOOMKilled)
+	notify("stopped", HealthStateUnhealthy, IssuePodNotRunning)
+	deliverQueued(n)
+
+	want := map[string]map[string]string{
+		SeverityInfo: {
+			"shop/starting": SeverityInfo, "shop/restarting": SeverityWarning, "shop/unknown": SeverityWarning,
+			"shop/crashing": SeverityCritical, "shop/oom": SeverityCritical, "shop/stopped": SeverityCritical,
+		},
+		SeverityWarning: {
+			"shop/restarting": SeverityWarning, "shop/unknown": SeverityWarning,
+			"shop/crashing": SeverityCritical, "shop/oom": SeverityCritical, "shop/stopped": SeverityCritical,
+		},
+		SeverityCritical: {"shop/crashing": SeverityCritical, "shop/oom": SeverityCritical, "shop/stopped": SeverityCritical},
+	}
+	for sink, expected := range want {
+		got := make(map[string]string)
+		for _, payload := range servers[sink].Payloads() {
+			got[payload["key"].(string)] = payload["severity"].(string)
+		}
+		if len(got) != len(expected) {
+			t.Errorf("sink %s received %v, want %v", sink, got, expected)
+			continue
+		}
+		for key, severity := range expected {
+			if got[key] != severity {
+				t.Errorf("sink %s: %s has severity %q, want %q", sink, key, got[key], severity)
+			}
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Offline analysis against saved objects, such as a support bundle or kubectl output.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// OfflineStats counts what was loaded from saved objects
+type OfflineStats struct {
+	Files     int
+	Objects   int
+	Skipped   int
+	Duplicate int
+}
+
+// isObjectFile reports whether a file may hold saved objects
+func isObjectFile(name string) bool {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// loadOfflineObjects reads every YAML and JSON file of a directory or tar.gz archive.
+// Files may hold several documents and kubectl-style lists. Files that are not objects,
+// like the health reports of a bundle, and kinds unknown to the client are skipped.
+func loadOfflineObjects(path string) ([]runtime.Object, OfflineStats, error) {
+	var objects []runtime.Object
+	var stats OfflineStats
+	read := func(name string, r io.Reader) error {
+		if !isObjectFile(name) {
+			return nil
+		}
+		stats.Files++
+		objs, skipped, err := decodeObjects(r)
+		if err != nil {
+			return fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		objects = append(objects, objs...)
+		stats.Skipped += skipped
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, stats, fmt.Errorf("failed to read offline data: %v", err)
+	}
+
+	if info.IsDir() {
+		err = filepath.WalkDir(path, func(name string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			return read(name, file)
+		})
+	} else {
+		err = readTarGz(path, read)
+	}
+	if err != nil {
+		return nil, stats, err
+	}
+
+	stats.Objects = len(objects)
+	return objects, stats, nil
+}
+
+// decodeObjects decodes the YAML or JSON documents of a file into typed objects,
+// returning how many documents were skipped
+func decodeObjects(r io.Reader) ([]runtime.Object, int, error) {
+	var objects []runtime.Object
+	skipped := 0
+	decoder := utilyaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var raw interface{}
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				return objects, skipped, nil
+			}
+			return nil, 0, err
+		}
+		doc, ok := raw.(map[string]interface{})
+		if !ok || doc["kind"] == nil {
+			// Not an object, e.g. a health report or bundle manifest
+			continue
+		}
+		objs, n := decodeDocument(doc)
+		objects = append(objects, objs...)
+		skipped += n
+	}
+}
+
+// decodeDocument decodes an object or the items of a list
+func decodeDocument(doc map[string]interface{}) ([]runtime.Object, int) {
+	if strings.HasSuffix(fmt.Sprint(doc["kind"]), "List") {
+		items, _ := doc["items"].([]interface{})
+		var objects []runtime.Object
+		skipped := 0
+		for _, item := range items {
+			if m, ok := item.(map[string]interface{}); ok {
+				objs, n := decodeDocument(m)
+				objects = append(objects, objs...)
+				skipped += n
+			}
+		}
+		return objects, skipped
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, 1
+	}
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(data, nil, nil)
+	if err != nil {
+		// Custom resources and kinds the client does not know
+		return nil, 1
+	}
+	return []runtime.Object{obj}, 0
+}
+
+// newOfflineClientset builds a fake clientset serving the saved objects. Namespaces that
+// are referenced but not saved themselves, as in support bundles, are added.
+func newOfflineClientset(objects []runtime.Object, stats *OfflineStats) (*fake.Clientset, error) {
+	client := fake.NewClientset()
+	namespaces := make(map[string]bool)
+	add := func(obj runtime.Object) error {
+		err := client.Tracker().Add(obj)
+		if apierrors.IsAlreadyExists(err) {
+			stats.Duplicate++
+			return nil
+		}
+		return err
+	}
+
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			continue
+		}
+		if ns, ok := obj.(*corev1.Namespace); ok {
+			namespaces[ns.Name] = true
+		}
+		if err := add(obj); err != nil {
+			return nil, fmt.Errorf("failed to load %s %s: %v", obj.GetObjectKind().GroupVersionKind().Kind, accessor.GetName(), err)
+		}
+	}
+
+	for _, obj := range objects {
+		accessor, err := meta.Accessor(obj)
+		if err != nil || accessor.GetNamespace() == "" || namespaces[accessor.GetNamespace()] {
+			continue
+		}
+		namespaces[accessor.GetNamespace()] = true
+		ns := &corev1.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: accessor.GetNamespace()},
+			Status:     corev1.NamespaceStatus{Phase: corev1.NamespaceActive},
+		}
+		if err := add(ns); err != nil {
+			return nil, fmt.Errorf("failed to add namespace %s: %v", ns.Name, err)
+		}
+	}
+	return client, nil
+}
+
+// initOfflineClient serves the checks from saved objects instead of a cluster.
+// Without a path the clientset is empty.
+func initOfflineClient(path string) error {
+	if path == "" {
+		clientset = fake.NewClientset()
+		return nil
+	}
+
+	objects, stats, err := loadOfflineObjects(path)
+	if err != nil {
+		return err
+	}
+	if stats.Files == 0 {
+		return fmt.Errorf("no YAML or JSON files found in %s", path)
+	}
+	client, err := newOfflineClientset(objects, &stats)
+	if err != nil {
+		return err
+	}
+
+	clientset = client
+	logger.Printf("Loaded %d objects from %d files in %s (%d skipped, %d duplicates)",
+		stats.Objects, stats.Files, path, stats.Skipped, stats.Duplicate)
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const offlineFixtures = "testdata/offline"
+
+// offlineFixturesTarGz packs the fixture directory into a tar.gz archive, like a bundle
+func offlineFixturesTarGz(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "bundle.tar.gz")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	archive := newTarGzWriter(file, time.Now())
+	err = filepath.WalkDir(offlineFixtures, func(name string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(offlineFixtures, name)
+		return archive.WriteFile(filepath.Join("k8stoolbox-bundle", rel), data)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadOfflineObjects(t *testing.T) {
+	tests := []struct {
+		name string
+		path func(t *testing.T) string
+	}{
+		{"directory", func(*testing.T) string { return offlineFixtures }},
+		{"tar.gz", offlineFixturesTarGz},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			objects, stats, err := loadOfflineObjects(tt.path(t))
+			if err != nil {
+				t.Fatal(err)
+			}
+			// 2 pods, 2 deployments from a List and the duplicate pod; the Widget is skipped
+			if stats.Files != 4 || stats.Objects != 5 || stats.Skipped != 1 || len(objects) != 5 {
+				t.Errorf("unexpected stats %+v for %d objects", stats, len(objects))
+			}
+
+			client, err := newOfflineClientset(objects, &stats)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats.Duplicate != 1 {
+				t.Errorf("expected 1 duplicate, got %d", stats.Duplicate)
+			}
+			if _, err := client.CoreV1().Namespaces().Get(context.Background(), "shop", metav1.GetOptions{}); err != nil {
+				t.Errorf("referenced namespace was not added: %v", err)
+			}
+		})
+	}
+}
+
+func TestOfflineChecks(t *testing.T) {
+	previous := clientset
+	t.Cleanup(func() { clientset = previous })
+	if err := initOfflineClient(offlineFixtures); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	healthyChecks := testutil.ToFloat64(checksTotal.WithLabelValues("shop", "healthy"))
+	result := performHealthCheckWithResults(ctx, "shop", false)
+	if result.HealthyPods != 1 || result.UnhealthyPods != 1 {
+		t.Fatalf("expected 1 healthy and 1 unhealthy pod, got %+v", result)
+	}
+	if checks := testutil.ToFloat64(checksTotal.WithLabelValues("shop", "healthy")) - healthyChecks; checks != 1 {
+		t.Errorf("expected the check to count 1 healthy pod, counted %v", checks)
+	}
+	for _, pod := range result.PodDetails {
+		if pod.Name == "worker-1" && !containsString(pod.Codes, IssueExcessiveRestarts) {
+			t.Errorf("crash looping pod is missing the restart issue: %+v", pod)
+		}
+	}
+
+	pods, err := clientset.CoreV1().Pods("shop").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, usage := range summarizePodResources(pods.Items) {
+		if usage.Pod == "web-1" && (usage.CPURequest != "100m" || usage.MemoryLimit != "256Mi") {
+			t.Errorf("unexpected resources of web-1: %+v", usage)
+		}
+	}
+
+	deployments, err := clientset.AppsV1().Deployments("shop").List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments.Items) != 2 {
+		t.Fatalf("expected 2 deployments, got %d", len(deployments.Items))
+	}
+	for i := range deployments.Items {
+		status := evaluateDeployment(&deployments.Items[i], defaultHealthThresholds)
+		if healthy := len(status.Issues) == 0; healthy != (status.Name == "web") {
+			t.Errorf("unexpected health of deployment %s: %+v", status.Name, status)
+		}
+	}
+}
+
+func TestInitOfflineClientRejectsEmptyData(t *testing.T) {
+	previous := clientset
+	t.Cleanup(func() { clientset = previous })
+	if err := initOfflineClient(t.TempDir()); err == nil {
+		t.Error("expected an error for a directory without YAML or JSON files")
+	}
+}
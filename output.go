@@ -0,0 +1,553 @@
+// Monitor output sinks: stdout, rotating NDJSON files, socket line streams and syslog.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/syslog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Monitor event kinds
+const (
+	MonitorEventCheck      = "check"
+	MonitorEventTransition = "transition"
+)
+
+// MonitorEvent is a single monitor result written to the output sinks
+type MonitorEvent struct {
+	Check      *HealthCheckResult
+	Transition *HealthTransition
+}
+
+// Kind returns the event kind
+func (e MonitorEvent) Kind() string {
+	if e.Transition != nil {
+		return MonitorEventTransition
+	}
+	return MonitorEventCheck
+}
+
+// MarshalJSON flattens the event into one object tagged with its kind
+func (e MonitorEvent) MarshalJSON() ([]byte, error) {
+	if e.Transition != nil {
+		return json.Marshal(struct {
+			Event string `json:"event"`
+			*HealthTransition
+		}{MonitorEventTransition, e.Transition})
+	}
+	return json.Marshal(struct {
+		Event string `json:"event"`
+		*HealthCheckResult
+	}{MonitorEventCheck, e.Check})
+}
+
+// OutputSink receives monitor events
+type OutputSink interface {
+	Write(event MonitorEvent) error
+	Close() error
+}
+
+// MonitorOutputs fans monitor events out to several sinks
+type MonitorOutputs struct {
+	mu    sync.Mutex
+	sinks []namedSink
+}
+
+type namedSink struct {
+	spec string
+	sink OutputSink
+}
+
+// parseMonitorOutputs builds sinks from a comma-separated list of output specs:
+//
+//	stdout                         human readable summary (default)
+//	json                           NDJSON on stdout
+//	prometheus                     only update metrics
+//	file:<path>[?maxSizeMB=100&maxAge=24h&maxBackups=5&compress=true]
+//	tcp:<host:port>, unix:<path>   NDJSON line stream
+//	syslog[:<udp|tcp>://<host:port>]
+func parseMonitorOutputs(specs string) (*MonitorOutputs, error) {
+	outputs := &MonitorOutputs{}
+	for _, spec := range splitList(specs) {
+		sink, err := newOutputSink(spec)
+		if err != nil {
+			outputs.Close()
+			return nil, fmt.Errorf("output %q: %v", spec, err)
+		}
+		outputs.sinks = append(outputs.sinks, namedSink{spec: spec, sink: sink})
+	}
+	if len(outputs.sinks) == 0 {
+		outputs.sinks = append(outputs.sinks, namedSink{spec: "stdout", sink: textSink{}})
+	}
+	return outputs, nil
+}
+
+func newOutputSink(spec string) (OutputSink, error) {
+	kind, target, _ := strings.Cut(spec, ":")
+	switch kind {
+	case "stdout":
+		return textSink{}, nil
+	case "json":
+		return &lineSink{w: os.Stdout}, nil
+	case "prometheus":
+		return prometheusSink{}, nil
+	case "file":
+		return newRotatingFileSink(target)
+	case "tcp", "unix":
+		if target == "" {
+			return nil, fmt.Errorf("address is required")
+		}
+		return newAsyncSink(spec, &streamSink{network: kind, address: target}), nil
+	case "syslog":
+		sink, err := newSyslogSink(target)
+		if err != nil {
+			return nil, err
+		}
+		return newAsyncSink(spec, sink), nil
+	default:
+		return nil, fmt.Errorf("unknown output type %q", kind)
+	}
+}
+
+// Emit writes an event to every sink; a failing sink does not affect the others
+func (o *MonitorOutputs) Emit(event MonitorEvent) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, s := range o.sinks {
+		if err := s.sink.Write(event); err != nil {
+			logger.Printf("Failed to write monitor output to %s: %v", s.spec, err)
+		}
+	}
+}
+
+// Close closes every sink
+func (o *MonitorOutputs) Close() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, s := range o.sinks {
+		if err := s.sink.Close(); err != nil {
+			logger.Printf("Failed to close monitor output %s: %v", s.spec, err)
+		}
+	}
+}
+
+// textSink prints the human readable summary used by the default stdout output
+type textSink struct{}
+
+func (textSink) Write(event MonitorEvent) error {
+	if t := event.Transition; t != nil {
+		fmt.Printf("Transition at %s: pod %s/%s %s -> %s (Status: %s)\n",
+			t.Timestamp.Format(time.RFC3339), t.Namespace, t.Pod, t.Previous, t.Current, t.Status)
+		for _, issue := range t.Issues {
+			fmt.Printf("  - %s\n", issue)
+		}
+		return nil
+	}
+	check := event.Check
+	scope := check.Namespace
+	if scope == "" {
+		scope = "cluster"
+	}
+	fmt.Printf("Health check at %s [%s]: %d healthy pods, %d unhealthy pods\n",
+		check.Timestamp.Format(time.RFC3339),
+		scope,
+		check.HealthyPods,
+		check.UnhealthyPods)
+	for _, issue := range check.CheckIssues() {
+		fmt.Printf("  - %s\n", issue)
+	}
+	return nil
+}
+
+func (textSink) Close() error { return nil }
+
+// prometheusSink only acknowledges checks; metrics are updated while evaluating
+type prometheusSink struct{}
+
+func (prometheusSink) Write(event MonitorEvent) error {
+	if event.Check != nil {
+		logger.Println("Metrics updated in Prometheus")
+	}
+	return nil
+}
+
+func (prometheusSink) Close() error { return nil }
+
+// lineSink writes one JSON object per line
+type lineSink struct {
+	w io.Writer
+}
+
+func (s *lineSink) Write(event MonitorEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(data, '\n'))
+	return err
+}
+
+func (s *lineSink) Close() error { return nil }
+
+// asyncSinkBuffer is the number of events a network sink may fall behind before events are dropped
+const asyncSinkBuffer = 1024
+
+// asyncSink writes to a slow sink from its own goroutine so network timeouts never block
+// the monitor. Events are dropped while the buffer is full.
+type asyncSink struct {
+	spec   string
+	sink   OutputSink
+	events chan MonitorEvent
+	done   chan struct{}
+
+	mu      sync.Mutex
+	closed  bool
+	dropped int
+}
+
+func newAsyncSink(spec string, sink OutputSink) *asyncSink {
+	s := &asyncSink{
+		spec:   spec,
+		sink:   sink,
+		events: make(chan MonitorEvent, asyncSinkBuffer),
+		done:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+func (s *asyncSink) run() {
+	defer close(s.done)
+	for event := range s.events {
+		if err := s.sink.Write(event); err != nil {
+			logger.Printf("Failed to write monitor output to %s: %v", s.spec, err)
+		}
+	}
+}
+
+func (s *asyncSink) Write(event MonitorEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return fmt.Errorf("output is closed")
+	}
+	select {
+	case s.events <- event:
+		if s.dropped > 0 {
+			logger.Printf("⚠️ Monitor output %s dropped %d events while it was falling behind", s.spec, s.dropped)
+			s.dropped = 0
+		}
+	default:
+		s.dropped++
+	}
+	return nil
+}
+
+// Close flushes the buffered events and closes the sink
+func (s *asyncSink) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.events)
+	s.mu.Unlock()
+
+	<-s.done
+	return s.sink.Close()
+}
+
+// Reconnect backoff of a streamSink after a failed dial or write
+const (
+	streamMinBackoff = time.Second
+	streamMaxBackoff = time.Minute
+)
+
+// streamSink writes NDJSON to a TCP or Unix socket, reconnecting after failures.
+// While the endpoint is down, reconnects back off and events are dropped.
+type streamSink struct {
+	network string
+	address string
+	conn    net.Conn
+
+	backoff time.Duration
+	retryAt time.Time
+	dropped int
+}
+
+func (s *streamSink) Write(event MonitorEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	if s.conn == nil {
+		if time.Now().Before(s.retryAt) {
+			s.dropped++
+			return nil
+		}
+		conn, err := net.DialTimeout(s.network, s.address, 5*time.Second)
+		if err != nil {
+			s.fail()
+			return fmt.Errorf("%v (retrying in %s)", err, s.backoff)
+		}
+		s.conn = conn
+		if s.dropped > 0 {
+			logger.Printf("Reconnected to %s %s after dropping %d events", s.network, s.address, s.dropped)
+		}
+		s.backoff, s.dropped = 0, 0
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if _, err := s.conn.Write(append(data, '\n')); err != nil {
+		// Drop the connection so a later event reconnects
+		s.conn.Close()
+		s.conn = nil
+		s.fail()
+		return err
+	}
+	return nil
+}
+
+// fail doubles the reconnect backoff
+func (s *streamSink) fail() {
+	s.backoff *= 2
+	if s.backoff < streamMinBackoff {
+		s.backoff = streamMinBackoff
+	}
+	if s.backoff > streamMaxBackoff {
+		s.backoff = streamMaxBackoff
+	}
+	s.retryAt = time.Now().Add(s.backoff)
+}
+
+func (s *streamSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// syslogSink sends each event as a JSON syslog message
+type syslogSink struct {
+	writer *syslog.Writer
+}
+
+func newSyslogSink(target string) (*syslogSink, error) {
+	network, address := "", ""
+	if target != "" {
+		u, err := url.Parse(target)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog address %q, expected udp://host:port or tcp://host:port", target)
+		}
+		network, address = u.Scheme, u.Host
+	}
+
+	writer, err := syslog.Dial(network, address, syslog.LOG_INFO|syslog.LOG_DAEMON, "k8stoolbox")
+	if err != nil {
+		return nil, err
+	}
+	return &syslogSink{writer: writer}, nil
+}
+
+func (s *syslogSink) Write(event MonitorEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if t := event.Transition; t != nil && t.Current != HealthStateHealthy {
+		return s.writer.Warning(string(data))
+	}
+	return s.writer.Info(string(data))
+}
+
+func (s *syslogSink) Close() error {
+	return s.writer.Close()
+}
+
+// rotatingFileSink writes NDJSON to a file and rotates it by size or age
+type rotatingFileSink struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+func newRotatingFileSink(target string) (*rotatingFileSink, error) {
+	path, rawQuery, _ := strings.Cut(target, "?")
+	if path == "" {
+		return nil, fmt.Errorf("file path is required")
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &rotatingFileSink{path: path, maxSize: 100 << 20, maxBackups: 5}
+	if value := query.Get("maxSizeMB"); value != "" {
+		mb, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid maxSizeMB: %v", err)
+		}
+		s.maxSize = int64(mb) << 20
+	}
+	if value := query.Get("maxAge"); value != "" {
+		if s.maxAge, err = time.ParseDuration(value); err != nil {
+			return nil, fmt.Errorf("invalid maxAge: %v", err)
+		}
+	}
+	if value := query.Get("maxBackups"); value != "" {
+		if s.maxBackups, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid maxBackups: %v", err)
+		}
+	}
+	if value := query.Get("compress"); value != "" {
+		if s.compress, err = strconv.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid compress: %v", err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *rotatingFileSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	s.opened = time.Now()
+	return nil
+}
+
+func (s *rotatingFileSink) Write(event MonitorEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if s.needsRotation(int64(len(data))) {
+		if err := s.rotate(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %v", s.path, err)
+		}
+	}
+
+	n, err := s.file.Write(data)
+	s.size += int64(n)
+	return err
+}
+
+func (s *rotatingFileSink) needsRotation(next int64) bool {
+	if s.size == 0 {
+		return false
+	}
+	if s.maxSize > 0 && s.size+next > s.maxSize {
+		return true
+	}
+	return s.maxAge > 0 && time.Since(s.opened) >= s.maxAge
+}
+
+// rotate renames the current file with a timestamp suffix, optionally gzips it,
+// and removes the oldest backups beyond maxBackups
+func (s *rotatingFileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.%s", s.path, time.Now().Format("20060102-150405.000"))
+	if err := os.Rename(s.path, backup); err != nil {
+		return err
+	}
+	if err := s.open(); err != nil {
+		return err
+	}
+
+	if s.compress {
+		if err := gzipFile(backup); err != nil {
+			logger.Printf("Failed to compress %s: %v", backup, err)
+		}
+	}
+	s.pruneBackups()
+	return nil
+}
+
+func (s *rotatingFileSink) pruneBackups() {
+	if s.maxBackups <= 0 {
+		return
+	}
+	backups, err := filepath.Glob(s.path + ".*")
+	if err != nil {
+		return
+	}
+	// Timestamp suffixes sort chronologically
+	sort.Strings(backups)
+	for len(backups) > s.maxBackups {
+		if err := os.Remove(backups[0]); err != nil {
+			logger.Printf("Failed to remove old output file %s: %v", backups[0], err)
+		}
+		backups = backups[1:]
+	}
+}
+
+func (s *rotatingFileSink) Close() error {
+	return s.file.Close()
+}
+
+// gzipFile compresses path to path.gz and removes the original
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+
+	gz := gzip.NewWriter(out)
+	if _, err := io.Copy(gz, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
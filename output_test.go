@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"net"
+	"testing"
+	"time"
+)
+
+// blockingSink blocks every write until it is released
+type blockingSink struct {
+	release chan struct{}
+	written chan MonitorEvent
+}
+
+func (s *blockingSink) Write(event MonitorEvent) error {
+	<-s.release
+	s.written <- event
+	return nil
+}
+
+func (s *blockingSink) Close() error { return nil }
+
+func TestAsyncSinkDoesNotBlockEmit(t *testing.T) {
+	inner := &blockingSink{release: make(chan struct{}), written: make(chan MonitorEvent, 2*asyncSinkBuffer)}
+	outputs := &MonitorOutputs{sinks: []namedSink{{spec: "slow", sink: newAsyncSink("slow", inner)}}}
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 2*asyncSinkBuffer; i++ {
+			outputs.Emit(MonitorEvent{Check: &HealthCheckResult{Namespace: "shop"}})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Emit blocked on a slow sink")
+	}
+
+	close(inner.release)
+	outputs.Close()
+	written := len(inner.written)
+	if written == 0 || written > asyncSinkBuffer+1 {
+		t.Errorf("expected the buffered events to be flushed and the rest dropped, wrote %d", written)
+	}
+}
+
+func TestStreamSinkBacksOffReconnects(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	sink := &streamSink{network: "tcp", address: address}
+	event := MonitorEvent{Check: &HealthCheckResult{Namespace: "shop"}}
+	if err := sink.Write(event); err == nil {
+		t.Fatal("expected a dial error")
+	}
+	if sink.backoff != streamMinBackoff {
+		t.Errorf("expected a backoff of %s, got %s", streamMinBackoff, sink.backoff)
+	}
+	// Within the backoff the event is dropped without dialing
+	if err := sink.Write(event); err != nil || sink.dropped != 1 {
+		t.Errorf("expected the event to be dropped during backoff: err=%v dropped=%d", err, sink.dropped)
+	}
+
+	listener, err = net.Listen("tcp", address)
+	if err != nil {
+		t.Skipf("cannot listen on %s again: %v", address, err)
+	}
+	defer listener.Close()
+	sink.retryAt = time.Time{}
+	if err := sink.Write(event); err != nil {
+		t.Fatalf("expected a reconnect: %v", err)
+	}
+	defer sink.Close()
+	if sink.backoff != 0 || sink.dropped != 0 {
+		t.Errorf("backoff was not reset after reconnecting: %s, %d dropped", sink.backoff, sink.dropped)
+	}
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line == "" {
+		t.Errorf("expected an NDJSON line, got %q: %v", line, err)
+	}
+}
@@ -0,0 +1,62 @@
+// Health policy shared by CLI checks, the monitor, the exporter and generated alerts.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// HealthPolicy holds the thresholds every check evaluates against and the alerting settings
+// derived from them
+type HealthPolicy struct {
+	Thresholds HealthThresholds `json:"thresholds,omitempty"`
+	// AlertFor is how long a condition must hold before a generated alert fires
+	AlertFor metav1.Duration `json:"alertFor,omitempty"`
+	// StaleAfter is how old the last completed check may be before it is alerted on
+	StaleAfter metav1.Duration `json:"staleAfter,omitempty"`
+}
+
+// healthPolicy is the policy in effect, loaded from HEALTH_POLICY when set
+var healthPolicy = HealthPolicy{
+	Thresholds: defaultHealthThresholds,
+	AlertFor:   metav1.Duration{Duration: 5 * time.Minute},
+	StaleAfter: metav1.Duration{Duration: 10 * time.Minute},
+}
+
+// loadHealthPolicy reads a policy file (YAML or JSON), filling unset values from the built-in defaults
+func loadHealthPolicy(path string) (HealthPolicy, error) {
+	// Decoding on top of the defaults keeps thresholds that are explicitly set to 0
+	policy := HealthPolicy{Thresholds: defaultHealthThresholds}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return policy, fmt.Errorf("failed to read health policy: %v", err)
+	}
+	if err := yaml.UnmarshalStrict(data, &policy); err != nil {
+		return policy, fmt.Errorf("failed to parse health policy: %v", err)
+	}
+
+	if policy.AlertFor.Duration == 0 {
+		policy.AlertFor = healthPolicy.AlertFor
+	}
+	if policy.StaleAfter.Duration == 0 {
+		policy.StaleAfter = healthPolicy.StaleAfter
+	}
+	if policy.AlertFor.Duration < 0 || policy.StaleAfter.Duration < 0 {
+		return policy, fmt.Errorf("invalid health policy: durations must not be negative")
+	}
+	if err := policy.Thresholds.Validate(); err != nil {
+		return policy, fmt.Errorf("invalid health policy: %v", err)
+	}
+	return policy, nil
+}
+
+// applyHealthPolicy makes the policy the default for every check that does not set its own thresholds
+func applyHealthPolicy(policy HealthPolicy) {
+	healthPolicy = policy
+	defaultHealthThresholds = policy.Thresholds
+}
@@ -0,0 +1,278 @@
+// Synthetic connectivity probes scheduled by the monitor.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// ConnectivityProbe is a connectivity test run from a pod on its own schedule, e.g.
+//
+//	from: app=frontend
+//	to: svc/payments:8443
+//	every: 60s
+type ConnectivityProbe struct {
+	Name string `json:"name,omitempty"`
+	// Namespace of the source pod, required unless the target lists exactly one namespace
+	Namespace string `json:"namespace,omitempty"`
+	// Pod names the source pod; From selects a ready source pod by label instead
+	Pod  string `json:"pod,omitempty"`
+	From string `json:"from,omitempty"`
+	// To is svc/<name>[.<namespace>]:<port> or <host>:<port>; Target and Port may be used instead
+	To       string `json:"to,omitempty"`
+	Target   string `json:"target,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol,omitempty"`
+	// Every is the probe interval, defaulting to the target interval
+	Every metav1.Duration `json:"every,omitempty"`
+}
+
+// Validate checks the probe definition and fills in defaults
+func (p *ConnectivityProbe) Validate() error {
+	if (p.Pod == "") == (p.From == "") {
+		return fmt.Errorf("exactly one of pod and from is required")
+	}
+	if p.From != "" {
+		if _, err := labels.Parse(p.From); err != nil {
+			return fmt.Errorf("invalid from selector %q: %v", p.From, err)
+		}
+	}
+	if p.To != "" {
+		target, port, err := parseProbeDestination(p.To, p.Namespace)
+		if err != nil {
+			return err
+		}
+		if p.Target != "" && p.Target != target {
+			return fmt.Errorf("to and target are mutually exclusive")
+		}
+		p.Target, p.Port = target, port
+	}
+	if p.Target == "" {
+		return fmt.Errorf("to or target is required")
+	}
+	if p.Protocol == "" {
+		p.Protocol = "tcp"
+	}
+	p.Protocol = strings.ToLower(p.Protocol)
+	if p.Port == 0 && p.Protocol != "icmp" {
+		p.Port = 80
+	}
+	if _, err := connectivityCommand(p.Protocol, p.Target, p.Port); err != nil {
+		return err
+	}
+	if p.Every.Duration < 0 {
+		return fmt.Errorf("every must not be negative")
+	}
+	if p.Name == "" {
+		source := p.Pod
+		if source == "" {
+			source = p.From
+		}
+		p.Name = fmt.Sprintf("%s->%s:%d", source, p.Target, p.Port)
+	}
+	return nil
+}
+
+// parseProbeDestination resolves svc/<name>[.<namespace>]:<port> or <host>:<port>.
+// Services without a namespace are looked up in the namespace of the source pod.
+func parseProbeDestination(to, namespace string) (string, int, error) {
+	host, rawPort, err := net.SplitHostPort(to)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid destination %q: %v", to, err)
+	}
+	port, err := strconv.Atoi(rawPort)
+	if err != nil || port <= 0 || port > 65535 {
+		return "", 0, fmt.Errorf("invalid port in destination %q", to)
+	}
+
+	if service, ok := strings.CutPrefix(host, "svc/"); ok {
+		name, serviceNamespace, _ := strings.Cut(service, ".")
+		if serviceNamespace == "" {
+			serviceNamespace = namespace
+		}
+		if errs := validation.IsDNS1035Label(name); len(errs) > 0 {
+			return "", 0, fmt.Errorf("invalid service name %q: %s", name, strings.Join(errs, ", "))
+		}
+		host = fmt.Sprintf("%s.%s.svc", name, serviceNamespace)
+	}
+	return host, port, nil
+}
+
+// ProbeResult is the outcome of a single connectivity probe
+type ProbeResult struct {
+	Name      string        `json:"name"`
+	Pod       string        `json:"pod"`
+	Target    string        `json:"target"`
+	Protocol  string        `json:"protocol"`
+	Port      int           `json:"port,omitempty"`
+	Success   bool          `json:"success"`
+	Latency   time.Duration `json:"latency"`
+	Error     string        `json:"error,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// ProbeScheduler runs connectivity probes on their own intervals and keeps the latest result of each
+type ProbeScheduler struct {
+	mu      sync.Mutex
+	results map[string]map[string]ProbeResult
+}
+
+func newProbeScheduler() *ProbeScheduler {
+	return &ProbeScheduler{results: make(map[string]map[string]ProbeResult)}
+}
+
+// Run starts one loop per probe and blocks until ctx is cancelled
+func (s *ProbeScheduler) Run(ctx context.Context, probes []ConnectivityProbe, defaultInterval, maxJitter time.Duration) {
+	var wg sync.WaitGroup
+	for _, probe := range probes {
+		interval := probe.Every.Duration
+		if interval == 0 {
+			interval = defaultInterval
+		}
+
+		wg.Add(1)
+		go func(probe ConnectivityProbe) {
+			defer wg.Done()
+			timer := time.NewTimer(jitter(maxJitter))
+			defer timer.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+					probeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+					result := runConnectivityProbe(probeCtx, probe)
+					cancel()
+					if ctx.Err() != nil {
+						return
+					}
+					s.record(probe.Namespace, result)
+					timer.Reset(interval + jitter(maxJitter))
+				}
+			}
+		}(probe)
+	}
+	wg.Wait()
+}
+
+func (s *ProbeScheduler) record(namespace string, result ProbeResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.results[namespace] == nil {
+		s.results[namespace] = make(map[string]ProbeResult)
+	}
+	s.results[namespace][result.Name] = result
+}
+
+// Results returns the latest result of every probe that has run, grouped by namespace
+func (s *ProbeScheduler) Results() map[string][]ProbeResult {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	grouped := make(map[string][]ProbeResult, len(s.results))
+	for namespace, byName := range s.results {
+		for _, result := range byName {
+			grouped[namespace] = append(grouped[namespace], result)
+		}
+		sort.Slice(grouped[namespace], func(i, j int) bool { return grouped[namespace][i].Name < grouped[namespace][j].Name })
+	}
+	return grouped
+}
+
+// runConnectivityProbe executes a probe from its source pod and records the outcome in the metrics
+func runConnectivityProbe(ctx context.Context, probe ConnectivityProbe) (result ProbeResult) {
+	ctx, span := startSpan(ctx, "connectivity.probe",
+		attribute.String("k8s.namespace.name", probe.Namespace),
+		attribute.String("probe", probe.Name),
+		attribute.String("target", probe.Target),
+		attribute.String("protocol", probe.Protocol))
+	defer func() {
+		span.SetAttributes(attribute.String("k8s.pod.name", result.Pod), attribute.Bool("success", result.Success))
+		var err error
+		if result.Error != "" {
+			err = errors.New(result.Error)
+		}
+		endSpan(span, err)
+	}()
+
+	result = ProbeResult{
+		Name:      probe.Name,
+		Pod:       probe.Pod,
+		Target:    probe.Target,
+		Protocol:  probe.Protocol,
+		Port:      probe.Port,
+		Timestamp: time.Now(),
+	}
+
+	if probe.From != "" {
+		pod, err := selectProbeSource(ctx, probe.Namespace, probe.From)
+		if err != nil {
+			result.Error = err.Error()
+			connectivityChecksTotal.WithLabelValues(probe.Namespace, "error", probe.Target).Inc()
+			return result
+		}
+		result.Pod = pod
+	}
+
+	command, err := connectivityCommand(probe.Protocol, probe.Target, probe.Port)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var stderr bytes.Buffer
+	start := time.Now()
+	err = execInPod(ctx, probe.Namespace, result.Pod, "", command, nil, &bytes.Buffer{}, &stderr)
+	result.Latency = time.Since(start)
+
+	status := "success"
+	if err != nil {
+		status = "failed"
+		result.Error = strings.TrimSpace(fmt.Sprintf("%v %s", err, stderr.String()))
+	} else {
+		result.Success = true
+	}
+	connectivityChecksTotal.WithLabelValues(probe.Namespace, status, probe.Target).Inc()
+	connectivityProbeDuration.WithLabelValues(probe.Namespace, probe.Name, status).Observe(result.Latency.Seconds())
+	return result
+}
+
+// selectProbeSource picks a random running and ready pod matching the selector
+func selectProbeSource(ctx context.Context, namespace, selector string) (string, error) {
+	pods, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return "", fmt.Errorf("failed to list source pods: %v", err)
+	}
+
+	var ready []string
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != corev1.PodRunning || pod.DeletionTimestamp != nil {
+			continue
+		}
+		for _, condition := range pod.Status.Conditions {
+			if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
+				ready = append(ready, pod.Name)
+				break
+			}
+		}
+	}
+	if len(ready) == 0 {
+		return "", fmt.Errorf("no ready pod matches '%s' in namespace '%s'", selector, namespace)
+	}
+	return ready[rand.Intn(len(ready))], nil
+}
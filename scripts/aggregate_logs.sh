@@ -1,22 +1,14 @@
 #!/bin/bash
 # aggregate_logs.sh - Collect logs from multiple namespaces and pods for analysis
+# Wrapper around 'k8stoolbox logs aggregate', which collects every container
+# (including previous instances) concurrently. Extra options can be passed via
+# AGGREGATE_OPTS, e.g. AGGREGATE_OPTS="-grep error -ignore-case".
 
 NAMESPACES=${@:-default}
 LOG_DIR="./logs_$(date +%Y%m%d_%H%M%S)"
 
-mkdir -p $LOG_DIR
-
 echo "Aggregating logs for namespaces: $NAMESPACES"
 
-for NAMESPACE in $NAMESPACES
- do
-    echo "Collecting logs from namespace: $NAMESPACE"
-    for POD in $(kubectl get pods -n $NAMESPACE -o jsonpath='{.items[*].metadata.name}')
-    do
-        echo "Collecting logs for pod: $POD"
-        kubectl logs $POD -n $NAMESPACE > $LOG_DIR/${NAMESPACE}_${POD}.log
-    done
-    echo "------------------------------------"
-done
+k8stoolbox logs aggregate -namespaces "$(echo $NAMESPACES | tr ' ' ',')" -output-dir "$LOG_DIR" $AGGREGATE_OPTS || exit 1
 
 echo "Logs aggregated and saved in directory: $LOG_DIR"
@@ -1,52 +1,33 @@
 #!/bin/bash
 # backup_restore.sh - Backup and restore Kubernetes resources
+# Wrapper around 'k8stoolbox backup' and 'k8stoolbox restore', which back up
+# every namespaced resource found through discovery and restore it in
+# dependency order. Extra options can be passed via BACKUP_OPTS, e.g.
+# BACKUP_OPTS="-dry-run" or BACKUP_OPTS="-namespace-mapping default=default-copy".
 
 ACTION=$1
 NAMESPACE=${2:-default}
-BACKUP_DIR="./backup_$NAMESPACE"
+BACKUP_FILE="./backup_$NAMESPACE.tar.gz"
 
 if [ -z "$ACTION" ]; then
     echo "Usage: $0 <backup|restore> [namespace]"
     exit 1
 fi
 
-# Function to backup resources
-backup() {
-    echo "Backing up resources in namespace: $NAMESPACE"
-    mkdir -p $BACKUP_DIR
-
-    for RESOURCE in pods services deployments configmaps secrets
-    do
-        echo "Backing up $RESOURCE..."
-        kubectl get $RESOURCE -n $NAMESPACE -o yaml > $BACKUP_DIR/${RESOURCE}.yaml
-    done
-
-    echo "Backup completed and saved in directory: $BACKUP_DIR"
-}
-
-# Function to restore resources
-restore() {
-    if [ ! -d "$BACKUP_DIR" ]; then
-        echo "Backup directory $BACKUP_DIR does not exist. Please run a backup first."
-        exit 1
-    fi
-
-    echo "Restoring resources in namespace: $NAMESPACE"
-    for FILE in $BACKUP_DIR/*.yaml
-    do
-        echo "Restoring resource from $FILE..."
-        kubectl apply -f $FILE
-    done
-
-    echo "Restore completed."
-}
-
 case $ACTION in
     backup)
-        backup
+        echo "Backing up resources in namespace: $NAMESPACE"
+        k8stoolbox backup -namespaces "$NAMESPACE" -output "$BACKUP_FILE" $BACKUP_OPTS || exit 1
+        echo "Backup completed and saved in: $BACKUP_FILE"
         ;;
     restore)
-        restore
+        if [ ! -f "$BACKUP_FILE" ]; then
+            echo "Backup file $BACKUP_FILE does not exist. Please run a backup first."
+            exit 1
+        fi
+        echo "Restoring resources in namespace: $NAMESPACE"
+        k8stoolbox restore -from "$BACKUP_FILE" -namespaces "$NAMESPACE" $BACKUP_OPTS || exit 1
+        echo "Restore completed."
         ;;
     *)
         echo "Invalid action: $ACTION. Use 'backup' or 'restore'."
@@ -1,35 +1,21 @@
 #!/bin/bash
 # delete_stuck_crds.sh - Delete stuck CRDs in a Kubernetes cluster
+# Wrapper around 'k8stoolbox crd cleanup', which lists the instances of the CRD
+# per namespace with their finalizers and owning controllers, deletes them,
+# removes the finalizers given as the second argument and waits for the CRD to
+# disappear. The CRD's own finalizer is left to the API server so that no
+# instances are orphaned. Extra options can be passed via CLEANUP_OPTS, e.g.
+# CLEANUP_OPTS="-dry-run" or CLEANUP_OPTS="-namespaces team-a".
 
 if [ -z "$1" ]; then
-    echo "Usage: $0 <crd_name>"
+    echo "Usage: $0 <crd_name> [finalizers-to-remove]"
     exit 1
 fi
 
 CRD=$1
+FINALIZERS=$2
 echo "Attempting to delete CRD: $CRD"
 
-# Patch CRD to remove finalizers if it is stuck
-echo "Patching CRD to remove finalizers..."
-kubectl patch crd $CRD -p '{"metadata":{"finalizers":null}}' --type=merge
-
-# Delete all resources associated with the CRD
-echo "Deleting all instances of the CRD: $CRD"
-for RESOURCE in $(kubectl get $CRD --all-namespaces -o jsonpath='{.items[*].metadata.name}')
-do
-    kubectl delete $CRD $RESOURCE --all-namespaces --force --grace-period=0
-    if [ $? -ne 0 ]; then
-        echo "Failed to delete resource: $RESOURCE"
-    else
-        echo "Resource $RESOURCE deleted successfully."
-    fi
-done
-
-# Delete the CRD itself
-echo "Deleting the CRD: $CRD"
-kubectl delete crd $CRD --grace-period=0 --force
-if [ $? -eq 0 ]; then
-    echo "CRD $CRD deleted successfully."
-else
-    echo "Failed to delete CRD $CRD."
-fi
+k8stoolbox crd cleanup -delete-instances -delete-crd \
+    ${FINALIZERS:+-remove-finalizers "$FINALIZERS"} \
+    $CLEANUP_OPTS "$CRD"
@@ -1,22 +1,21 @@
 #!/bin/bash
 # delete_stuck_namespace.sh - Delete stuck namespaces in a Kubernetes cluster
+# Wrapper around 'k8stoolbox namespace unstick', which lists the objects left in
+# the namespace, the finalizers holding them and unavailable APIServices before
+# changing anything. Finalizers given as the second argument are removed from the
+# remaining objects; set FORCE=true to clear the namespace's own finalizers as a
+# last resort. Extra options can be passed via UNSTICK_OPTS, e.g. UNSTICK_OPTS="-dry-run".
 
 if [ -z "$1" ]; then
-    echo "Usage: $0 <namespace>"
+    echo "Usage: $0 <namespace> [finalizers-to-remove]"
     exit 1
 fi
 
 NAMESPACE=$1
-echo "Attempting to delete namespace: $NAMESPACE"
+FINALIZERS=$2
+echo "Diagnosing namespace: $NAMESPACE"
 
-# Remove finalizers to force delete the namespace
-kubectl get namespace $NAMESPACE -o json | jq '.spec = {"finalizers":[]}' | kubectl replace --raw /api/v1/namespaces/$NAMESPACE/finalize -f -
-
-# Delete the namespace
-kubectl delete namespace $NAMESPACE --grace-period=0 --force
-
-if [ $? -eq 0 ]; then
-    echo "Namespace $NAMESPACE deleted successfully."
-else
-    echo "Failed to delete namespace $NAMESPACE."
-fi
+k8stoolbox namespace unstick \
+    ${FINALIZERS:+-remove-finalizers "$FINALIZERS"} \
+    $([ "$FORCE" = "true" ] && echo "-force-finalize") \
+    $UNSTICK_OPTS "$NAMESPACE"
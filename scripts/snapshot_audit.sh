@@ -1,28 +1,25 @@
 #!/bin/bash
 # snapshot_audit.sh - Take a snapshot of the current Kubernetes cluster state for auditing
+# Wrapper around 'k8stoolbox snapshot take', which saves the normalized objects of
+# every resource found through discovery. When a previous snapshot is given, the
+# drift since that snapshot is reported with 'k8stoolbox snapshot diff'.
+# Extra options for the diff can be passed via DIFF_OPTS, e.g. DIFF_OPTS="-output json".
 
-OUTPUT_DIR="./cluster_snapshot_$(date +%Y%m%d_%H%M%S)"
-mkdir -p $OUTPUT_DIR
+PREVIOUS=$1
+NAMESPACES=$2
+SNAPSHOT_FILE="./cluster_snapshot_$(date +%Y%m%d_%H%M%S).tar.gz"
 
-echo "Taking a snapshot of the Kubernetes cluster state..."
-
-# Collect all namespaces
-echo "Collecting namespace data..."
-kubectl get namespaces -o yaml > $OUTPUT_DIR/namespaces.yaml
-
-# Collect all pods, services, deployments, configmaps, etc.
-for RESOURCE in pods services deployments configmaps nodes
-do
-    echo "Collecting data for resource: $RESOURCE"
-    kubectl get $RESOURCE --all-namespaces -o yaml > $OUTPUT_DIR/${RESOURCE}.yaml
-done
+if [ -n "$PREVIOUS" ] && [ ! -f "$PREVIOUS" ]; then
+    echo "Previous snapshot $PREVIOUS does not exist."
+    echo "Usage: $0 [previous-snapshot] [namespaces]"
+    exit 1
+fi
 
-# Collect node resource utilization
-echo "Collecting node resource usage..."
-kubectl top nodes > $OUTPUT_DIR/node_usage.txt
-
-# Collect cluster events
-echo "Collecting cluster events..."
-kubectl get events --all-namespaces > $OUTPUT_DIR/cluster_events.txt
+echo "Taking a snapshot of the Kubernetes cluster state..."
+k8stoolbox snapshot take -output "$SNAPSHOT_FILE" ${NAMESPACES:+-namespaces "$NAMESPACES"} ${PREVIOUS:+-compare-with "$PREVIOUS"} || exit 1
+echo "Cluster state snapshot saved to: $SNAPSHOT_FILE"
 
-echo "Cluster state snapshot saved to: $OUTPUT_DIR"
+if [ -n "$PREVIOUS" ]; then
+    echo "Comparing with $PREVIOUS..."
+    k8stoolbox snapshot diff $DIFF_OPTS "$PREVIOUS" "$SNAPSHOT_FILE"
+fi
@@ -0,0 +1,520 @@
+// Alert silences and recurring maintenance windows for monitor notifications.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	// The container image ships without zoneinfo; embed it for window timezones
+	_ "time/tzdata"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/util/retry"
+)
+
+const (
+	// silencesConfigMap stores silences so they survive restarts
+	silencesConfigMap = "k8stoolbox-silences"
+	silencesDataKey   = "silences.json"
+	// silenceRefreshInterval bounds how often the monitor re-reads the silences ConfigMap
+	silenceRefreshInterval = 30 * time.Second
+)
+
+// AlertMatcher selects notifications by namespace, pod labels and issue code.
+// Empty fields match everything.
+type AlertMatcher struct {
+	Namespace   string `json:"namespace,omitempty"`
+	PodSelector string `json:"podSelector,omitempty"`
+	IssueCode   string `json:"issueCode,omitempty"`
+}
+
+// Validate checks that the pod selector parses
+func (m AlertMatcher) Validate() error {
+	if m.PodSelector == "" {
+		return nil
+	}
+	if _, err := labels.Parse(m.PodSelector); err != nil {
+		return fmt.Errorf("invalid pod selector %q: %v", m.PodSelector, err)
+	}
+	return nil
+}
+
+// Matches reports whether a notification is selected by the matcher
+func (m AlertMatcher) Matches(n Notification) bool {
+	if m.Namespace != "" && m.Namespace != n.Namespace {
+		return false
+	}
+	if m.PodSelector != "" {
+		selector, err := labels.Parse(m.PodSelector)
+		if err != nil || !selector.Matches(labels.Set(n.Labels)) {
+			return false
+		}
+	}
+	if m.IssueCode != "" && !containsString(n.Codes, m.IssueCode) {
+		return false
+	}
+	return true
+}
+
+// Silence suppresses matching notifications until it expires
+type Silence struct {
+	ID string `json:"id"`
+	AlertMatcher
+	StartsAt  time.Time `json:"startsAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Author    string    `json:"author"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Active reports whether the silence is in effect at the given time
+func (s Silence) Active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.ExpiresAt)
+}
+
+// MaintenanceWindow is a recurring period during which matching notifications are suppressed
+type MaintenanceWindow struct {
+	Name string `json:"name"`
+	AlertMatcher
+	// Days lists weekdays (Mon, Tue, ...); empty means every day
+	Days []string `json:"days,omitempty"`
+	// Start is the local start time as HH:MM
+	Start    string          `json:"start"`
+	Duration metav1.Duration `json:"duration"`
+	Timezone string          `json:"timezone,omitempty"`
+}
+
+// Validate checks the window's schedule fields
+func (w MaintenanceWindow) Validate() error {
+	if _, err := time.Parse("15:04", w.Start); err != nil {
+		return fmt.Errorf("maintenance window %s: invalid start %q, expected HH:MM", w.Name, w.Start)
+	}
+	if w.Duration.Duration <= 0 {
+		return fmt.Errorf("maintenance window %s: duration must be positive", w.Name)
+	}
+	if _, err := time.LoadLocation(w.Timezone); err != nil {
+		return fmt.Errorf("maintenance window %s: %v", w.Name, err)
+	}
+	for _, day := range w.Days {
+		if _, ok := parseWeekday(day); !ok {
+			return fmt.Errorf("maintenance window %s: unknown day %q", w.Name, day)
+		}
+	}
+	return w.AlertMatcher.Validate()
+}
+
+// Active reports whether the window covers the given time. Windows that
+// cross midnight are attributed to the day on which they start.
+func (w MaintenanceWindow) Active(now time.Time) bool {
+	loc, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return false
+	}
+	start, err := time.Parse("15:04", w.Start)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(loc)
+	// Check the window starting today and any still running from previous days
+	for offset := 0; offset <= int(w.Duration.Hours()/24)+1; offset++ {
+		day := local.AddDate(0, 0, -offset)
+		begin := time.Date(day.Year(), day.Month(), day.Day(), start.Hour(), start.Minute(), 0, 0, loc)
+		if !w.onDay(begin.Weekday()) {
+			continue
+		}
+		if !local.Before(begin) && local.Before(begin.Add(w.Duration.Duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w MaintenanceWindow) onDay(weekday time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, day := range w.Days {
+		if d, ok := parseWeekday(day); ok && d == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// parseWeekday accepts full or three-letter English weekday names
+func parseWeekday(day string) (time.Weekday, bool) {
+	day = strings.ToLower(day)
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if day == name || day == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// SilenceStore persists silences in a ConfigMap in the toolbox namespace
+type SilenceStore struct {
+	namespace string
+
+	mu     sync.Mutex
+	cached []Silence
+}
+
+// newSilenceStore creates a store backed by the silences ConfigMap
+func newSilenceStore(namespace string) *SilenceStore {
+	return &SilenceStore{namespace: namespace}
+}
+
+// List returns all stored silences, optionally including expired ones
+func (s *SilenceStore) List(ctx context.Context, includeExpired bool) ([]Silence, error) {
+	silences, _, err := s.load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	result := []Silence{}
+	for _, silence := range silences {
+		if includeExpired || now.Before(silence.ExpiresAt) {
+			result = append(result, silence)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].CreatedAt.Before(result[j].CreatedAt) })
+	return result, nil
+}
+
+// Create validates and stores a new silence, dropping silences that have long expired
+func (s *SilenceStore) Create(ctx context.Context, silence Silence) (Silence, error) {
+	if silence.Author == "" {
+		return silence, fmt.Errorf("author is required")
+	}
+	if silence.Namespace == "" && silence.PodSelector == "" && silence.IssueCode == "" {
+		return silence, fmt.Errorf("at least one of namespace, podSelector or issueCode is required")
+	}
+	if err := silence.AlertMatcher.Validate(); err != nil {
+		return silence, err
+	}
+
+	now := time.Now()
+	silence.ID = rand.String(8)
+	silence.CreatedAt = now
+	if silence.StartsAt.IsZero() {
+		silence.StartsAt = now
+	}
+	if !silence.ExpiresAt.After(silence.StartsAt) {
+		return silence, fmt.Errorf("expiry must be after the start time")
+	}
+
+	err := s.update(ctx, func(silences []Silence) []Silence {
+		kept := []Silence{}
+		for _, existing := range silences {
+			// Keep expired silences around for a day so they remain visible in listings
+			if now.Sub(existing.ExpiresAt) < 24*time.Hour {
+				kept = append(kept, existing)
+			}
+		}
+		return append(kept, silence)
+	})
+	return silence, err
+}
+
+// Expire ends a silence immediately
+func (s *SilenceStore) Expire(ctx context.Context, id string) error {
+	found := false
+	err := s.update(ctx, func(silences []Silence) []Silence {
+		now := time.Now()
+		for i := range silences {
+			if silences[i].ID == id {
+				found = true
+				if now.Before(silences[i].ExpiresAt) {
+					silences[i].ExpiresAt = now
+				}
+			}
+		}
+		return silences
+	})
+	if err == nil && !found {
+		return fmt.Errorf("silence %s not found", id)
+	}
+	return err
+}
+
+// Run re-reads the silences ConfigMap every silenceRefreshInterval until ctx is done, so that
+// Active never waits for the API server
+func (s *SilenceStore) Run(ctx context.Context) {
+	ticker := time.NewTicker(silenceRefreshInterval)
+	defer ticker.Stop()
+	for {
+		if _, _, err := s.load(ctx); err != nil {
+			logger.Printf("Failed to refresh silences: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Active returns the silences in effect now from the last snapshot read by Run or a write
+func (s *SilenceStore) Active() []Silence {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	var active []Silence
+	for _, silence := range s.cached {
+		if silence.Active(now) {
+			active = append(active, silence)
+		}
+	}
+	return active
+}
+
+// load reads the silences ConfigMap, returning nil if it does not exist yet
+func (s *SilenceStore) load(ctx context.Context) ([]Silence, *corev1.ConfigMap, error) {
+	cm, err := clientset.CoreV1().ConfigMaps(s.namespace).Get(ctx, silencesConfigMap, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		s.setCache(nil)
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read silences: %v", err)
+	}
+
+	var silences []Silence
+	if data := cm.Data[silencesDataKey]; data != "" {
+		if err := json.Unmarshal([]byte(data), &silences); err != nil {
+			return nil, nil, fmt.Errorf("failed to parse silences in ConfigMap %s/%s: %v", s.namespace, silencesConfigMap, err)
+		}
+	}
+	s.setCache(silences)
+	return silences, cm, nil
+}
+
+func (s *SilenceStore) setCache(silences []Silence) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cached = silences
+}
+
+// update applies a mutation to the stored silences, retrying on write conflicts
+func (s *SilenceStore) update(ctx context.Context, mutate func([]Silence) []Silence) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		silences, cm, err := s.load(ctx)
+		if err != nil {
+			return err
+		}
+
+		updated := mutate(silences)
+		data, err := json.Marshal(updated)
+		if err != nil {
+			return err
+		}
+
+		if cm == nil {
+			cm = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      silencesConfigMap,
+					Namespace: s.namespace,
+					Labels:    map[string]string{"app": "k8stoolbox"},
+				},
+				Data: map[string]string{silencesDataKey: string(data)},
+			}
+			_, err = clientset.CoreV1().ConfigMaps(s.namespace).Create(ctx, cm, metav1.CreateOptions{})
+			if apierrors.IsAlreadyExists(err) {
+				// Another writer created it first; retry as an update
+				return apierrors.NewConflict(corev1.Resource("configmaps"), silencesConfigMap, err)
+			}
+		} else {
+			if cm.Data == nil {
+				cm.Data = map[string]string{}
+			}
+			cm.Data[silencesDataKey] = string(data)
+			_, err = clientset.CoreV1().ConfigMaps(s.namespace).Update(ctx, cm, metav1.UpdateOptions{})
+		}
+		if err != nil {
+			return err
+		}
+		s.setCache(updated)
+		return nil
+	})
+}
+
+// silenceFilter builds a notifier filter that drops notifications matched by an
+// active silence or maintenance window. It only reads the store's cached snapshot.
+func silenceFilter(store *SilenceStore, windows []MaintenanceWindow) func(Notification) bool {
+	return func(n Notification) bool {
+		now := time.Now()
+		for _, window := range windows {
+			if window.Active(now) && window.Matches(n) {
+				logger.Printf("Notification for %s suppressed by maintenance window %s", n.Key, window.Name)
+				return false
+			}
+		}
+		if store == nil {
+			return true
+		}
+		for _, silence := range store.Active() {
+			if silence.Matches(n) {
+				logger.Printf("Notification for %s suppressed by silence %s (%s)", n.Key, silence.ID, silence.Author)
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// runSilenceCommand implements the silence CLI subcommands (create, list, expire)
+func runSilenceCommand(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: k8stoolbox silence <create|list|expire> [options]")
+		os.Exit(1)
+	}
+
+	store := newSilenceStore(toolboxNamespace())
+
+	switch args[0] {
+	case "create":
+		cmd := flag.NewFlagSet("silence create", flag.ExitOnError)
+		namespace := cmd.String("namespace", "", "Namespace to silence")
+		selector := cmd.String("selector", "", "Pod label selector to silence (e.g. app=web)")
+		issue := cmd.String("issue", "", "Issue code to silence (e.g. ExcessiveRestarts)")
+		duration := cmd.Duration("duration", 2*time.Hour, "How long the silence lasts")
+		author := cmd.String("author", getEnv("USER", ""), "Who created the silence")
+		comment := cmd.String("comment", "", "Reason for the silence")
+		if err := cmd.Parse(args[1:]); err != nil {
+			return
+		}
+
+		now := time.Now()
+		silence, err := store.Create(ctx, Silence{
+			AlertMatcher: AlertMatcher{Namespace: *namespace, PodSelector: *selector, IssueCode: *issue},
+			StartsAt:     now,
+			ExpiresAt:    now.Add(*duration),
+			Author:       *author,
+			Comment:      *comment,
+		})
+		if err != nil {
+			logger.Fatalf("Failed to create silence: %v", err)
+		}
+		logger.Printf("Created silence %s expiring at %s\n", silence.ID, silence.ExpiresAt.Format(time.RFC3339))
+	case "list":
+		cmd := flag.NewFlagSet("silence list", flag.ExitOnError)
+		all := cmd.Bool("all", false, "Include expired silences")
+		if err := cmd.Parse(args[1:]); err != nil {
+			return
+		}
+
+		silences, err := store.List(ctx, *all)
+		if err != nil {
+			logger.Fatalf("Failed to list silences: %v", err)
+		}
+		fmt.Printf("%-10s %-20s %-25s %-20s %-21s %-15s %s\n", "ID", "NAMESPACE", "SELECTOR", "ISSUE", "EXPIRES", "AUTHOR", "COMMENT")
+		fmt.Println(strings.Repeat("-", 130))
+		for _, silence := range silences {
+			fmt.Printf("%-10s %-20s %-25s %-20s %-21s %-15s %s\n", silence.ID,
+				conditionalString(silence.Namespace == "", "*", silence.Namespace),
+				conditionalString(silence.PodSelector == "", "*", silence.PodSelector),
+				conditionalString(silence.IssueCode == "", "*", silence.IssueCode),
+				silence.ExpiresAt.Format(time.RFC3339), silence.Author, silence.Comment)
+		}
+	case "expire":
+		cmd := flag.NewFlagSet("silence expire", flag.ExitOnError)
+		id := cmd.String("id", "", "ID of the silence to expire")
+		if err := cmd.Parse(args[1:]); err != nil {
+			return
+		}
+		if *id == "" {
+			logger.Fatalf("Please specify the silence id")
+		}
+		if err := store.Expire(ctx, *id); err != nil {
+			logger.Fatalf("Failed to expire silence: %v", err)
+		}
+		logger.Printf("Expired silence %s\n", *id)
+	default:
+		logger.Printf("Unknown silence command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
+
+// silencesHandler lists (GET), creates (POST) and expires (DELETE ?id=) silences
+func silencesHandler(w http.ResponseWriter, r *http.Request) {
+	if StandaloneMode {
+		errorResponse(w, "silences are not available in standalone mode", http.StatusServiceUnavailable)
+		return
+	}
+	store := newSilenceStore(toolboxNamespace())
+
+	switch r.Method {
+	case http.MethodGet:
+		silences, err := store.List(r.Context(), r.URL.Query().Get("all") == "true")
+		if err != nil {
+			errorResponse(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Data: silences})
+	case http.MethodPost:
+		var req struct {
+			Silence
+			Duration string `json:"duration,omitempty"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			errorResponse(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		silence := req.Silence
+		if req.Duration != "" {
+			d, err := time.ParseDuration(req.Duration)
+			if err != nil {
+				errorResponse(w, fmt.Sprintf("invalid duration: %v", err), http.StatusBadRequest)
+				return
+			}
+			if silence.StartsAt.IsZero() {
+				silence.StartsAt = time.Now()
+			}
+			silence.ExpiresAt = silence.StartsAt.Add(d)
+		}
+		if silence.Author == "" {
+			// Fall back to the authenticated user
+			silence.Author, _, _ = r.BasicAuth()
+		}
+
+		created, err := store.Create(r.Context(), silence)
+		if err != nil {
+			errorResponse(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Data: created})
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			errorResponse(w, "id parameter is required", http.StatusBadRequest)
+			return
+		}
+		if err := store.Expire(r.Context(), id); err != nil {
+			errorResponse(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(APIResponse{Success: true, Message: fmt.Sprintf("silence %s expired", id)})
+	default:
+		errorResponse(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMaintenanceWindowActive(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Fri 23:00 to Sat 01:00 Berlin time
+	window := MaintenanceWindow{
+		Name:     "nightly",
+		Days:     []string{"Fri"},
+		Start:    "23:00",
+		Duration: metav1.Duration{Duration: 2 * time.Hour},
+		Timezone: "Europe/Berlin",
+	}
+	if err := window.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want bool
+	}{
+		{"before start", time.Date(2026, 1, 9, 22, 59, 0, 0, berlin), false},
+		{"at start", time.Date(2026, 1, 9, 23, 0, 0, 0, berlin), true},
+		{"after midnight", time.Date(2026, 1, 10, 0, 30, 0, 0, berlin), true},
+		{"after midnight given in UTC", time.Date(2026, 1, 9, 23, 30, 0, 0, time.UTC), true},
+		{"at end", time.Date(2026, 1, 10, 1, 0, 0, 0, berlin), false},
+		{"before midnight given in UTC", time.Date(2026, 1, 9, 22, 30, 0, 0, time.UTC), true},
+		{"before start in UTC", time.Date(2026, 1, 9, 21, 30, 0, 0, time.UTC), false},
+		{"other day", time.Date(2026, 1, 8, 23, 30, 0, 0, berlin), false},
+		{"after midnight of other day", time.Date(2026, 1, 9, 0, 30, 0, 0, berlin), false},
+		{"summer time given in UTC", time.Date(2026, 7, 3, 21, 30, 0, 0, time.UTC), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := window.Active(tt.now); got != tt.want {
+				t.Errorf("Active(%s) = %v, want %v", tt.now, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,732 @@
+// Cluster snapshots and drift detection between two snapshots.
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
+)
+
+// snapshotManifestFile is the index at the root of a snapshot archive
+const snapshotManifestFile = "snapshot.json"
+
+// snapshotNamePrefix starts the default names of snapshots
+const snapshotNamePrefix = "k8stoolbox-snapshot-"
+
+// Change types of objects between two snapshots
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// Finding categories highlighted in a snapshot diff
+const (
+	FindingImage      = "image"
+	FindingReplicas   = "replicas"
+	FindingRBAC       = "rbac"
+	FindingPrivileged = "privileged"
+)
+
+// rbacKinds are the kinds whose changes are always reported as findings
+var rbacKinds = map[string]bool{"Role": true, "ClusterRole": true, "RoleBinding": true, "ClusterRoleBinding": true}
+
+// SnapshotManifest describes a snapshot archive
+type SnapshotManifest struct {
+	Name          string    `json:"name"`
+	CreatedAt     time.Time `json:"createdAt"`
+	Version       string    `json:"version"`
+	ServerVersion string    `json:"serverVersion,omitempty"`
+	Namespaces    []string  `json:"namespaces,omitempty"`
+	Objects       int       `json:"objects"`
+	// SecretSalt is the hex-encoded HMAC key of the secret value hashes. Secret values can only
+	// be compared between snapshots with the same salt.
+	SecretSalt string `json:"secretSalt,omitempty"`
+}
+
+// SnapshotOptions selects what a snapshot contains
+type SnapshotOptions struct {
+	// Namespaces limits namespaced objects; all namespaces are included when empty
+	Namespaces []string
+	// IncludeOwned keeps objects created by controllers, such as the pods of a ReplicaSet
+	IncludeOwned bool
+	// SecretSalt reuses the salt of an earlier snapshot so that secret changes since that
+	// snapshot show up in a diff; a random salt is used when empty
+	SecretSalt string
+}
+
+// ObjectRef identifies an object independently of its API version
+type ObjectRef struct {
+	Group     string `json:"group,omitempty"`
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+func (r ObjectRef) String() string {
+	if r.Namespace == "" {
+		return r.Kind + " " + r.Name
+	}
+	return r.Kind + " " + r.Namespace + "/" + r.Name
+}
+
+func objectRefOf(obj *unstructured.Unstructured) ObjectRef {
+	gvk := obj.GroupVersionKind()
+	return ObjectRef{Group: gvk.Group, Kind: gvk.Kind, Namespace: obj.GetNamespace(), Name: obj.GetName()}
+}
+
+// Snapshot is a loaded snapshot
+type Snapshot struct {
+	Manifest SnapshotManifest
+	Objects  map[ObjectRef]*unstructured.Unstructured
+}
+
+// normalizeSnapshotObject strips fields that change without anyone changing the object, so that
+// only real drift shows up in a diff. Secret values are replaced by their HMACs keyed with salt.
+func normalizeSnapshotObject(obj *unstructured.Unstructured, salt []byte) {
+	sanitizeObject(obj)
+	annotations := obj.GetAnnotations()
+	for _, key := range []string{lastAppliedAnnotation, "deployment.kubernetes.io/revision"} {
+		delete(annotations, key)
+	}
+	obj.SetAnnotations(annotations)
+	if len(annotations) == 0 {
+		unstructured.RemoveNestedField(obj.Object, "metadata", "annotations")
+	}
+
+	if obj.GetKind() == "Secret" {
+		data, _, _ := unstructured.NestedMap(obj.Object, "data")
+		for key, value := range data {
+			data[key] = secretValueHash(salt, fmt.Sprint(value))
+		}
+		if data != nil {
+			unstructured.SetNestedMap(obj.Object, data, "data")
+		}
+	}
+}
+
+// secretValueHash hides a secret value. The salt prevents looking values up in precomputed
+// tables and matching equal secrets across snapshots that do not share it.
+func secretValueHash(salt []byte, value string) string {
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(value))
+	return "hmac-sha256:" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// snapshotObjectPath is the archive path of an object
+func snapshotObjectPath(r BackupResource, namespace, name string) string {
+	if namespace == "" {
+		return path.Join("objects", r.GroupResource(), "_cluster", name+".yaml")
+	}
+	return path.Join("objects", r.GroupResource(), namespace, name+".yaml")
+}
+
+// takeSnapshot writes the normalized objects of every discovered resource to w
+func takeSnapshot(ctx context.Context, w io.Writer, opts SnapshotOptions) (SnapshotManifest, error) {
+	now := time.Now()
+	manifest := SnapshotManifest{
+		Name:       snapshotNamePrefix + now.Format("20060102-150405"),
+		CreatedAt:  now,
+		Version:    Version,
+		Namespaces: opts.Namespaces,
+	}
+	if dynamicClient == nil {
+		return manifest, fmt.Errorf("snapshots need a Kubernetes cluster")
+	}
+	manifest.SecretSalt = opts.SecretSalt
+	if manifest.SecretSalt == "" {
+		salt := make([]byte, 32)
+		if _, err := rand.Read(salt); err != nil {
+			return manifest, fmt.Errorf("failed to generate secret salt: %v", err)
+		}
+		manifest.SecretSalt = hex.EncodeToString(salt)
+	}
+	salt, err := hex.DecodeString(manifest.SecretSalt)
+	if err != nil {
+		return manifest, fmt.Errorf("invalid secret salt: %v", err)
+	}
+	if info, err := clientset.Discovery().ServerVersion(); err == nil {
+		manifest.ServerVersion = info.GitVersion
+	}
+	resources, err := discoverBackupResources()
+	if err != nil {
+		return manifest, err
+	}
+	filter := ResourceFilter{Exclude: defaultBackupExclusions}
+
+	archive := newTarGzWriter(w, now)
+	for _, r := range resources {
+		if !filter.Allows(r) {
+			continue
+		}
+		namespaces := []string{metav1.NamespaceAll}
+		if r.Namespaced && len(opts.Namespaces) > 0 {
+			namespaces = opts.Namespaces
+		}
+		for _, namespace := range namespaces {
+			list, err := dynamicClient.Resource(r.GroupVersionResource()).Namespace(namespace).List(ctx, metav1.ListOptions{})
+			if err != nil {
+				if apierrors.IsForbidden(err) || apierrors.IsNotFound(err) || apierrors.IsMethodNotSupported(err) {
+					logger.Printf("⚠️ Skipping %s: %v", r.GroupResource(), err)
+					continue
+				}
+				return manifest, fmt.Errorf("failed to list %s: %v", r.GroupResource(), err)
+			}
+			for i := range list.Items {
+				obj := &list.Items[i]
+				if !opts.IncludeOwned && metav1.GetControllerOfNoCopy(obj) != nil {
+					continue
+				}
+				normalizeSnapshotObject(obj, salt)
+				data, err := yaml.Marshal(obj.Object)
+				if err != nil {
+					return manifest, fmt.Errorf("failed to encode %s %s: %v", r.Kind, obj.GetName(), err)
+				}
+				if err := archive.WriteFile(snapshotObjectPath(r, obj.GetNamespace(), obj.GetName()), data); err != nil {
+					return manifest, err
+				}
+				manifest.Objects++
+			}
+		}
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, fmt.Errorf("failed to encode manifest: %v", err)
+	}
+	if err := archive.WriteFile(snapshotManifestFile, data); err != nil {
+		return manifest, err
+	}
+	return manifest, archive.Close()
+}
+
+// readSnapshot loads a snapshot archive
+func readSnapshot(name string, r io.Reader) (*Snapshot, error) {
+	snapshot := &Snapshot{Objects: make(map[ObjectRef]*unstructured.Unstructured)}
+	found := false
+	err := readTarGzStream(name, r, func(file string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %v", file, err)
+		}
+		if file == snapshotManifestFile {
+			found = true
+			return json.Unmarshal(data, &snapshot.Manifest)
+		}
+		if !strings.HasPrefix(file, "objects/") {
+			return nil
+		}
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(data, &obj.Object); err != nil {
+			return fmt.Errorf("failed to parse %s: %v", file, err)
+		}
+		snapshot.Objects[objectRefOf(obj)] = obj
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("%s is not a snapshot: %s is missing", name, snapshotManifestFile)
+	}
+	return snapshot, nil
+}
+
+// readSnapshotFile loads a snapshot from a file
+func readSnapshotFile(filename string) (*Snapshot, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %v", err)
+	}
+	defer file.Close()
+	return readSnapshot(filename, file)
+}
+
+// FieldChange is a changed field of a modified object
+type FieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// ObjectDiff is an object that differs between two snapshots
+type ObjectDiff struct {
+	ObjectRef
+	Change string        `json:"change"`
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// DriftFinding highlights a change that matters for operations or security
+type DriftFinding struct {
+	Category string    `json:"category"`
+	Object   ObjectRef `json:"object"`
+	Message  string    `json:"message"`
+}
+
+// SnapshotDiff is the drift between two snapshots
+type SnapshotDiff struct {
+	From     SnapshotManifest `json:"from"`
+	To       SnapshotManifest `json:"to"`
+	Added    int              `json:"added"`
+	Removed  int              `json:"removed"`
+	Modified int              `json:"modified"`
+	// SecretValuesCompared is false when the snapshots hash secret values with different salts
+	SecretValuesCompared bool           `json:"secretValuesCompared"`
+	Findings             []DriftFinding `json:"findings"`
+	Objects              []ObjectDiff   `json:"objects"`
+}
+
+// diffSnapshots compares two snapshots object by object and field by field. Secret values are
+// only compared when both snapshots use the same salt.
+func diffSnapshots(from, to *Snapshot) SnapshotDiff {
+	diff := SnapshotDiff{From: from.Manifest, To: to.Manifest, Findings: []DriftFinding{}, Objects: []ObjectDiff{}}
+	diff.SecretValuesCompared = from.Manifest.SecretSalt == to.Manifest.SecretSalt
+	refs := make(map[ObjectRef]bool)
+	for ref := range from.Objects {
+		refs[ref] = true
+	}
+	for ref := range to.Objects {
+		refs[ref] = true
+	}
+
+	for ref := range refs {
+		old, new := from.Objects[ref], to.Objects[ref]
+		var d ObjectDiff
+		switch {
+		case old == nil:
+			d = ObjectDiff{ObjectRef: ref, Change: ChangeAdded}
+			diff.Added++
+		case new == nil:
+			d = ObjectDiff{ObjectRef: ref, Change: ChangeRemoved}
+			diff.Removed++
+		default:
+			var fields []FieldChange
+			diffValues("", withoutAPIVersion(old.Object), withoutAPIVersion(new.Object), &fields)
+			if ref.Group == "" && ref.Kind == "Secret" && !diff.SecretValuesCompared {
+				fields = withoutSecretValues(fields)
+			}
+			if len(fields) == 0 {
+				continue
+			}
+			d = ObjectDiff{ObjectRef: ref, Change: ChangeModified, Fields: fields}
+			diff.Modified++
+		}
+		diff.Objects = append(diff.Objects, d)
+		diff.Findings = append(diff.Findings, driftFindings(d, old, new)...)
+	}
+
+	sort.Slice(diff.Objects, func(i, j int) bool { return lessObjectRef(diff.Objects[i].ObjectRef, diff.Objects[j].ObjectRef) })
+	sort.SliceStable(diff.Findings, func(i, j int) bool {
+		if diff.Findings[i].Category != diff.Findings[j].Category {
+			return diff.Findings[i].Category < diff.Findings[j].Category
+		}
+		return lessObjectRef(diff.Findings[i].Object, diff.Findings[j].Object)
+	})
+	return diff
+}
+
+// withoutSecretValues drops changes of secret values, whose hashes cannot be compared
+func withoutSecretValues(fields []FieldChange) []FieldChange {
+	var kept []FieldChange
+	for _, f := range fields {
+		if f.Path != "data" && !strings.HasPrefix(f.Path, "data.") {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+func lessObjectRef(a, b ObjectRef) bool {
+	if a.Kind != b.Kind {
+		return a.Kind < b.Kind
+	}
+	if a.Namespace != b.Namespace {
+		return a.Namespace < b.Namespace
+	}
+	return a.Name < b.Name
+}
+
+// withoutAPIVersion ignores version changes, such as a resource served at a newer version
+func withoutAPIVersion(obj map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(obj))
+	for k, v := range obj {
+		if k != "apiVersion" {
+			copied[k] = v
+		}
+	}
+	return copied
+}
+
+// diffValues records the differing leaves of two values. Lists of objects with a name, like
+// containers or env, are matched by name; other lists by index.
+func diffValues(fieldPath string, old, new interface{}, changes *[]FieldChange) {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		n, ok := new.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make(map[string]bool)
+		for k := range o {
+			keys[k] = true
+		}
+		for k := range n {
+			keys[k] = true
+		}
+		sorted := make([]string, 0, len(keys))
+		for k := range keys {
+			sorted = append(sorted, k)
+		}
+		sort.Strings(sorted)
+		for _, k := range sorted {
+			diffValues(joinFieldPath(fieldPath, k), o[k], n[k], changes)
+		}
+		return
+	case []interface{}:
+		n, ok := new.([]interface{})
+		if !ok {
+			break
+		}
+		oldNamed, oldOK := namedItems(o)
+		newNamed, newOK := namedItems(n)
+		if oldOK && newOK {
+			names := make(map[string]bool)
+			var order []string
+			for _, items := range [][]interface{}{o, n} {
+				for _, item := range items {
+					name := item.(map[string]interface{})["name"].(string)
+					if !names[name] {
+						names[name] = true
+						order = append(order, name)
+					}
+				}
+			}
+			for _, name := range order {
+				diffValues(fmt.Sprintf("%s[%s]", fieldPath, name), oldNamed[name], newNamed[name], changes)
+			}
+			return
+		}
+		if len(o) == len(n) {
+			for i := range o {
+				diffValues(fmt.Sprintf("%s[%d]", fieldPath, i), o[i], n[i], changes)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, FieldChange{Path: fieldPath, Old: old, New: new})
+	}
+}
+
+func joinFieldPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+// namedItems indexes a list whose items are all objects with a unique name
+func namedItems(items []interface{}) (map[string]interface{}, bool) {
+	named := make(map[string]interface{}, len(items))
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		name, ok := m["name"].(string)
+		if !ok || named[name] != nil {
+			return nil, false
+		}
+		named[name] = m
+	}
+	return named, len(items) > 0
+}
+
+// driftFindings highlights image, replica, RBAC and privilege changes of an object diff
+func driftFindings(d ObjectDiff, old, new *unstructured.Unstructured) []DriftFinding {
+	var findings []DriftFinding
+	add := func(category, format string, args ...interface{}) {
+		findings = append(findings, DriftFinding{Category: category, Object: d.ObjectRef, Message: fmt.Sprintf(format, args...)})
+	}
+
+	for _, f := range d.Fields {
+		switch {
+		case strings.HasSuffix(f.Path, "].image") && strings.Contains(f.Path, "ontainers["):
+			container := strings.TrimSuffix(f.Path, "].image")
+			container = container[strings.LastIndex(container, "[")+1:]
+			add(FindingImage, "container %s image changed from %s to %s", container, formatFieldValue(f.Old), formatFieldValue(f.New))
+		case f.Path == "spec.replicas":
+			add(FindingReplicas, "replicas changed from %s to %s", formatFieldValue(f.Old), formatFieldValue(f.New))
+		}
+	}
+
+	if rbacKinds[d.Kind] {
+		switch d.Change {
+		case ChangeAdded:
+			add(FindingRBAC, "%s added%s", d.Kind, describeRBAC(new))
+		case ChangeRemoved:
+			add(FindingRBAC, "%s removed%s", d.Kind, describeRBAC(old))
+		case ChangeModified:
+			var changed []string
+			for _, f := range d.Fields {
+				field := strings.SplitN(f.Path, ".", 2)[0]
+				field = strings.SplitN(field, "[", 2)[0]
+				if field != "metadata" {
+					changed = appendUnique(changed, field)
+				}
+			}
+			if len(changed) > 0 {
+				add(FindingRBAC, "%s changed%s", strings.Join(changed, " and "), describeRBAC(new))
+			}
+		}
+	}
+
+	if new != nil {
+		before := map[string]bool{}
+		if old != nil {
+			for _, risk := range podSpecRisks(old) {
+				before[risk] = true
+			}
+		}
+		var risks []string
+		for _, risk := range podSpecRisks(new) {
+			if !before[risk] {
+				risks = append(risks, risk)
+			}
+		}
+		if len(risks) > 0 {
+			add(FindingPrivileged, "%s runs with %s", conditionalString(old == nil, "new workload", "workload now"), strings.Join(risks, ", "))
+		}
+	}
+	return findings
+}
+
+// describeRBAC summarizes what a role or binding grants
+func describeRBAC(obj *unstructured.Unstructured) string {
+	if obj == nil {
+		return ""
+	}
+	if roleKind, _, _ := unstructured.NestedString(obj.Object, "roleRef", "kind"); roleKind != "" {
+		roleName, _, _ := unstructured.NestedString(obj.Object, "roleRef", "name")
+		subjects, _, _ := unstructured.NestedSlice(obj.Object, "subjects")
+		var names []string
+		for _, s := range subjects {
+			if subject, ok := s.(map[string]interface{}); ok {
+				name := fmt.Sprint(subject["kind"], " ", subject["name"])
+				if ns, ok := subject["namespace"].(string); ok && ns != "" {
+					name = fmt.Sprint(subject["kind"], " ", ns, "/", subject["name"])
+				}
+				names = append(names, name)
+			}
+		}
+		return fmt.Sprintf(": binds %s %s to %s", roleKind, roleName, conditionalString(len(names) == 0, "no subjects", strings.Join(names, ", ")))
+	}
+	rules, _, _ := unstructured.NestedSlice(obj.Object, "rules")
+	for _, r := range rules {
+		rule, _ := r.(map[string]interface{})
+		verbs, _ := rule["verbs"].([]interface{})
+		resources, _ := rule["resources"].([]interface{})
+		for _, v := range verbs {
+			for _, res := range resources {
+				if v == "*" && res == "*" {
+					return ": grants all verbs on all resources"
+				}
+			}
+		}
+	}
+	return fmt.Sprintf(": %d rules", len(rules))
+}
+
+// podSpecPaths are where kinds embed pod specs
+var podSpecPaths = map[string][]string{
+	"Pod":                   {"spec"},
+	"Deployment":            {"spec", "template", "spec"},
+	"StatefulSet":           {"spec", "template", "spec"},
+	"DaemonSet":             {"spec", "template", "spec"},
+	"ReplicaSet":            {"spec", "template", "spec"},
+	"ReplicationController": {"spec", "template", "spec"},
+	"Job":                   {"spec", "template", "spec"},
+	"CronJob":               {"spec", "jobTemplate", "spec", "template", "spec"},
+}
+
+// podSpecRisks lists the privileged settings of the pod spec of an object
+func podSpecRisks(obj *unstructured.Unstructured) []string {
+	fields, ok := podSpecPaths[obj.GetKind()]
+	if !ok {
+		return nil
+	}
+	spec, found, _ := unstructured.NestedMap(obj.Object, fields...)
+	if !found {
+		return nil
+	}
+
+	var risks []string
+	for _, host := range []string{"hostNetwork", "hostPID", "hostIPC"} {
+		if enabled, _ := spec[host].(bool); enabled {
+			risks = append(risks, host)
+		}
+	}
+	for _, list := range []string{"initContainers", "containers"} {
+		containers, _ := spec[list].([]interface{})
+		for _, c := range containers {
+			container, _ := c.(map[string]interface{})
+			if privileged, _, _ := unstructured.NestedBool(container, "securityContext", "privileged"); privileged {
+				risks = append(risks, fmt.Sprintf("privileged container %v", container["name"]))
+			}
+			if escalation, found, _ := unstructured.NestedBool(container, "securityContext", "allowPrivilegeEscalation"); found && escalation {
+				risks = append(risks, fmt.Sprintf("privilege escalation in container %v", container["name"]))
+			}
+			capabilities, _, _ := unstructured.NestedStringSlice(container, "securityContext", "capabilities", "add")
+			for _, capability := range capabilities {
+				if capability == "SYS_ADMIN" || capability == "ALL" {
+					risks = append(risks, fmt.Sprintf("capability %s in container %v", capability, container["name"]))
+				}
+			}
+		}
+	}
+	return risks
+}
+
+// formatFieldValue renders a field value on one line
+func formatFieldValue(value interface{}) string {
+	if value == nil {
+		return "<none>"
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	text := string(data)
+	if len(text) > 120 {
+		text = text[:117] + "..."
+	}
+	return text
+}
+
+// printSnapshotDiff writes a diff as text
+func printSnapshotDiff(w io.Writer, diff SnapshotDiff) {
+	fmt.Fprintf(w, "Snapshot diff: %s (%s) -> %s (%s)\n", diff.From.Name, diff.From.CreatedAt.Format(time.RFC3339),
+		diff.To.Name, diff.To.CreatedAt.Format(time.RFC3339))
+	fmt.Fprintf(w, "%d added, %d removed, %d modified\n", diff.Added, diff.Removed, diff.Modified)
+	if !diff.SecretValuesCompared {
+		fmt.Fprintf(w, "⚠️ Secret values were not compared: the snapshots use different salts (take the newer one with -compare-with the older one)\n")
+	}
+
+	if len(diff.Findings) > 0 {
+		fmt.Fprintf(w, "\nFindings:\n")
+		for _, f := range diff.Findings {
+			fmt.Fprintf(w, "  ⚠️ [%s] %s: %s\n", f.Category, f.Object, f.Message)
+		}
+	}
+	if len(diff.Objects) > 0 {
+		fmt.Fprintf(w, "\nChanges:\n")
+		symbols := map[string]string{ChangeAdded: "+", ChangeRemoved: "-", ChangeModified: "~"}
+		for _, d := range diff.Objects {
+			fmt.Fprintf(w, "  %s %s\n", symbols[d.Change], d.ObjectRef)
+			for _, f := range d.Fields {
+				fmt.Fprintf(w, "      %s: %s -> %s\n", f.Path, formatFieldValue(f.Old), formatFieldValue(f.New))
+			}
+		}
+	}
+}
+
+// runSnapshotCommand dispatches the snapshot subcommands
+func runSnapshotCommand(ctx context.Context, args []string) {
+	if len(args) < 1 {
+		fmt.Println("Usage: k8stoolbox snapshot <take|diff> [options]")
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "take":
+		cmd := flag.NewFlagSet("snapshot take", flag.ExitOnError)
+		namespaces := cmd.String("namespaces", "", "Comma-separated namespaces of namespaced objects (default: all)")
+		includeOwned := cmd.Bool("include-owned", false, "Include objects created by controllers, such as the pods of a ReplicaSet")
+		output := cmd.String("output", "", "Snapshot file (default: k8stoolbox-snapshot-<timestamp>.tar.gz)")
+		compareWith := cmd.String("compare-with", "", "Earlier snapshot whose salt is reused, so secret changes since it can be diffed")
+		if err := cmd.Parse(args[1:]); err != nil {
+			return
+		}
+		opts := SnapshotOptions{Namespaces: splitList(*namespaces), IncludeOwned: *includeOwned}
+		if *compareWith != "" {
+			previous, err := readSnapshotFile(*compareWith)
+			if err != nil {
+				logger.Fatalf("%v", err)
+			}
+			opts.SecretSalt = previous.Manifest.SecretSalt
+		}
+
+		filename := *output
+		if filename == "" {
+			filename = snapshotNamePrefix + time.Now().Format("20060102-150405") + backupArchiveSuffix
+		}
+		file, err := os.Create(filename)
+		if err != nil {
+			logger.Fatalf("Failed to create snapshot file: %v", err)
+		}
+		manifest, err := takeSnapshot(ctx, file, opts)
+		if err == nil {
+			err = file.Close()
+		}
+		if err != nil {
+			file.Close()
+			os.Remove(filename)
+			logger.Fatalf("Snapshot failed: %v", err)
+		}
+		logger.Printf("✅ Snapshot of %d objects written to %s", manifest.Objects, filename)
+	case "diff":
+		cmd := flag.NewFlagSet("snapshot diff", flag.ExitOnError)
+		outputFormat := cmd.String("output", "text", "Output format: text or json")
+		if err := cmd.Parse(args[1:]); err != nil {
+			return
+		}
+		if cmd.NArg() != 2 {
+			fmt.Println("Usage: k8stoolbox snapshot diff [options] <snapshot-a> <snapshot-b>")
+			os.Exit(1)
+		}
+
+		from, err := readSnapshotFile(cmd.Arg(0))
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		to, err := readSnapshotFile(cmd.Arg(1))
+		if err != nil {
+			logger.Fatalf("%v", err)
+		}
+		diff := diffSnapshots(from, to)
+		switch *outputFormat {
+		case "json":
+			encoder := json.NewEncoder(os.Stdout)
+			encoder.SetIndent("", "  ")
+			encoder.Encode(diff)
+		case "text":
+			printSnapshotDiff(os.Stdout, diff)
+		default:
+			logger.Fatalf("Unknown output format %q, expected text or json", *outputFormat)
+		}
+	default:
+		logger.Printf("Unknown snapshot command: %s\n", args[0])
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestDiffValues(t *testing.T) {
+	container := func(name, image string) map[string]interface{} {
+		return map[string]interface{}{"name": name, "image": image}
+	}
+	tests := []struct {
+		name     string
+		old, new interface{}
+		want     []FieldChange
+	}{
+		{
+			name: "equal",
+			old:  map[string]interface{}{"replicas": int64(2)},
+			new:  map[string]interface{}{"replicas": int64(2)},
+		},
+		{
+			name: "changed leaf",
+			old:  map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(2)}},
+			new:  map[string]interface{}{"spec": map[string]interface{}{"replicas": int64(3)}},
+			want: []FieldChange{{Path: "spec.replicas", Old: int64(2), New: int64(3)}},
+		},
+		{
+			name: "added and removed keys",
+			old:  map[string]interface{}{"a": "1", "b": "2"},
+			new:  map[string]interface{}{"b": "2", "c": "3"},
+			want: []FieldChange{{Path: "a", Old: "1"}, {Path: "c", New: "3"}},
+		},
+		{
+			name: "named lists are matched by name",
+			old:  []interface{}{container("app", "app:1"), container("sidecar", "proxy:1")},
+			new:  []interface{}{container("sidecar", "proxy:1"), container("app", "app:2")},
+			want: []FieldChange{{Path: "[app].image", Old: "app:1", New: "app:2"}},
+		},
+		{
+			name: "named list item added",
+			old:  []interface{}{container("app", "app:1")},
+			new:  []interface{}{container("app", "app:1"), container("sidecar", "proxy:1")},
+			want: []FieldChange{{Path: "[sidecar]", New: container("sidecar", "proxy:1")}},
+		},
+		{
+			name: "other lists are matched by index",
+			old:  []interface{}{"a", "b"},
+			new:  []interface{}{"a", "c"},
+			want: []FieldChange{{Path: "[1]", Old: "b", New: "c"}},
+		},
+		{
+			name: "list length change replaces the list",
+			old:  []interface{}{"a"},
+			new:  []interface{}{"a", "b"},
+			want: []FieldChange{{Path: "", Old: []interface{}{"a"}, New: []interface{}{"a", "b"}}},
+		},
+		{
+			name: "type change",
+			old:  map[string]interface{}{"port": "http"},
+			new:  map[string]interface{}{"port": int64(80)},
+			want: []FieldChange{{Path: "port", Old: "http", New: int64(80)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []FieldChange
+			diffValues("", tt.old, tt.new, &got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+// snapshotObject builds a normalized object the way takeSnapshot stores it
+func snapshotObject(apiVersion, kind, name string, fields map[string]interface{}, salt []byte) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	for k, v := range fields {
+		obj.Object[k] = v
+	}
+	obj.SetAPIVersion(apiVersion)
+	obj.SetKind(kind)
+	obj.SetNamespace("shop")
+	obj.SetName(name)
+	obj.SetResourceVersion("42")
+	normalizeSnapshotObject(obj, salt)
+	return obj
+}
+
+func testSnapshot(salt string, objects ...*unstructured.Unstructured) *Snapshot {
+	s := &Snapshot{Manifest: SnapshotManifest{SecretSalt: salt}, Objects: map[ObjectRef]*unstructured.Unstructured{}}
+	for _, obj := range objects {
+		s.Objects[objectRefOf(obj)] = obj
+	}
+	return s
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	salt, otherSalt := []byte("salt-1"), []byte("salt-2")
+	deployment := func(apiVersion, image string) *unstructured.Unstructured {
+		return snapshotObject(apiVersion, "Deployment", "web", map[string]interface{}{
+			"spec": map[string]interface{}{"template": map[string]interface{}{"spec": map[string]interface{}{
+				"containers": []interface{}{map[string]interface{}{"name": "web", "image": image}},
+			}}},
+		}, salt)
+	}
+	secret := func(password string, labels map[string]interface{}, salt []byte) *unstructured.Unstructured {
+		fields := map[string]interface{}{"data": map[string]interface{}{"password": password}}
+		obj := snapshotObject("v1", "Secret", "db", fields, salt)
+		if labels != nil {
+			unstructured.SetNestedMap(obj.Object, labels, "metadata", "labels")
+		}
+		return obj
+	}
+	configMap := snapshotObject("v1", "ConfigMap", "settings", map[string]interface{}{"data": map[string]interface{}{"mode": "fast"}}, salt)
+
+	tests := []struct {
+		name          string
+		from, to      *Snapshot
+		wantObjects   []string
+		wantFindings  []string
+		wantCompared  bool
+		wantSecretKey bool
+	}{
+		{
+			name:         "unchanged",
+			from:         testSnapshot("a", deployment("apps/v1", "web:1"), configMap),
+			to:           testSnapshot("a", deployment("apps/v1", "web:1"), configMap),
+			wantCompared: true,
+		},
+		{
+			name:         "added and removed",
+			from:         testSnapshot("a", deployment("apps/v1", "web:1")),
+			to:           testSnapshot("a", configMap),
+			wantObjects:  []string{"added ConfigMap shop/settings", "removed Deployment shop/web"},
+			wantCompared: true,
+		},
+		{
+			name:         "image change",
+			from:         testSnapshot("a", deployment("apps/v1", "web:1")),
+			to:           testSnapshot("a", deployment("apps/v1", "web:2")),
+			wantObjects:  []string{"modified Deployment shop/web"},
+			wantFindings: []string{"container web image changed from web:1 to web:2"},
+			wantCompared: true,
+		},
+		{
+			name:         "apiVersion change is ignored",
+			from:         testSnapshot("a", deployment("apps/v1beta2", "web:1")),
+			to:           testSnapshot("a", deployment("apps/v1", "web:1")),
+			wantCompared: true,
+		},
+		{
+			name:          "secret value change with the same salt",
+			from:          testSnapshot("a", secret("hunter2", nil, salt)),
+			to:            testSnapshot("a", secret("hunter3", nil, salt)),
+			wantObjects:   []string{"modified Secret shop/db"},
+			wantCompared:  true,
+			wantSecretKey: true,
+		},
+		{
+			name:         "secret values are not compared across salts",
+			from:         testSnapshot("a", secret("hunter2", nil, salt)),
+			to:           testSnapshot("b", secret("hunter2", nil, otherSalt)),
+			wantCompared: false,
+		},
+		{
+			name:         "other secret fields are compared across salts",
+			from:         testSnapshot("a", secret("hunter2", nil, salt)),
+			to:           testSnapshot("b", secret("hunter2", map[string]interface{}{"team": "payments"}, otherSalt)),
+			wantObjects:  []string{"modified Secret shop/db"},
+			wantCompared: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := diffSnapshots(tt.from, tt.to)
+			var objects []string
+			secretKey := false
+			for _, d := range diff.Objects {
+				objects = append(objects, d.Change+" "+d.ObjectRef.String())
+				for _, f := range d.Fields {
+					if strings.HasPrefix(f.Path, "data.") && d.Kind == "Secret" {
+						secretKey = true
+					}
+				}
+			}
+			var findings []string
+			for _, f := range diff.Findings {
+				findings = append(findings, f.Message)
+			}
+			if !reflect.DeepEqual(objects, tt.wantObjects) {
+				t.Errorf("objects %q, want %q", objects, tt.wantObjects)
+			}
+			if !reflect.DeepEqual(findings, tt.wantFindings) {
+				t.Errorf("findings %q, want %q", findings, tt.wantFindings)
+			}
+			if diff.SecretValuesCompared != tt.wantCompared {
+				t.Errorf("SecretValuesCompared = %v, want %v", diff.SecretValuesCompared, tt.wantCompared)
+			}
+			if secretKey != tt.wantSecretKey {
+				t.Errorf("secret value change reported = %v, want %v", secretKey, tt.wantSecretKey)
+			}
+		})
+	}
+}
+
+func TestNormalizeSnapshotObjectHashesSecrets(t *testing.T) {
+	hash := func(salt string) string {
+		obj := snapshotObject("v1", "Secret", "db", map[string]interface{}{"data": map[string]interface{}{"password": "aHVudGVyMg=="}}, []byte(salt))
+		value, _, _ := unstructured.NestedString(obj.Object, "data", "password")
+		return value
+	}
+	first := hash("salt-1")
+	if !strings.HasPrefix(first, "hmac-sha256:") || strings.Contains(first, "aHVudGVyMg==") {
+		t.Fatalf("unexpected secret hash %q", first)
+	}
+	if strings.TrimPrefix(first, "hmac-sha256:") == sha256Hex([]byte("aHVudGVyMg==")) {
+		t.Error("secret hash is an unsalted SHA-256")
+	}
+	if hash("salt-1") != first {
+		t.Error("expected the same hash for the same salt")
+	}
+	if hash("salt-2") == first {
+		t.Error("expected a different hash for another salt")
+	}
+}
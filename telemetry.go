@@ -0,0 +1,160 @@
+// OpenTelemetry export of metrics and traces over OTLP.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	promBridge "go.opentelemetry.io/contrib/bridges/prometheus"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetricgrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlpmetric/otlpmetrichttp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the toolbox spans; it is a no-op until telemetry is enabled
+var tracer = otel.Tracer("github.com/narmidm/K8sToolbox")
+
+// telemetryEnabled reports whether an OTLP endpoint is configured through the standard
+// OTEL_* environment variables and the SDK is not disabled
+func telemetryEnabled() bool {
+	if getBoolEnv("OTEL_SDK_DISABLED", false) {
+		return false
+	}
+	for _, name := range []string{"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "OTEL_EXPORTER_OTLP_METRICS_ENDPOINT"} {
+		if os.Getenv(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// otlpProtocol returns the protocol of a signal: grpc or http/protobuf (the OTLP default)
+func otlpProtocol(signal string) string {
+	protocol := getEnv("OTEL_EXPORTER_OTLP_"+signal+"_PROTOCOL", getEnv("OTEL_EXPORTER_OTLP_PROTOCOL", "http/protobuf"))
+	return strings.ToLower(protocol)
+}
+
+// initTelemetry sets up OTLP trace and metric export. Endpoints, headers, TLS, timeouts,
+// the export interval and the sampler are read by the SDK from the OTEL_* variables.
+// The returned function flushes and stops the exporters.
+func initTelemetry(ctx context.Context) (func(context.Context) error, error) {
+	noop := func(context.Context) error { return nil }
+	if !telemetryEnabled() {
+		return noop, nil
+	}
+
+	res, err := resource.Merge(
+		resource.Default(),
+		resource.NewWithAttributes(semconv.SchemaURL,
+			semconv.ServiceName("k8stoolbox"),
+			semconv.ServiceVersion(Version)),
+	)
+	if err != nil {
+		return noop, fmt.Errorf("failed to build telemetry resource: %v", err)
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES take precedence over the defaults
+	if fromEnv, err := resource.New(ctx, resource.WithFromEnv()); err == nil {
+		if merged, err := resource.Merge(res, fromEnv); err == nil {
+			res = merged
+		}
+	}
+
+	var traceExporter sdktrace.SpanExporter
+	switch protocol := otlpProtocol("TRACES"); protocol {
+	case "grpc":
+		traceExporter, err = otlptracegrpc.New(ctx)
+	case "http/protobuf":
+		traceExporter, err = otlptracehttp.New(ctx)
+	default:
+		err = fmt.Errorf("unsupported OTLP protocol %q, expected grpc or http/protobuf", protocol)
+	}
+	if err != nil {
+		return noop, fmt.Errorf("failed to create OTLP trace exporter: %v", err)
+	}
+
+	var metricExporter sdkmetric.Exporter
+	switch protocol := otlpProtocol("METRICS"); protocol {
+	case "grpc":
+		metricExporter, err = otlpmetricgrpc.New(ctx)
+	case "http/protobuf":
+		metricExporter, err = otlpmetrichttp.New(ctx)
+	default:
+		err = fmt.Errorf("unsupported OTLP protocol %q, expected grpc or http/protobuf", protocol)
+	}
+	if err != nil {
+		traceExporter.Shutdown(ctx)
+		return noop, fmt.Errorf("failed to create OTLP metric exporter: %v", err)
+	}
+
+	tracerProvider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(traceExporter),
+		sdktrace.WithResource(res),
+	)
+	// The Prometheus registry stays the single source of metrics; the bridge exports it over OTLP
+	meterProvider := sdkmetric.NewMeterProvider(
+		sdkmetric.WithReader(sdkmetric.NewPeriodicReader(metricExporter,
+			sdkmetric.WithProducer(promBridge.NewMetricProducer()))),
+		sdkmetric.WithResource(res),
+	)
+
+	otel.SetTracerProvider(tracerProvider)
+	otel.SetMeterProvider(meterProvider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	logger.Println("OpenTelemetry export enabled")
+
+	return func(ctx context.Context) error {
+		return errors.Join(tracerProvider.Shutdown(ctx), meterProvider.Shutdown(ctx))
+	}, nil
+}
+
+// traceKubernetesClient records a client span for every Kubernetes API request
+func traceKubernetesClient() {
+	if config == nil || !telemetryEnabled() {
+		return
+	}
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			return "k8s " + r.Method + " " + r.URL.Path
+		}))
+	})
+}
+
+// traceHTTPHandler records a server span for every API request
+func traceHTTPHandler(handler http.Handler) http.Handler {
+	if !telemetryEnabled() {
+		return handler
+	}
+	return otelhttp.NewHandler(handler, "api", otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return r.Method + " " + r.URL.Path
+	}))
+}
+
+// startSpan starts a span with the given attributes
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}